@@ -0,0 +1,148 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build ignore
+
+// uap_regexes vendors the regexes.yaml database of uap-core at the given
+// version for the user_agent processor, along with its license. The rules
+// that Go can't compile, because they use lookarounds or other features not
+// supported by RE2, are listed in the header of the generated file.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const baseURL = "https://raw.githubusercontent.com/ua-parser/uap-core"
+
+var (
+	version = flag.String("version", "", "uap-core version (git tag) to vendor")
+	output  = flag.String("out", "regexes.yaml", "Output path of the database")
+	license = flag.String("license", "regexes.LICENSE.txt", "Output path of the uap-core license")
+)
+
+type rule struct {
+	Regex     string `yaml:"regex"`
+	RegexFlag string `yaml:"regex_flag"`
+}
+
+type database struct {
+	UserAgentParsers []rule `yaml:"user_agent_parsers"`
+	OSParsers        []rule `yaml:"os_parsers"`
+	DeviceParsers    []rule `yaml:"device_parsers"`
+}
+
+func main() {
+	flag.Parse()
+	if *version == "" {
+		fmt.Fprintln(os.Stderr, "-version is required")
+		os.Exit(1)
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	client := &http.Client{Timeout: time.Minute}
+	regexes, err := download(client, "regexes.yaml")
+	if err != nil {
+		return err
+	}
+	licenseText, err := download(client, "LICENSE")
+	if err != nil {
+		return err
+	}
+
+	var db database
+	if err := yaml.Unmarshal(regexes, &db); err != nil {
+		return fmt.Errorf("failed to parse regexes.yaml: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Code generated by dev-tools/cmd/uap_regexes - DO NOT EDIT.\n")
+	fmt.Fprintf(&buf, "#\n")
+	fmt.Fprintf(&buf, "# regexes.yaml of uap-core %s (https://github.com/ua-parser/uap-core),\n", *version)
+	fmt.Fprintf(&buf, "# licensed under the Apache License, Version 2.0, see %s.\n", *license)
+	fmt.Fprintf(&buf, "# To update it, change the version in the go:generate directive of parser.go\n")
+	fmt.Fprintf(&buf, "# and run go generate.\n")
+	fmt.Fprintf(&buf, "#\n")
+
+	skipped := 0
+	for _, section := range []struct {
+		name  string
+		rules []rule
+	}{
+		{"user_agent_parsers", db.UserAgentParsers},
+		{"os_parsers", db.OSParsers},
+		{"device_parsers", db.DeviceParsers},
+	} {
+		for i, r := range section.rules {
+			regex := r.Regex
+			if r.RegexFlag == "i" {
+				regex = "(?i)" + regex
+			}
+			if _, err := regexp.Compile(regex); err != nil {
+				if skipped == 0 {
+					fmt.Fprintf(&buf, "# The following rules use regular expression features not supported by\n")
+					fmt.Fprintf(&buf, "# Go, like lookarounds, and are skipped by the user_agent processor:\n")
+				}
+				skipped++
+				fmt.Fprintf(&buf, "#   %s[%d]: %s\n", section.name, i, strings.ReplaceAll(r.Regex, "\n", " "))
+			}
+		}
+	}
+	if skipped == 0 {
+		fmt.Fprintf(&buf, "# All the rules are supported by the user_agent processor.\n")
+	}
+	buf.WriteString("\n")
+	buf.Write(regexes)
+
+	if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*license, licenseText, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Vendored uap-core %s, %d rules skipped\n", *version, skipped)
+	return nil
+}
+
+func download(client *http.Client, name string) ([]byte, error) {
+	url := baseURL + "/" + *version + "/" + name
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -48,6 +48,9 @@ type commonInputConfig struct {
 		DisableHost bool `config:"disable_host"` // Disable addition of host.name.
 	} `config:"publisher_pipeline"`
 
+	// ID of the input, used to route the events of the input to a queue
+	ID string `config:"id"`
+
 	// implicit event fields
 	Type        string `config:"type"`         // input.type
 	ServiceType string `config:"service.type"` // service.type
@@ -187,6 +190,9 @@ func newCommonConfigEditor(
 		clientCfg.Processing.Processor = procs
 		clientCfg.Processing.KeepNull = config.KeepNull
 		clientCfg.Processing.DisableHost = config.PublisherPipeline.DisableHost
+		if clientCfg.InputID == "" {
+			clientCfg.InputID = config.ID
+		}
 
 		return clientCfg, nil
 	}, nil
@@ -0,0 +1,279 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/filebeat/beater"
+	"github.com/elastic/beats/v7/filebeat/config"
+	"github.com/elastic/beats/v7/filebeat/registrar"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/statestore"
+)
+
+func genRegistryCmd(settings instance.Settings) *cobra.Command {
+	registryCmd := cobra.Command{
+		Use:   "registry",
+		Short: "Inspect and edit the Filebeat registry",
+		Long: "Inspect and edit the states stored in the Filebeat registry. " +
+			"Filebeat must not be running.",
+	}
+	registryCmd.AddCommand(genRegistryListCmd(settings))
+	registryCmd.AddCommand(genRegistryGetCmd(settings))
+	registryCmd.AddCommand(genRegistryDeleteCmd(settings))
+	registryCmd.AddCommand(genRegistryResetCmd(settings))
+
+	return &registryCmd
+}
+
+func genRegistryListCmd(settings instance.Settings) *cobra.Command {
+	var filter registrar.EntryFilter
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List the registry entries",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store) error {
+				entries, err := registrar.ListEntries(store, filter)
+				if err != nil {
+					return err
+				}
+				return printEntries(cmd.OutOrStdout(), entries)
+			})
+		}),
+	}
+	addEntryFilterFlags(command, &filter)
+	return command
+}
+
+func genRegistryGetCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a registry entry as JSON",
+		Args:  cobra.ExactArgs(1),
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store) error {
+				entry, err := registrar.GetEntry(store, args[0])
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(entryDoc(entry))
+			})
+		}),
+	}
+}
+
+func genRegistryDeleteCmd(settings instance.Settings) *cobra.Command {
+	var filter registrar.EntryFilter
+	var all bool
+	command := &cobra.Command{
+		Use:   "delete [KEY...]",
+		Short: "Remove registry entries",
+		Long: "Remove the registry entries with the given keys or selected by the filters. " +
+			"The inputs will read the sources of the removed entries again from the start.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store) error {
+				entries, err := selectEntries(store, args, filter, all)
+				if err != nil {
+					return err
+				}
+				if err := registrar.RemoveEntries(store, entries); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Removed %d entries\n", len(entries))
+				return nil
+			})
+		}),
+	}
+	addEntryFilterFlags(command, &filter)
+	command.Flags().BoolVar(&all, "all", false, "Remove all entries")
+	return command
+}
+
+func genRegistryResetCmd(settings instance.Settings) *cobra.Command {
+	var filter registrar.EntryFilter
+	var all bool
+	command := &cobra.Command{
+		Use:   "reset [KEY...]",
+		Short: "Reset the offset of registry entries",
+		Long: "Reset the offset of the registry entries with the given keys or selected by the " +
+			"filters, so the inputs read their sources again from the start.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store) error {
+				entries, err := selectEntries(store, args, filter, all)
+				if err != nil {
+					return err
+				}
+				if err := registrar.ResetEntries(store, entries); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Reset %d entries\n", len(entries))
+				return nil
+			})
+		}),
+	}
+	addEntryFilterFlags(command, &filter)
+	command.Flags().BoolVar(&all, "all", false, "Reset all entries")
+	return command
+}
+
+func addEntryFilterFlags(command *cobra.Command, filter *registrar.EntryFilter) {
+	command.Flags().StringVar(&filter.InputID, "input-id", "", "Only select the entries of the input with this ID")
+	command.Flags().StringVar(&filter.Source, "source", "", "Only select the entries whose source path matches this path or glob pattern")
+	command.Flags().StringVar(&filter.KeyPrefix, "prefix", "", "Only select the entries whose key starts with this prefix")
+}
+
+// selectEntries returns the entries with the given keys, or the entries
+// selected by the filter. Selecting all entries requires all to be set.
+func selectEntries(store *statestore.Store, keys []string, filter registrar.EntryFilter, all bool) ([]registrar.Entry, error) {
+	if len(keys) == 0 {
+		if filter.IsEmpty() && !all {
+			return nil, errors.New("no entries selected, pass keys, filters or --all")
+		}
+		return registrar.ListEntries(store, filter)
+	}
+
+	var entries []registrar.Entry
+	for _, key := range keys {
+		entry, err := registrar.GetEntry(store, key)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// withRegistryStore opens the registry configured for Filebeat and calls fn
+// with the Filebeat store. The data path lock is held while fn runs, so the
+// registry is not modified by a running Filebeat.
+func withRegistryStore(settings instance.Settings, fn func(*statestore.Store) error) error {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return fmt.Errorf("error initializing beat: %w", err)
+	}
+
+	lock := locks.New(b.Info)
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("cannot access the registry while Filebeat is running: %w", err)
+	}
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	cfg := config.DefaultConfig
+	beatConfig, err := b.BeatConfig()
+	if err != nil {
+		return err
+	}
+	if err := beatConfig.Unpack(&cfg); err != nil {
+		return fmt.Errorf("error reading the configuration: %w", err)
+	}
+
+	reg, err := beater.NewRegistryBackend(b.Info.Logger, cfg.Registry)
+	if err != nil {
+		return err
+	}
+	registry := statestore.NewRegistry(reg)
+	defer registry.Close()
+
+	store, err := registry.Get(b.Info.Beat)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return fn(store)
+}
+
+func printEntries(w io.Writer, entries []registrar.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tINPUT ID\tSOURCE\tOFFSET\tUPDATED\tTTL")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Key, orDash(e.InputID), orDash(e.Source), offsetString(e.Offset),
+			timeString(e.Updated), ttlString(e.TTL))
+	}
+	return tw.Flush()
+}
+
+// entryDoc returns the JSON document printed for an entry by the get
+// command.
+func entryDoc(e registrar.Entry) map[string]interface{} {
+	doc := map[string]interface{}{
+		"key":   e.Key,
+		"ttl":   ttlString(e.TTL),
+		"value": e.Value,
+	}
+	if e.InputID != "" {
+		doc["input_id"] = e.InputID
+	}
+	if e.Source != "" {
+		doc["source"] = e.Source
+	}
+	if e.Offset != nil {
+		doc["offset"] = *e.Offset
+	}
+	if !e.Updated.IsZero() {
+		doc["updated"] = e.Updated
+	}
+	return doc
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func offsetString(offset *int64) string {
+	if offset == nil {
+		return "-"
+	}
+	return fmt.Sprint(*offset)
+}
+
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ttlString formats the TTL of an entry, a negative TTL means the entry
+// never expires.
+func ttlString(ttl time.Duration) string {
+	if ttl < 0 {
+		return "never"
+	}
+	return ttl.String()
+}
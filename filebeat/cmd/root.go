@@ -31,6 +31,7 @@ import (
 
 	// Import processors.
 	_ "github.com/elastic/beats/v7/libbeat/processors/cache"
+	_ "github.com/elastic/beats/v7/libbeat/processors/dedup"
 	_ "github.com/elastic/beats/v7/libbeat/processors/timestamp"
 )
 
@@ -70,5 +71,6 @@ func Filebeat(inputs beater.PluginFactory, settings instance.Settings) *cmd.Beat
 	command.SetupCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("modules"))
 	command.AddCommand(cmd.GenModulesCmd(Name, "", buildModulesManager))
 	command.AddCommand(genGenerateCmd())
+	command.AddCommand(genRegistryCmd(settings))
 	return command
 }
@@ -56,6 +56,7 @@ type Registry struct {
 	FlushTimeout  time.Duration `config:"flush"`
 	CleanInterval time.Duration `config:"cleanup_interval"`
 	MigrateFile   string        `config:"migrate_file"`
+	Backend       string        `config:"backend"`
 }
 
 var DefaultConfig = Config{
@@ -63,6 +64,7 @@ var DefaultConfig = Config{
 		Path:          "registry",
 		Permissions:   0o600,
 		MigrateFile:   "",
+		Backend:       "memlog",
 		CleanInterval: 5 * time.Minute,
 		FlushTimeout:  time.Second,
 	},
@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package filestream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionNone reads every file as plain text.
+	CompressionNone = "none"
+	// CompressionAuto detects compressed files by their magic bytes and
+	// decompresses them while reading.
+	CompressionAuto = "auto"
+
+	compressionGZIP = "gzip"
+	compressionZSTD = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression reads the first bytes of the file and returns the
+// compression format it is using. Files too short to contain a magic
+// number are treated as not compressed. The read offset of f is not changed.
+func detectCompression(f *os.File) (string, error) {
+	buf := make([]byte, len(zstdMagic))
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read magic bytes from %s: %w", f.Name(), err)
+	}
+	buf = buf[:n]
+
+	switch {
+	case bytes.HasPrefix(buf, gzipMagic):
+		return compressionGZIP, nil
+	case bytes.HasPrefix(buf, zstdMagic):
+		return compressionZSTD, nil
+	default:
+		return CompressionNone, nil
+	}
+}
+
+// decompressor exposes the decompressed content of a file. The offset it
+// keeps track of refers to the decompressed stream, it is the offset
+// stored in the registry for compressed files.
+type decompressor struct {
+	r      io.Reader
+	close  func() error
+	offset int64
+}
+
+// newDecompressor creates a decompressor reading from the beginning
+// of the compressed file f.
+func newDecompressor(compression string, f *os.File) (*decompressor, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case compressionGZIP:
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader for %s: %w", f.Name(), err)
+		}
+		return &decompressor{r: r, close: r.Close}, nil
+	case compressionZSTD:
+		r, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader for %s: %w", f.Name(), err)
+		}
+		return &decompressor{r: r, close: func() error { r.Close(); return nil }}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression '%s'", compression)
+	}
+}
+
+// isCompressedFile returns true if the file at path is compressed in a
+// format supported by the decompressor.
+func isCompressedFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	compression, err := detectCompression(f)
+	if err != nil {
+		return false, err
+	}
+	return compression != CompressionNone, nil
+}
+
+func (d *decompressor) Read(buf []byte) (int, error) {
+	n, err := d.r.Read(buf)
+	d.offset += int64(n)
+	return n, err
+}
+
+// skip discards the next n bytes of the decompressed stream. It returns
+// io.EOF if the stream ends before n bytes could be skipped.
+func (d *decompressor) skip(n int64) error {
+	skipped, err := io.CopyN(io.Discard, d, n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if skipped < n {
+		return io.EOF
+	}
+	return nil
+}
+
+func (d *decompressor) Close() error {
+	return d.close()
+}
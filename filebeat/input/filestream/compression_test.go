@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package filestream
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/reader/readfile/encoding"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestDecompressor(t *testing.T) {
+	content := []byte("first line\nsecond line\nthird line\n")
+
+	testCases := map[string]struct {
+		compress            func(t *testing.T, data []byte) []byte
+		expectedCompression string
+	}{
+		"plain": {
+			compress:            func(_ *testing.T, data []byte) []byte { return data },
+			expectedCompression: CompressionNone,
+		},
+		"gzip": {
+			compress:            gzipData,
+			expectedCompression: compressionGZIP,
+		},
+		"zstd": {
+			compress:            zstdData,
+			expectedCompression: compressionZSTD,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			f := writeTestFile(t, tc.compress(t, content))
+
+			compression, err := detectCompression(f)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedCompression, compression)
+
+			if compression == CompressionNone {
+				return
+			}
+
+			dec, err := newDecompressor(compression, f)
+			require.NoError(t, err)
+			defer dec.Close()
+
+			skip := int64(len("first line\n"))
+			require.NoError(t, dec.skip(skip))
+			require.Equal(t, skip, dec.offset)
+
+			rest, err := io.ReadAll(dec)
+			require.NoError(t, err)
+			require.Equal(t, content[skip:], rest)
+			require.Equal(t, int64(len(content)), dec.offset)
+
+			dec, err = newDecompressor(compression, f)
+			require.NoError(t, err)
+			defer dec.Close()
+			require.ErrorIs(t, dec.skip(int64(len(content)+1)), io.EOF)
+		})
+	}
+}
+
+func TestOpenFileIgnored(t *testing.T) {
+	content := []byte("first line\nsecond line\n")
+	testCases := map[string]func(t *testing.T, data []byte) []byte{
+		"plain": func(_ *testing.T, data []byte) []byte { return data },
+		"gzip":  gzipData,
+		"zstd":  zstdData,
+	}
+
+	for name, compress := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestFile(t, compress(t, content)).Name()
+			inp := &filestream{
+				readerConfig:    readerConfig{Compression: CompressionAuto},
+				encodingFactory: encoding.Plain,
+			}
+
+			f, dec, _, offset, err := inp.openFile(logptest.NewTestingLogger(t, ""), path, state{EOF: true})
+			require.NoError(t, err)
+			defer f.Close()
+			var src io.Reader = f
+			if dec != nil {
+				defer dec.Close()
+				src = dec
+			}
+			require.Equal(t, int64(len(content)), offset)
+
+			rest, err := io.ReadAll(src)
+			require.NoError(t, err)
+			require.Empty(t, rest)
+		})
+	}
+}
+
+func TestDetectCompressionShortFile(t *testing.T) {
+	f := writeTestFile(t, []byte{0x1f})
+
+	compression, err := detectCompression(f)
+	require.NoError(t, err)
+	require.Equal(t, CompressionNone, compression)
+}
+
+func writeTestFile(t *testing.T, data []byte) *os.File {
+	path := filepath.Join(t.TempDir(), "test.log")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, data []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer w.Close()
+	return w.EncodeAll(data, nil)
+}
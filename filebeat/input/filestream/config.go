@@ -81,6 +81,7 @@ type stateChangeCloserConfig struct {
 type readerConfig struct {
 	Backoff        backoffConfig           `config:"backoff"`
 	BufferSize     int                     `config:"buffer_size"`
+	Compression    string                  `config:"compression"`
 	Encoding       string                  `config:"encoding"`
 	ExcludeLines   []match.Matcher         `config:"exclude_lines"`
 	IncludeLines   []match.Matcher         `config:"include_lines"`
@@ -141,6 +142,7 @@ func defaultReaderConfig() readerConfig {
 			Max:  10 * time.Second,
 		},
 		BufferSize:     16 * humanize.KiByte,
+		Compression:    CompressionNone,
 		LineTerminator: readfile.AutoLineTerminator,
 		MaxBytes:       10 * humanize.MiByte,
 		Tail:           false,
@@ -168,6 +170,13 @@ func (c *config) Validate() error {
 		return errors.New("'take_over' mode is only allowed if an input ID is set")
 	}
 
+	switch c.Reader.Compression {
+	case "", CompressionNone, CompressionAuto:
+	default:
+		return fmt.Errorf("invalid compression '%s', supported values are '%s' and '%s'",
+			c.Reader.Compression, CompressionNone, CompressionAuto)
+	}
+
 	return nil
 }
 
@@ -55,6 +55,19 @@ func TestConfigValidate(t *testing.T) {
 		err := c.Validate()
 		assert.NoError(t, err)
 	})
+
+	t.Run("compression must be none or auto", func(t *testing.T) {
+		c := config{
+			Paths:  []string{"/foo/bar"},
+			Reader: readerConfig{Compression: "gzip"},
+		}
+		err := c.Validate()
+		assert.ErrorContains(t, err, "invalid compression 'gzip'")
+
+		c.Reader.Compression = CompressionAuto
+		err = c.Validate()
+		assert.NoError(t, err)
+	})
 }
 
 func TestValidateInputIDs(t *testing.T) {
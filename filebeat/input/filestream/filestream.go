@@ -41,7 +41,10 @@ var (
 
 // logFile contains all log related data
 type logFile struct {
-	file      *os.File
+	file *os.File
+	// dec is set for compressed files, data is then read from it
+	// instead of directly from file.
+	dec       *decompressor
 	log       *logp.Logger
 	readerCtx ctxtool.CancelContext
 
@@ -59,17 +62,25 @@ type logFile struct {
 	tg           *unison.TaskGroup
 }
 
-// newFileReader creates a new log instance to read log sources
+// newFileReader creates a new log instance to read log sources.
+// If dec is not nil, the decompressed content of f is read from it.
 func newFileReader(
 	log *logp.Logger,
 	canceler input.Canceler,
 	f *os.File,
+	dec *decompressor,
 	config readerConfig,
 	closerConfig closerConfig,
 ) (*logFile, error) {
-	offset, err := f.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, err
+	var offset int64
+	if dec != nil {
+		offset = dec.offset
+	} else {
+		var err error
+		offset, err = f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	readerCtx := ctxtool.WithCancelContext(ctxtool.FromCanceller(canceler))
@@ -77,6 +88,7 @@ func newFileReader(
 
 	l := &logFile{
 		file:               f,
+		dec:                dec,
 		log:                log,
 		closeAfterInterval: closerConfig.Reader.AfterInterval,
 		closeOnEOF:         closerConfig.Reader.OnEOF,
@@ -102,7 +114,7 @@ func (f *logFile) Read(buf []byte) (int, error) {
 	totalN := 0
 
 	for f.readerCtx.Err() == nil {
-		n, err := f.file.Read(buf)
+		n, err := f.read(buf)
 		if n > 0 {
 			f.offset += int64(n)
 			f.lastTimeRead = time.Now()
@@ -135,6 +147,13 @@ func (f *logFile) Read(buf []byte) (int, error) {
 	return 0, ErrClosed
 }
 
+func (f *logFile) read(buf []byte) (int, error) {
+	if f.dec != nil {
+		return f.dec.Read(buf)
+	}
+	return f.file.Read(buf)
+}
+
 func (f *logFile) startFileMonitoringIfNeeded() {
 	if f.closeInactive > 0 || f.closeRemoved || f.closeRenamed {
 		err := f.tg.Go(func(ctx context.Context) error {
@@ -254,8 +273,9 @@ func (f *logFile) handleEOF() error {
 		return statErr
 	}
 
-	// check if file was truncated
-	if info.Size() < f.offset {
+	// check if file was truncated, the offset of compressed files
+	// refers to the decompressed data, so it cannot be compared to the size
+	if f.dec == nil && info.Size() < f.offset {
 		f.log.Debugf("File was truncated as offset (%d) > size (%d): %s", f.offset, info.Size(), f.file.Name())
 		return ErrFileTruncate
 	}
@@ -266,6 +286,11 @@ func (f *logFile) handleEOF() error {
 // Close
 func (f *logFile) Close() error {
 	f.readerCtx.Cancel()
+	if f.dec != nil {
+		if err := f.dec.Close(); err != nil {
+			f.log.Errorf("Error closing decompressor of %s: %v", f.file.Name(), err)
+		}
+	}
 	err := f.file.Close()
 	_ = f.tg.Stop() // Wait until all resources are released for sure.
 	return err
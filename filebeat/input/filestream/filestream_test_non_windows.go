@@ -42,6 +42,7 @@ func TestLogFileRenamed(t *testing.T) {
 		logp.L(),
 		context.TODO(),
 		f,
+		nil,
 		readerConfig{},
 		closerConfig{
 			OnStateChange: stateChangeCloserConfig{
@@ -77,6 +78,7 @@ func TestLogFileRemoved(t *testing.T) {
 		logp.L(),
 		context.TODO(),
 		f,
+		nil,
 		readerConfig{},
 		closerConfig{
 			OnStateChange: stateChangeCloserConfig{
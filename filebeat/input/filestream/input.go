@@ -50,6 +50,11 @@ const pluginName = "filestream"
 
 type state struct {
 	Offset int64 `json:"offset" struct:"offset"`
+
+	// EOF is set for files ignored by the prospector, which are considered
+	// read until their end without knowing the offset of their end: the
+	// offsets of compressed files refer to their decompressed content.
+	EOF bool `json:"eof,omitempty" struct:"eof,omitempty"`
 }
 
 type fileMeta struct {
@@ -120,7 +125,7 @@ func (inp *filestream) Test(src loginp.Source, ctx input.TestContext) error {
 		return fmt.Errorf("not file source")
 	}
 
-	reader, _, err := inp.open(ctx.Logger, ctx.Cancelation, fs, 0)
+	reader, _, err := inp.open(ctx.Logger, ctx.Cancelation, fs, state{})
 	if err != nil {
 		return err
 	}
@@ -142,15 +147,12 @@ func (inp *filestream) Run(
 	log := ctx.Logger.With("path", fs.newPath).With("state-id", src.Name())
 	state := initState(log, cursor, fs)
 
-	r, truncated, err := inp.open(log, ctx.Cancelation, fs, state.Offset)
+	r, offset, err := inp.open(log, ctx.Cancelation, fs, state)
 	if err != nil {
 		log.Errorf("File could not be opened for reading: %v", err)
 		return err
 	}
-
-	if truncated {
-		state.Offset = 0
-	}
+	state.Offset, state.EOF = offset, false
 
 	metrics.FilesActive.Inc()
 	metrics.HarvesterRunning.Inc()
@@ -185,31 +187,34 @@ func initState(log *logp.Logger, c loginp.Cursor, s fileSource) state {
 	return state
 }
 
+// open returns a reader of the file starting at the offset of st, along
+// with the offset the reader actually starts at. It is 0 if the file was
+// truncated, and the end of the file if st is marked as read until EOF.
 func (inp *filestream) open(
 	log *logp.Logger,
 	canceler input.Canceler,
 	fs fileSource,
-	offset int64,
-) (reader.Reader, bool, error) {
+	st state,
+) (reader.Reader, int64, error) {
 
-	f, encoding, truncated, err := inp.openFile(log, fs.newPath, offset)
+	f, dec, encoding, offset, err := inp.openFile(log, fs.newPath, st)
 	if err != nil {
-		return nil, truncated, err
-	}
-
-	if truncated {
-		offset = 0
+		return nil, st.Offset, err
 	}
 
 	ok := false // used for cleanup
 	defer cleanup.IfNot(&ok, cleanup.IgnoreError(f.Close))
+	if dec != nil {
+		defer cleanup.IfNot(&ok, cleanup.IgnoreError(dec.Close))
+	}
 
 	log.Debug("newLogFileReader with config.MaxBytes:", inp.readerConfig.MaxBytes)
 
 	// if the file is archived, it means that it is not going to be updated in the future
-	// thus, when EOF is reached, it can be closed
+	// thus, when EOF is reached, it can be closed. The same applies to compressed
+	// files, data appended to them after EOF cannot be decompressed anyway.
 	closerCfg := inp.closerConfig
-	if fs.archived && !inp.closerConfig.Reader.OnEOF {
+	if (fs.archived || dec != nil) && !inp.closerConfig.Reader.OnEOF {
 		closerCfg = closerConfig{
 			Reader: readerCloserConfig{
 				OnEOF:         true,
@@ -221,14 +226,14 @@ func (inp *filestream) open(
 	// NewLineReader uses additional buffering to deal with encoding and testing
 	// for new lines in input stream. Simple 8-bit based encodings, or plain
 	// don't require 'complicated' logic.
-	logReader, err := newFileReader(log, canceler, f, inp.readerConfig, closerCfg)
+	logReader, err := newFileReader(log, canceler, f, dec, inp.readerConfig, closerCfg)
 	if err != nil {
-		return nil, truncated, err
+		return nil, offset, err
 	}
 
 	dbgReader, err := debug.AppendReaders(logReader)
 	if err != nil {
-		return nil, truncated, err
+		return nil, offset, err
 	}
 
 	// Configure MaxBytes limit for EncodeReader as multiplied by 4
@@ -245,7 +250,7 @@ func (inp *filestream) open(
 		MaxBytes:   encReaderMaxBytes,
 	})
 	if err != nil {
-		return nil, truncated, err
+		return nil, offset, err
 	}
 
 	r = readfile.NewStripNewline(r, inp.readerConfig.LineTerminator)
@@ -257,7 +262,7 @@ func (inp *filestream) open(
 	r = readfile.NewLimitReader(r, inp.readerConfig.MaxBytes)
 
 	ok = true // no need to close the file
-	return r, truncated, nil
+	return r, offset, nil
 }
 
 // openFile opens a file and checks for the encoding. In case the encoding cannot be detected
@@ -265,64 +270,135 @@ func (inp *filestream) open(
 // is returned and the harvester is closed. The file will be picked up again the next time
 // the file system is scanned.
 //
-// openFile will also detect and hadle file truncation. If a file is truncated
-// then the 3rd return value is true.
+// openFile will also detect and hadle file truncation. The 4th return value
+// is the offset the file is read from: the offset of st, 0 if the file was
+// truncated, or the end of the file if st is marked as read until EOF.
+//
+// If decompression is enabled and the file is compressed, a decompressor
+// reading the file is returned as well. The offset then refers to the
+// decompressed content of the file.
 func (inp *filestream) openFile(
 	log *logp.Logger,
 	path string,
-	offset int64,
-) (*os.File, encoding.Encoding, bool, error) {
+	st state,
+) (*os.File, *decompressor, encoding.Encoding, int64, error) {
+	offset := st.Offset
 	fi, err := os.Stat(path)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
+		return nil, nil, nil, 0, fmt.Errorf("failed to stat source file %s: %w", path, err)
 	}
 
 	// it must be checked if the file is not a named pipe before we try to open it
 	// if it is a named pipe os.OpenFile fails, so there is no need to try opening it.
 	if fi.Mode()&os.ModeNamedPipe != 0 {
-		return nil, nil, false, fmt.Errorf("failed to open file %s, named pipes are not supported", fi.Name())
+		return nil, nil, nil, 0, fmt.Errorf("failed to open file %s, named pipes are not supported", fi.Name())
 	}
 
 	f, err := file.ReadOpen(path)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed opening %s: %w", path, err)
+		return nil, nil, nil, 0, fmt.Errorf("failed opening %s: %w", path, err)
 	}
 	ok := false
 	defer cleanup.IfNot(&ok, cleanup.IgnoreError(f.Close))
 
 	fi, err = f.Stat()
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
+		return nil, nil, nil, 0, fmt.Errorf("failed to stat source file %s: %w", path, err)
 	}
 
 	err = checkFileBeforeOpening(fi)
 	if err != nil {
-		return nil, nil, false, err
+		return nil, nil, nil, 0, err
 	}
 
-	truncated := false
-	if fi.Size() < offset {
-		// if the file was truncated we need to reset the offset and notify
-		// all callers so they can also reset their offsets
-		truncated = true
-		log.Infof("File was truncated. Reading file from offset 0. Path=%s", path)
-		offset = 0
+	compression := CompressionNone
+	if inp.readerConfig.Compression == CompressionAuto {
+		compression, err = detectCompression(f)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
 	}
-	err = inp.initFileOffset(f, offset)
-	if err != nil {
-		return nil, nil, truncated, err
+
+	var (
+		dec *decompressor
+		src io.Reader = f
+	)
+	if compression != CompressionNone {
+		dec, err = inp.initDecompressor(log, f, compression, offset, st.EOF)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		defer cleanup.IfNot(&ok, cleanup.IgnoreError(dec.Close))
+		src = dec
+		offset = dec.offset
+	} else {
+		if st.EOF {
+			offset = fi.Size()
+		}
+		if fi.Size() < offset {
+			// if the file was truncated we need to reset the offset and notify
+			// all callers so they can also reset their offsets
+			log.Infof("File was truncated. Reading file from offset 0. Path=%s", path)
+			offset = 0
+		}
+		err = inp.initFileOffset(f, offset)
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
 	}
 
-	encoding, err := inp.encodingFactory(f)
+	encoding, err := inp.encodingFactory(src)
 	if err != nil {
 		if errors.Is(err, transform.ErrShortSrc) {
-			return nil, nil, truncated, fmt.Errorf("initialising encoding for '%v' failed due to file being too short", f)
+			return nil, nil, nil, 0, fmt.Errorf("initialising encoding for '%v' failed due to file being too short", f)
 		}
-		return nil, nil, truncated, fmt.Errorf("initialising encoding for '%v' failed: %w", f, err)
+		return nil, nil, nil, 0, fmt.Errorf("initialising encoding for '%v' failed: %w", f, err)
 	}
 
 	ok = true // no need to close the file
-	return f, encoding, truncated, nil
+	return f, dec, encoding, offset, nil
+}
+
+// initDecompressor creates a decompressor for the compressed file f and
+// skips the already ingested part of the decompressed content. Compressed
+// files cannot be seeked, so resuming from offset requires decompressing
+// everything before it. If eof is set, the whole content is skipped. If the
+// decompressed content is shorter than offset, the file is considered
+// truncated and is read from the beginning. The offset of the returned
+// decompressor is the offset the file is read from.
+func (inp *filestream) initDecompressor(
+	log *logp.Logger,
+	f *os.File,
+	compression string,
+	offset int64,
+	eof bool,
+) (*decompressor, error) {
+	dec, err := newDecompressor(compression, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if eof {
+		// The file was ignored by the prospector, only the data that may
+		// have been added since then is read.
+		if _, err := io.Copy(io.Discard, dec); err != nil {
+			_ = dec.Close()
+			return nil, fmt.Errorf("failed to seek to the end of compressed file %s: %w", f.Name(), err)
+		}
+		return dec, nil
+	}
+
+	err = dec.skip(offset)
+	if err == nil {
+		return dec, nil
+	}
+	_ = dec.Close()
+	if !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to seek to offset %d in compressed file %s: %w", offset, f.Name(), err)
+	}
+
+	log.Infof("Compressed file was truncated. Reading file from offset 0. Path=%s", f.Name())
+	return newDecompressor(compression, f)
 }
 
 func checkFileBeforeOpening(fi os.FileInfo) error {
@@ -1161,3 +1161,43 @@ func TestRotatingCloseInactiveLowWriteRate(t *testing.T) {
 	cancelInput()
 	env.waitUntilInputStops()
 }
+
+func TestFilestreamCompressedFileResume(t *testing.T) {
+	env := newInputTestingEnvironment(t)
+
+	testlogName := "test.log.gz"
+	id := "fake-ID-" + uuid.Must(uuid.NewV4()).String()
+	inp := env.mustCreateInput(map[string]interface{}{
+		"id":                                     id,
+		"paths":                                  []string{env.abspath(testlogName)},
+		"prospector.scanner.check_interval":      "1ms",
+		"prospector.scanner.fingerprint.enabled": false,
+		"file_identity.native":                   map[string]any{},
+		"compression":                            "auto",
+	})
+
+	testlines := []byte("first line\nsecond line\n")
+	env.mustWriteToFile(testlogName, gzipData(t, testlines))
+
+	ctx, cancelInput := context.WithCancel(context.Background())
+	env.startInput(ctx, id, inp)
+
+	env.waitUntilEventCount(2)
+	// the offset refers to the decompressed content
+	env.waitUntilOffsetInRegistry(testlogName, id, len(testlines), 10*time.Second)
+	// compressed files are closed on EOF
+	env.waitUntilHarvesterIsDone()
+
+	// the new harvester resumes from the offset in the registry,
+	// so only the new line is read
+	moreLines := append(testlines, []byte("third line\n")...)
+	env.mustWriteToFile(testlogName, gzipData(t, moreLines))
+
+	env.waitUntilEventCount(3)
+	env.waitUntilOffsetInRegistry(testlogName, id, len(moreLines), 10*time.Second)
+
+	cancelInput()
+	env.waitUntilInputStops()
+
+	env.requireEventsReceived([]string{"first line", "second line", "third line"})
+}
@@ -77,6 +77,7 @@ type fileProspector struct {
 	identifier          fileIdentifier
 	ignoreOlder         time.Duration
 	ignoreInactiveSince ignoreInactiveType
+	compression         string
 	cleanRemoved        bool
 	stateChangeCloser   stateChangeCloserConfig
 	takeOver            takeOverConfig
@@ -339,7 +340,7 @@ func (p *fileProspector) onFSEvent(
 		}
 
 		if p.isFileIgnored(log, event, ignoreSince) {
-			err := updater.ResetCursor(src, state{Offset: event.Descriptor.Info.Size()})
+			err := updater.ResetCursor(src, p.ignoredFileState(log, event))
 			if err != nil {
 				log.Errorf("setting cursor for ignored file: %v", err)
 			}
@@ -372,6 +373,23 @@ func (p *fileProspector) onFSEvent(
 	}
 }
 
+// ignoredFileState returns the cursor of an ignored file, marking it as
+// completely read. The offset of compressed files refers to their
+// decompressed content, so their size can't be used and they are marked as
+// read until EOF instead.
+func (p *fileProspector) ignoredFileState(log *logp.Logger, fe loginp.FSEvent) state {
+	if p.compression == CompressionAuto {
+		compressed, err := isCompressedFile(fe.NewPath)
+		if err != nil {
+			log.Warnf("Failed to check if ignored file %s is compressed: %v", fe.NewPath, err)
+		}
+		if compressed {
+			return state{EOF: true}
+		}
+	}
+	return state{Offset: fe.Descriptor.Info.Size()}
+}
+
 func (p *fileProspector) isFileIgnored(log *logp.Logger, fe loginp.FSEvent, ignoreInactiveSince time.Time) bool {
 	if p.ignoreOlder > 0 {
 		now := time.Now()
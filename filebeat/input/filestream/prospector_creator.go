@@ -61,6 +61,7 @@ func newProspector(config config) (loginp.Prospector, error) {
 		identifier:          identifier,
 		ignoreOlder:         config.IgnoreOlder,
 		ignoreInactiveSince: config.IgnoreInactive,
+		compression:         config.Reader.Compression,
 		cleanRemoved:        config.CleanRemoved,
 		stateChangeCloser:   config.Close.OnStateChange,
 		logger:              logger.Named("prospector"),
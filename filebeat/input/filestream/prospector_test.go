@@ -455,6 +455,45 @@ func TestProspectorHarvesterUpdateIgnoredFiles(t *testing.T) {
 	)
 }
 
+// TestProspectorIgnoredCompressedFile checks that compressed files ignored
+// because of ignore_older are marked as read until their end, as their size
+// is not an offset in their decompressed content.
+func TestProspectorIgnoredCompressedFile(t *testing.T) {
+	minuteAgo := time.Now().Add(-1 * time.Minute)
+	dir := t.TempDir()
+	content := []byte("first line\nsecond line\n")
+	compressedPath := filepath.Join(dir, "compressed.log.gz")
+	require.NoError(t, os.WriteFile(compressedPath, gzipData(t, content), 0o644))
+	plainPath := filepath.Join(dir, "plain.log")
+	require.NoError(t, os.WriteFile(plainPath, content, 0o644))
+
+	events := []loginp.FSEvent{
+		{
+			Op:         loginp.OpCreate,
+			NewPath:    compressedPath,
+			Descriptor: createTestFileDescriptorWithInfo(&testFileInfo{compressedPath, 40, minuteAgo, nil}),
+		},
+		{
+			Op:         loginp.OpCreate,
+			NewPath:    plainPath,
+			Descriptor: createTestFileDescriptorWithInfo(&testFileInfo{plainPath, int64(len(content)), minuteAgo, nil}),
+		},
+	}
+	p := fileProspector{
+		logger:      logp.L(),
+		filewatcher: newMockFileWatcher(events, len(events)),
+		identifier:  mustPathIdentifier(false),
+		ignoreOlder: 10 * time.Second,
+		compression: CompressionAuto,
+	}
+	ctx := input.Context{Logger: logp.L(), Cancelation: context.Background()}
+	testStore := newMockMetadataUpdater()
+	p.Run(ctx, testStore, newTestHarvesterGroup())
+
+	assert.Equal(t, state{EOF: true}, testStore.table["path::"+compressedPath])
+	assert.Equal(t, state{Offset: int64(len(content))}, testStore.table["path::"+plainPath])
+}
+
 func TestProspectorDeletedFile(t *testing.T) {
 	testCases := map[string]struct {
 		events       []loginp.FSEvent
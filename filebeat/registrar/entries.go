@@ -0,0 +1,230 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package registrar
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/v7/filebeat/input/file"
+	"github.com/elastic/beats/v7/libbeat/common/transform/typeconv"
+	"github.com/elastic/beats/v7/libbeat/statestore"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// Entry is a registry entry with the fields of the states of the log input
+// and of the inputs based on input-cursor or input-logfile decoded.
+type Entry struct {
+	Key string
+
+	// InputID is the ID of the input the entry belongs to. It is only known
+	// for cursor states.
+	InputID string
+
+	// Source is the path of the file read by the input, if known.
+	Source string
+
+	// Offset is the offset of the next byte to read in the source, or nil if
+	// the state has no offset.
+	Offset *int64
+
+	TTL     time.Duration
+	Updated time.Time
+
+	// Value is the raw value stored in the registry.
+	Value mapstr.M
+}
+
+// EntryFilter selects registry entries. Empty fields match all entries.
+type EntryFilter struct {
+	InputID string
+
+	// Source is a path or a glob pattern matched against the source of the
+	// entries.
+	Source string
+
+	KeyPrefix string
+}
+
+// cursorState is the document written by input-cursor and input-logfile.
+type cursorState struct {
+	TTL     time.Duration
+	Updated time.Time
+	Cursor  interface{}
+	Meta    interface{}
+}
+
+// IsEmpty returns true if the filter matches all entries.
+func (f EntryFilter) IsEmpty() bool {
+	return f.InputID == "" && f.Source == "" && f.KeyPrefix == ""
+}
+
+// Match returns true if the entry is selected by the filter.
+func (f EntryFilter) Match(e Entry) bool {
+	if f.KeyPrefix != "" && !strings.HasPrefix(e.Key, f.KeyPrefix) {
+		return false
+	}
+	if f.InputID != "" && e.InputID != f.InputID {
+		return false
+	}
+	if f.Source != "" {
+		if e.Source == "" {
+			return false
+		}
+		if e.Source != f.Source {
+			matched, err := filepath.Match(f.Source, e.Source)
+			if err != nil || !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsCursorState returns true if the entry was written by input-cursor or
+// input-logfile, like the states of the filestream input.
+func (e Entry) IsCursorState() bool {
+	return !strings.HasPrefix(e.Key, fileStatePrefix)
+}
+
+// DecodeEntry reads the registry entry stored under key.
+func DecodeEntry(key string, dec statestore.ValueDecoder) (Entry, error) {
+	entry := Entry{Key: key}
+	if err := dec.Decode(&entry.Value); err != nil {
+		return entry, fmt.Errorf("failed to decode registry entry '%v': %w", key, err)
+	}
+
+	if !entry.IsCursorState() {
+		var st file.State
+		if err := typeconv.Convert(&st, entry.Value); err != nil {
+			return entry, fmt.Errorf("failed to decode log input state '%v': %w", key, err)
+		}
+		offset := st.Offset
+		entry.Source = st.Source
+		entry.Offset = &offset
+		entry.TTL = st.TTL
+		entry.Updated = st.Timestamp
+		return entry, nil
+	}
+
+	var st cursorState
+	if err := typeconv.Convert(&st, entry.Value); err != nil {
+		return entry, fmt.Errorf("failed to decode cursor state '%v': %w", key, err)
+	}
+	entry.TTL = st.TTL
+	entry.Updated = st.Updated
+
+	// Cursor keys have the format '<input type>::<input ID>::<source ID>'.
+	if parts := strings.SplitN(key, "::", 3); len(parts) == 3 {
+		entry.InputID = parts[1]
+	}
+
+	var meta struct {
+		Source string `struct:"source"`
+	}
+	if st.Meta != nil {
+		if err := typeconv.Convert(&meta, st.Meta); err == nil {
+			entry.Source = meta.Source
+		}
+	}
+
+	var cursor struct {
+		Offset *int64 `struct:"offset"`
+	}
+	if _, ok := st.Cursor.(map[string]interface{}); ok {
+		if err := typeconv.Convert(&cursor, st.Cursor); err == nil {
+			entry.Offset = cursor.Offset
+		}
+	}
+	return entry, nil
+}
+
+// ListEntries returns the entries of the store selected by the filter,
+// ordered by key.
+func ListEntries(store *statestore.Store, filter EntryFilter) ([]Entry, error) {
+	var entries []Entry
+	err := store.Each(func(key string, dec statestore.ValueDecoder) (bool, error) {
+		if filter.KeyPrefix != "" && !strings.HasPrefix(key, filter.KeyPrefix) {
+			return true, nil
+		}
+		entry, err := DecodeEntry(key, dec)
+		if err != nil {
+			return false, err
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// GetEntry returns the entry stored under key.
+func GetEntry(store *statestore.Store, key string) (Entry, error) {
+	var value mapstr.M
+	if err := store.Get(key, &value); err != nil {
+		return Entry{}, err
+	}
+	return DecodeEntry(key, valueDecoder{value})
+}
+
+// RemoveEntries removes the entries from the store.
+func RemoveEntries(store *statestore.Store, entries []Entry) error {
+	for _, e := range entries {
+		if err := store.Remove(e.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetEntries resets the position of the entries, so the inputs read their
+// sources again from the start. The cursor of cursor states is removed, and
+// the offset of log input states is set to 0. Other fields are kept.
+func ResetEntries(store *statestore.Store, entries []Entry) error {
+	for _, e := range entries {
+		value := e.Value.Clone()
+		if e.IsCursorState() {
+			value["cursor"] = nil
+		} else {
+			value["offset"] = 0
+		}
+		if err := store.Set(e.Key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type valueDecoder struct {
+	value mapstr.M
+}
+
+func (d valueDecoder) Decode(to interface{}) error {
+	return typeconv.Convert(to, d.value)
+}
@@ -0,0 +1,182 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package registrar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/filebeat/input/file"
+	"github.com/elastic/beats/v7/libbeat/statestore"
+	"github.com/elastic/beats/v7/libbeat/statestore/storetest"
+)
+
+type testCursorState struct {
+	TTL     time.Duration
+	Updated time.Time
+	Cursor  interface{}
+	Meta    interface{}
+}
+
+type testCursor struct {
+	Offset int64 `struct:"offset"`
+	EOF    bool  `struct:"eof"`
+}
+
+type testMeta struct {
+	Source         string `struct:"source"`
+	IdentifierName string `struct:"identifier_name"`
+}
+
+func openTestEntriesStore(t *testing.T) *statestore.Store {
+	updated := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	reg := statestore.NewRegistry(storetest.NewMemoryStoreBackend())
+	t.Cleanup(func() { reg.Close() })
+	store, err := reg.Get("filebeat")
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	require.NoError(t, store.Set("filestream::my-input::native::1-2", testCursorState{
+		TTL:     -1,
+		Updated: updated,
+		Cursor:  testCursor{Offset: 42},
+		Meta:    testMeta{Source: "/var/log/a.log", IdentifierName: "native"},
+	}))
+	require.NoError(t, store.Set("filestream::other::native::3-4", testCursorState{
+		TTL:     time.Hour,
+		Updated: updated,
+		Cursor:  nil,
+		Meta:    testMeta{Source: "/var/log/b.log", IdentifierName: "native"},
+	}))
+	require.NoError(t, store.Set(fileStatePrefix+"native::5-6", file.State{
+		Id:        "native::5-6",
+		Source:    "/var/log/c.log",
+		Offset:    7,
+		TTL:       -1,
+		Timestamp: updated,
+		Type:      "log",
+	}))
+	return store
+}
+
+func TestListEntries(t *testing.T) {
+	store := openTestEntriesStore(t)
+
+	entries, err := ListEntries(store, EntryFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	byKey := map[string]Entry{}
+	for _, e := range entries {
+		byKey[e.Key] = e
+	}
+
+	e := byKey["filestream::my-input::native::1-2"]
+	assert.Equal(t, "my-input", e.InputID)
+	assert.Equal(t, "/var/log/a.log", e.Source)
+	require.NotNil(t, e.Offset)
+	assert.Equal(t, int64(42), *e.Offset)
+	assert.Equal(t, time.Duration(-1), e.TTL)
+	assert.True(t, e.IsCursorState())
+
+	e = byKey["filestream::other::native::3-4"]
+	assert.Nil(t, e.Offset)
+	assert.Equal(t, time.Hour, e.TTL)
+
+	e = byKey[fileStatePrefix+"native::5-6"]
+	assert.False(t, e.IsCursorState())
+	assert.Equal(t, "", e.InputID)
+	assert.Equal(t, "/var/log/c.log", e.Source)
+	require.NotNil(t, e.Offset)
+	assert.Equal(t, int64(7), *e.Offset)
+
+	testCases := map[string]struct {
+		filter EntryFilter
+		keys   []string
+	}{
+		"input ID": {
+			filter: EntryFilter{InputID: "my-input"},
+			keys:   []string{"filestream::my-input::native::1-2"},
+		},
+		"source": {
+			filter: EntryFilter{Source: "/var/log/c.log"},
+			keys:   []string{fileStatePrefix + "native::5-6"},
+		},
+		"source pattern": {
+			filter: EntryFilter{Source: "/var/log/[ab].log"},
+			keys:   []string{"filestream::my-input::native::1-2", "filestream::other::native::3-4"},
+		},
+		"key prefix": {
+			filter: EntryFilter{KeyPrefix: "filebeat::"},
+			keys:   []string{fileStatePrefix + "native::5-6"},
+		},
+		"no match": {
+			filter: EntryFilter{InputID: "other", Source: "/var/log/a.log"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			entries, err := ListEntries(store, tc.filter)
+			require.NoError(t, err)
+			var keys []string
+			for _, e := range entries {
+				keys = append(keys, e.Key)
+			}
+			assert.Equal(t, tc.keys, keys)
+		})
+	}
+}
+
+func TestResetEntries(t *testing.T) {
+	store := openTestEntriesStore(t)
+
+	entries, err := ListEntries(store, EntryFilter{Source: "/var/log/[ac].log"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.NoError(t, ResetEntries(store, entries))
+
+	e, err := GetEntry(store, "filestream::my-input::native::1-2")
+	require.NoError(t, err)
+	assert.Nil(t, e.Offset)
+	assert.Equal(t, "/var/log/a.log", e.Source, "metadata must be kept")
+
+	e, err = GetEntry(store, fileStatePrefix+"native::5-6")
+	require.NoError(t, err)
+	require.NotNil(t, e.Offset)
+	assert.Equal(t, int64(0), *e.Offset)
+	assert.Equal(t, "/var/log/c.log", e.Source)
+}
+
+func TestRemoveEntries(t *testing.T) {
+	store := openTestEntriesStore(t)
+
+	entries, err := ListEntries(store, EntryFilter{KeyPrefix: "filestream::"})
+	require.NoError(t, err)
+	require.NoError(t, RemoveEntries(store, entries))
+
+	entries, err = ListEntries(store, EntryFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, fileStatePrefix+"native::5-6", entries[0].Key)
+
+	_, err = GetEntry(store, "filestream::my-input::native::1-2")
+	assert.Error(t, err)
+}
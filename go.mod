@@ -209,6 +209,7 @@ require (
 	github.com/prometheus/prometheus v0.300.1
 	github.com/shirou/gopsutil/v4 v4.25.1
 	github.com/teambition/rrule-go v1.8.2
+	github.com/tetratelabs/wazero v1.10.1
 	github.com/tklauser/go-sysconf v0.3.12
 	github.com/xdg-go/scram v1.1.2
 	github.com/zyedidia/generic v1.2.1
@@ -0,0 +1,497 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+
+	// metricsPrefix is prepended to the names of all the metrics.
+	metricsPrefix = "beat"
+	// inputMetricsPrefix is prepended to the names of the metrics of inputs.
+	inputMetricsPrefix = "beat_input"
+)
+
+// Metric types as written in the TYPE lines.
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+	typeSummary = "summary"
+	typeInfo    = "info"
+	typeUnknown = "unknown"
+)
+
+// gaugeNames are the names of integer metrics, or the last part of their
+// name, that are gauges. The monitoring registries don't record the type of
+// their metrics, integer metrics that are neither known gauges nor known
+// counters are exposed with the unknown type.
+var gaugeNames = []string{
+	"active",
+	"clients",
+	"current",
+	"goroutines",
+	"open",
+	"open_files",
+	"running",
+	"size",
+	"batch_size",
+	"read_offset",
+	"max_events",
+	"max_bytes",
+	"filled.events",
+	"filled.bytes",
+	"memstats.gc_next",
+	"memstats.memory_alloc",
+	"memstats.memory_sys",
+	"memstats.rss",
+	"handles.limit.hard",
+	"handles.limit.soft",
+}
+
+// gaugeSuffixes are the suffixes of the last part of the name of integer
+// metrics that are gauges.
+var gaugeSuffixes = []string{"_gauge", "_active", "_length", "_waiting"}
+
+// counterNames are the names of integer metrics, or the last part of their
+// name, that are counters.
+var counterNames = []string{
+	"acked",
+	"batches",
+	"dropped",
+	"duplicates",
+	"errors",
+	"failed",
+	"filtered",
+	"published",
+	"retry",
+	"toomany",
+	"total",
+	"ticks",
+	"read.bytes",
+	"write.bytes",
+	"info.uptime.ms",
+}
+
+// counterSuffixes are the suffixes of the last part of the name of integer
+// metrics that are counters.
+var counterSuffixes = []string{"_total", "_count"}
+
+// histogramQuantiles maps the percentiles reported by histograms to the
+// quantiles of the summaries.
+var histogramQuantiles = []struct {
+	key      string
+	quantile string
+}{
+	{"median", "0.5"},
+	{"p75", "0.75"},
+	{"p95", "0.95"},
+	{"p99", "0.99"},
+	{"p999", "0.999"},
+}
+
+type metricFamily struct {
+	name    string
+	typ     string
+	samples []metricSample
+}
+
+type metricSample struct {
+	suffix string
+	labels string
+	value  string
+}
+
+// metricSet collects the metric families rendered by the /metrics route,
+// by name and type.
+type metricSet struct {
+	families map[string]map[string]*metricFamily
+}
+
+// flatValue is a value reported by a registry, along with the path of the
+// variable holding it.
+type flatValue struct {
+	name  string
+	value interface{}
+}
+
+// makeMetricsHandler serves the beat info, the stats registry and the
+// metrics of the inputs in the dataset registry in the OpenMetrics text
+// format, or in the Prometheus text format for clients that don't accept
+// OpenMetrics.
+func makeMetricsHandler(info, stats, dataset *monitoring.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+
+		set := &metricSet{families: map[string]map[string]*metricFamily{}}
+		set.addInfo(info)
+		set.addRegistry(stats, metricsPrefix, "")
+		set.addInputs(dataset)
+
+		if openMetrics {
+			w.Header().Set("Content-Type", openMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", textContentType)
+		}
+		bw := bufio.NewWriter(w)
+		set.write(bw, openMetrics)
+		_ = bw.Flush()
+	}
+}
+
+// addInfo adds the beat_info metric, labelled with the string values of the
+// info registry.
+func (s *metricSet) addInfo(reg *monitoring.Registry) {
+	if reg == nil {
+		return
+	}
+
+	var labels []string
+	for _, v := range collectValues(reg) {
+		if str, ok := v.value.(string); ok && !strings.Contains(v.name, ".") {
+			labels = append(labels, formatLabel(sanitizeName(v.name), str))
+		}
+	}
+	sort.Strings(labels)
+	s.add(metricsPrefix, typeInfo, metricSample{
+		suffix: "_info",
+		labels: strings.Join(labels, ","),
+		value:  "1",
+	})
+}
+
+// addInputs adds the metrics of every input registered in the dataset
+// registry, labelled with the ID and type of the input.
+func (s *metricSet) addInputs(reg *monitoring.Registry) {
+	if reg == nil {
+		return
+	}
+
+	inputs := map[string][]flatValue{}
+	for _, v := range collectValues(reg) {
+		id, name, found := strings.Cut(v.name, ".")
+		if !found {
+			continue
+		}
+		inputs[id] = append(inputs[id], flatValue{name: name, value: v.value})
+	}
+
+	for key, values := range inputs {
+		var inputID, inputType string
+		for _, v := range values {
+			switch v.name {
+			case "id":
+				inputID, _ = v.value.(string)
+			case "input":
+				inputType, _ = v.value.(string)
+			}
+		}
+		// Only registries created by inputmon have an ID.
+		if inputID == "" {
+			continue
+		}
+
+		labels := formatLabel("input_id", inputID) + "," + formatLabel("input_type", inputType)
+		s.addValues(reg.GetRegistry(key), values, inputMetricsPrefix, labels)
+	}
+}
+
+// addRegistry adds the numeric metrics of the registry, prefixing their
+// name with prefix.
+func (s *metricSet) addRegistry(reg *monitoring.Registry, prefix, labels string) {
+	if reg == nil {
+		return
+	}
+	s.addValues(reg, collectValues(reg), prefix, labels)
+}
+
+func (s *metricSet) addValues(reg *monitoring.Registry, values []flatValue, prefix, labels string) {
+	composites := map[string][]flatValue{}
+	for _, v := range values {
+		if parent, ok := compositeName(reg, v.name); ok {
+			composites[parent] = append(composites[parent], v)
+			continue
+		}
+		s.addValue(prefix, labels, v)
+	}
+
+	for parent, fields := range composites {
+		if !s.addHistogram(prefix, labels, parent, fields) {
+			for _, v := range fields {
+				s.addValue(prefix, labels, v)
+			}
+		}
+	}
+}
+
+func (s *metricSet) addValue(prefix, labels string, v flatValue) {
+	value, isInt, ok := formatValue(v.value)
+	if !ok {
+		return
+	}
+
+	name := metricName(prefix, v.name)
+	switch {
+	case !isInt || matchesName(v.name, gaugeNames, gaugeSuffixes):
+		s.add(name, typeGauge, metricSample{labels: labels, value: value})
+	case matchesName(v.name, counterNames, counterSuffixes):
+		s.add(strings.TrimSuffix(name, "_total"), typeCounter, metricSample{suffix: "_total", labels: labels, value: value})
+	default:
+		s.add(name, typeUnknown, metricSample{labels: labels, value: value})
+	}
+}
+
+// addHistogram adds the values reported by a histogram of the monitoring
+// adapter as a summary. It returns false if the values are not the ones of
+// a histogram. The summary has no sum, the histograms only keep the sum of
+// their sampled values, which doesn't match their count.
+func (s *metricSet) addHistogram(prefix, labels, histogram string, values []flatValue) bool {
+	fields := make(map[string]interface{}, len(values))
+	for _, v := range values {
+		fields[strings.TrimPrefix(v.name, histogram+".")] = v.value
+	}
+	if _, ok := fields["count"]; !ok {
+		return false
+	}
+	if _, ok := fields["p99"]; !ok {
+		return false
+	}
+
+	name := metricName(prefix, histogram)
+	for _, q := range histogramQuantiles {
+		if value, _, ok := formatValue(fields[q.key]); ok {
+			s.add(name, typeSummary, metricSample{labels: joinLabels(labels, formatLabel("quantile", q.quantile)), value: value})
+		}
+	}
+	if value, _, ok := formatValue(fields["count"]); ok {
+		s.add(name, typeSummary, metricSample{suffix: "_count", labels: labels, value: value})
+	}
+	return true
+}
+
+// add adds a sample to a metric family.
+func (s *metricSet) add(name, typ string, sample metricSample) {
+	types, ok := s.families[name]
+	if !ok {
+		types = map[string]*metricFamily{}
+		s.families[name] = types
+	}
+	family, ok := types[typ]
+	if !ok {
+		family = &metricFamily{name: name, typ: typ}
+		types[typ] = family
+	}
+	family.samples = append(family.samples, sample)
+}
+
+// sortedFamilies returns the metric families sorted by name. When metrics
+// of different types share a name, the family of the first type in
+// alphabetical order keeps the name and the name of the other families is
+// suffixed with their type.
+func (s *metricSet) sortedFamilies() []*metricFamily {
+	var families []*metricFamily
+	for name, types := range s.families {
+		typs := make([]string, 0, len(types))
+		for typ := range types {
+			typs = append(typs, typ)
+		}
+		sort.Strings(typs)
+		for i, typ := range typs {
+			family := types[typ]
+			if i > 0 {
+				family.name = name + "_" + typ
+			}
+			families = append(families, family)
+		}
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	return families
+}
+
+func (s *metricSet) write(w io.Writer, openMetrics bool) {
+	for _, family := range s.sortedFamilies() {
+		sort.SliceStable(family.samples, func(i, j int) bool {
+			if family.samples[i].suffix != family.samples[j].suffix {
+				return family.samples[i].suffix < family.samples[j].suffix
+			}
+			return family.samples[i].labels < family.samples[j].labels
+		})
+
+		typeName, typ := family.name, family.typ
+		if !openMetrics {
+			// The Prometheus text format has no info type, names the
+			// unknown type untyped, and the TYPE of counters uses the name
+			// of their samples.
+			switch typ {
+			case typeInfo:
+				typeName, typ = family.name+"_info", typeGauge
+			case typeUnknown:
+				typ = "untyped"
+			case typeCounter:
+				typeName = family.name + "_total"
+			}
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", typeName, typ)
+
+		for _, sample := range family.samples {
+			if sample.labels == "" {
+				fmt.Fprintf(w, "%s%s %s\n", family.name, sample.suffix, sample.value)
+			} else {
+				fmt.Fprintf(w, "%s%s{%s} %s\n", family.name, sample.suffix, sample.labels, sample.value)
+			}
+		}
+	}
+
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
+
+// collectValues returns all the values reported by the registry, sorted by
+// name.
+func collectValues(reg *monitoring.Registry) []flatValue {
+	var values []flatValue
+	reg.Do(monitoring.Full, func(name string, value interface{}) {
+		values = append(values, flatValue{name: name, value: value})
+	})
+	sort.Slice(values, func(i, j int) bool { return values[i].name < values[j].name })
+	return values
+}
+
+// compositeName checks if the value is reported by a variable reporting
+// several values, like the histograms of the monitoring adapter, and
+// returns the name of that variable.
+func compositeName(reg *monitoring.Registry, name string) (string, bool) {
+	if reg == nil || reg.Get(name) != nil {
+		return "", false
+	}
+	idx := strings.LastIndexByte(name, '.')
+	if idx < 0 {
+		return "", false
+	}
+	parent := name[:idx]
+	v := reg.Get(parent)
+	if _, isRegistry := v.(*monitoring.Registry); v == nil || isRegistry {
+		return "", false
+	}
+	return parent, true
+}
+
+// matchesName checks if the name of a metric, or its last parts, is one of
+// names, or if the last part of its name ends with one of suffixes.
+func matchesName(name string, names, suffixes []string) bool {
+	for _, n := range names {
+		if name == n || strings.HasSuffix(name, "."+n) {
+			return true
+		}
+	}
+	leaf := name[strings.LastIndexByte(name, '.')+1:]
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(leaf, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue formats a numeric or boolean value. It reports whether the
+// value is an integer, and false if the value is not a number.
+func formatValue(v interface{}) (string, bool, bool) {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true, true
+	case uint64:
+		return strconv.FormatUint(v, 10), true, true
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN", false, true
+		case math.IsInf(v, 1):
+			return "+Inf", false, true
+		case math.IsInf(v, -1):
+			return "-Inf", false, true
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), false, true
+	case bool:
+		if v {
+			return "1", false, true
+		}
+		return "0", false, true
+	}
+	return "", false, false
+}
+
+// metricName builds a valid metric name from a registry path. The beat
+// registry of the stats is not prefixed a second time.
+func metricName(prefix, name string) string {
+	if name == prefix || strings.HasPrefix(name, prefix+".") {
+		return sanitizeName(name)
+	}
+	return prefix + "_" + sanitizeName(name)
+}
+
+// sanitizeName replaces the characters not allowed in metric and label
+// names by underscores.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(name, value string) string {
+	return name + `="` + labelValueReplacer.Replace(value) + `"`
+}
+
+func joinLabels(labels ...string) string {
+	var nonEmpty []string
+	for _, l := range labels {
+		if l != "" {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
@@ -0,0 +1,153 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/monitoring/adapter"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	info := monitoring.NewRegistry()
+	monitoring.NewString(info, "beat").Set("testbeat")
+	monitoring.NewString(info, "version").Set("9.9.9")
+
+	stats := monitoring.NewRegistry()
+	monitoring.NewUint(stats, "libbeat.output.events.acked").Set(42)
+	monitoring.NewUint(stats, "libbeat.output.events.active").Set(3)
+	monitoring.NewInt(stats, "libbeat.config.module.running").Set(2)
+	monitoring.NewFloat(stats, "system.load.1").Set(0.5)
+	monitoring.NewInt(stats, "beat.info.uptime.ms").Set(1000)
+	monitoring.NewInt(stats, "libbeat.output.write.latency").Set(12)
+	monitoring.NewFunc(stats, "beat.memstats", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		monitoring.ReportInt(v, "memory_total", 100)
+		monitoring.ReportInt(v, "rss", 10)
+	})
+
+	dataset := monitoring.NewRegistry()
+	input := dataset.NewRegistry("my-input")
+	monitoring.NewString(input, "id").Set("my-input")
+	monitoring.NewString(input, "input").Set("filestream")
+	monitoring.NewUint(input, "events_processed_total").Set(7)
+	monitoring.NewUint(input, "files_active").Set(1)
+	sample := metrics.NewUniformSample(10)
+	sample.Update(4)
+	sample.Update(6)
+	_ = adapter.NewGoMetrics(input, "processing_time", adapter.Accept).Register("histogram", metrics.NewHistogram(sample))
+	other := dataset.NewRegistry("other")
+	monitoring.NewUint(other, "events_processed_total").Set(1)
+
+	handler := makeMetricsHandler(info, stats, dataset)
+
+	t.Run("openmetrics", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		assert.Equal(t, openMetricsContentType, rec.Header().Get("Content-Type"))
+		body, err := io.ReadAll(rec.Body)
+		require.NoError(t, err)
+		assert.Equal(t, `# TYPE beat info
+beat_info{beat="testbeat",version="9.9.9"} 1
+# TYPE beat_info_uptime_ms counter
+beat_info_uptime_ms_total 1000
+# TYPE beat_input_events_processed counter
+beat_input_events_processed_total{input_id="my-input",input_type="filestream"} 7
+# TYPE beat_input_files_active gauge
+beat_input_files_active{input_id="my-input",input_type="filestream"} 1
+# TYPE beat_input_processing_time_histogram summary
+beat_input_processing_time_histogram{input_id="my-input",input_type="filestream",quantile="0.5"} 5
+beat_input_processing_time_histogram{input_id="my-input",input_type="filestream",quantile="0.75"} 6
+beat_input_processing_time_histogram{input_id="my-input",input_type="filestream",quantile="0.95"} 6
+beat_input_processing_time_histogram{input_id="my-input",input_type="filestream",quantile="0.99"} 6
+beat_input_processing_time_histogram{input_id="my-input",input_type="filestream",quantile="0.999"} 6
+beat_input_processing_time_histogram_count{input_id="my-input",input_type="filestream"} 2
+# TYPE beat_libbeat_config_module_running gauge
+beat_libbeat_config_module_running 2
+# TYPE beat_libbeat_output_events_acked counter
+beat_libbeat_output_events_acked_total 42
+# TYPE beat_libbeat_output_events_active gauge
+beat_libbeat_output_events_active 3
+# TYPE beat_libbeat_output_write_latency unknown
+beat_libbeat_output_write_latency 12
+# TYPE beat_memstats_memory counter
+beat_memstats_memory_total 100
+# TYPE beat_memstats_rss gauge
+beat_memstats_rss 10
+# TYPE beat_system_load_1 gauge
+beat_system_load_1 0.5
+# EOF
+`, string(body))
+	})
+
+	t.Run("prometheus text", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, textContentType, rec.Header().Get("Content-Type"))
+		body := rec.Body.String()
+		assert.Contains(t, body, "# TYPE beat_info gauge\nbeat_info{")
+		assert.Contains(t, body, "# TYPE beat_libbeat_output_events_acked_total counter\nbeat_libbeat_output_events_acked_total 42\n")
+		assert.Contains(t, body, "# TYPE beat_libbeat_output_write_latency untyped\nbeat_libbeat_output_write_latency 12\n")
+		assert.NotContains(t, body, "# EOF")
+	})
+}
+
+func TestMetricsNameCollisions(t *testing.T) {
+	stats := monitoring.NewRegistry()
+	monitoring.NewInt(stats, "queue.latency").Set(3)
+	monitoring.NewFloat(stats, "queue_latency").Set(0.5)
+	monitoring.NewUint(stats, "events_total").Set(7)
+	monitoring.NewInt(stats, "events").Set(2)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	makeMetricsHandler(nil, stats, nil)(rec, req)
+
+	assert.Equal(t, `# TYPE beat_events counter
+beat_events_total 7
+# TYPE beat_events_unknown unknown
+beat_events_unknown 2
+# TYPE beat_queue_latency gauge
+beat_queue_latency 0.5
+# TYPE beat_queue_latency_unknown unknown
+beat_queue_latency_unknown 3
+# EOF
+`, rec.Body.String())
+}
+
+func TestMetricsNames(t *testing.T) {
+	assert.Equal(t, "beat_libbeat_pipeline_queue_filled_pct", metricName(metricsPrefix, "libbeat.pipeline.queue.filled.pct"))
+	assert.Equal(t, "beat_cpu_total_ticks", metricName(metricsPrefix, "beat.cpu.total.ticks"))
+	assert.Equal(t, "beat_input_http_request_2xx_total", metricName(inputMetricsPrefix, "http-request.2xx_total"))
+	assert.Equal(t, "_1m", sanitizeName("1m"))
+	assert.Equal(t, `id="a \"b\"\\\n"`, formatLabel("id", "a \"b\"\\\n"))
+}
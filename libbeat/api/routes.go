@@ -58,6 +58,7 @@ func NewWithDefaultRoutes(log *logp.Logger, config *config.C, reg LookupFunc) (*
 		api.AttachHandler("/state", makeAPIHandler(reg("state"))),
 		api.AttachHandler("/stats", makeAPIHandler(reg("stats"))),
 		api.AttachHandler("/dataset", makeAPIHandler(reg("dataset"))),
+		api.AttachHandler("/metrics", makeMetricsHandler(reg("info"), reg("stats"), reg("dataset"))),
 	)
 	if err != nil {
 		return nil, err
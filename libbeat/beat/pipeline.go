@@ -47,6 +47,10 @@ type Client interface {
 type ClientConfig struct {
 	PublishMode PublishMode
 
+	// InputID is the ID of the input publishing with this client. It is
+	// used to route the events of the client to a named queue.
+	InputID string
+
 	Processing ProcessingConfig
 
 	// WaitClose sets the maximum duration to wait on ACK, if client still has events
@@ -151,6 +155,21 @@ type Processor interface {
 	Run(in *Event) (event *Event, err error)
 }
 
+// MultiProcessor is implemented by processors that can turn one event into
+// several events. Processors wrapping other processors implement it too, and
+// use SplitsEvents to report whether one of the wrapped processors splits
+// events. The pipeline only uses RunMulti if SplitsEvents returns true.
+type MultiProcessor interface {
+	Processor
+
+	// RunMulti returns the events created from in. The event is dropped if no
+	// events are returned.
+	RunMulti(in *Event) (events []*Event, err error)
+
+	// SplitsEvents reports whether RunMulti can return more than one event.
+	SplitsEvents() bool
+}
+
 // PublishMode enum sets some requirements on the client connection to the beats
 // publisher pipeline
 type PublishMode uint8
@@ -31,20 +31,27 @@ import (
 	_ "github.com/elastic/beats/v7/libbeat/processors/add_process_metadata"
 	_ "github.com/elastic/beats/v7/libbeat/processors/communityid"
 	_ "github.com/elastic/beats/v7/libbeat/processors/convert"
+	_ "github.com/elastic/beats/v7/libbeat/processors/decode_binary_fields"
 	_ "github.com/elastic/beats/v7/libbeat/processors/decode_duration"
+	_ "github.com/elastic/beats/v7/libbeat/processors/decode_kv"
 	_ "github.com/elastic/beats/v7/libbeat/processors/decode_xml"
 	_ "github.com/elastic/beats/v7/libbeat/processors/decode_xml_wineventlog"
 	_ "github.com/elastic/beats/v7/libbeat/processors/dissect"
 	_ "github.com/elastic/beats/v7/libbeat/processors/dns"
 	_ "github.com/elastic/beats/v7/libbeat/processors/extract_array"
 	_ "github.com/elastic/beats/v7/libbeat/processors/fingerprint"
+	_ "github.com/elastic/beats/v7/libbeat/processors/geoip"
+	_ "github.com/elastic/beats/v7/libbeat/processors/grok"
 	_ "github.com/elastic/beats/v7/libbeat/processors/move_fields"
 	_ "github.com/elastic/beats/v7/libbeat/processors/ratelimit"
+	_ "github.com/elastic/beats/v7/libbeat/processors/redact"
 	_ "github.com/elastic/beats/v7/libbeat/processors/registered_domain"
+	_ "github.com/elastic/beats/v7/libbeat/processors/sample"
 	_ "github.com/elastic/beats/v7/libbeat/processors/script"
 	_ "github.com/elastic/beats/v7/libbeat/processors/syslog"
 	_ "github.com/elastic/beats/v7/libbeat/processors/translate_ldap_attribute"
 	_ "github.com/elastic/beats/v7/libbeat/processors/translate_sid"
 	_ "github.com/elastic/beats/v7/libbeat/processors/urldecode"
+	_ "github.com/elastic/beats/v7/libbeat/processors/user_agent"
 	_ "github.com/elastic/beats/v7/libbeat/publisher/includes" // Register publisher pipeline modules
 )
@@ -0,0 +1,267 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func genQueueCmd(settings instance.Settings) *cobra.Command {
+	queueCmd := cobra.Command{
+		Use:   "queue",
+		Short: "Inspect and repair the disk queue",
+	}
+
+	queueCmd.AddCommand(genQueueInspectCmd(settings))
+	queueCmd.AddCommand(genQueueDumpCmd(settings))
+	queueCmd.AddCommand(genQueueRepairCmd(settings))
+
+	return &queueCmd
+}
+
+func genQueueInspectCmd(settings instance.Settings) *cobra.Command {
+	var flagVerify bool
+	command := &cobra.Command{
+		Use:   "inspect",
+		Short: "Show the segments of the disk queue and the ACK position",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := getDiskQueueSettings(settings)
+			if err != nil {
+				return err
+			}
+			segments, err := diskqueue.InspectSegments(queueSettings)
+			if err != nil {
+				return err
+			}
+			var checks []*diskqueue.SegmentCheck
+			if flagVerify {
+				checks = make([]*diskqueue.SegmentCheck, len(segments))
+				for i, segment := range segments {
+					check, err := diskqueue.CheckSegment(queueSettings, segment.ID)
+					if err != nil {
+						segments[i].Err = err
+						continue
+					}
+					checks[i] = &check
+				}
+			}
+
+			w := cmd.OutOrStdout()
+			position, err := diskqueue.ReadQueuePosition(queueSettings)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				fmt.Fprintln(w, "ACK position: no state file")
+			case err != nil:
+				fmt.Fprintf(w, "ACK position: %v\n", err)
+			default:
+				fmt.Fprintf(w, "ACK position: segment %d, frame %d, offset %d\n",
+					position.SegmentID, position.FrameIndex, position.ByteIndex)
+			}
+			return printSegments(w, segments, checks)
+		}),
+	}
+	command.Flags().BoolVar(&flagVerify, "verify", false, "Check the length and checksum of every frame")
+	return command
+}
+
+func genQueueDumpCmd(settings instance.Settings) *cobra.Command {
+	var flagSegment int64
+	var flagPending bool
+	command := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the events in the disk queue as JSON, one per line",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := getDiskQueueSettings(settings)
+			if err != nil {
+				return err
+			}
+			var position diskqueue.QueuePosition
+			if flagPending {
+				position, err = diskqueue.ReadQueuePosition(queueSettings)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("could not read ACK position: %w", err)
+				}
+			}
+			segments, err := diskqueue.InspectSegments(queueSettings)
+			if err != nil {
+				return err
+			}
+			for _, segment := range segments {
+				if flagSegment >= 0 && segment.ID != uint64(flagSegment) {
+					continue
+				}
+				if segment.ID < position.SegmentID {
+					continue
+				}
+				if err := dumpSegment(cmd, queueSettings, segment.ID, position); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	command.Flags().Int64Var(&flagSegment, "segment", -1, "Only dump the events of the segment with this ID")
+	command.Flags().BoolVar(&flagPending, "pending", false, "Only dump the events that haven't been acknowledged")
+	return command
+}
+
+func dumpSegment(cmd *cobra.Command, queueSettings diskqueue.Settings, id uint64, position diskqueue.QueuePosition) error {
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	check, err := diskqueue.ReadSegmentFrames(queueSettings, id, func(frame diskqueue.Frame) error {
+		if id == position.SegmentID && frame.Index < position.FrameIndex {
+			return nil
+		}
+		if frame.Err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "segment %d frame %d: %v\n", id, frame.Index, frame.Err)
+			return nil
+		}
+		event := frame.Event.Content
+		doc := event.Fields.Clone()
+		if doc == nil {
+			doc = mapstr.M{}
+		}
+		doc["@timestamp"] = event.Timestamp
+		if len(event.Meta) > 0 {
+			doc["@metadata"] = event.Meta
+		}
+		return enc.Encode(doc)
+	})
+	if err != nil {
+		return fmt.Errorf("could not read segment %d: %w", id, err)
+	}
+	if check.Err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "segment %d frame %d: %v\n", id, check.ValidFrames, check.Err)
+	}
+	return nil
+}
+
+func genQueueRepairCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair",
+		Short: "Truncate the segments of the disk queue at their last valid frame",
+		Long: "Truncate the segments of the disk queue at their last valid frame, remove the " +
+			"segments without any valid frame and rewrite the state file if the ACK position " +
+			"points to removed data. The beat must not be running.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := getDiskQueueSettings(settings)
+			if err != nil {
+				return err
+			}
+			// Hold the data path lock so the queue isn't used while it is
+			// being repaired.
+			lock := locks.New(b.Info)
+			if err := lock.Lock(); err != nil {
+				return fmt.Errorf("cannot repair the queue while the beat is running: %w", err)
+			}
+			defer func() {
+				_ = lock.Unlock()
+			}()
+
+			result, err := diskqueue.RepairQueue(queueSettings)
+			w := cmd.OutOrStdout()
+			if len(result.Segments) > 0 {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "SEGMENT\tFRAMES\tACTION\tERROR")
+				for _, s := range result.Segments {
+					fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", s.ID, s.Frames, s.Action, errorString(s.Err))
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if result.Position != nil {
+				fmt.Fprintf(w, "ACK position set to segment %d, frame %d, offset %d\n",
+					result.Position.SegmentID, result.Position.FrameIndex, result.Position.ByteIndex)
+			}
+			return nil
+		}),
+	}
+}
+
+// getDiskQueueSettings returns the settings of the disk queue configured
+// for the beat, including the settings set on the output.
+func getDiskQueueSettings(settings instance.Settings) (*instance.Beat, diskqueue.Settings, error) {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return nil, diskqueue.Settings{}, fmt.Errorf("error initializing beat: %w", err)
+	}
+
+	queue := b.Config.Pipeline.Queue
+	if !queue.IsSet() || queue.Name() != diskqueue.QueueType {
+		return nil, diskqueue.Settings{}, errors.New("the disk queue is not configured")
+	}
+	queueSettings, err := diskqueue.SettingsForUserConfig(queue.Config())
+	return b, queueSettings, err
+}
+
+// printSegments prints a table of the segments, checks is nil unless the
+// frames were verified.
+func printSegments(w io.Writer, segments []diskqueue.SegmentInfo, checks []*diskqueue.SegmentCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if checks != nil {
+		fmt.Fprintln(tw, "SEGMENT\tVERSION\tFRAMES\tVALID FRAMES\tSIZE\tCOMPRESSION\tENCRYPTION\tERROR")
+	} else {
+		fmt.Fprintln(tw, "SEGMENT\tVERSION\tFRAMES\tSIZE\tCOMPRESSION\tENCRYPTION\tERROR")
+	}
+	for i, s := range segments {
+		encryption := "none"
+		if s.Encrypted {
+			encryption = "key " + s.KeyID
+			if !s.KeyAvailable {
+				encryption += " (unknown key)"
+			}
+		}
+		errStr := errorString(s.Err)
+		fmt.Fprintf(tw, "%d\t%d\t%d\t", s.ID, s.Version, s.FrameCount)
+		if checks != nil {
+			valid := "-"
+			if checks[i] != nil {
+				valid = fmt.Sprint(checks[i].ValidFrames)
+				if checks[i].Err != nil {
+					errStr = checks[i].Err.Error()
+				}
+			}
+			fmt.Fprintf(tw, "%s\t", valid)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Size, s.Compression, encryption, errStr)
+	}
+	return tw.Flush()
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,397 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+const (
+	// maxResponseLogSize limits how much of an error response body is logged.
+	maxResponseLogSize = 1024
+
+	// maxItemsResponseSize limits how much of a successful JSON response
+	// body is read for the results of the events.
+	maxItemsResponseSize = 10 * 1024 * 1024
+)
+
+type clientSettings struct {
+	URL              string
+	Index            string
+	Codec            codec.Codec
+	Format           string
+	CompressionLevel int
+	Headers          map[string]string
+	Username         string
+	Password         string
+	BearerToken      string
+	UserAgent        string
+	Transport        httpcommon.HTTPTransportSettings
+	Observer         outputs.Observer
+}
+
+// client publishes batches of events to an HTTP endpoint, one POST
+// request per batch.
+type client struct {
+	clientSettings
+
+	log  *logp.Logger
+	http *http.Client
+}
+
+// statusAction is the action applied to the events of a request, or to a
+// single event, depending on the response status code.
+type statusAction int
+
+const (
+	actionACK statusAction = iota
+	actionRetry
+	actionSplit
+	actionDrop
+)
+
+var errPayloadTooLarge = errors.New("the http endpoint rejected the request because it is too large")
+
+// itemsResponse is the optional body of a successful response reporting the
+// result of each event, in the order of the request, like the responses of
+// the Elasticsearch bulk API.
+type itemsResponse struct {
+	Errors bool `json:"errors"`
+	Items  []struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// itemsResultStats counts the events of a request by the action applied to
+// them according to their result.
+type itemsResultStats struct {
+	acked   int
+	dropped int
+	tooMany int
+}
+
+func newClient(s clientSettings, logger *logp.Logger) *client {
+	if s.Observer == nil {
+		s.Observer = outputs.NewNilObserver()
+	}
+	return &client{
+		clientSettings: s,
+		log:            logger.Named("http"),
+	}
+}
+
+func (c *client) Connect(_ context.Context) error {
+	if c.http != nil {
+		return nil
+	}
+
+	httpClient, err := c.Transport.Client(
+		httpcommon.WithLogger(c.log),
+		httpcommon.WithIOStats(c.Observer),
+		httpcommon.WithHeaderRoundTripper(map[string]string{"User-Agent": c.UserAgent}),
+	)
+	if err != nil {
+		return err
+	}
+	c.http = httpClient
+	return nil
+}
+
+func (c *client) Close() error {
+	if c.http != nil {
+		c.http.CloseIdleConnections()
+		c.http = nil
+	}
+	return nil
+}
+
+func (c *client) String() string {
+	return "http(" + c.URL + ")"
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.Observer.NewBatch(len(events))
+
+	body, okEvents := c.encodeEvents(events)
+	c.Observer.PermanentErrors(len(events) - len(okEvents))
+	if len(okEvents) == 0 {
+		batch.ACK()
+		return nil
+	}
+
+	begin := time.Now()
+	status, resp, err := c.send(ctx, body)
+	if err != nil {
+		c.log.Errorf("Failed to publish events: %v", err)
+		batch.RetryEvents(okEvents)
+		c.Observer.RetryableErrors(len(okEvents))
+		return err
+	}
+
+	switch statusActionFor(status) {
+	case actionACK:
+		c.Observer.ReportLatency(time.Since(begin))
+		c.log.Debugf("%d events have been sent to %s in %v.", len(okEvents), c.URL, time.Since(begin))
+		eventsToRetry, stats := c.collectPublishFails(okEvents, resp)
+		c.Observer.AckedEvents(stats.acked)
+		c.Observer.PermanentErrors(stats.dropped)
+		if len(eventsToRetry) == 0 {
+			batch.ACK()
+			return nil
+		}
+		c.Observer.ErrTooMany(stats.tooMany)
+		c.Observer.RetryableErrors(len(eventsToRetry))
+		batch.RetryEvents(eventsToRetry)
+		return nil
+
+	case actionSplit:
+		if batch.SplitRetry() {
+			c.Observer.BatchSplit()
+			c.Observer.RetryableErrors(len(okEvents))
+		} else {
+			// The batch cannot be split any further, no option left but to drop it.
+			batch.Drop()
+			c.Observer.PermanentErrors(len(okEvents))
+			c.log.Error(errPayloadTooLarge)
+		}
+		return nil
+
+	case actionDrop:
+		c.log.Warnw(fmt.Sprintf("Cannot publish %d events (status=%v): %s, dropping events!",
+			len(okEvents), status, resp), logp.TypeKey, logp.EventType)
+		batch.Drop()
+		c.Observer.PermanentErrors(len(okEvents))
+		return nil
+
+	default:
+		if status == http.StatusTooManyRequests {
+			c.Observer.ErrTooMany(len(okEvents))
+		}
+		if isConfigurationError(status) {
+			// The events are kept until the credentials or the URL are
+			// fixed, make it visible that nothing is being published.
+			c.log.Errorf("Failed to publish %d events (status=%v), check the credentials and URL of the output: %s",
+				len(okEvents), status, resp)
+		}
+		batch.RetryEvents(okEvents)
+		c.Observer.RetryableErrors(len(okEvents))
+		// Returning an error makes the backoff client wait before the next attempt.
+		return fmt.Errorf("failed to publish events (status=%v): %s", status, resp)
+	}
+}
+
+// statusActionFor maps a response or event status code to the action
+// applied to the events of the request. Server errors, timeouts and throttling are retried,
+// as well as authentication errors and unknown paths, which are caused by
+// the configuration of the output rather than by the events. Other client
+// errors mean the events are never going to be accepted, so they are
+// dropped.
+func statusActionFor(status int) statusAction {
+	switch {
+	case status >= 200 && status < 300:
+		return actionACK
+	case status == http.StatusRequestEntityTooLarge:
+		return actionSplit
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return actionRetry
+	case isConfigurationError(status):
+		return actionRetry
+	case status >= 400 && status < 500:
+		return actionDrop
+	default:
+		return actionRetry
+	}
+}
+
+// collectPublishFails applies the per-event results of a successful
+// response to the events of the request. It returns the events to retry,
+// the dropped events are logged. Responses without results acknowledge all
+// the events.
+func (c *client) collectPublishFails(events []publisher.Event, body []byte) ([]publisher.Event, itemsResultStats) {
+	var resp itemsResponse
+	if len(body) == 0 || json.Unmarshal(body, &resp) != nil || !resp.Errors {
+		return nil, itemsResultStats{acked: len(events)}
+	}
+	if len(resp.Items) != len(events) {
+		// The results can't be matched to the events, retry all of them.
+		c.log.Errorf("Failed to read the results of %d events, the response has %d items", len(events), len(resp.Items))
+		return events, itemsResultStats{}
+	}
+
+	var (
+		eventsToRetry []publisher.Event
+		stats         itemsResultStats
+	)
+	for i, item := range resp.Items {
+		switch statusActionFor(item.Status) {
+		case actionACK:
+			stats.acked++
+		case actionRetry:
+			if item.Status == http.StatusTooManyRequests {
+				stats.tooMany++
+			}
+			c.log.Debugf("Failed to publish event (i=%v, status=%v): %s", i, item.Status, item.Error)
+			eventsToRetry = append(eventsToRetry, events[i])
+		default:
+			// A single event too large to be accepted can't be split
+			// further, it is dropped like other rejected events.
+			c.log.Warnw(fmt.Sprintf("Cannot publish event (status=%v): %s, dropping event!", item.Status, item.Error),
+				logp.TypeKey, logp.EventType)
+			stats.dropped++
+		}
+	}
+	return eventsToRetry, stats
+}
+
+// isConfigurationError returns true for the status codes returned when the
+// credentials or the URL of the output are wrong.
+func isConfigurationError(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// encodeEvents encodes the events into a request body, according to the
+// configured format. It returns the body and the events successfully encoded,
+// events failing to encode are logged and dropped.
+func (c *client) encodeEvents(events []publisher.Event) ([]byte, []publisher.Event) {
+	var buf bytes.Buffer
+	okEvents := make([]publisher.Event, 0, len(events))
+
+	if c.Format == formatJSONArray {
+		buf.WriteByte('[')
+	}
+	for i := range events {
+		event := &events[i]
+		serialized, err := c.Codec.Encode(c.Index, &event.Content)
+		if err != nil {
+			if event.Guaranteed() {
+				c.log.Errorf("Failed to serialize the event: %+v", err)
+			} else {
+				c.log.Warnf("Failed to serialize the event: %+v", err)
+			}
+			c.log.Debugw(fmt.Sprintf("Failed event: %v", event), logp.TypeKey, logp.EventType)
+			continue
+		}
+
+		switch c.Format {
+		case formatJSONArray:
+			if len(okEvents) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(serialized)
+		default:
+			buf.Write(serialized)
+			buf.WriteByte('\n')
+		}
+		okEvents = append(okEvents, *event)
+	}
+	if c.Format == formatJSONArray {
+		buf.WriteByte(']')
+	}
+
+	return buf.Bytes(), okEvents
+}
+
+// send POSTs the body to the configured URL, returning the response
+// status code and the response body: the beginning of it for unsuccessful
+// requests, and the JSON body of successful requests, which can hold the
+// results of the events.
+func (c *client) send(ctx context.Context, body []byte) (int, []byte, error) {
+	if c.http == nil {
+		return 0, nil, errors.New("http client is not connected")
+	}
+
+	var contentEncoding string
+	if c.CompressionLevel > 0 {
+		var compressed bytes.Buffer
+		w, err := gzip.NewWriterLevel(&compressed, c.CompressionLevel)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return 0, nil, err
+		}
+		if err := w.Close(); err != nil {
+			return 0, nil, err
+		}
+		body = compressed.Bytes()
+		contentEncoding = "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if c.Format == formatJSONArray {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	var respBody []byte
+	if resp.StatusCode >= 300 {
+		respBody, _ = io.ReadAll(io.LimitReader(resp.Body, maxResponseLogSize))
+	} else if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		// The events have been accepted, an incomplete body is handled
+		// like a body without results.
+		respBody, _ = io.ReadAll(io.LimitReader(resp.Body, maxItemsResponseSize))
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, respBody, nil
+}
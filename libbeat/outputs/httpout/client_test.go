@@ -0,0 +1,327 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	_ "github.com/elastic/beats/v7/libbeat/outputs/codec/json"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newTestServer(t *testing.T, status int) (*httptest.Server, <-chan receivedRequest) {
+	requests := make(chan receivedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		data, _ := io.ReadAll(body)
+		requests <- receivedRequest{header: r.Header.Clone(), body: data}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newTestClient(t *testing.T, s clientSettings) *client {
+	enc, err := codec.CreateEncoder(beat.Info{Beat: "testbeat", Version: "9.9.9"}, codec.Config{})
+	require.NoError(t, err)
+
+	s.Index = "testbeat"
+	s.Codec = enc
+	s.UserAgent = "testbeat"
+	s.Transport = httpcommon.DefaultHTTPTransportSettings()
+	if s.Format == "" {
+		s.Format = formatLines
+	}
+
+	c := newClient(s, logptest.NewTestingLogger(t, ""))
+	require.NoError(t, c.Connect(context.Background()))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testBatch() *outest.Batch {
+	return outest.NewBatch(
+		beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"message": "first"}},
+		beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"message": "second"}},
+	)
+}
+
+func TestPublishFormats(t *testing.T) {
+	t.Run("lines", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		c := newTestClient(t, clientSettings{URL: server.URL})
+
+		batch := testBatch()
+		require.NoError(t, c.Publish(context.Background(), batch))
+
+		req := <-requests
+		assert.Equal(t, "application/x-ndjson", req.header.Get("Content-Type"))
+		lines := strings.Split(strings.TrimSuffix(string(req.body), "\n"), "\n")
+		require.Len(t, lines, 2)
+		for i, expected := range []string{"first", "second"} {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(lines[i]), &doc))
+			assert.Equal(t, expected, doc["message"])
+		}
+		require.Len(t, batch.Signals, 1)
+		assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+	})
+
+	t.Run("json_array", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		c := newTestClient(t, clientSettings{URL: server.URL, Format: formatJSONArray})
+
+		require.NoError(t, c.Publish(context.Background(), testBatch()))
+
+		req := <-requests
+		assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+		var docs []map[string]interface{}
+		require.NoError(t, json.Unmarshal(req.body, &docs))
+		require.Len(t, docs, 2)
+		assert.Equal(t, "first", docs[0]["message"])
+		assert.Equal(t, "second", docs[1]["message"])
+	})
+}
+
+func TestPublishRequestOptions(t *testing.T) {
+	t.Run("gzip, headers and basic auth", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		c := newTestClient(t, clientSettings{
+			URL:              server.URL,
+			CompressionLevel: 5,
+			Headers:          map[string]string{"X-Custom": "value"},
+			Username:         "user",
+			Password:         "pass",
+		})
+
+		require.NoError(t, c.Publish(context.Background(), testBatch()))
+
+		req := <-requests
+		assert.Equal(t, "gzip", req.header.Get("Content-Encoding"))
+		assert.Equal(t, "value", req.header.Get("X-Custom"))
+		assert.Equal(t, "testbeat", req.header.Get("User-Agent"))
+		assert.Equal(t, "Basic dXNlcjpwYXNz", req.header.Get("Authorization"))
+		assert.Equal(t, 2, strings.Count(string(req.body), "\n"))
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		c := newTestClient(t, clientSettings{URL: server.URL, BearerToken: "secret"})
+
+		require.NoError(t, c.Publish(context.Background(), testBatch()))
+
+		req := <-requests
+		assert.Equal(t, "Bearer secret", req.header.Get("Authorization"))
+	})
+}
+
+func TestPublishStatusHandling(t *testing.T) {
+	testCases := map[string]struct {
+		status      int
+		expectedTag outest.BatchSignalTag
+		expectErr   bool
+	}{
+		"created is acked":           {status: http.StatusCreated, expectedTag: outest.BatchACK},
+		"server error is retried":    {status: http.StatusServiceUnavailable, expectedTag: outest.BatchRetryEvents, expectErr: true},
+		"too many requests retried":  {status: http.StatusTooManyRequests, expectedTag: outest.BatchRetryEvents, expectErr: true},
+		"bad request is dropped":     {status: http.StatusBadRequest, expectedTag: outest.BatchDrop},
+		"unauthorized is retried":    {status: http.StatusUnauthorized, expectedTag: outest.BatchRetryEvents, expectErr: true},
+		"forbidden is retried":       {status: http.StatusForbidden, expectedTag: outest.BatchRetryEvents, expectErr: true},
+		"not found is retried":       {status: http.StatusNotFound, expectedTag: outest.BatchRetryEvents, expectErr: true},
+		"payload too large is split": {status: http.StatusRequestEntityTooLarge, expectedTag: outest.BatchSplitRetry},
+		"request timeout is retried": {status: http.StatusRequestTimeout, expectedTag: outest.BatchRetryEvents, expectErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server, _ := newTestServer(t, tc.status)
+			c := newTestClient(t, clientSettings{URL: server.URL})
+
+			batch := testBatch()
+			err := c.Publish(context.Background(), batch)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, tc.expectedTag, batch.Signals[0].Tag)
+			if tc.expectedTag == outest.BatchRetryEvents {
+				assert.Len(t, batch.Signals[0].Events, 2)
+			}
+		})
+	}
+}
+
+func TestPublishEventResults(t *testing.T) {
+	testCases := map[string]struct {
+		contentType string
+		body        string
+		expectedTag outest.BatchSignalTag
+		retried     []string
+	}{
+		"failed events are retried or dropped": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"errors":true,"items":[{"status":201},{"status":429,"error":"slow down"},{"status":400,"error":"invalid"}]}`,
+			expectedTag: outest.BatchRetryEvents,
+			retried:     []string{"second"},
+		},
+		"no errors are acked": {
+			contentType: "application/json",
+			body:        `{"errors":false,"items":[{"status":201},{"status":201},{"status":201}]}`,
+			expectedTag: outest.BatchACK,
+		},
+		"mismatched results are retried": {
+			contentType: "application/json",
+			body:        `{"errors":true,"items":[{"status":400}]}`,
+			expectedTag: outest.BatchRetryEvents,
+			retried:     []string{"first", "second", "third"},
+		},
+		"non JSON responses are acked": {
+			contentType: "text/plain",
+			body:        `{"errors":true,"items":[{"status":400},{"status":400},{"status":400}]}`,
+			expectedTag: outest.BatchACK,
+		},
+		"invalid JSON responses are acked": {
+			contentType: "application/json",
+			body:        `accepted`,
+			expectedTag: outest.BatchACK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.Header().Set("Content-Type", tc.contentType)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			t.Cleanup(server.Close)
+			c := newTestClient(t, clientSettings{URL: server.URL})
+
+			batch := outest.NewBatch(
+				beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"message": "first"}},
+				beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"message": "second"}},
+				beat.Event{Timestamp: time.Now(), Fields: mapstr.M{"message": "third"}},
+			)
+			require.NoError(t, c.Publish(context.Background(), batch))
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, tc.expectedTag, batch.Signals[0].Tag)
+			var retried []string
+			for _, event := range batch.Signals[0].Events {
+				retried = append(retried, event.Content.Fields["message"].(string))
+			}
+			assert.Equal(t, tc.retried, retried)
+		})
+	}
+}
+
+func TestPublishConnectionError(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusOK)
+	c := newTestClient(t, clientSettings{URL: server.URL})
+	server.Close()
+
+	batch := testBatch()
+	require.Error(t, c.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+}
+
+func TestMakeHTTP(t *testing.T) {
+	testCases := map[string]struct {
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		"valid config": {
+			config: map[string]interface{}{
+				"hosts":   []string{"https://localhost:8080/ingest"},
+				"headers": map[string]string{"X-Custom": "value"},
+			},
+		},
+		"missing hosts": {
+			config:  map[string]interface{}{},
+			wantErr: true,
+		},
+		"invalid scheme": {
+			config:  map[string]interface{}{"hosts": []string{"ftp://localhost"}},
+			wantErr: true,
+		},
+		"invalid format": {
+			config: map[string]interface{}{
+				"hosts":  []string{"http://localhost"},
+				"format": "xml",
+			},
+			wantErr: true,
+		},
+		"bearer token and basic auth": {
+			config: map[string]interface{}{
+				"hosts":        []string{"http://localhost"},
+				"bearer_token": "secret",
+				"username":     "user",
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(tc.config)
+			info := beat.Info{Beat: "testbeat", Logger: logptest.NewTestingLogger(t, "")}
+			group, err := makeHTTP(nil, info, outputs.NewNilObserver(), cfg)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, group.Clients, 1)
+		})
+	}
+}
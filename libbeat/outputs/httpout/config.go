@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+const (
+	// formatLines sends one encoded event per line (NDJSON).
+	formatLines = "lines"
+	// formatJSONArray sends the encoded events as elements of a JSON array.
+	formatJSONArray = "json_array"
+)
+
+type httpConfig struct {
+	Hosts            []string          `config:"hosts" validate:"required"`
+	Format           string            `config:"format"`
+	Codec            codec.Config      `config:"codec"`
+	Headers          map[string]string `config:"headers"`
+	Username         string            `config:"username"`
+	Password         string            `config:"password"`
+	BearerToken      string            `config:"bearer_token"`
+	LoadBalance      bool              `config:"loadbalance"`
+	CompressionLevel int               `config:"compression_level" validate:"min=0, max=9"`
+	BulkMaxSize      int               `config:"bulk_max_size"`
+	MaxRetries       int               `config:"max_retries"`
+	Backoff          backoff           `config:"backoff"`
+	Queue            config.Namespace  `config:"queue"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+func defaultConfig() httpConfig {
+	return httpConfig{
+		Format:           formatLines,
+		LoadBalance:      true,
+		CompressionLevel: 0,
+		BulkMaxSize:      1600,
+		MaxRetries:       3,
+		Backoff: backoff{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+}
+
+func (c *httpConfig) Validate() error {
+	switch c.Format {
+	case formatLines, formatJSONArray:
+	default:
+		return fmt.Errorf("unsupported format '%v', must be one of '%v' or '%v'", c.Format, formatLines, formatJSONArray)
+	}
+
+	if c.BearerToken != "" && (c.Username != "" || c.Password != "") {
+		return errors.New("cannot set both bearer_token and username/password")
+	}
+
+	return nil
+}
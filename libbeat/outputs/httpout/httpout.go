@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/beats/v7/libbeat/version"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/useragent"
+)
+
+func init() {
+	outputs.RegisterType("http", makeHTTP)
+}
+
+// makeHTTP instantiates a new http output instance, creating one client
+// per configured host.
+func makeHTTP(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *config.C,
+) (outputs.Group, error) {
+	hConfig := defaultConfig()
+	if err := cfg.Unpack(&hConfig); err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	userAgent := beat.UserAgent
+	if userAgent == "" {
+		userAgent = useragent.UserAgent(beat.Beat, version.GetDefaultVersion(), version.Commit(), version.BuildTime().String())
+	}
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		hostURL, err := url.Parse(host)
+		if err != nil {
+			return outputs.Fail(fmt.Errorf("invalid http output host '%v': %w", host, err))
+		}
+		if hostURL.Scheme != "http" && hostURL.Scheme != "https" {
+			return outputs.Fail(fmt.Errorf("invalid http output host '%v': scheme must be http or https", host))
+		}
+
+		enc, err := codec.CreateEncoder(beat, hConfig.Codec)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		client := newClient(clientSettings{
+			URL:              hostURL.String(),
+			Index:            beat.Beat,
+			Codec:            enc,
+			Format:           hConfig.Format,
+			CompressionLevel: hConfig.CompressionLevel,
+			Headers:          hConfig.Headers,
+			Username:         hConfig.Username,
+			Password:         hConfig.Password,
+			BearerToken:      hConfig.BearerToken,
+			UserAgent:        userAgent,
+			Transport:        hConfig.Transport,
+			Observer:         observer,
+		}, beat.Logger)
+
+		clients[i] = outputs.WithBackoff(client, hConfig.Backoff.Init, hConfig.Backoff.Max)
+	}
+
+	return outputs.SuccessNet(hConfig.Queue, hConfig.LoadBalance, hConfig.BulkMaxSize, hConfig.MaxRetries, nil, clients)
+}
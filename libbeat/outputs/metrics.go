@@ -78,6 +78,8 @@ type Stats struct {
 	readErrors *monitoring.Uint // total number of errors while waiting for response on output
 
 	sendLatencyMillis metrics.Sample
+
+	registry *monitoring.Registry
 }
 
 // NewStats creates a new Stats instance using a backing monitoring registry.
@@ -104,11 +106,28 @@ func NewStats(reg *monitoring.Registry) *Stats {
 		readErrors: monitoring.NewUint(reg, "read.errors"),
 
 		sendLatencyMillis: metrics.NewUniformSample(1024),
+
+		registry: reg,
 	}
 	_ = adapter.NewGoMetrics(reg, "write.latency", adapter.Accept).Register("histogram", metrics.NewHistogram(obj.sendLatencyMillis))
 	return obj
 }
 
+// Branch returns the stats of an output wrapped by this output. The
+// metrics of the branch are reported in the name sub-registry.
+func (s *Stats) Branch(name string) Observer {
+	if s == nil {
+		return NewNilObserver()
+	}
+	reg := s.registry.GetRegistry(name)
+	if reg == nil {
+		reg = s.registry.NewRegistry(name)
+	} else {
+		_ = reg.Clear()
+	}
+	return NewStats(reg)
+}
+
 // NewBatch updates active batch and event metrics.
 func (s *Stats) NewBatch(n int) {
 	if s != nil {
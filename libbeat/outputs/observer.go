@@ -41,6 +41,15 @@ type Observer interface {
 	ReportLatency(time.Duration) // report the duration a send to the output takes
 }
 
+// BranchObserver is implemented by observers that can report the stats of
+// the outputs wrapped by another output separately.
+type BranchObserver interface {
+	Observer
+
+	// Branch returns the observer used by the named wrapped output.
+	Branch(name string) Observer
+}
+
 type emptyObserver struct{}
 
 var nilObserver = (*emptyObserver)(nil)
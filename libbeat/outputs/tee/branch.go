@@ -0,0 +1,368 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tee
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// eventIndexKey is the key of the event cache holding the position of an
+// event in the batch received by the tee output.
+const eventIndexKey = "tee_index"
+
+// branch delivers copies of the events published to the tee output to one of
+// its outputs. Each branch retries the batches of its output on its own, so
+// a slow or failing output does not cause the events to be sent again to the
+// other outputs.
+type branch struct {
+	name       string
+	log        *logp.Logger
+	observer   outputs.Observer
+	clients    []outputs.Client
+	encoder    queue.Encoder
+	batchSize  int
+	timeToLive int
+
+	mu            sync.Mutex
+	queue         []*branchBatch
+	pendingEvents int
+
+	notify chan struct{}
+	work   chan *branchBatch
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// branchBatch is the part of a batch of the tee output sent to a branch.
+type branchBatch struct {
+	branch *branch
+	tee    *teeBatch
+	events []publisher.Event
+	ttl    int
+}
+
+func newBranch(name string, group outputs.Group, observer outputs.Observer, log *logp.Logger) *branch {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &branch{
+		name:       name,
+		log:        log.With("branch", name),
+		observer:   observer,
+		clients:    group.Clients,
+		batchSize:  group.BatchSize,
+		timeToLive: group.Retry + 1,
+		notify:     make(chan struct{}, 1),
+		work:       make(chan *branchBatch),
+		done:       make(chan struct{}),
+		cancel:     cancel,
+	}
+	if group.EncoderFactory != nil {
+		b.encoder = group.EncoderFactory()
+	}
+
+	go b.run()
+	for _, client := range b.clients {
+		go b.runWorker(ctx, client)
+	}
+	return b
+}
+
+// publish sends a copy of the events to the output of the branch. If
+// maxPending is set and the branch already has too many events waiting to
+// be delivered, the events are dropped by this branch.
+func (b *branch) publish(tee *teeBatch, events []publisher.Event, maxPending int) {
+	b.mu.Lock()
+	if maxPending > 0 && b.pendingEvents+len(events) > maxPending {
+		b.mu.Unlock()
+		b.log.Warnf("Dropping %d events, tee output %v has too many pending events.", len(events), b.name)
+		b.observer.NewBatch(len(events))
+		b.observer.PermanentErrors(len(events))
+		tee.finish(batchIndexes(len(events)), false)
+		return
+	}
+	b.pendingEvents += len(events)
+	b.mu.Unlock()
+
+	copies := make([]publisher.Event, len(events))
+	for i, event := range events {
+		// The event cache belongs to the output, every branch gets its own.
+		event.Cache = publisher.EventCache{}
+		_, _ = event.Cache.Put(eventIndexKey, i)
+		if b.encoder != nil {
+			entry, _ := b.encoder.EncodeEntry(event)
+			if encoded, ok := entry.(publisher.Event); ok {
+				event = encoded
+			}
+		}
+		copies[i] = event
+	}
+
+	size := b.batchSize
+	if size <= 0 {
+		size = len(copies)
+	}
+	for start := 0; start < len(copies); start += size {
+		end := min(start+size, len(copies))
+		b.push(&branchBatch{
+			branch: b,
+			tee:    tee,
+			events: copies[start:end:end],
+			ttl:    b.timeToLive,
+		}, false)
+	}
+}
+
+// push queues a batch for the workers of the branch. Batches being retried
+// are queued in front of the new ones.
+func (b *branch) push(batch *branchBatch, retry bool) {
+	b.mu.Lock()
+	if retry {
+		b.queue = append([]*branchBatch{batch}, b.queue...)
+	} else {
+		b.queue = append(b.queue, batch)
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *branch) next() *branchBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queue) == 0 {
+		return nil
+	}
+	batch := b.queue[0]
+	b.queue[0] = nil
+	b.queue = b.queue[1:]
+	return batch
+}
+
+// finished is called once the branch is done with n events, either because
+// they have been delivered or dropped.
+func (b *branch) finished(n int) {
+	b.mu.Lock()
+	b.pendingEvents -= n
+	b.mu.Unlock()
+}
+
+// run hands the queued batches to the workers of the branch.
+func (b *branch) run() {
+	for {
+		batch := b.next()
+		if batch == nil {
+			select {
+			case <-b.notify:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+
+		select {
+		case b.work <- batch:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *branch) runWorker(ctx context.Context, client outputs.Client) {
+	netClient, reconnect := client.(outputs.NetworkClient)
+	connected := !reconnect
+	reconnectAttempts := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case batch := <-b.work:
+			// Try to (re)connect so we can publish batch
+			if !connected {
+				// Return batch to other output workers while we try to (re)connect
+				batch.Cancelled()
+
+				if reconnectAttempts == 0 {
+					b.log.Infof("Connecting to %v", client)
+				} else {
+					b.log.Infof("Attempting to reconnect to %v with %d reconnect attempt(s)", client, reconnectAttempts)
+				}
+
+				err := netClient.Connect(ctx)
+				connected = err == nil
+				if connected {
+					b.log.Infof("Connection to %v established", client)
+					reconnectAttempts = 0
+				} else {
+					b.log.Errorf("Failed to connect to %v: %v", client, err)
+					reconnectAttempts++
+				}
+				continue
+			}
+
+			if err := client.Publish(ctx, batch); err != nil {
+				b.log.Errorf("Failed to publish events: %v", err)
+				// on error return to connect loop
+				connected = !reconnect
+			}
+		}
+	}
+}
+
+func (b *branch) close() error {
+	close(b.done)
+	b.cancel()
+
+	var errs []error
+	for _, client := range b.clients {
+		errs = append(errs, client.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (b *branchBatch) Events() []publisher.Event {
+	return b.events
+}
+
+func (b *branchBatch) ACK() {
+	b.finish(b.events, true)
+	b.events = nil
+}
+
+func (b *branchBatch) Drop() {
+	b.finish(b.events, false)
+	b.events = nil
+}
+
+func (b *branchBatch) Retry() {
+	if b.reduceTTL() {
+		b.branch.push(b, true)
+	}
+}
+
+func (b *branchBatch) RetryEvents(events []publisher.Event) {
+	retry := make(map[int]struct{}, len(events))
+	for _, index := range eventIndexes(events) {
+		retry[index] = struct{}{}
+	}
+
+	var acked []publisher.Event
+	for _, event := range b.events {
+		if _, ok := retry[eventIndex(&event)]; !ok {
+			acked = append(acked, event)
+		}
+	}
+	b.finish(acked, true)
+
+	b.events = events
+	b.Retry()
+}
+
+func (b *branchBatch) SplitRetry() bool {
+	if len(b.events) < 2 {
+		return false
+	}
+	mid := len(b.events) / 2
+	b.branch.push(&branchBatch{branch: b.branch, tee: b.tee, events: b.events[mid:], ttl: b.ttl}, true)
+	b.branch.push(&branchBatch{branch: b.branch, tee: b.tee, events: b.events[:mid:mid], ttl: b.ttl}, true)
+	return true
+}
+
+func (b *branchBatch) Cancelled() {
+	b.branch.push(b, true)
+}
+
+// reduceTTL reduces the time to live of the batch, dropping the events
+// without guaranteed sending requirements once it expires. reduceTTL
+// returns true if the batch is still alive.
+func (b *branchBatch) reduceTTL() bool {
+	if b.ttl <= 0 {
+		return true
+	}
+
+	b.ttl--
+	if b.ttl > 0 {
+		return true
+	}
+
+	var guaranteed, dropped []publisher.Event
+	for _, event := range b.events {
+		if event.Guaranteed() {
+			guaranteed = append(guaranteed, event)
+		} else {
+			dropped = append(dropped, event)
+		}
+	}
+	b.finish(dropped, false)
+
+	if len(guaranteed) == 0 {
+		b.events = nil
+		return false
+	}
+	// we need infinite retry for all events left in this batch
+	b.events = guaranteed
+	b.ttl = -1
+	return true
+}
+
+func (b *branchBatch) finish(events []publisher.Event, delivered bool) {
+	if len(events) == 0 {
+		return
+	}
+	b.branch.finished(len(events))
+	b.tee.finish(eventIndexes(events), delivered)
+}
+
+// eventIndexes returns the position of the events in the batch of the tee
+// output.
+func eventIndexes(events []publisher.Event) []int {
+	indexes := make([]int, len(events))
+	for i := range events {
+		indexes[i] = eventIndex(&events[i])
+	}
+	return indexes
+}
+
+// batchIndexes returns the positions of all the events of a batch of n
+// events.
+func batchIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+func eventIndex(event *publisher.Event) int {
+	v, err := event.Cache.GetValue(eventIndexKey)
+	if err != nil {
+		return -1
+	}
+	index, _ := v.(int)
+	return index
+}
@@ -0,0 +1,260 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/testing"
+)
+
+var errClientClosed = errors.New("tee output client is closed")
+
+type clientSettings struct {
+	Branches         []*branch
+	ACKPolicy        string
+	MaxPendingEvents int
+	Observer         outputs.Observer
+}
+
+type client struct {
+	log              *logp.Logger
+	branches         []*branch
+	ackPolicy        string
+	maxPendingEvents int
+	observer         outputs.Observer
+
+	mu     sync.Mutex
+	closed bool
+	active map[*teeBatch]struct{}
+}
+
+// teeBatch tracks the delivery of a batch by every branch, and acknowledges
+// it according to the ACK policy once all its events have been resolved.
+type teeBatch struct {
+	parent   publisher.Batch
+	policy   string
+	branches int
+	observer outputs.Observer
+	onDone   func(*teeBatch)
+
+	mu        sync.Mutex
+	done      bool
+	open      int
+	acked     int
+	dropped   int
+	delivered []int
+	finished  []int
+	resolved  []bool
+}
+
+func newClient(s clientSettings, log *logp.Logger) *client {
+	return &client{
+		log:              log,
+		branches:         s.Branches,
+		ackPolicy:        s.ACKPolicy,
+		maxPendingEvents: s.MaxPendingEvents,
+		observer:         s.Observer,
+		active:           map[*teeBatch]struct{}{},
+	}
+}
+
+func (c *client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	active := c.active
+	c.active = nil
+	c.mu.Unlock()
+
+	var errs []error
+	for _, b := range c.branches {
+		errs = append(errs, b.close())
+	}
+
+	// Batches not yet acknowledged are returned to the pipeline, to be sent
+	// again by the next output.
+	for batch := range active {
+		batch.cancel()
+	}
+	return errors.Join(errs...)
+}
+
+func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		batch.Cancelled()
+		return errClientClosed
+	}
+	c.observer.NewBatch(len(events))
+	if len(events) == 0 {
+		c.mu.Unlock()
+		batch.ACK()
+		return nil
+	}
+	tb := newTeeBatch(batch, len(events), len(c.branches), c.ackPolicy, c.observer, c.batchDone)
+	c.active[tb] = struct{}{}
+	c.mu.Unlock()
+
+	maxPending := 0
+	if c.ackPolicy == ackPolicyAny {
+		maxPending = c.maxPendingEvents
+	}
+	for _, b := range c.branches {
+		b.publish(tb, events, maxPending)
+	}
+	return nil
+}
+
+func (c *client) batchDone(batch *teeBatch) {
+	c.mu.Lock()
+	delete(c.active, batch)
+	c.mu.Unlock()
+}
+
+func (c *client) Test(d testing.Driver) {
+	for _, b := range c.branches {
+		for i, client := range b.clients {
+			t, ok := client.(testing.Testable)
+			d.Run(fmt.Sprintf("%v %d", b.name, i), func(d testing.Driver) {
+				if !ok {
+					d.Fatal("output", errors.New("client doesn't support testing"))
+				}
+				t.Test(d)
+			})
+		}
+	}
+}
+
+func (c *client) String() string {
+	names := make([]string, 0, len(c.branches))
+	for _, b := range c.branches {
+		for _, client := range b.clients {
+			names = append(names, client.String())
+		}
+	}
+	return "tee(" + strings.Join(names, ",") + ")"
+}
+
+func newTeeBatch(
+	parent publisher.Batch,
+	events int,
+	branches int,
+	policy string,
+	observer outputs.Observer,
+	onDone func(*teeBatch),
+) *teeBatch {
+	return &teeBatch{
+		parent:    parent,
+		policy:    policy,
+		branches:  branches,
+		observer:  observer,
+		onDone:    onDone,
+		open:      events,
+		delivered: make([]int, events),
+		finished:  make([]int, events),
+		resolved:  make([]bool, events),
+	}
+}
+
+// finish records that a branch is done with the events at the given
+// positions. Once all events are resolved, the batch is acknowledged if at
+// least one of its events has been delivered, and dropped otherwise.
+func (t *teeBatch) finish(indexes []int, delivered bool) {
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return
+	}
+	for _, i := range indexes {
+		if i < 0 || i >= len(t.finished) {
+			continue
+		}
+		t.finished[i]++
+		if delivered {
+			t.delivered[i]++
+		}
+		if t.resolved[i] {
+			continue
+		}
+
+		switch {
+		case t.policy == ackPolicyAny && t.delivered[i] > 0:
+			t.resolve(i, true)
+		case t.finished[i] == t.branches:
+			t.resolve(i, t.delivered[i] == t.branches)
+		}
+	}
+	t.done = t.open == 0
+	done, acked, dropped := t.done, t.acked, t.dropped
+	t.mu.Unlock()
+
+	if !done {
+		return
+	}
+	t.onDone(t)
+	if acked > 0 {
+		t.observer.AckedEvents(acked)
+	}
+	if dropped > 0 {
+		t.observer.PermanentErrors(dropped)
+	}
+	if acked > 0 {
+		t.parent.ACK()
+	} else {
+		t.parent.Drop()
+	}
+}
+
+// resolve marks the event at position i as delivered or dropped by the tee
+// output.
+func (t *teeBatch) resolve(i int, delivered bool) {
+	t.resolved[i] = true
+	t.open--
+	if delivered {
+		t.acked++
+	} else {
+		t.dropped++
+	}
+}
+
+// cancel returns the batch to the pipeline if it has not been resolved yet.
+func (t *teeBatch) cancel() {
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return
+	}
+	t.done = true
+	t.mu.Unlock()
+
+	t.parent.Cancelled()
+}
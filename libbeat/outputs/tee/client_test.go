@@ -0,0 +1,282 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tee
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	_ "github.com/elastic/beats/v7/libbeat/outputs/discard"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// mockClient records the events of the batches it receives and lets the
+// test decide how to complete them.
+type mockClient struct {
+	mu      sync.Mutex
+	calls   int
+	events  []publisher.Event
+	publish func(call int, batch publisher.Batch)
+}
+
+func (c *mockClient) Publish(_ context.Context, batch publisher.Batch) error {
+	c.mu.Lock()
+	c.calls++
+	call := c.calls
+	c.events = append(c.events, batch.Events()...)
+	c.mu.Unlock()
+
+	c.publish(call, batch)
+	return nil
+}
+
+func (c *mockClient) Close() error   { return nil }
+func (c *mockClient) String() string { return "mock" }
+
+func (c *mockClient) received() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events)
+}
+
+func ackAll(_ int, batch publisher.Batch) { batch.ACK() }
+
+func newTestClient(t *testing.T, policy string, clients ...*mockClient) *client {
+	log := logptest.NewTestingLogger(t, "")
+	branches := make([]*branch, len(clients))
+	for i, c := range clients {
+		branches[i] = newBranch("mock", outputs.Group{Clients: []outputs.Client{c}, Retry: 3}, outputs.NewNilObserver(), log)
+	}
+	client := newClient(clientSettings{
+		Branches:         branches,
+		ACKPolicy:        policy,
+		MaxPendingEvents: 100,
+		Observer:         outputs.NewNilObserver(),
+	}, log)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newTestBatch(n int) (*outest.Batch, <-chan outest.BatchSignal) {
+	events := make([]beat.Event, n)
+	for i := range events {
+		events[i] = beat.Event{Fields: mapstr.M{"i": i}}
+	}
+	batch := outest.NewBatch(events...)
+	signals := make(chan outest.BatchSignal, 10)
+	batch.OnSignal = func(sig outest.BatchSignal) { signals <- sig }
+	return batch, signals
+}
+
+func waitSignal(t *testing.T, signals <-chan outest.BatchSignal) outest.BatchSignal {
+	t.Helper()
+	select {
+	case sig := <-signals:
+		return sig
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for batch signal")
+		return outest.BatchSignal{}
+	}
+}
+
+func assertNoSignal(t *testing.T, signals <-chan outest.BatchSignal) {
+	t.Helper()
+	select {
+	case sig := <-signals:
+		t.Fatalf("unexpected batch signal %v", sig.Tag)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPublishToAllBranches(t *testing.T) {
+	a := &mockClient{publish: ackAll}
+	b := &mockClient{publish: ackAll}
+	client := newTestClient(t, ackPolicyAll, a, b)
+
+	batch, signals := newTestBatch(3)
+	require.NoError(t, client.Publish(context.Background(), batch))
+
+	assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	assert.Equal(t, 3, a.received())
+	assert.Equal(t, 3, b.received())
+
+	// The branches get their own copy of the events.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	assert.Equal(t, mapstr.M{"i": 1}, b.events[1].Content.Fields)
+	assert.Equal(t, 1, eventIndex(&b.events[1]))
+}
+
+func TestACKPolicyAll(t *testing.T) {
+	release := make(chan struct{})
+	a := &mockClient{publish: ackAll}
+	b := &mockClient{publish: func(call int, batch publisher.Batch) {
+		if call == 1 {
+			<-release
+			batch.Retry()
+			return
+		}
+		batch.ACK()
+	}}
+	client := newTestClient(t, ackPolicyAll, a, b)
+
+	batch, signals := newTestBatch(2)
+	require.NoError(t, client.Publish(context.Background(), batch))
+
+	assertNoSignal(t, signals)
+	close(release)
+
+	assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	assert.Equal(t, 2, a.received(), "events must not be sent again to the branch that delivered them")
+	assert.Equal(t, 4, b.received())
+}
+
+func TestACKPolicyAny(t *testing.T) {
+	release := make(chan struct{})
+	a := &mockClient{publish: ackAll}
+	b := &mockClient{publish: func(_ int, batch publisher.Batch) {
+		<-release
+		batch.ACK()
+	}}
+	client := newTestClient(t, ackPolicyAny, a, b)
+	defer close(release)
+
+	batch, signals := newTestBatch(2)
+	require.NoError(t, client.Publish(context.Background(), batch))
+
+	assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+}
+
+func TestBranchRetryEvents(t *testing.T) {
+	a := &mockClient{publish: ackAll}
+	b := &mockClient{publish: func(call int, batch publisher.Batch) {
+		if call == 1 {
+			batch.RetryEvents(batch.Events()[1:2])
+			return
+		}
+		batch.ACK()
+	}}
+	client := newTestClient(t, ackPolicyAll, a, b)
+
+	batch, signals := newTestBatch(3)
+	require.NoError(t, client.Publish(context.Background(), batch))
+
+	assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	require.Len(t, b.events, 4)
+	assert.Equal(t, 1, eventIndex(&b.events[3]))
+}
+
+func TestBranchDropsEvents(t *testing.T) {
+	drop := func(_ int, batch publisher.Batch) { batch.Drop() }
+
+	t.Run("all", func(t *testing.T) {
+		client := newTestClient(t, ackPolicyAll, &mockClient{publish: ackAll}, &mockClient{publish: drop})
+		batch, signals := newTestBatch(2)
+		require.NoError(t, client.Publish(context.Background(), batch))
+		assert.Equal(t, outest.BatchDrop, waitSignal(t, signals).Tag)
+	})
+
+	t.Run("any", func(t *testing.T) {
+		client := newTestClient(t, ackPolicyAny, &mockClient{publish: ackAll}, &mockClient{publish: drop})
+		batch, signals := newTestBatch(2)
+		require.NoError(t, client.Publish(context.Background(), batch))
+		assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+	})
+}
+
+func TestBranchRetryLimit(t *testing.T) {
+	retry := &mockClient{publish: func(_ int, batch publisher.Batch) { batch.Retry() }}
+	client := newTestClient(t, ackPolicyAll, &mockClient{publish: ackAll}, retry)
+
+	batch, signals := newTestBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+
+	assert.Equal(t, outest.BatchDrop, waitSignal(t, signals).Tag)
+	assert.Equal(t, 4, retry.received(), "the batch must be sent max_retries + 1 times")
+}
+
+func TestCloseCancelsPendingBatches(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	client := newTestClient(t, ackPolicyAll, &mockClient{publish: func(_ int, batch publisher.Batch) {
+		<-block
+	}})
+
+	batch, signals := newTestBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.NoError(t, client.Close())
+
+	assert.Equal(t, outest.BatchCancelled, waitSignal(t, signals).Tag)
+	assert.ErrorIs(t, client.Publish(context.Background(), batch), errClientClosed)
+}
+
+func TestMakeTee(t *testing.T) {
+	info := beat.Info{Beat: "testbeat", Logger: logptest.NewTestingLogger(t, "")}
+
+	t.Run("branch stats", func(t *testing.T) {
+		cfg := config.MustNewConfigFrom(mapstr.M{
+			"outputs": []mapstr.M{
+				{"discard": mapstr.M{}},
+				{"discard": mapstr.M{}},
+			},
+		})
+		reg := monitoring.NewRegistry()
+		group, err := makeTee(nil, info, outputs.NewStats(reg), cfg)
+		require.NoError(t, err)
+		require.Len(t, group.Clients, 1)
+		defer group.Clients[0].Close()
+
+		batch, signals := newTestBatch(2)
+		require.NoError(t, group.Clients[0].Publish(context.Background(), batch))
+		assert.Equal(t, outest.BatchACK, waitSignal(t, signals).Tag)
+
+		snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+		assert.Equal(t, int64(2), snapshot.Ints["events.acked"])
+		assert.Equal(t, int64(2), snapshot.Ints["branches.discard_0.events.acked"])
+		assert.Equal(t, int64(2), snapshot.Ints["branches.discard_1.events.acked"])
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		cases := map[string]mapstr.M{
+			"no outputs":     {},
+			"unknown policy": {"ack_policy": "some", "outputs": []mapstr.M{{"discard": mapstr.M{}}}},
+			"unknown output": {"outputs": []mapstr.M{{"unknown": mapstr.M{}}}},
+			"nested tee":     {"outputs": []mapstr.M{{"tee": mapstr.M{}}}},
+		}
+		for name, c := range cases {
+			t.Run(name, func(t *testing.T) {
+				_, err := makeTee(nil, info, outputs.NewNilObserver(), config.MustNewConfigFrom(c))
+				assert.Error(t, err)
+			})
+		}
+	})
+}
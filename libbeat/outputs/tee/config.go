@@ -0,0 +1,72 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tee
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+const (
+	// ackPolicyAll acknowledges events once they have been delivered by all
+	// the outputs.
+	ackPolicyAll = "all"
+	// ackPolicyAny acknowledges events as soon as they have been delivered by
+	// one of the outputs.
+	ackPolicyAny = "any"
+)
+
+type teeConfig struct {
+	Outputs          []config.Namespace `config:"outputs" validate:"required"`
+	ACKPolicy        string             `config:"ack_policy"`
+	BulkMaxSize      int                `config:"bulk_max_size" validate:"min=1"`
+	MaxPendingEvents int                `config:"max_pending_events" validate:"min=1"`
+	Queue            config.Namespace   `config:"queue"`
+}
+
+func defaultConfig() teeConfig {
+	return teeConfig{
+		ACKPolicy:        ackPolicyAll,
+		BulkMaxSize:      1600,
+		MaxPendingEvents: 8192,
+	}
+}
+
+func (c *teeConfig) Validate() error {
+	switch c.ACKPolicy {
+	case ackPolicyAll, ackPolicyAny:
+	default:
+		return fmt.Errorf("unsupported ack_policy '%v', must be one of '%v' or '%v'", c.ACKPolicy, ackPolicyAll, ackPolicyAny)
+	}
+
+	if len(c.Outputs) == 0 {
+		return errors.New("at least one output must be configured")
+	}
+	for i, out := range c.Outputs {
+		if !out.IsSet() {
+			return fmt.Errorf("output %d has no type configured", i)
+		}
+		if out.Name() == "tee" {
+			return errors.New("tee outputs cannot be nested")
+		}
+	}
+
+	return nil
+}
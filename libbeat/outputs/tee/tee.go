@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tee
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func init() {
+	outputs.RegisterType("tee", makeTee)
+}
+
+// makeTee instantiates a tee output, loading every configured output as a
+// branch that receives a copy of each event.
+func makeTee(
+	im outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *config.C,
+) (outputs.Group, error) {
+	tConfig := defaultConfig()
+	if err := cfg.Unpack(&tConfig); err != nil {
+		return outputs.Fail(err)
+	}
+
+	log := beat.Logger.Named("tee")
+	names := branchNames(tConfig.Outputs)
+	branches := make([]*branch, 0, len(tConfig.Outputs))
+	for i, out := range tConfig.Outputs {
+		branchObserver := outputs.NewNilObserver()
+		if bo, ok := observer.(outputs.BranchObserver); ok {
+			branchObserver = bo.Branch("branches." + names[i])
+		}
+
+		group, err := outputs.Load(im, beat, branchObserver, out.Name(), out.Config())
+		if err != nil {
+			for _, b := range branches {
+				b.close()
+			}
+			return outputs.Fail(fmt.Errorf("failed to load tee output %v: %w", names[i], err))
+		}
+		if group.QueueFactory != nil {
+			log.Warnf("The queue settings of tee output %v are ignored, configure the queue of the tee output instead.", names[i])
+		}
+
+		branches = append(branches, newBranch(names[i], group, branchObserver, log))
+	}
+
+	client := newClient(clientSettings{
+		Branches:         branches,
+		ACKPolicy:        tConfig.ACKPolicy,
+		MaxPendingEvents: tConfig.MaxPendingEvents,
+		Observer:         observer,
+	}, log)
+	return outputs.Success(tConfig.Queue, tConfig.BulkMaxSize, 0, nil, client)
+}
+
+// branchNames names the branches after the type of their output. Outputs of
+// a type configured more than once get their position appended to their name.
+func branchNames(outs []config.Namespace) []string {
+	count := map[string]int{}
+	for _, out := range outs {
+		count[out.Name()]++
+	}
+
+	names := make([]string, len(outs))
+	for i, out := range outs {
+		names[i] = out.Name()
+		if count[out.Name()] > 1 {
+			names[i] = fmt.Sprintf("%v_%d", out.Name(), i)
+		}
+	}
+	return names
+}
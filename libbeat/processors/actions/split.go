@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+type splitProcessor struct {
+	config splitConfig
+}
+
+type splitConfig struct {
+	Field         string  `config:"field" validate:"required"`
+	Target        *string `config:"target"`
+	IgnoreMissing bool    `config:"ignore_missing"`
+}
+
+var errSplitNotSupported = errors.New("split processor can only be used where events can be split, like the processors of the publishing pipeline")
+
+func init() {
+	processors.RegisterPlugin("split",
+		checks.ConfigChecked(NewSplit,
+			checks.RequireFields("field"),
+			checks.AllowedFields("field", "target", "ignore_missing", "when")))
+}
+
+// NewSplit returns a processor creating one event for each element of an
+// array field.
+func NewSplit(c *conf.C) (beat.Processor, error) {
+	var config splitConfig
+	if err := c.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("fail to unpack the split configuration: %w", err)
+	}
+	return &splitProcessor{config: config}, nil
+}
+
+// Run leaves the event unchanged, splitting events requires RunMulti.
+func (p *splitProcessor) Run(event *beat.Event) (*beat.Event, error) {
+	return event, errSplitNotSupported
+}
+
+// RunMulti returns one event per element of the array field. Each event is a
+// copy of the original event, where the array is replaced by the element.
+// Events without the field, or with an empty array, are returned unchanged.
+func (p *splitProcessor) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	field := p.config.Field
+	v, err := event.GetValue(field)
+	if err != nil {
+		if p.config.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return []*beat.Event{event}, nil
+		}
+		return []*beat.Event{event}, fmt.Errorf("could not fetch value for key: %s, Error: %w", field, err)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []*beat.Event{event}, fmt.Errorf("field %s is not an array but %T", field, v)
+	}
+	if rv.Len() == 0 {
+		return []*beat.Event{event}, nil
+	}
+
+	elements := make([]interface{}, rv.Len())
+	for i := range elements {
+		elements[i] = rv.Index(i).Interface()
+	}
+
+	toRoot := p.config.Target != nil && *p.config.Target == ""
+	if toRoot {
+		for i, e := range elements {
+			m := toObject(e)
+			if m == nil {
+				return []*beat.Event{event}, fmt.Errorf("element %d of field %s is not an object but %T", i, field, e)
+			}
+			elements[i] = m
+		}
+	}
+
+	// The array is removed from the parent first so that it is not copied
+	// into every event.
+	parent := event.Clone()
+	if err := parent.Delete(field); err != nil {
+		return []*beat.Event{event}, fmt.Errorf("failed to remove field %s: %w", field, err)
+	}
+
+	target := field
+	if p.config.Target != nil {
+		target = *p.config.Target
+	}
+
+	events := make([]*beat.Event, len(elements))
+	for i, e := range elements {
+		child := parent
+		if i < len(elements)-1 {
+			child = parent.Clone()
+		}
+
+		if toRoot {
+			child.DeepUpdate(e.(mapstr.M))
+		} else if _, err := child.PutValue(target, e); err != nil {
+			return []*beat.Event{event}, fmt.Errorf("failed to put element %d of field %s into %s: %w", i, field, target, err)
+		}
+		events[i] = child
+	}
+	return events, nil
+}
+
+// SplitsEvents is always true, the processor exists to split events.
+func (p *splitProcessor) SplitsEvents() bool {
+	return true
+}
+
+func toObject(v interface{}) mapstr.M {
+	switch m := v.(type) {
+	case mapstr.M:
+		return m
+	case map[string]interface{}:
+		return mapstr.M(m)
+	default:
+		return nil
+	}
+}
+
+func (p *splitProcessor) String() string {
+	target := p.config.Field
+	if p.config.Target != nil {
+		target = *p.config.Target
+	}
+	return fmt.Sprintf("split=[field=%s, target=%s]", p.config.Field, target)
+}
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package actions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestSplit(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := map[string]struct {
+		config  mapstr.M
+		fields  mapstr.M
+		want    []mapstr.M
+		wantErr bool
+	}{
+		"in place": {
+			config: mapstr.M{"field": "items"},
+			fields: mapstr.M{"id": 1, "items": []interface{}{"a", "b", "c"}},
+			want: []mapstr.M{
+				{"id": 1, "items": "a"},
+				{"id": 1, "items": "b"},
+				{"id": 1, "items": "c"},
+			},
+		},
+		"nested field and typed slice": {
+			config: mapstr.M{"field": "a.b"},
+			fields: mapstr.M{"a": mapstr.M{"b": []string{"x", "y"}, "c": true}},
+			want: []mapstr.M{
+				{"a": mapstr.M{"b": "x", "c": true}},
+				{"a": mapstr.M{"b": "y", "c": true}},
+			},
+		},
+		"target": {
+			config: mapstr.M{"field": "items", "target": "item"},
+			fields: mapstr.M{"items": []interface{}{mapstr.M{"n": 1}, mapstr.M{"n": 2}}},
+			want: []mapstr.M{
+				{"item": mapstr.M{"n": 1}},
+				{"item": mapstr.M{"n": 2}},
+			},
+		},
+		"root target": {
+			config: mapstr.M{"field": "items", "target": ""},
+			fields: mapstr.M{"id": 1, "items": []interface{}{
+				map[string]interface{}{"n": 1},
+				mapstr.M{"n": 2, "id": 2},
+			}},
+			want: []mapstr.M{
+				{"id": 1, "n": 1},
+				{"id": 2, "n": 2},
+			},
+		},
+		"root target requires objects": {
+			config:  mapstr.M{"field": "items", "target": ""},
+			fields:  mapstr.M{"items": []interface{}{mapstr.M{"n": 1}, "b"}},
+			want:    []mapstr.M{{"items": []interface{}{mapstr.M{"n": 1}, "b"}}},
+			wantErr: true,
+		},
+		"empty array": {
+			config: mapstr.M{"field": "items"},
+			fields: mapstr.M{"items": []interface{}{}},
+			want:   []mapstr.M{{"items": []interface{}{}}},
+		},
+		"not an array": {
+			config:  mapstr.M{"field": "items"},
+			fields:  mapstr.M{"items": "a"},
+			want:    []mapstr.M{{"items": "a"}},
+			wantErr: true,
+		},
+		"missing field": {
+			config:  mapstr.M{"field": "items"},
+			fields:  mapstr.M{"id": 1},
+			want:    []mapstr.M{{"id": 1}},
+			wantErr: true,
+		},
+		"ignore missing": {
+			config: mapstr.M{"field": "items", "ignore_missing": true},
+			fields: mapstr.M{"id": 1},
+			want:   []mapstr.M{{"id": 1}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewSplit(conf.MustNewConfigFrom(tc.config))
+			require.NoError(t, err)
+			mp, ok := p.(beat.MultiProcessor)
+			require.True(t, ok)
+			require.True(t, mp.SplitsEvents())
+
+			event := &beat.Event{
+				Timestamp: ts,
+				Meta:      mapstr.M{"_id": "abc"},
+				Fields:    tc.fields,
+			}
+			events, err := mp.RunMulti(event)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, events, len(tc.want))
+			for i, e := range events {
+				assert.Equal(t, tc.want[i], e.Fields, "event %d", i)
+				assert.Equal(t, ts, e.Timestamp)
+				assert.Equal(t, mapstr.M{"_id": "abc"}, e.Meta)
+			}
+		})
+	}
+}
+
+func TestSplitEventsAreIndependent(t *testing.T) {
+	p, err := NewSplit(conf.MustNewConfigFrom(mapstr.M{"field": "items"}))
+	require.NoError(t, err)
+
+	events, err := p.(beat.MultiProcessor).RunMulti(&beat.Event{Fields: mapstr.M{
+		"items":  []interface{}{1, 2},
+		"nested": mapstr.M{"a": 1},
+	}})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	_, err = events[0].PutValue("nested.a", 2)
+	require.NoError(t, err)
+	v, err := events[1].GetValue("nested.a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+}
+
+func TestSplitRun(t *testing.T) {
+	p, err := NewSplit(conf.MustNewConfigFrom(mapstr.M{"field": "items"}))
+	require.NoError(t, err)
+
+	event := &beat.Event{Fields: mapstr.M{"items": []interface{}{1, 2}}}
+	out, err := p.Run(event)
+	assert.ErrorIs(t, err, errSplitNotSupported)
+	assert.Same(t, event, out)
+}
+
+func TestSplitInProcessors(t *testing.T) {
+	ps, err := processors.New([]*conf.C{
+		conf.MustNewConfigFrom(mapstr.M{"add_fields": mapstr.M{"target": "", "fields": mapstr.M{"before": true}}}),
+		conf.MustNewConfigFrom(mapstr.M{"split": mapstr.M{
+			"field": "items",
+			"when":  mapstr.M{"has_fields": []string{"items"}},
+		}}),
+		conf.MustNewConfigFrom(mapstr.M{"drop_event": mapstr.M{"when": mapstr.M{"equals": mapstr.M{"items": 2}}}}),
+		conf.MustNewConfigFrom(mapstr.M{"add_tags": mapstr.M{"tags": []string{"split"}}}),
+	})
+	require.NoError(t, err)
+	require.True(t, ps.SplitsEvents())
+
+	events, err := ps.RunMulti(&beat.Event{Fields: mapstr.M{"items": []interface{}{1, 2, 3}}})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	for i, want := range []int{1, 3} {
+		assert.Equal(t, mapstr.M{
+			"before": true,
+			"items":  want,
+			"tags":   []string{"split"},
+		}, events[i].Fields)
+	}
+
+	events, err = ps.RunMulti(&beat.Event{Fields: mapstr.M{"id": 1}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, mapstr.M{"before": true, "id": 1, "tags": []string{"split"}}, events[0].Fields)
+}
@@ -109,6 +109,24 @@ func getStoreFor(cfg config, log *logp.Logger) (Store, context.CancelFunc, error
 	}
 }
 
+// NewStore returns the backing store configured by backend, which holds the
+// backend settings of a cache processor, storing values for ttl. Stores are
+// shared by ID with cache processors and other NewStore callers. The TTL of
+// a shared store is set by its first user putting values. The returned
+// context.CancelFunc releases the store and must be called when it is no
+// longer required.
+func NewStore(backend *conf.C, ttl time.Duration, log *logp.Logger) (Store, context.CancelFunc, error) {
+	var store storeConfig
+	if err := backend.Unpack(&store); err != nil {
+		return nil, noop, fmt.Errorf("failed to unpack the %s backend configuration: %w", name, err)
+	}
+	cfg := config{
+		Store: &store,
+		Put:   &putConfig{TTL: &ttl},
+	}
+	return getStoreFor(cfg, log)
+}
+
 // noop is a no-op context.CancelFunc.
 func noop() {}
 
@@ -84,6 +84,19 @@ func (r *WhenProcessor) Run(event *beat.Event) (*beat.Event, error) {
 	return r.p.Run(event)
 }
 
+// RunMulti executes this WhenProcessor on processors that may split events.
+func (r *WhenProcessor) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	if !(r.condition).Check(event) {
+		return []*beat.Event{event}, nil
+	}
+	return RunMulti(r.p, event)
+}
+
+// SplitsEvents reports whether the conditional processor can split events.
+func (r *WhenProcessor) SplitsEvents() bool {
+	return SplitsEvents(r.p)
+}
+
 func (r *WhenProcessor) String() string {
 	return fmt.Sprintf("%v, condition=%v", r.p.String(), r.condition.String())
 }
@@ -171,6 +184,21 @@ func (p *IfThenElseProcessor) Run(event *beat.Event) (*beat.Event, error) {
 	return event, nil
 }
 
+// RunMulti is like Run, for processors that may split events.
+func (p *IfThenElseProcessor) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	if p.cond.Check(event) {
+		return RunMulti(p.then, event)
+	} else if p.els != nil {
+		return RunMulti(p.els, event)
+	}
+	return []*beat.Event{event}, nil
+}
+
+// SplitsEvents reports whether the then or else processors can split events.
+func (p *IfThenElseProcessor) SplitsEvents() bool {
+	return p.then.SplitsEvents() || p.els.SplitsEvents()
+}
+
 func (p *IfThenElseProcessor) String() string {
 	var sb strings.Builder
 	sb.WriteString("if ")
@@ -0,0 +1,277 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/paths"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+func init() {
+	// We cannot use this as a JS plugin as it includes a Close method.
+	processors.RegisterPlugin("decode_avro_fields",
+		checks.ConfigChecked(NewDecodeAvroFields,
+			checks.RequireFields("fields"),
+			checks.AllowedFields("fields", "target", "overwrite_keys", "add_error_key", "encoding",
+				"schema_file", "schema_registry", "when")))
+}
+
+const (
+	// maxSchemaSize limits the size of the schemas read from the registry.
+	maxSchemaSize = 10 * 1024 * 1024
+
+	// defaultRegistryTimeout is the timeout of the schema registry requests.
+	defaultRegistryTimeout = 10 * time.Second
+
+	// schemaRetryBackoff is the time during which the error of a failed
+	// schema fetch is returned before the registry is queried again.
+	schemaRetryBackoff = 5 * time.Second
+)
+
+type avroConfig struct {
+	SchemaFile     string                `config:"schema_file"`
+	SchemaRegistry *schemaRegistryConfig `config:"schema_registry"`
+}
+
+// schemaRegistryConfig configures the access to a Confluent compatible schema
+// registry.
+type schemaRegistryConfig struct {
+	URL       string                           `config:"url" validate:"required"`
+	Username  string                           `config:"username"`
+	Password  string                           `config:"password"`
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+// InitDefaults initializes the defaults of the registry settings.
+func (c *schemaRegistryConfig) InitDefaults() {
+	c.Transport = httpcommon.DefaultHTTPTransportSettings()
+	c.Transport.Timeout = defaultRegistryTimeout
+}
+
+// Validate validates the data contained in the config.
+func (c *avroConfig) Validate() error {
+	if (c.SchemaFile == "") == (c.SchemaRegistry == nil) {
+		return errors.New("exactly one of schema_file or schema_registry must be set")
+	}
+	return nil
+}
+
+type avroDecoder struct {
+	avroConfig
+
+	// schema is the schema of the schema file.
+	schema *avroSchema
+
+	// The schemas read from the registry, by ID. Concurrent fetches of the
+	// same schema are shared, and failed fetches are kept in failures to
+	// not query the registry for every event while it is unavailable.
+	client   *http.Client
+	fetches  singleflight.Group
+	mu       sync.Mutex
+	schemas  map[uint32]*avroSchema
+	failures map[uint32]schemaFailure
+}
+
+// schemaFailure is the error of a failed schema fetch, returned until
+// retryAt.
+type schemaFailure struct {
+	err     error
+	retryAt time.Time
+}
+
+// NewDecodeAvroFields constructs a new decode_avro_fields processor.
+func NewDecodeAvroFields(cfg *conf.C) (beat.Processor, error) {
+	var c avroConfig
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the decode_avro_fields configuration: %w", err)
+	}
+
+	d := &avroDecoder{avroConfig: c}
+	if c.SchemaFile != "" {
+		path := paths.Resolve(paths.Config, c.SchemaFile)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Avro schema: %w", err)
+		}
+		if d.schema, err = parseAvroSchema(raw); err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+	} else {
+		client, err := c.SchemaRegistry.Transport.Client()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the schema registry client: %w", err)
+		}
+		d.client = client
+		d.schemas = map[uint32]*avroSchema{}
+		d.failures = map[uint32]schemaFailure{}
+		d.SchemaRegistry.URL = strings.TrimSuffix(d.SchemaRegistry.URL, "/")
+	}
+	p, err := newProcessor("decode_avro_fields", "Avro", cfg, d)
+	if err != nil {
+		return nil, err
+	}
+	return &avroProcessor{processor: p, decoder: d}, nil
+}
+
+// avroProcessor is the decode_avro_fields processor. Unlike the other
+// decoders, the Avro decoder holds connections to the schema registry that
+// must be closed, so only this processor implements processors.Closer.
+type avroProcessor struct {
+	*processor
+	decoder *avroDecoder
+}
+
+// Close closes the idle connections to the schema registry.
+func (p *avroProcessor) Close() error {
+	return p.decoder.Close()
+}
+
+// decode decodes plain Avro data with the schema file, or data framed in the
+// Confluent wire format with a schema of the registry. The frame is a zero
+// byte and the big endian schema ID, followed by the Avro data.
+func (d *avroDecoder) decode(data []byte) (interface{}, error) {
+	if d.schema != nil {
+		return decodeAvro(d.schema, data)
+	}
+
+	if len(data) < 5 || data[0] != 0 {
+		return nil, errors.New("data does not start with a schema registry header")
+	}
+	schema, err := d.registrySchema(binary.BigEndian.Uint32(data[1:5]))
+	if err != nil {
+		return nil, err
+	}
+	return decodeAvro(schema, data[5:])
+}
+
+// registrySchema returns the schema with the given ID, reading it from the
+// registry if it is not cached yet. The lock is not held while the schema is
+// fetched, so events using cached schemas are not delayed.
+func (d *avroDecoder) registrySchema(id uint32) (*avroSchema, error) {
+	d.mu.Lock()
+	if s, ok := d.schemas[id]; ok {
+		d.mu.Unlock()
+		return s, nil
+	}
+	if f, ok := d.failures[id]; ok && time.Now().Before(f.retryAt) {
+		d.mu.Unlock()
+		return nil, f.err
+	}
+	d.mu.Unlock()
+
+	s, err, _ := d.fetches.Do(strconv.FormatUint(uint64(id), 10), func() (interface{}, error) {
+		s, err := d.loadSchema(id)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err != nil {
+			d.failures[id] = schemaFailure{err: err, retryAt: time.Now().Add(schemaRetryBackoff)}
+			return nil, err
+		}
+		delete(d.failures, id)
+		d.schemas[id] = s
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.(*avroSchema), nil
+}
+
+// loadSchema fetches and parses the schema with the given ID.
+func (d *avroDecoder) loadSchema(id uint32) (*avroSchema, error) {
+	raw, err := d.fetchSchema(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema %d from the registry: %w", id, err)
+	}
+	s, err := parseAvroSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+	return s, nil
+}
+
+func (d *avroDecoder) fetchSchema(id uint32) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, d.SchemaRegistry.URL+"/schemas/ids/"+strconv.FormatUint(uint64(id), 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+	if d.SchemaRegistry.Username != "" || d.SchemaRegistry.Password != "" {
+		req.SetBasicAuth(d.SchemaRegistry.Username, d.SchemaRegistry.Password)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSchemaSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+
+	var r struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err = json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse the registry response: %w", err)
+	}
+	if r.SchemaType != "" && r.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema type is %s, not AVRO", r.SchemaType)
+	}
+	return []byte(r.Schema), nil
+}
+
+// Close closes the idle connections to the registry.
+func (d *avroDecoder) Close() error {
+	if d.client != nil {
+		d.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (d *avroDecoder) String() string {
+	if d.SchemaRegistry != nil {
+		return "format=avro, schema_registry=" + d.SchemaRegistry.URL
+	}
+	return "format=avro, schema_file=" + d.SchemaFile
+}
@@ -0,0 +1,447 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// This file implements a decoder for the Avro binary encoding as described in
+// https://avro.apache.org/docs/1.11.1/specification/.
+
+// maxAvroDepth limits the nesting of decoded values to protect against
+// recursive schemas and corrupted data.
+const maxAvroDepth = 64
+
+var errAvroUnexpectedEnd = errors.New("unexpected end of Avro data")
+
+type avroType uint8
+
+const (
+	avroNull avroType = iota
+	avroBoolean
+	avroInt
+	avroLong
+	avroFloat
+	avroDouble
+	avroBytes
+	avroString
+	avroRecord
+	avroEnum
+	avroArray
+	avroMap
+	avroUnion
+	avroFixed
+)
+
+var avroPrimitives = map[string]avroType{
+	"null":    avroNull,
+	"boolean": avroBoolean,
+	"int":     avroInt,
+	"long":    avroLong,
+	"float":   avroFloat,
+	"double":  avroDouble,
+	"bytes":   avroBytes,
+	"string":  avroString,
+}
+
+// avroSchema is a parsed Avro schema.
+type avroSchema struct {
+	typ         avroType
+	name        string // Full name of named types.
+	logicalType string
+
+	fields   []avroField   // Record fields.
+	symbols  []string      // Enum symbols.
+	items    *avroSchema   // Array items or map values.
+	branches []*avroSchema // Union branches.
+	size     int           // Fixed size.
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+// parseAvroSchema parses a schema in its JSON representation.
+func parseAvroSchema(raw []byte) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	p := avroSchemaParser{named: map[string]*avroSchema{}}
+	s, err := p.parse(v, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	return s, nil
+}
+
+type avroSchemaParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroSchemaParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if t, ok := avroPrimitives[v]; ok {
+			return &avroSchema{typ: t}, nil
+		}
+		return p.lookup(v, namespace)
+	case []interface{}:
+		s := &avroSchema{typ: avroUnion}
+		for _, b := range v {
+			branch, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	default:
+		return nil, fmt.Errorf("unexpected schema value of type %T", v)
+	}
+}
+
+func (p *avroSchemaParser) parseComplex(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	logicalType, _ := v["logicalType"].(string)
+
+	typeName, ok := v["type"].(string)
+	if !ok {
+		// The type is itself a schema.
+		return p.parse(v["type"], namespace)
+	}
+	if t, ok := avroPrimitives[typeName]; ok {
+		return &avroSchema{typ: t, logicalType: logicalType}, nil
+	}
+
+	switch typeName {
+	case "record", "error", "enum", "fixed":
+		s := &avroSchema{logicalType: logicalType}
+		if err := p.define(s, v, &namespace); err != nil {
+			return nil, err
+		}
+		switch typeName {
+		case "enum":
+			s.typ = avroEnum
+			symbols, _ := v["symbols"].([]interface{})
+			for _, sym := range symbols {
+				name, ok := sym.(string)
+				if !ok {
+					return nil, fmt.Errorf("enum %s has a symbol that is not a string", s.name)
+				}
+				s.symbols = append(s.symbols, name)
+			}
+		case "fixed":
+			s.typ = avroFixed
+			size, ok := v["size"].(float64)
+			if !ok || size < 0 || size != math.Trunc(size) {
+				return nil, fmt.Errorf("fixed %s has an invalid size", s.name)
+			}
+			s.size = int(size)
+		default:
+			s.typ = avroRecord
+			fields, ok := v["fields"].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record %s has no fields", s.name)
+			}
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				name, _ := field["name"].(string)
+				if name == "" {
+					return nil, fmt.Errorf("record %s has a field without name", s.name)
+				}
+				fs, err := p.parse(field["type"], namespace)
+				if err != nil {
+					return nil, fmt.Errorf("field %s of record %s: %w", name, s.name, err)
+				}
+				s.fields = append(s.fields, avroField{name: name, schema: fs})
+			}
+		}
+		return s, nil
+	case "array", "map":
+		key := "items"
+		s := &avroSchema{typ: avroArray, logicalType: logicalType}
+		if typeName == "map" {
+			key, s.typ = "values", avroMap
+		}
+		items, err := p.parse(v[key], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+		return s, nil
+	default:
+		return p.lookup(typeName, namespace)
+	}
+}
+
+// define registers a named type, and updates namespace to the namespace of
+// the type.
+func (p *avroSchemaParser) define(s *avroSchema, v map[string]interface{}, namespace *string) error {
+	name, _ := v["name"].(string)
+	if name == "" {
+		return errors.New("named type without name")
+	}
+	if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+		*namespace = ns
+	}
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		*namespace = name[:i]
+	} else if *namespace != "" {
+		name = *namespace + "." + name
+	}
+	if _, exists := p.named[name]; exists {
+		return fmt.Errorf("type %s is defined twice", name)
+	}
+	s.name = name
+	p.named[name] = s
+	return nil
+}
+
+func (p *avroSchemaParser) lookup(name, namespace string) (*avroSchema, error) {
+	if !strings.Contains(name, ".") && namespace != "" {
+		if s, ok := p.named[namespace+"."+name]; ok {
+			return s, nil
+		}
+	}
+	if s, ok := p.named[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", name)
+}
+
+// decodeAvro decodes a value of schema s from data.
+func decodeAvro(s *avroSchema, data []byte) (interface{}, error) {
+	r := avroReader{buf: data}
+	v, err := r.read(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.buf) > 0 {
+		return nil, fmt.Errorf("%d bytes left after the Avro value", len(r.buf))
+	}
+	return v, nil
+}
+
+type avroReader struct {
+	buf []byte
+}
+
+func (r *avroReader) read(s *avroSchema, depth int) (interface{}, error) {
+	if depth > maxAvroDepth {
+		return nil, errors.New("maximum Avro nesting depth exceeded")
+	}
+
+	switch s.typ {
+	case avroNull:
+		return nil, nil
+	case avroBoolean:
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case avroInt, avroLong:
+		v, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == avroInt && (v < math.MinInt32 || v > math.MaxInt32) {
+			return nil, fmt.Errorf("int value %d out of range", v)
+		}
+		return avroLogicalLong(s.logicalType, v), nil
+	case avroFloat:
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case avroDouble:
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case avroBytes, avroString:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == avroString {
+			return string(b), nil
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case avroFixed:
+		b, err := r.next(s.size)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case avroEnum:
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("enum %s index %d out of range", s.name, i)
+		}
+		return s.symbols[i], nil
+	case avroUnion:
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.branches)) {
+			return nil, fmt.Errorf("union index %d out of range", i)
+		}
+		return r.read(s.branches[i], depth+1)
+	case avroRecord:
+		m := make(mapstr.M, len(s.fields))
+		for _, f := range s.fields {
+			v, err := r.read(f.schema, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				m[f.name] = v
+			}
+		}
+		return m, nil
+	case avroArray:
+		a := []interface{}{}
+		err := r.blocks(func() error {
+			v, err := r.read(s.items, depth+1)
+			a = append(a, v)
+			return err
+		})
+		return a, err
+	case avroMap:
+		m := mapstr.M{}
+		err := r.blocks(func() error {
+			k, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			v, err := r.read(s.items, depth+1)
+			m[string(k)] = v
+			return err
+		})
+		return m, err
+	default:
+		return nil, fmt.Errorf("unsupported Avro type %d", s.typ)
+	}
+}
+
+// avroLogicalLong converts int and long values with a date or timestamp
+// logical type to time.Time.
+func avroLogicalLong(logicalType string, v int64) interface{} {
+	switch logicalType {
+	case "date":
+		return time.Unix(v*24*60*60, 0).UTC()
+	case "timestamp-millis", "local-timestamp-millis":
+		return time.UnixMilli(v).UTC()
+	case "timestamp-micros", "local-timestamp-micros":
+		return time.UnixMicro(v).UTC()
+	default:
+		return v
+	}
+}
+
+// blocks reads the blocks of an array or a map, calling item for each item.
+func (r *avroReader) blocks(item func() error) error {
+	for {
+		n, err := r.long()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n < 0 {
+			// Negative counts are followed by the size of the block.
+			if n == math.MinInt64 {
+				return fmt.Errorf("invalid block count %d", n)
+			}
+			n = -n
+			if _, err = r.long(); err != nil {
+				return err
+			}
+		}
+		// Every item takes at least one byte, except nulls.
+		if n > int64(len(r.buf))+1 && n > maxAvroNullItems {
+			return fmt.Errorf("block count %d exceeds the data size", n)
+		}
+		for ; n > 0; n-- {
+			if err = item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// maxAvroNullItems limits the number of zero byte items of a block.
+const maxAvroNullItems = 1 << 16
+
+// long reads a zig-zag encoded variable length integer.
+func (r *avroReader) long() (int64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(r.buf) == 0 {
+			return 0, errAvroUnexpectedEnd
+		}
+		b := r.buf[0]
+		r.buf = r.buf[1:]
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return int64(v>>1) ^ -int64(v&1), nil
+		}
+	}
+	return 0, errors.New("variable length integer is too long")
+}
+
+func (r *avroReader) bytes() ([]byte, error) {
+	n, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(len(r.buf)) {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	return r.next(int(n))
+}
+
+func (r *avroReader) next(n int) ([]byte, error) {
+	if n > len(r.buf) {
+		return nil, errAvroUnexpectedEnd
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
@@ -0,0 +1,288 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "test",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "user", "type": ["null", "string"]},
+    {"name": "email", "type": ["null", "string"]},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["INFO", "WARN"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "counts", "type": {"type": "map", "values": "int"}},
+    {"name": "ratio", "type": "double"},
+    {"name": "score", "type": "float"},
+    {"name": "ok", "type": "boolean"},
+    {"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "day", "type": {"type": "int", "logicalType": "date"}},
+    {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+    {"name": "raw", "type": "bytes"},
+    {"name": "parent", "type": ["null", "Event"]}
+  ]
+}`
+
+// avroEncoder builds Avro binary data.
+type avroEncoder []byte
+
+func (e avroEncoder) long(v int64) avroEncoder {
+	return binary.AppendUvarint(e, uint64(v<<1)^uint64(v>>63))
+}
+
+func (e avroEncoder) str(s string) avroEncoder {
+	return append(e.long(int64(len(s))), s...)
+}
+
+func (e avroEncoder) raw(b ...byte) avroEncoder {
+	return append(e, b...)
+}
+
+// testAvroEvent encodes a test.Event record.
+func testAvroEvent(created time.Time) []byte {
+	var score [4]byte
+	binary.LittleEndian.PutUint32(score[:], math.Float32bits(1.5))
+	var ratio [8]byte
+	binary.LittleEndian.PutUint64(ratio[:], math.Float64bits(0.25))
+
+	return avroEncoder(nil).
+		long(-42).
+		long(1).str("alice"). // user
+		long(0).              // email
+		long(1).              // level
+		long(2).str("a").str("b").long(0).
+		long(-1).long(4).str("x").long(3).long(0). // counts, in a block with its size
+		raw(ratio[:]...).
+		raw(score[:]...).
+		raw(1).
+		long(created.UnixMilli()).
+		long(19844). // day
+		raw(0xca, 0xfe).
+		long(3).raw(1, 2, 3).
+		long(1). // parent
+		long(-1).long(0).long(0).long(0).long(0).long(0).
+		raw(ratio[:]...).raw(score[:]...).raw(0).long(0).long(0).raw(0, 0).long(0).long(0)
+}
+
+func TestDecodeAvroSchemaFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event.avsc")
+	require.NoError(t, os.WriteFile(path, []byte(testAvroSchema), 0o600))
+
+	p, err := NewDecodeAvroFields(conf.MustNewConfigFrom(mapstr.M{
+		"fields":      []string{"payload"},
+		"schema_file": path,
+	}))
+	require.NoError(t, err)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": testAvroEvent(created)}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{
+		"id":      int64(-42),
+		"user":    "alice",
+		"level":   "WARN",
+		"tags":    []interface{}{"a", "b"},
+		"counts":  mapstr.M{"x": int64(3)},
+		"ratio":   0.25,
+		"score":   1.5,
+		"ok":      true,
+		"created": created,
+		"day":     time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		"hash":    "yv4=",
+		"raw":     "AQID",
+		"parent": mapstr.M{
+			"id":      int64(-1),
+			"level":   "INFO",
+			"tags":    []interface{}{},
+			"counts":  mapstr.M{},
+			"ratio":   0.25,
+			"score":   1.5,
+			"ok":      false,
+			"created": time.UnixMilli(0).UTC(),
+			"day":     time.Unix(0, 0).UTC(),
+			"hash":    "AAA=",
+			"raw":     "",
+		},
+	}, evt.Fields["payload"])
+
+	// Truncated data.
+	data := testAvroEvent(created)
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": data[:len(data)-1]}})
+	assert.ErrorContains(t, err, "unexpected end of Avro data")
+}
+
+func TestDecodeAvroSchemaRegistry(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		user, pass, _ := r.BasicAuth()
+		if user != "beats" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/schemas/ids/7" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": `{"type":"record","name":"R","fields":[{"name":"n","type":"int"}]}`})
+	}))
+	defer srv.Close()
+
+	p, err := NewDecodeAvroFields(conf.MustNewConfigFrom(mapstr.M{
+		"fields": []string{"payload"},
+		"target": "",
+		"schema_registry": mapstr.M{
+			"url":      srv.URL + "/",
+			"username": "beats",
+			"password": "secret",
+		},
+	}))
+	require.NoError(t, err)
+	defer p.(*avroProcessor).Close()
+
+	frame := func(id uint32, data []byte) []byte {
+		return append(binary.BigEndian.AppendUint32([]byte{0}, id), data...)
+	}
+	for i := 0; i < 3; i++ {
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(7, avroEncoder(nil).long(5))}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), evt.Fields["n"])
+	}
+	assert.EqualValues(t, 1, requests.Load(), "the schema is cached")
+
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(8, nil)}})
+	assert.ErrorContains(t, err, "failed to get schema 8 from the registry")
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(8, nil)}})
+	assert.ErrorContains(t, err, "failed to get schema 8 from the registry")
+	assert.EqualValues(t, 2, requests.Load(), "the failure is cached")
+
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": []byte{1, 2}}})
+	assert.ErrorContains(t, err, "schema registry header")
+}
+
+func TestDecodeAvroSchemaRegistryUnavailable(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/schemas/ids/2" {
+			// The registry hangs when queried for this schema.
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": `"int"`})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, err := NewDecodeAvroFields(conf.MustNewConfigFrom(mapstr.M{
+		"fields":          []string{"payload"},
+		"schema_registry": mapstr.M{"url": srv.URL},
+	}))
+	require.NoError(t, err)
+	defer p.(*avroProcessor).Close()
+
+	frame := func(id uint32, data []byte) []byte {
+		return append(binary.BigEndian.AppendUint32([]byte{0}, id), data...)
+	}
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(1, avroEncoder(nil).long(1))}})
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(2, avroEncoder(nil).long(1))}})
+	}()
+
+	// Events with a cached schema are not blocked by the pending fetch.
+	done := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": frame(1, avroEncoder(nil).long(1))}})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event with a cached schema blocked by a pending registry request")
+	}
+}
+
+func TestParseAvroSchema(t *testing.T) {
+	for name, schema := range map[string]string{
+		"invalid json":   `{`,
+		"unknown type":   `"Missing"`,
+		"unnamed record": `{"type": "record", "fields": []}`,
+		"duplicate name": `{"type": "record", "name": "A", "fields": [{"name": "a", "type": {"type": "fixed", "name": "A", "size": 1}}]}`,
+		"bad fixed size": `{"type": "fixed", "name": "F", "size": -1}`,
+		"field no name":  `{"type": "record", "name": "A", "fields": [{"type": "int"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseAvroSchema([]byte(schema))
+			assert.Error(t, err)
+		})
+	}
+
+	// Names are resolved in the namespace of the enclosing type.
+	s, err := parseAvroSchema([]byte(`{"type": "record", "name": "a.A", "fields": [
+		{"name": "b", "type": {"type": "record", "name": "B", "fields": []}},
+		{"name": "c", "type": "a.B"},
+		{"name": "d", "type": "B"}
+	]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "a.B", s.fields[1].schema.name)
+	assert.Same(t, s.fields[1].schema, s.fields[2].schema)
+}
+
+func TestDecodeAvroLimits(t *testing.T) {
+	// A huge block of null items.
+	s, err := parseAvroSchema([]byte(`{"type": "array", "items": "null"}`))
+	require.NoError(t, err)
+	_, err = decodeAvro(s, avroEncoder(nil).long(math.MaxInt64))
+	assert.ErrorContains(t, err, "exceeds the data size")
+
+	// Deeply nested recursive records.
+	s, err = parseAvroSchema([]byte(`{"type": "record", "name": "L", "fields": [{"name": "next", "type": ["null", "L"]}]}`))
+	require.NoError(t, err)
+	var data avroEncoder
+	for i := 0; i < 100; i++ {
+		data = data.long(1)
+	}
+	_, err = decodeAvro(s, data.long(0))
+	assert.ErrorContains(t, err, "maximum Avro nesting depth exceeded")
+}
@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Encodings of string fields.
+const (
+	encodingBase64 = "base64"
+	encodingRaw    = "raw"
+)
+
+// config holds the settings shared by the binary decoding processors. They
+// follow the conventions of decode_json_fields.
+type config struct {
+	Fields        []string `config:"fields" validate:"required"`
+	Target        *string  `config:"target"`
+	OverwriteKeys bool     `config:"overwrite_keys"`
+	AddErrorKey   bool     `config:"add_error_key"`
+
+	// Encoding of string fields, byte slices are always decoded as is.
+	Encoding string `config:"encoding"`
+}
+
+func defaultConfig() config {
+	return config{
+		Encoding: encodingBase64,
+	}
+}
+
+// Validate validates the data contained in the config.
+func (c *config) Validate() error {
+	switch c.Encoding {
+	case encodingBase64, encodingRaw:
+	default:
+		return fmt.Errorf("unknown encoding '%s', must be %s or %s", c.Encoding, encodingBase64, encodingRaw)
+	}
+	if len(c.Fields) == 0 {
+		return errors.New("at least one field is required")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package decode_binary_fields provides processors decoding fields holding
+// Protobuf, Avro or MessagePack encoded data.
+package decode_binary_fields
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/jsontransform"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// decoder decodes binary data into event fields. Objects are returned as
+// mapstr.M.
+type decoder interface {
+	decode(data []byte) (interface{}, error)
+	String() string
+}
+
+// processor decodes the configured fields with a decoder.
+type processor struct {
+	config
+	name    string // Name of the processor.
+	format  string // Name of the data format, used in error messages.
+	decoder decoder
+	log     *logp.Logger
+}
+
+// newProcessor unpacks the shared settings and returns a processor using
+// the decoder.
+func newProcessor(name, format string, cfg *conf.C, d decoder) (*processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the %s configuration: %w", name, err)
+	}
+	return &processor{
+		config:  c,
+		name:    name,
+		format:  format,
+		decoder: d,
+		log:     logp.NewLogger(name),
+	}, nil
+}
+
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	var errs []string
+
+	for _, field := range p.Fields {
+		value, err := event.GetValue(field)
+		if err != nil {
+			if !errors.Is(err, mapstr.ErrKeyNotFound) {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+
+		data, err := p.bytes(value)
+		if err != nil {
+			errs = append(errs, err.Error())
+			event.SetErrorWithOption(fmt.Sprintf("reading %s input: %s", p.format, err), p.AddErrorKey, "", field)
+			continue
+		}
+
+		output, err := p.decoder.decode(data)
+		if err != nil {
+			p.log.Debugf("Error trying to decode %s field %s: %v", p.format, field, err)
+			errs = append(errs, err.Error())
+			event.SetErrorWithOption(fmt.Sprintf("parsing input as %s: %s", p.format, err), p.AddErrorKey, "", field)
+			continue
+		}
+
+		target := field
+		if p.Target != nil {
+			target = *p.Target
+		}
+
+		if target != "" {
+			if _, err = event.PutValue(target, output); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+
+		if m, ok := output.(mapstr.M); ok {
+			jsontransform.WriteJSONKeys(event, m, false, p.OverwriteKeys, p.AddErrorKey)
+		} else {
+			errs = append(errs, "failed to add target to root")
+		}
+	}
+
+	if len(errs) > 0 {
+		return event, errors.New(strings.Join(errs, ", "))
+	}
+	return event, nil
+}
+
+// bytes returns the encoded data held by a field value.
+func (p *processor) bytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		if p.Encoding == encodingRaw {
+			return []byte(v), nil
+		}
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 value: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unexpected type %T, expected a string or bytes", value)
+	}
+}
+
+func (p *processor) String() string {
+	return fmt.Sprintf("%s=[fields=%v, %v]", p.name, p.Fields, p.decoder)
+}
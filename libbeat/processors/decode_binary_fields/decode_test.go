@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors/script/javascript"
+	_ "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor"
+	_ "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/require"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func encodeMsgpack(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	var (
+		buf bytes.Buffer
+		h   codec.MsgpackHandle
+	)
+	h.WriteExt = true
+	require.NoError(t, codec.NewEncoder(&buf, &h).Encode(v))
+	return buf.Bytes()
+}
+
+func newMsgpackProcessor(t *testing.T, settings mapstr.M) beat.Processor {
+	t.Helper()
+	p, err := NewDecodeMsgpackFields(conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p
+}
+
+func TestDecodeMsgpack(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data := encodeMsgpack(t, map[string]interface{}{
+		"user":    map[string]interface{}{"name": "alice", "id": 42},
+		"tags":    []interface{}{"a", "b"},
+		"ratio":   0.5,
+		"ok":      true,
+		"raw":     []byte{1, 2, 3},
+		"time":    ts,
+		"nothing": nil,
+	})
+
+	p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}, "target": "decoded"})
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": base64.StdEncoding.EncodeToString(data)}})
+	require.NoError(t, err)
+
+	decoded, err := evt.GetValue("decoded")
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{
+		"user":    mapstr.M{"name": "alice", "id": int64(42)},
+		"tags":    []interface{}{"a", "b"},
+		"ratio":   0.5,
+		"ok":      true,
+		"raw":     "AQID",
+		"time":    ts,
+		"nothing": nil,
+	}, decoded)
+}
+
+func TestDecodeMsgpackNonStringKeys(t *testing.T) {
+	p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}})
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": encodeMsgpack(t, map[int]string{1: "one"})}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{"payload": mapstr.M{"1": "one"}}, evt.Fields)
+}
+
+func TestDecodeTargets(t *testing.T) {
+	data := encodeMsgpack(t, map[string]interface{}{"message": "decoded", "level": "info"})
+
+	t.Run("replace field", func(t *testing.T) {
+		p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}})
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": data}})
+		require.NoError(t, err)
+		assert.Equal(t, mapstr.M{"payload": mapstr.M{"message": "decoded", "level": "info"}}, evt.Fields)
+	})
+
+	t.Run("root", func(t *testing.T) {
+		p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}, "target": ""})
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": data, "message": "original"}})
+		require.NoError(t, err)
+		assert.Equal(t, mapstr.M{"payload": data, "message": "original", "level": "info"}, evt.Fields)
+	})
+
+	t.Run("root overwrite", func(t *testing.T) {
+		p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}, "target": "", "overwrite_keys": true})
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": data, "message": "original"}})
+		require.NoError(t, err)
+		assert.Equal(t, mapstr.M{"payload": data, "message": "decoded", "level": "info"}, evt.Fields)
+	})
+
+	t.Run("raw string", func(t *testing.T) {
+		p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"payload"}, "encoding": "raw", "target": "x"})
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": string(data)}})
+		require.NoError(t, err)
+		assert.Equal(t, mapstr.M{"message": "decoded", "level": "info"}, evt.Fields["x"])
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	p := newMsgpackProcessor(t, mapstr.M{"fields": []string{"a", "b", "c", "missing"}, "add_error_key": true})
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{
+		"a": "not base64!",
+		"b": []byte{0xc1},
+		"c": append(encodeMsgpack(t, 1), 0x01),
+	}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to decode base64 value")
+	assert.Contains(t, err.Error(), "1 bytes left after the MessagePack value")
+
+	// The fields are kept, and the last error is added to the event.
+	assert.Equal(t, "not base64!", evt.Fields["a"])
+	msg, _ := evt.GetValue("error.message")
+	assert.Contains(t, msg, "parsing input as MessagePack")
+
+	_, err = NewDecodeMsgpackFields(conf.MustNewConfigFrom(mapstr.M{"fields": []string{"a"}, "encoding": "hex"}))
+	assert.Error(t, err)
+}
+
+func TestJavascriptPlugins(t *testing.T) {
+	msgpack := encodeMsgpack(t, map[string]interface{}{"message": "decoded"})
+	protobuf := encodeTestEvent(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	script := `
+var processor = require('processor');
+
+var msgpack = new processor.DecodeMsgpackFields({fields: ["msgpack"], target: "msgpack"});
+var protobuf = new processor.DecodeProtobufFields({
+    fields: ["protobuf"],
+    target: "protobuf",
+    descriptor_set: ` + strconv.Quote(writeDescriptorSet(t)) + `,
+    message_type: "test.Event",
+});
+
+function process(evt) {
+    msgpack.Run(evt);
+    protobuf.Run(evt);
+}
+`
+
+	p, err := javascript.NewFromConfig(javascript.Config{Source: script}, nil)
+	require.NoError(t, err)
+
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{
+		"msgpack":  base64.StdEncoding.EncodeToString(msgpack),
+		"protobuf": base64.StdEncoding.EncodeToString(protobuf),
+	}})
+	require.NoError(t, err)
+
+	message, err := evt.GetValue("msgpack.message")
+	require.NoError(t, err)
+	assert.Equal(t, "decoded", message)
+	name, err := evt.GetValue("protobuf.user_name")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+}
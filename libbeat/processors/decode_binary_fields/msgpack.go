@@ -0,0 +1,96 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func init() {
+	processors.RegisterPlugin("decode_msgpack_fields",
+		checks.ConfigChecked(NewDecodeMsgpackFields,
+			checks.RequireFields("fields"),
+			checks.AllowedFields("fields", "target", "overwrite_keys", "add_error_key", "encoding", "when")))
+
+	jsprocessor.RegisterPlugin("DecodeMsgpackFields", NewDecodeMsgpackFields)
+}
+
+type msgpackDecoder struct {
+	handle codec.MsgpackHandle
+}
+
+// NewDecodeMsgpackFields constructs a new decode_msgpack_fields processor.
+func NewDecodeMsgpackFields(cfg *conf.C) (beat.Processor, error) {
+	d := &msgpackDecoder{}
+	// Honor the str and bin types of the current MessagePack spec.
+	d.handle.WriteExt = true
+	return newProcessor("decode_msgpack_fields", "MessagePack", cfg, d)
+}
+
+func (d *msgpackDecoder) decode(data []byte) (interface{}, error) {
+	r := bytes.NewReader(data)
+	var v interface{}
+	if err := codec.NewDecoder(r, &d.handle).Decode(&v); err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes left after the MessagePack value", r.Len())
+	}
+	return msgpackValue(v), nil
+}
+
+// msgpackValue converts maps to mapstr.M, using the string representation of
+// non-string keys, and binary data to base64 strings.
+func msgpackValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(mapstr.M, len(v))
+		for k, e := range v {
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			m[key] = msgpackValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = msgpackValue(e)
+		}
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		return v
+	}
+}
+
+func (d *msgpackDecoder) String() string {
+	return "format=msgpack"
+}
@@ -0,0 +1,179 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+func init() {
+	processors.RegisterPlugin("decode_protobuf_fields",
+		checks.ConfigChecked(NewDecodeProtobufFields,
+			checks.RequireFields("fields", "descriptor_set", "message_type"),
+			checks.AllowedFields("fields", "target", "overwrite_keys", "add_error_key", "encoding",
+				"descriptor_set", "message_type", "use_json_names", "when")))
+
+	jsprocessor.RegisterPlugin("DecodeProtobufFields", NewDecodeProtobufFields)
+}
+
+type protobufConfig struct {
+	// DescriptorSet is the path of a FileDescriptorSet, as written by
+	// protoc --descriptor_set_out --include_imports.
+	DescriptorSet string `config:"descriptor_set" validate:"required"`
+	MessageType   string `config:"message_type" validate:"required"` // Full name of the message.
+	UseJSONNames  bool   `config:"use_json_names"`
+}
+
+type protobufDecoder struct {
+	protobufConfig
+	message protoreflect.MessageDescriptor
+}
+
+// NewDecodeProtobufFields constructs a new decode_protobuf_fields processor.
+func NewDecodeProtobufFields(cfg *conf.C) (beat.Processor, error) {
+	var c protobufConfig
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the decode_protobuf_fields configuration: %w", err)
+	}
+
+	md, err := loadMessageDescriptor(paths.Resolve(paths.Config, c.DescriptorSet), c.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	return newProcessor("decode_protobuf_fields", "Protobuf", cfg, &protobufDecoder{protobufConfig: c, message: md})
+}
+
+// loadMessageDescriptor returns the descriptor of a message type defined in
+// a descriptor set file.
+func loadMessageDescriptor(path, name string) (protoreflect.MessageDescriptor, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message type %s not found in %s: %w", name, path, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", name)
+	}
+	return md, nil
+}
+
+func (d *protobufDecoder) decode(data []byte) (interface{}, error) {
+	msg := dynamicpb.NewMessage(d.message)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return d.messageValue(msg), nil
+}
+
+// messageValue converts the populated fields of a message to mapstr.M.
+// google.protobuf.Timestamp messages are converted to time.Time.
+func (d *protobufDecoder) messageValue(msg protoreflect.Message) interface{} {
+	if msg.Descriptor().FullName() == "google.protobuf.Timestamp" {
+		fields := msg.Descriptor().Fields()
+		seconds := msg.Get(fields.ByName("seconds")).Int()
+		nanos := msg.Get(fields.ByName("nanos")).Int()
+		return time.Unix(seconds, nanos).UTC()
+	}
+
+	m := mapstr.M{}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		if d.UseJSONNames {
+			name = fd.JSONName()
+		}
+
+		switch {
+		case fd.IsList():
+			list := v.List()
+			values := make([]interface{}, list.Len())
+			for i := range values {
+				values[i] = d.scalarValue(fd, list.Get(i))
+			}
+			m[name] = values
+		case fd.IsMap():
+			entries := mapstr.M{}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				entries[k.String()] = d.scalarValue(fd.MapValue(), v)
+				return true
+			})
+			m[name] = entries
+		default:
+			m[name] = d.scalarValue(fd, v)
+		}
+		return true
+	})
+	return m
+}
+
+// scalarValue converts a singular value of a field.
+func (d *protobufDecoder) scalarValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return d.messageValue(v.Message())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	default:
+		// bool, float, double and string values.
+		return v.Interface()
+	}
+}
+
+func (d *protobufDecoder) String() string {
+	return fmt.Sprintf("format=protobuf, message_type=%s", d.MessageType)
+}
@@ -0,0 +1,209 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_binary_fields
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// testDescriptorSet returns the descriptors of:
+//
+//	syntax = "proto3";
+//	package test;
+//	import "google/protobuf/timestamp.proto";
+//
+//	enum Level { INFO = 0; WARN = 1; }
+//
+//	message Event {
+//	  int64 id = 1;
+//	  string user_name = 2;
+//	  repeated string tags = 3;
+//	  Level level = 4;
+//	  google.protobuf.Timestamp created = 5;
+//	  bytes payload = 6;
+//	  map<string, uint32> counts = 7;
+//	  Event parent = 8;
+//	}
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		if repeated {
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		return f
+	}
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Level"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("INFO"), Number: proto.Int32(0)},
+				{Name: proto.String("WARN"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, "", false),
+				field("user_name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+				field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true),
+				field("level", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Level", false),
+				field("created", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp", false),
+				field("payload", 6, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "", false),
+				field("counts", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Event.CountsEntry", true),
+				field("parent", 8, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Event", false),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+					field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT32, "", false),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		fd,
+	}}
+}
+
+func writeDescriptorSet(t *testing.T) string {
+	t.Helper()
+	b, err := proto.Marshal(testDescriptorSet())
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "test.desc")
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return path
+}
+
+// encodeTestEvent encodes a test.Event message.
+func encodeTestEvent(t *testing.T, created time.Time) []byte {
+	t.Helper()
+	files, err := protodesc.NewFiles(testDescriptorSet())
+	require.NoError(t, err)
+	d, err := files.FindDescriptorByName("test.Event")
+	require.NoError(t, err)
+	md := d.(protoreflect.MessageDescriptor)
+	fields := md.Fields()
+
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(fields.ByName("id"), protoreflect.ValueOfInt64(-7))
+	msg.Set(fields.ByName("user_name"), protoreflect.ValueOfString("alice"))
+	tags := msg.Mutable(fields.ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("a"))
+	tags.Append(protoreflect.ValueOfString("b"))
+	msg.Set(fields.ByName("level"), protoreflect.ValueOfEnum(1))
+	msg.Set(fields.ByName("created"), protoreflect.ValueOfMessage(timestamppb.New(created).ProtoReflect()))
+	msg.Set(fields.ByName("payload"), protoreflect.ValueOfBytes([]byte{1, 2, 3}))
+	msg.Mutable(fields.ByName("counts")).Map().Set(protoreflect.ValueOfString("x").MapKey(), protoreflect.ValueOfUint32(3))
+
+	parent := dynamicpb.NewMessage(md)
+	parent.Set(fields.ByName("id"), protoreflect.ValueOfInt64(1))
+	msg.Set(fields.ByName("parent"), protoreflect.ValueOfMessage(parent))
+
+	b, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	data := encodeTestEvent(t, created)
+	path := writeDescriptorSet(t)
+
+	p, err := NewDecodeProtobufFields(conf.MustNewConfigFrom(mapstr.M{
+		"fields":         []string{"payload"},
+		"descriptor_set": path,
+		"message_type":   "test.Event",
+	}))
+	require.NoError(t, err)
+
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"payload": data}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{
+		"id":        int64(-7),
+		"user_name": "alice",
+		"tags":      []interface{}{"a", "b"},
+		"level":     "WARN",
+		"created":   created,
+		"payload":   "AQID",
+		"counts":    mapstr.M{"x": uint64(3)},
+		"parent":    mapstr.M{"id": int64(1)},
+	}, evt.Fields["payload"])
+
+	p, err = NewDecodeProtobufFields(conf.MustNewConfigFrom(mapstr.M{
+		"fields":         []string{"payload"},
+		"descriptor_set": path,
+		"message_type":   "test.Event",
+		"use_json_names": true,
+		"target":         "event",
+	}))
+	require.NoError(t, err)
+	evt, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": data}})
+	require.NoError(t, err)
+	name, err := evt.GetValue("event.userName")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"payload": []byte{0xff}}})
+	assert.Error(t, err)
+}
+
+func TestDecodeProtobufConfig(t *testing.T) {
+	path := writeDescriptorSet(t)
+	for name, settings := range map[string]mapstr.M{
+		"unknown message": {"fields": []string{"a"}, "descriptor_set": path, "message_type": "test.Missing"},
+		"enum type":       {"fields": []string{"a"}, "descriptor_set": path, "message_type": "test.Level"},
+		"missing file":    {"fields": []string{"a"}, "descriptor_set": path + ".missing", "message_type": "test.Event"},
+		"no message type": {"fields": []string{"a"}, "descriptor_set": path},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewDecodeProtobufFields(conf.MustNewConfigFrom(settings))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_kv
+
+import (
+	"errors"
+	"fmt"
+)
+
+type config struct {
+	Field         string   `config:"field"`
+	TargetField   string   `config:"target_field"`
+	FieldSplit    string   `config:"field_split"`
+	ValueSplit    string   `config:"value_split"`
+	TrimKey       string   `config:"trim_key"`
+	TrimValue     string   `config:"trim_value"`
+	QuoteChars    string   `config:"quote_chars"`
+	IncludeKeys   []string `config:"include_keys"`
+	ExcludeKeys   []string `config:"exclude_keys"`
+	Prefix        string   `config:"prefix"`
+	IgnoreMissing bool     `config:"ignore_missing"`
+	OverwriteKeys bool     `config:"overwrite_keys"`
+	FailOnError   bool     `config:"fail_on_error"`
+}
+
+func defaultConfig() config {
+	return config{
+		Field:       "message",
+		FieldSplit:  " ",
+		ValueSplit:  "=",
+		QuoteChars:  `"'`,
+		FailOnError: true,
+	}
+}
+
+func (c *config) Validate() error {
+	if c.Field == "" {
+		return errors.New("field is required")
+	}
+	if c.FieldSplit == "" {
+		return errors.New("field_split must not be empty")
+	}
+	if c.ValueSplit == "" {
+		return errors.New("value_split must not be empty")
+	}
+	if c.FieldSplit == c.ValueSplit {
+		return fmt.Errorf("field_split and value_split must differ, both are '%s'", c.FieldSplit)
+	}
+	for i := 0; i < len(c.QuoteChars); i++ {
+		if c.QuoteChars[i] >= 0x80 {
+			return fmt.Errorf("quote_chars must only contain ASCII characters, got '%s'", c.QuoteChars)
+		}
+	}
+	return nil
+}
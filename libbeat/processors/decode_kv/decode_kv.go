@@ -0,0 +1,164 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const procName = "decode_kv"
+
+type decodeKV struct {
+	config  config
+	parser  *parser
+	include map[string]struct{}
+	exclude map[string]struct{}
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.AllowedFields(
+				"field", "target_field", "field_split", "value_split",
+				"trim_key", "trim_value", "quote_chars", "include_keys",
+				"exclude_keys", "prefix", "ignore_missing", "overwrite_keys",
+				"fail_on_error", "when")))
+
+	jsprocessor.RegisterPlugin("DecodeKV", New)
+}
+
+// New constructs a new decode_kv processor.
+func New(c *conf.C) (beat.Processor, error) {
+	config := defaultConfig()
+	if err := c.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("failed to unpack the %v configuration: %w", procName, err)
+	}
+
+	return &decodeKV{
+		config:  config,
+		parser:  newParser(config),
+		include: keySet(config.IncludeKeys),
+		exclude: keySet(config.ExcludeKeys),
+	}, nil
+}
+
+func keySet(keys []string) map[string]struct{} {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return set
+}
+
+// Run applies the decode_kv processor to an event.
+func (p *decodeKV) Run(event *beat.Event) (*beat.Event, error) {
+	var saved *beat.Event
+	if p.config.FailOnError {
+		saved = event.Clone()
+	}
+
+	if err := p.decode(event); err != nil {
+		if p.config.FailOnError {
+			return saved, err
+		}
+		return event, err
+	}
+	return event, nil
+}
+
+func (p *decodeKV) decode(event *beat.Event) error {
+	data, err := event.GetValue(p.config.Field)
+	if err != nil {
+		if p.config.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return nil
+		}
+		return fmt.Errorf("could not fetch value for field %s: %w", p.config.Field, err)
+	}
+
+	text, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("field %s is not of string type", p.config.Field)
+	}
+
+	// Keys are kept in order, so that errors and repeated keys are handled
+	// in the order of the input.
+	var keys []string
+	values := map[string]interface{}{}
+	p.parser.parse(text, func(key, value string) {
+		if !p.accept(key) {
+			return
+		}
+		key = p.config.Prefix + key
+		switch prev := values[key].(type) {
+		case nil:
+			keys = append(keys, key)
+			values[key] = value
+		case string:
+			values[key] = []string{prev, value}
+		case []string:
+			values[key] = append(prev, value)
+		}
+	})
+
+	var errs []error
+	for _, key := range keys {
+		dest := key
+		if p.config.TargetField != "" {
+			dest = p.config.TargetField + "." + key
+		}
+		if !p.config.OverwriteKeys {
+			if _, err := event.GetValue(dest); err == nil {
+				errs = append(errs, fmt.Errorf("target field %s already has a value. Set the overwrite_keys flag or drop/rename the field first", dest))
+				continue
+			}
+		}
+		if _, err := event.PutValue(dest, values[key]); err != nil {
+			errs = append(errs, fmt.Errorf("failed setting field %s: %w", dest, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// accept reports whether the key passes the include and exclude lists.
+func (p *decodeKV) accept(key string) bool {
+	if p.include != nil {
+		if _, ok := p.include[key]; !ok {
+			return false
+		}
+	}
+	_, excluded := p.exclude[key]
+	return !excluded
+}
+
+// String returns a string representation of this processor.
+func (p *decodeKV) String() string {
+	json, _ := json.Marshal(p.config)
+	return procName + "=" + string(json)
+}
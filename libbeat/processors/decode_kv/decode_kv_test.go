@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestDecodeKV(t *testing.T) {
+	cases := map[string]struct {
+		config mapstr.M
+		input  mapstr.M
+		want   mapstr.M
+		fail   bool
+	}{
+		"root target": {
+			input: mapstr.M{"message": `src=10.0.0.1 dst=10.0.0.2 msg="port scan"`},
+			want: mapstr.M{
+				"message": `src=10.0.0.1 dst=10.0.0.2 msg="port scan"`,
+				"src":     "10.0.0.1",
+				"dst":     "10.0.0.2",
+				"msg":     "port scan",
+			},
+		},
+		"target field and prefix": {
+			config: mapstr.M{"target_field": "fw", "prefix": "kv_"},
+			input:  mapstr.M{"message": "a=1 b.c=2"},
+			want: mapstr.M{
+				"message": "a=1 b.c=2",
+				"fw": mapstr.M{
+					"kv_a": "1",
+					"kv_b": mapstr.M{"c": "2"},
+				},
+			},
+		},
+		"custom field and separators": {
+			config: mapstr.M{"field": "log.raw", "field_split": ";", "value_split": ":", "trim_key": " ", "trim_value": " ", "target_field": "kv"},
+			input:  mapstr.M{"log": mapstr.M{"raw": "a: 1; b : 2"}},
+			want: mapstr.M{
+				"log": mapstr.M{"raw": "a: 1; b : 2"},
+				"kv":  mapstr.M{"a": "1", "b": "2"},
+			},
+		},
+		"repeated keys": {
+			config: mapstr.M{"target_field": "kv"},
+			input:  mapstr.M{"message": "tag=a n=1 tag=b tag=c"},
+			want: mapstr.M{
+				"message": "tag=a n=1 tag=b tag=c",
+				"kv":      mapstr.M{"tag": []string{"a", "b", "c"}, "n": "1"},
+			},
+		},
+		"include keys": {
+			config: mapstr.M{"target_field": "kv", "include_keys": []string{"a", "c"}},
+			input:  mapstr.M{"message": "a=1 b=2 c=3"},
+			want: mapstr.M{
+				"message": "a=1 b=2 c=3",
+				"kv":      mapstr.M{"a": "1", "c": "3"},
+			},
+		},
+		"exclude keys": {
+			config: mapstr.M{"target_field": "kv", "exclude_keys": []string{"b"}, "prefix": "x_"},
+			input:  mapstr.M{"message": "a=1 b=2 c=3"},
+			want: mapstr.M{
+				"message": "a=1 b=2 c=3",
+				"kv":      mapstr.M{"x_a": "1", "x_c": "3"},
+			},
+		},
+		"existing key fails": {
+			input: mapstr.M{"message": "a=1 b=2", "b": "old"},
+			want:  mapstr.M{"message": "a=1 b=2", "b": "old"},
+			fail:  true,
+		},
+		"existing key without fail_on_error": {
+			config: mapstr.M{"fail_on_error": false},
+			input:  mapstr.M{"message": "a=1 b=2", "b": "old"},
+			want:   mapstr.M{"message": "a=1 b=2", "a": "1", "b": "old"},
+			fail:   true,
+		},
+		"overwrite keys": {
+			config: mapstr.M{"overwrite_keys": true},
+			input:  mapstr.M{"message": "a=1 b=2", "b": "old"},
+			want:   mapstr.M{"message": "a=1 b=2", "a": "1", "b": "2"},
+		},
+		"missing field": {
+			input: mapstr.M{"other": "a=1"},
+			want:  mapstr.M{"other": "a=1"},
+			fail:  true,
+		},
+		"ignore missing": {
+			config: mapstr.M{"ignore_missing": true},
+			input:  mapstr.M{"other": "a=1"},
+			want:   mapstr.M{"other": "a=1"},
+		},
+		"not a string": {
+			input: mapstr.M{"message": 1},
+			want:  mapstr.M{"message": 1},
+			fail:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := tc.config
+			if config == nil {
+				config = mapstr.M{}
+			}
+			p, err := New(conf.MustNewConfigFrom(config))
+			require.NoError(t, err)
+
+			event, err := p.Run(&beat.Event{Fields: tc.input})
+			if tc.fail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, event.Fields)
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, config := range map[string]mapstr.M{
+		"empty field split":  {"field_split": ""},
+		"empty value split":  {"value_split": ""},
+		"same separators":    {"field_split": ":", "value_split": ":"},
+		"non-ASCII quote":    {"quote_chars": "«"},
+		"empty source field": {"field": ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(config))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_kv
+
+import "strings"
+
+// parser splits strings into key-value pairs. It scans the input once, only
+// comparing the separators at each position, so parsing time is linear in
+// the input size whatever the input is.
+type parser struct {
+	fieldSplit string
+	valueSplit string
+	trimKey    string
+	trimValue  string
+	// quotes marks the ASCII characters quoting keys and values.
+	quotes [128]bool
+}
+
+func newParser(c config) *parser {
+	p := &parser{
+		fieldSplit: c.FieldSplit,
+		valueSplit: c.ValueSplit,
+		trimKey:    c.TrimKey,
+		trimValue:  c.TrimValue,
+	}
+	for i := 0; i < len(c.QuoteChars); i++ {
+		p.quotes[c.QuoteChars[i]] = true
+	}
+	return p
+}
+
+// parse calls fn for each key-value pair of s, in order. Tokens without a
+// value separator and pairs with an empty key are skipped.
+func (p *parser) parse(s string, fn func(key, value string)) {
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], p.fieldSplit) {
+			i += len(p.fieldSplit)
+			continue
+		}
+
+		key, next, hasValue := p.scan(s, i, true)
+		if !hasValue {
+			i = next
+			continue
+		}
+		value, next, _ := p.scan(s, next+len(p.valueSplit), false)
+		i = next
+
+		if p.trimKey != "" {
+			key = strings.Trim(key, p.trimKey)
+		}
+		if p.trimValue != "" {
+			value = strings.Trim(value, p.trimValue)
+		}
+		if key != "" {
+			fn(key, value)
+		}
+	}
+}
+
+// scan reads a key, if isKey is set, or a value starting at i. It returns the
+// unquoted text and the position of the separator ending it. For keys it
+// reports whether the key is followed by the value separator.
+func (p *parser) scan(s string, i int, isKey bool) (string, int, bool) {
+	var quoted string
+	if i < len(s) && s[i] < 0x80 && p.quotes[s[i]] {
+		if end, ok := closingQuote(s, i); ok {
+			quoted = unquote(s[i+1:end], s[i])
+			i = end + 1
+		}
+	}
+
+	// Comparing the first byte before the whole separator keeps the common
+	// single character separators cheap.
+	start, fs, vs := i, p.fieldSplit[0], p.valueSplit[0]
+	for ; i < len(s); i++ {
+		if isKey && s[i] == vs && strings.HasPrefix(s[i:], p.valueSplit) {
+			return quoted + s[start:i], i, true
+		}
+		if s[i] == fs && strings.HasPrefix(s[i:], p.fieldSplit) {
+			break
+		}
+	}
+	return quoted + s[start:i], i, false
+}
+
+// closingQuote returns the position of the quote closing the one at start.
+// Quotes escaped with a backslash do not close the quoted text.
+func closingQuote(s string, start int) (int, bool) {
+	q := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case q:
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// unquote removes the backslashes escaping the quote character q and
+// backslashes.
+func unquote(s string, q byte) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == q || s[i+1] == '\\') {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
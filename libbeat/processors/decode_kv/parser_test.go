@@ -0,0 +1,149 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_kv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pair struct{ key, value string }
+
+func parseAll(p *parser, s string) []pair {
+	var pairs []pair
+	p.parse(s, func(k, v string) { pairs = append(pairs, pair{k, v}) })
+	return pairs
+}
+
+func TestParser(t *testing.T) {
+	cases := map[string]struct {
+		config func(*config)
+		input  string
+		want   []pair
+	}{
+		"simple": {
+			input: "a=1 b=2 c=3",
+			want:  []pair{{"a", "1"}, {"b", "2"}, {"c", "3"}},
+		},
+		"repeated separators": {
+			input: "  a=1    b=2 ",
+			want:  []pair{{"a", "1"}, {"b", "2"}},
+		},
+		"empty value and missing value": {
+			input: "a= b c=3 =4",
+			want:  []pair{{"a", ""}, {"c", "3"}},
+		},
+		"value containing the value separator": {
+			input: "url=http://example.com/?q=1&p=2 ok=1",
+			want:  []pair{{"url", "http://example.com/?q=1&p=2"}, {"ok", "1"}},
+		},
+		"quoted values": {
+			input: `msg="hello world" user='jane doe' n=1`,
+			want:  []pair{{"msg", "hello world"}, {"user", "jane doe"}, {"n", "1"}},
+		},
+		"escaped quotes": {
+			input: `msg="say \"hi\" \\ \n" n=1`,
+			want:  []pair{{"msg", `say "hi" \ \n`}, {"n", "1"}},
+		},
+		"quoted keys": {
+			input: `"first name"=jane`,
+			want:  []pair{{"first name", "jane"}},
+		},
+		"unterminated quote": {
+			input: `msg="hello world n=1`,
+			want:  []pair{{"msg", `"hello`}, {"n", "1"}},
+		},
+		"text after quotes": {
+			input: `a="b"c d=e`,
+			want:  []pair{{"a", "bc"}, {"d", "e"}},
+		},
+		"quotes disabled": {
+			config: func(c *config) { c.QuoteChars = "" },
+			input:  `msg="a b"`,
+			want:   []pair{{"msg", `"a`}},
+		},
+		"multi-character separators": {
+			config: func(c *config) { c.FieldSplit, c.ValueSplit = "||", ":=" },
+			input:  "a:=1||b:=x|y||c:=",
+			want:   []pair{{"a", "1"}, {"b", "x|y"}, {"c", ""}},
+		},
+		"overlapping separators": {
+			config: func(c *config) { c.FieldSplit, c.ValueSplit = " ", " = " },
+			input:  "a = 1 b = 2",
+			want:   []pair{{"a", "1"}, {"b", "2"}},
+		},
+		"trim": {
+			config: func(c *config) { c.FieldSplit, c.TrimKey, c.TrimValue = ",", " ", " <>" },
+			input:  "a = <1>, b=  2 ",
+			want:   []pair{{"a", "1"}, {"b", "2"}},
+		},
+		"utf-8": {
+			input: "名前=値 ключ=«значение»",
+			want:  []pair{{"名前", "値"}, {"ключ", "«значение»"}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := defaultConfig()
+			if tc.config != nil {
+				tc.config(&c)
+			}
+			assert.Equal(t, tc.want, parseAll(newParser(c), tc.input))
+		})
+	}
+}
+
+var benchResult int
+
+func benchmarkParse(b *testing.B, input string) {
+	p := newParser(defaultConfig())
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		p.parse(input, func(_, _ string) { n++ })
+		benchResult = n
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.Run("firewall", func(b *testing.B) {
+		benchmarkParse(b, `date=2024-05-01 time=12:00:01 devname="FW-01" devid="FG100E" logid="0000000013" type="traffic" subtype="forward" level="notice" srcip=10.1.1.10 srcport=51234 dstip=93.184.216.34 dstport=443 proto=6 action="accept" policyid=12 service="HTTPS" sentbyte=1024 rcvdbyte=4096 msg="allowed by \"default\" policy"`)
+	})
+
+	// Inputs that make backtracking parsers explode must stay linear: the
+	// throughput of these cases should not drop as the input grows.
+	for _, size := range []int{1 << 10, 1 << 16} {
+		b.Run(fmt.Sprintf("no separators/%dKiB", size>>10), func(b *testing.B) {
+			benchmarkParse(b, strings.Repeat("a", size))
+		})
+		b.Run(fmt.Sprintf("no values/%dKiB", size>>10), func(b *testing.B) {
+			benchmarkParse(b, strings.Repeat("a ", size/2))
+		})
+		b.Run(fmt.Sprintf("unterminated quotes/%dKiB", size>>10), func(b *testing.B) {
+			benchmarkParse(b, `a="`+strings.Repeat(`b=\" `, size/5))
+		})
+		b.Run(fmt.Sprintf("value separators/%dKiB", size>>10), func(b *testing.B) {
+			benchmarkParse(b, strings.Repeat("=", size))
+		})
+	}
+}
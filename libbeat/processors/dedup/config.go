@@ -0,0 +1,51 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dedup
+
+import (
+	"errors"
+	"time"
+
+	conf "github.com/elastic/elastic-agent-libs/config"
+)
+
+// config holds the dedup settings. The fields, method and ignore_missing
+// settings computing the event keys are those of the fingerprint processor.
+type config struct {
+	TTL       time.Duration `config:"ttl"`
+	CacheSize int           `config:"cache_size" validate:"min=1"`
+
+	// Backend holds the backend settings of the cache processor. If set,
+	// keys are kept in the cache processor store instead of in memory.
+	Backend *conf.C `config:"backend"`
+}
+
+func defaultConfig() config {
+	return config{
+		TTL:       time.Hour,
+		CacheSize: 10000,
+	}
+}
+
+// Validate validates the data contained in the config.
+func (c *config) Validate() error {
+	if c.TTL <= 0 {
+		return errors.New("ttl must be positive")
+	}
+	return nil
+}
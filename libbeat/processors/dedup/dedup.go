@@ -0,0 +1,153 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/cache"
+	"github.com/elastic/beats/v7/libbeat/processors/fingerprint"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const logName = "processor.dedup"
+
+func init() {
+	// We cannot use this as a JS plugin as it is stateful and includes a Close method.
+	processors.RegisterPlugin("dedup", New)
+}
+
+type processor struct {
+	config
+	log         *logp.Logger
+	fingerprint fingerprint.Fingerprinter
+	metrics     *monitoring.Registry
+	stats       processorStats
+
+	// Keys are kept in cache, or in store if a backend is configured.
+	cache  *lru
+	store  cache.Store
+	mu     sync.Mutex // serializes the lookups and updates of store.
+	cancel context.CancelFunc
+}
+
+type processorStats struct {
+	Hit      *monitoring.Int // Number of dropped duplicates.
+	Miss     *monitoring.Int // Number of events seen for the first time.
+	Eviction *monitoring.Int // Number of keys evicted from the full in-memory cache.
+}
+
+// New constructs a new dedup processor.
+func New(cfg *conf.C) (beat.Processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the dedup configuration: %w", err)
+	}
+	fp, err := fingerprint.NewFingerprinter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("fail to configure the dedup key: %w", err)
+	}
+
+	metrics, removeMetrics := processors.NewMetricsRegistry("dedup")
+	log := logp.NewLogger(logName)
+
+	p := &processor{
+		config:      c,
+		log:         log,
+		fingerprint: fp,
+		metrics:     metrics,
+		stats: processorStats{
+			Hit:      monitoring.NewInt(metrics, "hits"),
+			Miss:     monitoring.NewInt(metrics, "misses"),
+			Eviction: monitoring.NewInt(metrics, "evictions"),
+		},
+		cancel: removeMetrics,
+	}
+	if c.Backend == nil {
+		p.cache = newLRU(c.CacheSize, c.TTL, p.stats.Eviction)
+		return p, nil
+	}
+
+	store, cancel, err := cache.NewStore(c.Backend, c.TTL, log)
+	if err != nil {
+		removeMetrics()
+		return nil, fmt.Errorf("failed to get the store for dedup: %w", err)
+	}
+	p.store = store
+	p.cancel = func() {
+		cancel()
+		removeMetrics()
+	}
+	return p, nil
+}
+
+// Run drops the event if an event with the same key has been seen within
+// the TTL.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	key, err := p.fingerprint.Fingerprint(event)
+	if err != nil {
+		return event, fmt.Errorf("failed to compute the dedup key: %w", err)
+	}
+
+	if p.seen(key) {
+		p.stats.Hit.Inc()
+		return nil, nil
+	}
+	p.stats.Miss.Inc()
+	return event, nil
+}
+
+// seen reports whether key has been seen within the TTL, and remembers it
+// otherwise.
+func (p *processor) seen(key string) bool {
+	if p.cache != nil {
+		return p.cache.seen(key, time.Now())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The stores only fail to get expired or unknown keys.
+	if _, err := p.store.Get(key); err == nil {
+		return true
+	}
+	if err := p.store.Put(key, true); err != nil {
+		p.log.Errorw("failed to store dedup key", "error", err)
+	}
+	return false
+}
+
+// Close releases the backend store and removes the metrics.
+func (p *processor) Close() error {
+	p.cancel()
+	return nil
+}
+
+func (p *processor) String() string {
+	backend := "memory"
+	if p.store != nil {
+		backend = p.store.String()
+	}
+	return fmt.Sprintf("dedup=[fingerprint=%v, ttl=%v, backend=%s]", p.fingerprint, p.TTL, backend)
+}
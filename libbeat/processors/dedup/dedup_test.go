@@ -0,0 +1,137 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+func runAll(t *testing.T, p beat.Processor, events ...mapstr.M) []mapstr.M {
+	t.Helper()
+	var out []mapstr.M
+	for _, fields := range events {
+		e, err := p.Run(&beat.Event{Fields: fields})
+		require.NoError(t, err)
+		if e != nil {
+			out = append(out, e.Fields)
+		}
+	}
+	return out
+}
+
+func TestDedup(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{
+		"fields": []string{"id", "source"},
+	}))
+	require.NoError(t, err)
+
+	out := runAll(t, p,
+		mapstr.M{"id": 1, "source": "a", "n": 1},
+		mapstr.M{"id": 1, "source": "b", "n": 2},
+		mapstr.M{"id": 1, "source": "a", "n": 3},
+		mapstr.M{"id": 2, "source": "a", "n": 4},
+		mapstr.M{"id": 2, "source": "a", "n": 5},
+	)
+	assert.Equal(t, []mapstr.M{
+		{"id": 1, "source": "a", "n": 1},
+		{"id": 1, "source": "b", "n": 2},
+		{"id": 2, "source": "a", "n": 4},
+	}, out)
+
+	snapshot := monitoring.CollectFlatSnapshot(p.(*processor).metrics, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{"hits": 2, "misses": 3, "evictions": 0}, snapshot.Ints)
+}
+
+func TestDedupTTL(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{
+		"fields": []string{"id"},
+		"ttl":    "50ms",
+	}))
+	require.NoError(t, err)
+
+	assert.Len(t, runAll(t, p, mapstr.M{"id": 1}, mapstr.M{"id": 1}), 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, runAll(t, p, mapstr.M{"id": 1}), 1)
+}
+
+func TestDedupMissingField(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{
+		"fields": []string{"id"},
+	}))
+	require.NoError(t, err)
+
+	e, err := p.Run(&beat.Event{Fields: mapstr.M{"other": 1}})
+	assert.Error(t, err)
+	assert.NotNil(t, e, "events without key are kept")
+
+	p, err = New(conf.MustNewConfigFrom(mapstr.M{
+		"fields":         []string{"id"},
+		"ignore_missing": true,
+	}))
+	require.NoError(t, err)
+	assert.Len(t, runAll(t, p, mapstr.M{"other": 1}, mapstr.M{"other": 2}), 1)
+}
+
+func TestDedupFileBackend(t *testing.T) {
+	origDataPath := paths.Paths.Data
+	t.Cleanup(func() {
+		paths.Paths.Data = origDataPath
+	})
+	paths.Paths.Data = t.TempDir()
+
+	cfg := conf.MustNewConfigFrom(mapstr.M{
+		"fields":  []string{"id"},
+		"backend": mapstr.M{"file": mapstr.M{"id": "dedup_test"}},
+	})
+	p, err := New(cfg)
+	require.NoError(t, err)
+	assert.Len(t, runAll(t, p, mapstr.M{"id": 1}, mapstr.M{"id": 1}, mapstr.M{"id": 2}), 2)
+	assert.Contains(t, p.String(), "file:dedup_test")
+
+	// The keys are written out on close and survive a restart.
+	require.NoError(t, p.(*processor).Close())
+	p, err = New(cfg)
+	require.NoError(t, err)
+	defer p.(*processor).Close()
+	assert.Equal(t, []mapstr.M{{"id": 3}}, runAll(t, p, mapstr.M{"id": 1}, mapstr.M{"id": 2}, mapstr.M{"id": 3}))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, config := range map[string]mapstr.M{
+		"no fields":       {},
+		"unknown method":  {"fields": []string{"id"}, "method": "md4"},
+		"zero ttl":        {"fields": []string{"id"}, "ttl": "0s"},
+		"zero cache size": {"fields": []string{"id"}, "cache_size": 0},
+		"invalid backend": {"fields": []string{"id"}, "backend": mapstr.M{"capacity": 10}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(config))
+			assert.Error(t, err)
+		})
+	}
+}
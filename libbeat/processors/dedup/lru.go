@@ -0,0 +1,84 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// lru remembers up to size keys for ttl. The least recently seen keys are
+// evicted first when the cache is full, and counted in evictions.
+type lru struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	size      int
+	ll        *list.List
+	items     map[string]*list.Element
+	evictions *monitoring.Int
+}
+
+type lruEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration, evictions *monitoring.Int) *lru {
+	return &lru{
+		ttl:       ttl,
+		size:      size,
+		ll:        list.New(),
+		items:     make(map[string]*list.Element, size),
+		evictions: evictions,
+	}
+}
+
+// seen reports whether key has been seen within the TTL, and remembers it
+// otherwise. Seeing a key again does not extend its TTL.
+func (c *lru) seen(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		e := elem.Value.(*lruEntry)
+		if now.Before(e.expires) {
+			return true
+		}
+		e.expires = now.Add(c.ttl)
+		return false
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, expires: now.Add(c.ttl)})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+		c.evictions.Inc()
+	}
+	return false
+}
+
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
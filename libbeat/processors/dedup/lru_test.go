@@ -0,0 +1,49 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Now()
+	c := newLRU(2, time.Minute, monitoring.NewInt(nil, "evictions"))
+
+	assert.False(t, c.seen("a", now))
+	assert.True(t, c.seen("a", now.Add(time.Second)))
+	assert.False(t, c.seen("b", now))
+
+	// Seeing a key makes it the most recently used, so b is evicted.
+	assert.True(t, c.seen("a", now))
+	assert.False(t, c.seen("c", now))
+	assert.Equal(t, 2, c.len())
+	assert.False(t, c.seen("b", now))
+	assert.True(t, c.seen("c", now))
+	assert.EqualValues(t, 2, c.evictions.Get())
+
+	// The TTL starts when the key is first seen and is not extended.
+	assert.True(t, c.seen("c", now.Add(59*time.Second)))
+	assert.False(t, c.seen("c", now.Add(time.Minute)))
+	assert.True(t, c.seen("c", now.Add(time.Minute+time.Second)))
+}
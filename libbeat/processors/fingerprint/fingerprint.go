@@ -67,15 +67,27 @@ func New(cfg *config.C) (beat.Processor, error) {
 	return p, nil
 }
 
-// Run enriches the given event with a fingerprint.
-func (p *fingerprint) Run(event *beat.Event) (*beat.Event, error) {
-	hashFn := p.hash()
+// Fingerprinter computes the fingerprint of events without modifying them.
+type Fingerprinter interface {
+	Fingerprint(event *beat.Event) (string, error)
+}
 
-	if err := p.writeFields(hashFn, event); err != nil {
-		return nil, makeErrComputeFingerprint(err)
+// NewFingerprinter constructs a Fingerprinter from a fingerprint processor
+// configuration. The target_field setting is ignored.
+func NewFingerprinter(cfg *config.C) (Fingerprinter, error) {
+	p, err := New(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return p.(*fingerprint), nil
+}
 
-	encodedHash := p.config.Encoding.Encode(hashFn.Sum(nil))
+// Run enriches the given event with a fingerprint.
+func (p *fingerprint) Run(event *beat.Event) (*beat.Event, error) {
+	encodedHash, err := p.Fingerprint(event)
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := event.PutValue(p.config.TargetField, encodedHash); err != nil {
 		return nil, makeErrComputeFingerprint(err)
@@ -84,6 +96,17 @@ func (p *fingerprint) Run(event *beat.Event) (*beat.Event, error) {
 	return event, nil
 }
 
+// Fingerprint returns the encoded fingerprint of the event.
+func (p *fingerprint) Fingerprint(event *beat.Event) (string, error) {
+	hashFn := p.hash()
+
+	if err := p.writeFields(hashFn, event); err != nil {
+		return "", makeErrComputeFingerprint(err)
+	}
+
+	return p.config.Encoding.Encode(hashFn.Sum(nil)), nil
+}
+
 func (p *fingerprint) String() string {
 	json, _ := json.Marshal(&p.config)
 	return procName + "=" + string(json)
@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// lookupResult holds the ECS fields found for an address. Nil fields mean the
+// address was not found in the corresponding database.
+type lookupResult struct {
+	geo mapstr.M
+	as  mapstr.M
+}
+
+// lookupCache is a least recently used cache of lookup results. Results for
+// addresses that are not in the databases are cached too.
+type lookupCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[netip.Addr]*list.Element
+	order   *list.List // Front is the most recently used entry.
+}
+
+type cacheEntry struct {
+	ip     netip.Addr
+	result *lookupResult
+}
+
+// newLookupCache returns a cache holding up to maxSize results. A zero size
+// disables caching.
+func newLookupCache(maxSize int) *lookupCache {
+	return &lookupCache{
+		maxSize: maxSize,
+		entries: make(map[netip.Addr]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lookupCache) get(ip netip.Addr) (*lookupResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries[ip]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).result, true
+}
+
+func (c *lookupCache) set(ip netip.Addr, result *lookupResult) {
+	if c.maxSize == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, found := c.entries[ip]; found {
+		e.Value.(*cacheEntry).result = result
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.maxSize {
+		c.evict()
+	}
+	c.entries[ip] = c.order.PushFront(&cacheEntry{ip: ip, result: result})
+}
+
+// evict removes the least recently used entry.
+func (c *lookupCache) evict() {
+	e := c.order.Back()
+	if e == nil {
+		return
+	}
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).ip)
+}
+
+func (c *lookupCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// config defines the configuration options for the GeoIP processor.
+type config struct {
+	Fields         mapstr.M      `config:"fields" validate:"required"` // Mapping of source IP fields to target field prefixes.
+	GeoDatabase    string        `config:"geo_database"`               // Path to a City or Country database.
+	ASNDatabase    string        `config:"asn_database"`               // Path to an ASN database.
+	ReloadInterval time.Duration `config:"reload_interval" validate:"min=0"`
+	CacheSize      int           `config:"cache_size" validate:"min=0"`
+	OverwriteKeys  bool          `config:"overwrite_keys"`
+	TagOnFailure   []string      `config:"tag_on_failure"` // Tags to append when a failure occurs.
+	targets        map[string]string
+}
+
+// Validate validates the data contained in the config.
+func (c *config) Validate() error {
+	if c.GeoDatabase == "" && c.ASNDatabase == "" {
+		return errors.New("at least one of geo_database or asn_database must be set")
+	}
+
+	// Flatten the mapping of source fields to target fields.
+	c.targets = map[string]string{}
+	for k, v := range c.Fields.Flatten() {
+		target, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("target field for geoip lookup of %v "+
+				"must be a string but got %T", k, v)
+		}
+		c.targets[k] = target
+	}
+	return nil
+}
+
+func defaultConfig() config {
+	return config{
+		ReloadInterval: time.Minute,
+		CacheSize:      1000,
+		TagOnFailure:   []string{"_geoip_lookup_failure"},
+	}
+}
@@ -0,0 +1,398 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+const logName = "processor.geoip"
+
+func init() {
+	// We cannot use this as a JS plugin as it watches the database files and
+	// includes a Close method.
+	processors.RegisterPlugin("geoip", New)
+}
+
+type processor struct {
+	config
+	log           *logp.Logger
+	metrics       *monitoring.Registry
+	removeMetrics func()
+	stats         processorStats
+
+	// state is replaced as a whole when the databases are reloaded.
+	state atomic.Pointer[state]
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type processorStats struct {
+	Hit     *monitoring.Int
+	Miss    *monitoring.Int
+	Reloads *monitoring.Int
+}
+
+// state holds the loaded databases and the cache of their lookup results.
+type state struct {
+	geo, asn *database
+	files    map[string]os.FileInfo
+	cache    *lookupCache
+}
+
+// New constructs a new GeoIP processor.
+func New(cfg *conf.C) (beat.Processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the geoip configuration: %w", err)
+	}
+	if c.GeoDatabase != "" {
+		c.GeoDatabase = paths.Resolve(paths.Config, c.GeoDatabase)
+	}
+	if c.ASNDatabase != "" {
+		c.ASNDatabase = paths.Resolve(paths.Config, c.ASNDatabase)
+	}
+
+	metrics, removeMetrics := processors.NewMetricsRegistry("geoip")
+
+	p := &processor{
+		config:        c,
+		log:           logp.NewLogger(logName),
+		metrics:       metrics,
+		removeMetrics: removeMetrics,
+		stats: processorStats{
+			Hit:     monitoring.NewInt(metrics, "cache.hits"),
+			Miss:    monitoring.NewInt(metrics, "cache.misses"),
+			Reloads: monitoring.NewInt(metrics, "reloads"),
+		},
+		done: make(chan struct{}),
+	}
+
+	s, err := p.load()
+	if err != nil {
+		removeMetrics()
+		return nil, err
+	}
+	p.state.Store(s)
+
+	if c.ReloadInterval > 0 {
+		p.wg.Add(1)
+		go p.watch()
+	}
+	return p, nil
+}
+
+// load opens the configured databases.
+func (p *processor) load() (*state, error) {
+	s := &state{
+		files: map[string]os.FileInfo{},
+		cache: newLookupCache(p.CacheSize),
+	}
+	for _, db := range []struct {
+		path string
+		dst  **database
+	}{
+		{p.GeoDatabase, &s.geo},
+		{p.ASNDatabase, &s.asn},
+	} {
+		if db.path == "" {
+			continue
+		}
+		info, err := os.Stat(db.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat GeoIP database: %w", err)
+		}
+		if *db.dst, err = openDatabase(db.path); err != nil {
+			return nil, err
+		}
+		s.files[db.path] = info
+		p.log.Infow("Loaded GeoIP database", "path", db.path,
+			"database_type", (*db.dst).DatabaseType,
+			"build_time", time.Unix(int64((*db.dst).BuildEpoch), 0).UTC())
+	}
+	return s, nil
+}
+
+// watch reloads the databases when one of the files changes on disk. The
+// previous databases are kept if the new files cannot be loaded.
+func (p *processor) watch() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !p.state.Load().changed() {
+			continue
+		}
+		s, err := p.load()
+		if err != nil {
+			p.log.Warnw("Failed to reload GeoIP databases, keeping the previous ones", "error", err)
+			continue
+		}
+		p.state.Store(s)
+		p.stats.Reloads.Inc()
+	}
+}
+
+// changed reports whether a database file has been modified since it was
+// loaded.
+func (s *state) changed() bool {
+	for path, loaded := range s.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file may be in the middle of being replaced.
+			continue
+		}
+		if !info.ModTime().Equal(loaded.ModTime()) || info.Size() != loaded.Size() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	s := p.state.Load()
+
+	var tagOnce sync.Once
+	for field, target := range p.targets {
+		if err := p.processField(s, event, field, target); err != nil {
+			p.log.Debugf("GeoIP processor failed: %v", err)
+			tagOnce.Do(func() { _ = mapstr.AddTags(event.Fields, p.TagOnFailure) })
+		}
+	}
+	return event, nil
+}
+
+func (p *processor) processField(s *state, event *beat.Event, source, target string) error {
+	v, err := event.GetValue(source)
+	if err != nil {
+		//nolint:nilerr // an empty source field isn't considered an error for this processor
+		return nil
+	}
+
+	var values []string
+	switch v := v.(type) {
+	case string:
+		values = []string{v}
+	case []string:
+		values = v
+	case []interface{}:
+		for _, ifc := range v {
+			if s, ok := ifc.(string); ok {
+				values = append(values, s)
+			}
+		}
+	default:
+		return fmt.Errorf("geoip lookup of %s failed: value is a %T, not an IP address", source, v)
+	}
+
+	// The first address found in the databases is used.
+	for _, value := range values {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return fmt.Errorf("geoip lookup of %s value '%s' failed: %w", source, value, err)
+		}
+
+		result, err := p.lookup(s, ip.WithZone(""))
+		if err != nil {
+			return fmt.Errorf("geoip lookup of %s value '%s' failed: %w", source, value, err)
+		}
+		if result.geo == nil && result.as == nil {
+			continue
+		}
+		return p.putResult(event, target, result)
+	}
+	return nil
+}
+
+func (p *processor) lookup(s *state, ip netip.Addr) (*lookupResult, error) {
+	if r, found := s.cache.get(ip); found {
+		p.stats.Hit.Inc()
+		return r, nil
+	}
+	p.stats.Miss.Inc()
+
+	var r lookupResult
+	if s.geo != nil {
+		record, err := s.geo.lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		r.geo = geoFields(record)
+	}
+	if s.asn != nil {
+		record, err := s.asn.lookup(ip)
+		if err != nil {
+			return nil, err
+		}
+		r.as = asFields(record)
+	}
+	s.cache.set(ip, &r)
+	return &r, nil
+}
+
+func (p *processor) putResult(event *beat.Event, target string, r *lookupResult) error {
+	for _, f := range []struct {
+		name   string
+		fields mapstr.M
+	}{
+		{"geo", r.geo},
+		{"as", r.as},
+	} {
+		if f.fields == nil {
+			continue
+		}
+		key := f.name
+		if target != "" {
+			key = target + "." + key
+		}
+		if !p.OverwriteKeys {
+			if _, err := event.GetValue(key); err == nil {
+				return fmt.Errorf("target field '%s' already exists and overwrite_keys is false", key)
+			}
+		}
+		// Cached results are shared between events.
+		if _, err := event.PutValue(key, f.fields.Clone()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// geoFields maps a City or Country database record to the ECS geo fields.
+func geoFields(record map[string]any) mapstr.M {
+	if record == nil {
+		return nil
+	}
+
+	geo := mapstr.M{}
+	put := func(key string, value any) {
+		if s, _ := value.(string); s != "" {
+			geo[key] = s
+		}
+	}
+
+	country := lookupPath(record, "country")
+	if country == nil {
+		country = lookupPath(record, "registered_country")
+	}
+	countryISO, _ := lookupPath(country, "iso_code").(string)
+
+	put("continent_code", lookupPath(record, "continent", "code"))
+	put("continent_name", lookupPath(record, "continent", "names", "en"))
+	put("country_iso_code", lookupPath(country, "iso_code"))
+	put("country_name", lookupPath(country, "names", "en"))
+	put("city_name", lookupPath(record, "city", "names", "en"))
+	put("postal_code", lookupPath(record, "postal", "code"))
+	put("timezone", lookupPath(record, "location", "time_zone"))
+
+	if subdivisions, _ := record["subdivisions"].([]any); len(subdivisions) > 0 {
+		if iso, _ := lookupPath(subdivisions[0], "iso_code").(string); iso != "" && countryISO != "" {
+			geo["region_iso_code"] = countryISO + "-" + iso
+		}
+		put("region_name", lookupPath(subdivisions[0], "names", "en"))
+	}
+
+	lat, latOK := lookupPath(record, "location", "latitude").(float64)
+	lon, lonOK := lookupPath(record, "location", "longitude").(float64)
+	if latOK && lonOK {
+		geo["location"] = mapstr.M{"lat": lat, "lon": lon}
+	}
+
+	if len(geo) == 0 {
+		return nil
+	}
+	return geo
+}
+
+// asFields maps an ASN database record to the ECS as fields.
+func asFields(record map[string]any) mapstr.M {
+	if record == nil {
+		return nil
+	}
+
+	as := mapstr.M{}
+	if number, ok := record["autonomous_system_number"].(uint64); ok {
+		as["number"] = int64(number)
+	}
+	if org, _ := record["autonomous_system_organization"].(string); org != "" {
+		as["organization"] = mapstr.M{"name": org}
+	}
+
+	if len(as) == 0 {
+		return nil
+	}
+	return as
+}
+
+// lookupPath returns the value nested under keys in a decoded record.
+func lookupPath(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// Close stops watching the database files for changes and removes the
+// metrics of the processor.
+func (p *processor) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.removeMetrics()
+	})
+	p.wg.Wait()
+	return nil
+}
+
+func (p *processor) String() string {
+	fields := make([]string, 0, len(p.targets))
+	for field, target := range p.targets {
+		fields = append(fields, field+"="+target)
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("geoip=[geo_database=%v, asn_database=%v, fields=[%v]]",
+		p.GeoDatabase, p.ASNDatabase, strings.Join(fields, ","))
+}
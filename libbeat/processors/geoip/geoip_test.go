@@ -0,0 +1,295 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var testCityNetworks = map[string]map[string]any{
+	"89.160.20.128/25": {
+		"city":      map[string]any{"names": map[string]any{"en": "Linköping", "de": "Linköping"}},
+		"continent": map[string]any{"code": "EU", "names": map[string]any{"en": "Europe"}},
+		"country":   map[string]any{"iso_code": "SE", "names": map[string]any{"en": "Sweden"}},
+		"location": map[string]any{
+			"latitude":  58.4167,
+			"longitude": 15.6167,
+			"time_zone": "Europe/Stockholm",
+		},
+		"postal": map[string]any{"code": "587 58"},
+		"subdivisions": []any{
+			map[string]any{"iso_code": "E", "names": map[string]any{"en": "Östergötland County"}},
+		},
+	},
+	"2a02:cf40::/29": {
+		"continent":          map[string]any{"code": "EU", "names": map[string]any{"en": "Europe"}},
+		"registered_country": map[string]any{"iso_code": "NO", "names": map[string]any{"en": "Norway"}},
+	},
+}
+
+var testASNNetworks = map[string]map[string]any{
+	"89.160.0.0/17": {
+		"autonomous_system_number":       uint32(29518),
+		"autonomous_system_organization": "Bredband2 AB",
+	},
+}
+
+var linkopingGeo = mapstr.M{
+	"city_name":        "Linköping",
+	"continent_code":   "EU",
+	"continent_name":   "Europe",
+	"country_iso_code": "SE",
+	"country_name":     "Sweden",
+	"location":         mapstr.M{"lat": 58.4167, "lon": 15.6167},
+	"postal_code":      "587 58",
+	"region_iso_code":  "SE-E",
+	"region_name":      "Östergötland County",
+	"timezone":         "Europe/Stockholm",
+}
+
+func newTestProcessor(t *testing.T, settings mapstr.M) *processor {
+	t.Helper()
+
+	dir := t.TempDir()
+	c := mapstr.M{
+		"geo_database": filepath.Join(dir, "city.mmdb"),
+		"asn_database": filepath.Join(dir, "asn.mmdb"),
+		"fields": mapstr.M{
+			"source.ip":      "source",
+			"destination.ip": "destination",
+		},
+	}
+	c.DeepUpdate(settings)
+	writeTestDatabase(t, filepath.Join(dir, "city.mmdb"), "GeoLite2-City", testCityNetworks)
+	writeTestDatabase(t, filepath.Join(dir, "asn.mmdb"), "GeoLite2-ASN", testASNNetworks)
+
+	p, err := New(conf.MustNewConfigFrom(c))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.(*processor).Close() })
+	return p.(*processor)
+}
+
+func TestProcessor(t *testing.T) {
+	p := newTestProcessor(t, nil)
+
+	tests := map[string]struct {
+		fields mapstr.M
+		want   mapstr.M
+	}{
+		"geo and as": {
+			fields: mapstr.M{"source": mapstr.M{"ip": "89.160.20.129"}},
+			want: mapstr.M{
+				"source": mapstr.M{
+					"ip":  "89.160.20.129",
+					"geo": linkopingGeo,
+					"as": mapstr.M{
+						"number":       int64(29518),
+						"organization": mapstr.M{"name": "Bredband2 AB"},
+					},
+				},
+			},
+		},
+		"as only": {
+			fields: mapstr.M{"destination": mapstr.M{"ip": "89.160.1.1"}},
+			want: mapstr.M{
+				"destination": mapstr.M{
+					"ip": "89.160.1.1",
+					"as": mapstr.M{
+						"number":       int64(29518),
+						"organization": mapstr.M{"name": "Bredband2 AB"},
+					},
+				},
+			},
+		},
+		"ipv6 registered country": {
+			fields: mapstr.M{"source": mapstr.M{"ip": "2a02:cf40::1"}},
+			want: mapstr.M{
+				"source": mapstr.M{
+					"ip": "2a02:cf40::1",
+					"geo": mapstr.M{
+						"continent_code":   "EU",
+						"continent_name":   "Europe",
+						"country_iso_code": "NO",
+						"country_name":     "Norway",
+					},
+				},
+			},
+		},
+		"first address found in list": {
+			fields: mapstr.M{"source": mapstr.M{"ip": []string{"10.0.0.1", "2a02:cf40::1"}}},
+			want: mapstr.M{
+				"source": mapstr.M{
+					"ip": []string{"10.0.0.1", "2a02:cf40::1"},
+					"geo": mapstr.M{
+						"continent_code":   "EU",
+						"continent_name":   "Europe",
+						"country_iso_code": "NO",
+						"country_name":     "Norway",
+					},
+				},
+			},
+		},
+		"not found": {
+			fields: mapstr.M{"source": mapstr.M{"ip": "10.0.0.1"}},
+			want:   mapstr.M{"source": mapstr.M{"ip": "10.0.0.1"}},
+		},
+		"missing field": {
+			fields: mapstr.M{"message": "hello"},
+			want:   mapstr.M{"message": "hello"},
+		},
+		"invalid address": {
+			fields: mapstr.M{"source": mapstr.M{"ip": "not-an-ip"}},
+			want: mapstr.M{
+				"source": mapstr.M{"ip": "not-an-ip"},
+				"tags":   []string{"_geoip_lookup_failure"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			event, err := p.Run(&beat.Event{Fields: test.fields})
+			require.NoError(t, err)
+			assert.Equal(t, test.want, event.Fields)
+		})
+	}
+}
+
+func TestProcessorCache(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{"cache_size": 1})
+
+	run := func(ip string) mapstr.M {
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"source": mapstr.M{"ip": ip}}})
+		require.NoError(t, err)
+		return event.Fields
+	}
+
+	first := run("89.160.20.129")
+	second := run("89.160.20.129")
+	assert.Equal(t, first, second)
+	assert.EqualValues(t, 1, p.stats.Hit.Get())
+	assert.EqualValues(t, 1, p.stats.Miss.Get())
+
+	// Events must not share the cached fields.
+	_, _ = first.Put("source.geo.city_name", "changed")
+	assert.Equal(t, "Linköping", run("89.160.20.129")["source"].(mapstr.M)["geo"].(mapstr.M)["city_name"])
+
+	run("10.0.0.1")
+	run("89.160.20.129")
+	snapshot := monitoring.CollectFlatSnapshot(p.metrics, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{"cache.hits": 2, "cache.misses": 3, "reloads": 0}, snapshot.Ints)
+}
+
+func TestProcessorOverwriteKeys(t *testing.T) {
+	fields := func() mapstr.M {
+		return mapstr.M{"source": mapstr.M{"ip": "89.160.1.1", "as": mapstr.M{"number": 1}}}
+	}
+
+	p := newTestProcessor(t, nil)
+	event, err := p.Run(&beat.Event{Fields: fields()})
+	require.NoError(t, err)
+	assert.Equal(t, 1, event.Fields["source"].(mapstr.M)["as"].(mapstr.M)["number"])
+	assert.Equal(t, []string{"_geoip_lookup_failure"}, event.Fields["tags"])
+
+	p = newTestProcessor(t, mapstr.M{"overwrite_keys": true})
+	event, err = p.Run(&beat.Event{Fields: fields()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(29518), event.Fields["source"].(mapstr.M)["as"].(mapstr.M)["number"])
+	assert.NotContains(t, event.Fields, "tags")
+}
+
+func TestProcessorReload(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{"reload_interval": "10ms"})
+
+	cityName := func() any {
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"source": mapstr.M{"ip": "89.160.20.129"}}})
+		require.NoError(t, err)
+		v, _ := event.GetValue("source.geo.city_name")
+		return v
+	}
+	require.Equal(t, "Linköping", cityName())
+
+	// An invalid file keeps the previous database.
+	path := p.GeoDatabase
+	require.NoError(t, os.WriteFile(path, []byte("truncated"), 0o600))
+	require.Never(t, func() bool { return p.stats.Reloads.Get() > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+	require.Equal(t, "Linköping", cityName())
+
+	writeTestDatabase(t, path, "GeoLite2-City", map[string]map[string]any{
+		"89.160.20.0/24": {"city": map[string]any{"names": map[string]any{"en": "Norrköping"}}},
+	})
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	require.Eventually(t, func() bool { return cityName() == "Norrköping" }, 5*time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 1, p.stats.Reloads.Get())
+}
+
+func TestNewErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.mmdb")
+	require.NoError(t, os.WriteFile(invalid, []byte("invalid"), 0o600))
+
+	for name, c := range map[string]mapstr.M{
+		"no database":      {"fields": mapstr.M{"source.ip": "source"}},
+		"no fields":        {"geo_database": invalid},
+		"missing database": {"geo_database": filepath.Join(dir, "missing.mmdb"), "fields": mapstr.M{"source.ip": "source"}},
+		"invalid database": {"geo_database": invalid, "fields": mapstr.M{"source.ip": "source"}},
+		"invalid target":   {"geo_database": invalid, "fields": mapstr.M{"source.ip": 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(c))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLookupCacheEviction(t *testing.T) {
+	c := newLookupCache(2)
+	a, b, d := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")
+
+	c.set(a, &lookupResult{})
+	c.set(b, &lookupResult{})
+	_, found := c.get(a)
+	require.True(t, found)
+
+	// b is now the least recently used entry.
+	c.set(d, &lookupResult{})
+	assert.Equal(t, 2, c.len())
+	_, found = c.get(b)
+	assert.False(t, found)
+	_, found = c.get(a)
+	assert.True(t, found)
+
+	disabled := newLookupCache(0)
+	disabled.set(a, &lookupResult{})
+	_, found = disabled.get(a)
+	assert.False(t, found)
+}
@@ -0,0 +1,388 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// This file implements a reader for the MaxMind DB file format as described
+// in https://maxmind.github.io/MaxMind-DB/. The whole file is loaded in memory
+// and is never modified afterwards, so a database can be used concurrently.
+
+// metadataStartMarker separates the data section from the metadata section.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparatorSize is the number of zero bytes between the search tree
+// and the data section.
+const dataSectionSeparatorSize = 16
+
+// maxDecodeDepth limits the nesting of maps, arrays and pointers to protect
+// against corrupted files.
+const maxDecodeDepth = 64
+
+var errUnexpectedEnd = errors.New("unexpected end of MaxMind DB data")
+
+type dataType uint8
+
+// Data types of the MaxMind DB data section.
+const (
+	typeExtended dataType = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBoolean
+	typeFloat
+)
+
+// metadata holds the database metadata fields used by the reader.
+type metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+type database struct {
+	metadata
+	tree      []byte
+	data      decoder
+	ipv4Start uint
+}
+
+// openDatabase reads and validates the MaxMind DB file at path.
+func openDatabase(path string) (*database, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := newDatabase(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MaxMind DB file '%s': %w", path, err)
+	}
+	return db, nil
+}
+
+func newDatabase(buf []byte) (*database, error) {
+	end := bytes.LastIndex(buf, metadataStartMarker)
+	if end < 0 {
+		return nil, errors.New("metadata section not found")
+	}
+
+	raw, _, err := decoder{buf: buf[end+len(metadataStartMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	fields, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("metadata is a %T, not a map", raw)
+	}
+
+	if v, _ := fields["binary_format_major_version"].(uint64); v != 2 {
+		return nil, fmt.Errorf("unsupported binary format version %d", v)
+	}
+	md := metadata{
+		NodeCount:  uint(asUint(fields["node_count"])),
+		RecordSize: uint(asUint(fields["record_size"])),
+		IPVersion:  uint(asUint(fields["ip_version"])),
+		BuildEpoch: asUint(fields["build_epoch"]),
+	}
+	md.DatabaseType, _ = fields["database_type"].(string)
+
+	switch md.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", md.RecordSize)
+	}
+	if md.IPVersion != 4 && md.IPVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", md.IPVersion)
+	}
+
+	treeSize := md.NodeCount * md.RecordSize / 4
+	if treeSize+dataSectionSeparatorSize > uint(end) {
+		return nil, fmt.Errorf("search tree of %d nodes exceeds the file size", md.NodeCount)
+	}
+
+	db := &database{
+		metadata: md,
+		tree:     buf[:treeSize],
+		data:     decoder{buf: buf[treeSize+dataSectionSeparatorSize : end]},
+	}
+
+	// IPv4 addresses are stored in the ::/96 subtree of IPv6 databases.
+	if md.IPVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < md.NodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// lookup returns the record for ip or nil if the address is not in the
+// database.
+func (db *database) lookup(ip netip.Addr) (map[string]any, error) {
+	var (
+		bits []byte
+		node uint
+	)
+	if ip = ip.Unmap(); ip.Is4() {
+		b := ip.As4()
+		bits, node = b[:], db.ipv4Start
+	} else {
+		if db.IPVersion == 4 {
+			return nil, nil
+		}
+		b := ip.As16()
+		bits = b[:]
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.NodeCount; i++ {
+		node = db.record(node, uint(bits[i>>3]>>(7-i&7))&1)
+	}
+
+	switch {
+	case node == db.NodeCount:
+		return nil, nil
+	case node < db.NodeCount:
+		return nil, errors.New("invalid search tree: no record at the end of the address")
+	}
+
+	raw, _, err := db.data.decode(node-db.NodeCount-dataSectionSeparatorSize, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode record for %v: %w", ip, err)
+	}
+	record, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("record for %v is a %T, not a map", ip, raw)
+	}
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (db *database) record(node, bit uint) uint {
+	b := db.tree
+	switch db.RecordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[node*8+bit*4:]))
+	}
+}
+
+// decoder decodes values of a MaxMind DB data section. Pointers are relative
+// to the start of buf.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset following it.
+func (d decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("maximum data nesting depth exceeded")
+	}
+
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		ptr, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr, depth+1)
+		return v, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			var k, v any
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is a %T, not a string", k)
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, size)
+		for i := range a {
+			if a[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeBoolean:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("invalid boolean size %d", size)
+		}
+		return size == 1, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errUnexpectedEnd
+	}
+	b, next := d.buf[offset:offset+size], offset+size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		limit := uint(4)
+		switch typ {
+		case typeUint16:
+			limit = 2
+		case typeUint64:
+			limit = 8
+		}
+		if size > limit {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int64(int32(uint32(v))), next, nil
+		}
+		return v, next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid integer size %d", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+}
+
+// decodeControl decodes the control byte(s) at offset and returns the data
+// type, the payload size and the offset of the payload. For pointers the size
+// is the raw 5-bit value of the control byte.
+func (d decoder) decodeControl(offset uint) (dataType, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errUnexpectedEnd
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := dataType(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errUnexpectedEnd
+		}
+		typ = dataType(7 + d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, 0, errUnexpectedEnd
+	}
+	var v uint
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+	switch n {
+	case 1:
+		size = 29 + v
+	case 2:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+	return typ, size, offset + n, nil
+}
+
+// decodePointer decodes a pointer payload whose control byte holds size.
+func (d decoder) decodePointer(size, offset uint) (uint, uint, error) {
+	n := (size>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errUnexpectedEnd
+	}
+	var v uint
+	if n < 4 {
+		v = size & 0x7
+	}
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func asUint(v any) uint64 {
+	u, _ := v.(uint64)
+	return u
+}
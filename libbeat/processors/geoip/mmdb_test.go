@@ -0,0 +1,324 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseLookup(t *testing.T) {
+	networks := map[string]map[string]any{
+		"89.160.20.128/25": {"name": "v4"},
+		"1.0.0.0/8":        {"name": "v4-short"},
+		"2a02:cf40::/29":   {"name": "v6"},
+	}
+
+	for _, recordSize := range []uint{24, 28, 32} {
+		db, err := newDatabase(buildTestDatabase(t, "Test", recordSize, networks))
+		require.NoError(t, err, "record size %d", recordSize)
+		assert.Equal(t, "Test", db.DatabaseType)
+		assert.Equal(t, recordSize, db.RecordSize)
+
+		for addr, want := range map[string]string{
+			"89.160.20.129":        "v4",
+			"89.160.20.255":        "v4",
+			"::ffff:89.160.20.200": "v4",
+			"1.2.3.4":              "v4-short",
+			"2a02:cf40:1234::1":    "v6",
+			"2a02:cf48::1":         "",
+			"89.160.20.127":        "",
+			"10.0.0.1":             "",
+			"2001:db8::1":          "",
+			"255.255.255.255":      "",
+		} {
+			record, err := db.lookup(netip.MustParseAddr(addr))
+			require.NoError(t, err)
+			if want == "" {
+				assert.Nil(t, record, "address %s, record size %d", addr, recordSize)
+				continue
+			}
+			assert.Equal(t, want, record["name"], "address %s, record size %d", addr, recordSize)
+		}
+	}
+}
+
+func TestOpenDatabaseErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := openDatabase(filepath.Join(dir, "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(dir, "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+	_, err = openDatabase(path)
+	assert.ErrorContains(t, err, "metadata section not found")
+
+	// Metadata claiming more nodes than the file holds.
+	buf := append([]byte(nil), metadataStartMarker...)
+	buf = encodeTestValue(buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"node_count":                  uint32(1000),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(6),
+	})
+	_, err = newDatabase(buf)
+	assert.ErrorContains(t, err, "exceeds the file size")
+}
+
+func TestDecoder(t *testing.T) {
+	tests := map[string]struct {
+		buf    []byte
+		offset uint
+		want   any
+	}{
+		"string":         {buf: []byte{0x43, 'a', 'b', 'c'}, want: "abc"},
+		"empty string":   {buf: []byte{0x40}, want: ""},
+		"long string":    {buf: append([]byte{0x5e, 0x00, 0x0f}, bytes.Repeat([]byte("x"), 300)...), want: string(bytes.Repeat([]byte("x"), 300))},
+		"uint16":         {buf: []byte{0xa2, 0x01, 0x00}, want: uint64(256)},
+		"uint32":         {buf: []byte{0xc1, 0x2a}, want: uint64(42)},
+		"uint64":         {buf: []byte{0x02, 0x02, 0x01, 0x00}, want: uint64(256)},
+		"int32 negative": {buf: []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xfe}, want: int64(-2)},
+		"boolean":        {buf: []byte{0x01, 0x07}, want: true},
+		"double":         {buf: append([]byte{0x68}, binary.BigEndian.AppendUint64(nil, math.Float64bits(1.5))...), want: 1.5},
+		"float":          {buf: append([]byte{0x04, 0x08}, binary.BigEndian.AppendUint32(nil, math.Float32bits(0.5))...), want: 0.5},
+		"array":          {buf: []byte{0x02, 0x04, 0x41, 'a', 0xc1, 0x01}, want: []any{"a", uint64(1)}},
+		"map":            {buf: []byte{0xe1, 0x41, 'k', 0x41, 'v'}, want: map[string]any{"k": "v"}},
+		"pointer": {
+			buf:    []byte{0x43, 'a', 'b', 'c', 0x20, 0x00},
+			offset: 4,
+			want:   "abc",
+		},
+		"pointer as map key": {
+			buf:    []byte{0x41, 'k', 0xe1, 0x20, 0x00, 0x41, 'v'},
+			offset: 2,
+			want:   map[string]any{"k": "v"},
+		},
+		"two byte pointer": {
+			buf:    append(append(bytes.Repeat([]byte{0}, 2048), 0x41, 'z'), 0x28, 0x00, 0x00),
+			offset: 2050,
+			want:   "z",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			v, _, err := decoder{buf: test.buf}.decode(test.offset, 0)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, v)
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		_, _, err := decoder{buf: []byte{0x45, 'a'}}.decode(0, 0)
+		assert.ErrorIs(t, err, errUnexpectedEnd)
+	})
+
+	t.Run("pointer loop", func(t *testing.T) {
+		_, _, err := decoder{buf: []byte{0x20, 0x00}}.decode(0, 0)
+		assert.ErrorContains(t, err, "depth")
+	})
+}
+
+// writeTestDatabase writes a MaxMind DB file holding networks to path.
+func writeTestDatabase(t testing.TB, path, databaseType string, networks map[string]map[string]any) {
+	t.Helper()
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, buildTestDatabase(t, databaseType, 28, networks), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+// buildTestDatabase builds an IPv6 MaxMind DB file where IPv4 networks are
+// stored in the ::/96 subtree.
+func buildTestDatabase(t testing.TB, databaseType string, recordSize uint, networks map[string]map[string]any) []byte {
+	t.Helper()
+
+	const (
+		empty = iota
+		child
+		leaf
+	)
+	type record struct {
+		kind  int
+		value int
+	}
+	nodes := [][2]record{{}}
+
+	prefixes := make([]string, 0, len(networks))
+	for p := range networks {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	var data []byte
+	for _, p := range prefixes {
+		prefix := netip.MustParsePrefix(p)
+		addr, bits := prefix.Addr().As16(), prefix.Bits()
+		if prefix.Addr().Is4() {
+			var v6 [16]byte
+			v4 := prefix.Addr().As4()
+			copy(v6[12:], v4[:])
+			addr, bits = v6, bits+96
+		}
+
+		node := 0
+		for i := 0; i < bits; i++ {
+			bit := (addr[i/8] >> (7 - i%8)) & 1
+			r := &nodes[node][bit]
+			if i == bits-1 {
+				require.Equal(t, empty, r.kind, "overlapping network %s", p)
+				*r = record{kind: leaf, value: len(data)}
+				break
+			}
+			switch r.kind {
+			case empty:
+				nodes = append(nodes, [2]record{})
+				r = &nodes[node][bit]
+				*r = record{kind: child, value: len(nodes) - 1}
+			case leaf:
+				t.Fatalf("network %s is inside another network", p)
+			}
+			node = r.value
+		}
+		data = encodeTestValue(data, networks[p])
+	}
+
+	nodeCount := len(nodes)
+	value := func(r record) uint32 {
+		switch r.kind {
+		case child:
+			return uint32(r.value)
+		case leaf:
+			return uint32(nodeCount + dataSectionSeparatorSize + r.value)
+		default:
+			return uint32(nodeCount)
+		}
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>20)&0xF0|byte(right>>24)&0x0F,
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = binary.BigEndian.AppendUint32(buf, left)
+			buf = binary.BigEndian.AppendUint32(buf, right)
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparatorSize)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataStartMarker...)
+	return encodeTestValue(buf, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               databaseType,
+		"description":                 map[string]any{"en": "Test database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+}
+
+func encodeTestValue(buf []byte, v any) []byte {
+	control := func(buf []byte, typ dataType, size int) []byte {
+		var ctrl, ext []byte
+		if typ > 7 {
+			ctrl, ext = []byte{0}, []byte{byte(typ - 7)}
+		} else {
+			ctrl = []byte{byte(typ) << 5}
+		}
+		var extra []byte
+		switch {
+		case size < 29:
+			ctrl[0] |= byte(size)
+		case size < 285:
+			ctrl[0] |= 29
+			extra = []byte{byte(size - 29)}
+		case size < 65821:
+			ctrl[0] |= 30
+			extra = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+		default:
+			ctrl[0] |= 31
+			s := size - 65821
+			extra = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+		}
+		buf = append(buf, ctrl...)
+		buf = append(buf, ext...)
+		return append(buf, extra...)
+	}
+	putUint := func(buf []byte, typ dataType, v uint64) []byte {
+		b := binary.BigEndian.AppendUint64(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		return append(control(buf, typ, len(b)), b...)
+	}
+
+	switch v := v.(type) {
+	case string:
+		return append(control(buf, typeString, len(v)), v...)
+	case float64:
+		return binary.BigEndian.AppendUint64(control(buf, typeDouble, 8), math.Float64bits(v))
+	case bool:
+		if v {
+			return control(buf, typeBoolean, 1)
+		}
+		return control(buf, typeBoolean, 0)
+	case uint16:
+		return putUint(buf, typeUint16, uint64(v))
+	case uint32:
+		return putUint(buf, typeUint32, uint64(v))
+	case uint64:
+		return putUint(buf, typeUint64, v)
+	case []any:
+		buf = control(buf, typeArray, len(v))
+		for _, e := range v {
+			buf = encodeTestValue(buf, e)
+		}
+		return buf
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = control(buf, typeMap, len(v))
+		for _, k := range keys {
+			buf = encodeTestValue(buf, k)
+			buf = encodeTestValue(buf, v[k])
+		}
+		return buf
+	default:
+		panic("unsupported test value type")
+	}
+}
@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// patternReference matches the %{SYNTAX}, %{SYNTAX:field} and
+	// %{SYNTAX:field:type} references to other patterns.
+	patternReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?\}`)
+
+	patternName = regexp.MustCompile(`^\w+$`)
+)
+
+// groupPrefix is the prefix of the names of the regular expression groups
+// capturing fields.
+const groupPrefix = "_grok_"
+
+// pattern is a grok pattern compiled into a regular expression.
+type pattern struct {
+	raw      string
+	re       *regexp.Regexp
+	captures []capture
+}
+
+// capture is a group of the regular expression capturing a field.
+type capture struct {
+	group int
+	field string
+	typ   string
+}
+
+// compile expands the references to other patterns and compiles the result
+// into a regular expression.
+func compile(raw string, definitions map[string]string) (*pattern, error) {
+	c := &compiler{definitions: definitions}
+	expanded, err := c.expand(raw, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile grok pattern '%v': %w", raw, err)
+	}
+
+	var captures []capture
+	for group, name := range re.SubexpNames() {
+		switch {
+		case name == "":
+		case strings.HasPrefix(name, groupPrefix):
+			idx, err := strconv.Atoi(strings.TrimPrefix(name, groupPrefix))
+			if err != nil || idx >= len(c.captures) {
+				return nil, fmt.Errorf("invalid group name '%v' in grok pattern '%v'", name, raw)
+			}
+			captures = append(captures, capture{group: group, field: c.captures[idx].field, typ: c.captures[idx].typ})
+		default:
+			// Named groups of the regular expression syntax capture a field too.
+			captures = append(captures, capture{group: group, field: name})
+		}
+	}
+
+	return &pattern{raw: raw, re: re, captures: captures}, nil
+}
+
+type compiler struct {
+	definitions map[string]string
+	captures    []capture
+}
+
+// expand replaces recursively the pattern references by their definition.
+// Stack contains the names of the patterns being expanded, to detect cycles.
+func (c *compiler) expand(raw string, stack []string) (string, error) {
+	var err error
+	expanded := patternReference.ReplaceAllStringFunc(raw, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := patternReference.FindStringSubmatch(ref)
+		name, field, typ := m[1], m[2], m[3]
+
+		definition, found := c.definitions[name]
+		if !found {
+			err = fmt.Errorf("pattern %%{%v} is not defined", name)
+			return ""
+		}
+		for _, parent := range stack {
+			if parent == name {
+				err = fmt.Errorf("pattern %%{%v} is recursive", name)
+				return ""
+			}
+		}
+		if err = validateType(typ); err != nil {
+			return ""
+		}
+
+		var sub string
+		sub, err = c.expand(definition, append(stack, name))
+		if err != nil {
+			return ""
+		}
+		if field == "" {
+			return "(?:" + sub + ")"
+		}
+
+		c.captures = append(c.captures, capture{field: field, typ: typ})
+		return fmt.Sprintf("(?P<%s%d>%s)", groupPrefix, len(c.captures)-1, sub)
+	})
+	return expanded, err
+}
+
+// match matches the pattern against s and returns the captured fields.
+func (p *pattern) match(s string) (map[string]interface{}, bool, error) {
+	loc := p.re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, false, nil
+	}
+
+	fields := make(map[string]interface{}, len(p.captures))
+	for _, c := range p.captures {
+		start, end := loc[2*c.group], loc[2*c.group+1]
+		if start < 0 {
+			// The group did not participate in the match.
+			continue
+		}
+		if _, exists := fields[c.field]; exists {
+			continue
+		}
+		v, err := convert(s[start:end], c.typ)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to convert field '%v': %w", c.field, err)
+		}
+		fields[c.field] = v
+	}
+	return fields, true, nil
+}
+
+func validateType(typ string) error {
+	switch typ {
+	case "", "string", "int", "long", "float", "double", "boolean", "bool":
+		return nil
+	}
+	return fmt.Errorf("unsupported type '%v', must be one of int, long, float, double, boolean or string", typ)
+}
+
+func convert(v, typ string) (interface{}, error) {
+	switch typ {
+	case "int", "long":
+		return strconv.ParseInt(v, 10, 64)
+	case "float", "double":
+		return strconv.ParseFloat(v, 64)
+	case "boolean", "bool":
+		return strconv.ParseBool(v)
+	}
+	return v, nil
+}
@@ -0,0 +1,69 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+)
+
+//go:embed patterns
+var patternFiles embed.FS
+
+// standardPatterns holds the bundled pattern definitions, indexed by name.
+var standardPatterns = mustLoadStandardPatterns()
+
+func mustLoadStandardPatterns() map[string]string {
+	definitions := map[string]string{}
+	entries, err := patternFiles.ReadDir("patterns")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := patternFiles.ReadFile(path.Join("patterns", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		if err := parsePatternDefinitions(string(data), definitions); err != nil {
+			panic(fmt.Errorf("invalid grok pattern file %v: %w", entry.Name(), err))
+		}
+	}
+	return definitions
+}
+
+// parsePatternDefinitions parses definitions in the format of the Logstash
+// pattern files, with one 'NAME pattern' definition per line. Empty lines and
+// lines starting with '#' are ignored.
+func parsePatternDefinitions(data string, definitions map[string]string) error {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, pattern, found := strings.Cut(line, " ")
+		if !found || !patternName.MatchString(name) {
+			return fmt.Errorf("invalid pattern definition on line %d", lineNum)
+		}
+		definitions[name] = strings.TrimSpace(pattern)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	cfg "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const flagParsingError = "grok_parsing_error"
+
+var errNoMatch = errors.New("provided grok patterns do not match field value")
+
+type processor struct {
+	config   config
+	patterns []*pattern
+}
+
+func init() {
+	processors.RegisterPlugin("grok", NewProcessor)
+	jsprocessor.RegisterPlugin("Grok", NewProcessor)
+}
+
+// NewProcessor constructs a new grok processor. The patterns are compiled
+// once, using the bundled pattern definitions and the custom ones.
+func NewProcessor(c *cfg.C) (beat.Processor, error) {
+	config := defaultConfig()
+	if err := c.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("fail to unpack the grok configuration: %w", err)
+	}
+
+	definitions := make(map[string]string, len(standardPatterns)+len(config.PatternDefinitions))
+	for name, definition := range standardPatterns {
+		definitions[name] = definition
+	}
+	for name, definition := range config.PatternDefinitions {
+		if !patternName.MatchString(name) {
+			return nil, fmt.Errorf("invalid grok pattern definition name '%v'", name)
+		}
+		definitions[name] = definition
+	}
+
+	p := &processor{config: config}
+	for _, raw := range config.Patterns {
+		compiled, err := compile(raw, definitions)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, compiled)
+	}
+	return p, nil
+}
+
+// Run matches the configured field against the patterns and adds the fields
+// captured by the first matching pattern to the event.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	v, err := event.GetValue(p.config.Field)
+	if err != nil {
+		if p.config.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return event, nil
+		}
+		return event, err
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return event, fmt.Errorf("field is not a string, value: `%v`, field: `%s`", v, p.config.Field)
+	}
+
+	var (
+		fields  map[string]interface{}
+		matched = -1
+	)
+	for i, pattern := range p.patterns {
+		var ok bool
+		fields, ok, err = pattern.match(s)
+		if err != nil {
+			break
+		}
+		if ok {
+			matched = i
+			break
+		}
+	}
+	if err == nil && matched < 0 {
+		err = errNoMatch
+	}
+	if err != nil {
+		if err := mapstr.AddTagsWithKey(
+			event.Fields,
+			beat.FlagField,
+			[]string{flagParsingError},
+		); err != nil {
+			return event, fmt.Errorf("cannot add new flag the event: %w", err)
+		}
+		if p.config.IgnoreFailure {
+			return event, nil
+		}
+		return event, err
+	}
+
+	backup := event.Clone()
+	if err := p.mapper(event, fields); err != nil {
+		return backup, err
+	}
+	if p.config.MatchField != "" {
+		if _, err := event.PutValue(p.config.MatchField, matched); err != nil {
+			return backup, fmt.Errorf("failed to put the matching pattern index in `%s`: %w", p.config.MatchField, err)
+		}
+	}
+	return event, nil
+}
+
+func (p *processor) mapper(event *beat.Event, fields map[string]interface{}) error {
+	prefix := ""
+	if p.config.TargetPrefix != "" {
+		prefix = p.config.TargetPrefix + "."
+	}
+	for k, v := range fields {
+		key := prefix + k
+		// The parsed field can always be replaced by a capture.
+		overwrite := p.config.OverwriteKeys || key == p.config.Field
+		if _, err := event.GetValue(key); errors.Is(err, mapstr.ErrKeyNotFound) || overwrite {
+			_, _ = event.PutValue(key, v)
+		} else {
+			// When the target key exists but is a string instead of a map.
+			if err != nil {
+				return fmt.Errorf("cannot override existing key with `%s`: %w", key, err)
+			}
+			return fmt.Errorf("cannot override existing key with `%s`", key)
+		}
+	}
+	return nil
+}
+
+func (p *processor) String() string {
+	return "grok=[field=" + p.config.Field +
+		",patterns=" + strings.Join(p.config.Patterns, "|") +
+		",target_prefix=" + p.config.TargetPrefix + "]"
+}
@@ -0,0 +1,200 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestStandardPatternsCompile(t *testing.T) {
+	for name := range standardPatterns {
+		_, err := compile("%{"+name+"}", standardPatterns)
+		assert.NoError(t, err, "pattern %v", name)
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	tests := map[string]struct {
+		config  mapstr.M
+		message string
+		want    mapstr.M
+	}{
+		"apache combined log": {
+			config:  mapstr.M{"patterns": []string{"%{COMBINEDAPACHELOG}"}},
+			message: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			want: mapstr.M{
+				"clientip":    "127.0.0.1",
+				"ident":       "-",
+				"auth":        "frank",
+				"timestamp":   "10/Oct/2000:13:55:36 -0700",
+				"verb":        "GET",
+				"request":     "/apache_pb.gif",
+				"httpversion": "1.0",
+				"response":    "200",
+				"bytes":       "2326",
+				"referrer":    `"http://www.example.com/start.html"`,
+				"agent":       `"Mozilla/4.08"`,
+			},
+		},
+		"syslog": {
+			config:  mapstr.M{"patterns": []string{"%{SYSLOGLINE}"}},
+			message: "Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8",
+			want: mapstr.M{
+				"timestamp": "Oct 11 22:14:15",
+				"logsource": "mymachine",
+				"program":   "su",
+				"pid":       "230",
+				"message":   "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		"types and nested fields": {
+			config: mapstr.M{
+				"patterns": []string{`%{IP:client.ip} %{INT:http.response.status_code:int} %{NUMBER:event.duration:float} %{WORD:ok:boolean}`},
+			},
+			message: "2001:db8::1 404 0.25 true",
+			want: mapstr.M{
+				"client": mapstr.M{"ip": "2001:db8::1"},
+				"http":   mapstr.M{"response": mapstr.M{"status_code": int64(404)}},
+				"event":  mapstr.M{"duration": 0.25},
+				"ok":     true,
+			},
+		},
+		"first matching pattern": {
+			config: mapstr.M{
+				"patterns":    []string{`^%{INT:id:long}$`, `^%{WORD:word}$`, `^%{GREEDYDATA:rest}$`},
+				"match_field": "grok.match",
+			},
+			message: "hello",
+			want: mapstr.M{
+				"word": "hello",
+				"grok": mapstr.M{"match": 1},
+			},
+		},
+		"custom definitions and optional segments": {
+			config: mapstr.M{
+				"patterns": []string{`^%{LEVEL:level}(?: \[%{WORD:module}\])? %{GREEDYDATA:message}$`},
+				"pattern_definitions": mapstr.M{
+					"LEVEL": "DEBUG|INFO|WARN|ERROR",
+				},
+				"target_prefix": "app",
+			},
+			message: "INFO starting up",
+			want: mapstr.M{
+				"app": mapstr.M{"level": "INFO", "message": "starting up"},
+			},
+		},
+		"regular expression named groups": {
+			config:  mapstr.M{"patterns": []string{`^(?P<key>\w+)=%{GREEDYDATA:value}$`}},
+			message: "user=alice",
+			want:    mapstr.M{"key": "user", "value": "alice"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewProcessor(conf.MustNewConfigFrom(test.config))
+			require.NoError(t, err)
+
+			event, err := p.Run(&beat.Event{Fields: mapstr.M{"message": test.message}})
+			require.NoError(t, err)
+
+			want := mapstr.M{"message": test.message}
+			want.DeepUpdate(test.want)
+			assert.Equal(t, want, event.Fields)
+		})
+	}
+}
+
+func TestProcessorFailures(t *testing.T) {
+	newProcessor := func(t *testing.T, c mapstr.M) *processor {
+		p, err := NewProcessor(conf.MustNewConfigFrom(c))
+		require.NoError(t, err)
+		return p.(*processor)
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		p := newProcessor(t, mapstr.M{"patterns": []string{`^%{INT:id}$`}})
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "abc"}})
+		assert.ErrorIs(t, err, errNoMatch)
+		tags, _ := event.GetValue(beat.FlagField)
+		assert.Equal(t, []string{flagParsingError}, tags)
+	})
+
+	t.Run("ignore failure", func(t *testing.T) {
+		p := newProcessor(t, mapstr.M{"patterns": []string{`^%{INT:id}$`}, "ignore_failure": true})
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "abc"}})
+		assert.NoError(t, err)
+		tags, _ := event.GetValue(beat.FlagField)
+		assert.Equal(t, []string{flagParsingError}, tags)
+	})
+
+	t.Run("conversion error", func(t *testing.T) {
+		p := newProcessor(t, mapstr.M{"patterns": []string{`^%{NOTSPACE:id:int}$`}})
+		_, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "abc"}})
+		assert.ErrorContains(t, err, "failed to convert field 'id'")
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		p := newProcessor(t, mapstr.M{"patterns": []string{`%{INT:id}`}})
+		_, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+		assert.Error(t, err)
+
+		p = newProcessor(t, mapstr.M{"patterns": []string{`%{INT:id}`}, "ignore_missing": true})
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+		assert.NoError(t, err)
+		assert.Equal(t, mapstr.M{}, event.Fields)
+	})
+
+	t.Run("existing key", func(t *testing.T) {
+		p := newProcessor(t, mapstr.M{"patterns": []string{`%{INT:id}`}})
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "1", "id": "old"}})
+		assert.Error(t, err)
+		assert.Equal(t, "old", event.Fields["id"])
+
+		p = newProcessor(t, mapstr.M{"patterns": []string{`%{INT:id}`}, "overwrite_keys": true})
+		event, err = p.Run(&beat.Event{Fields: mapstr.M{"message": "1", "id": "old"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", event.Fields["id"])
+	})
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := map[string]mapstr.M{
+		"no patterns":       {},
+		"undefined pattern": {"patterns": []string{"%{UNKNOWN:x}"}},
+		"recursive pattern": {
+			"patterns":            []string{"%{A}"},
+			"pattern_definitions": mapstr.M{"A": "a%{B}", "B": "b%{A}"},
+		},
+		"invalid type":       {"patterns": []string{"%{INT:x:date}"}},
+		"invalid expression": {"patterns": []string{"%{INT:x}("}},
+	}
+	for name, c := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewProcessor(conf.MustNewConfigFrom(c))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+
+package processors
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+var (
+	metricsMutex sync.Mutex
+	metricsIDs   = map[string]int{}
+)
+
+// NewMetricsRegistry returns a new registry for the metrics of one instance
+// of the named processor. The registry is added to the stats namespace as
+// processor.<name>.<id>, the ID being unique to the instance, so that its
+// metrics are reported along with the other metrics of the beat. The
+// returned function removes the registry, processors that can be closed call
+// it when they are closed.
+func NewMetricsRegistry(name string) (*monitoring.Registry, func()) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	metricsIDs[name]++
+	id := strconv.Itoa(metricsIDs[name])
+	parent := monitoring.GetNamespace("stats").GetRegistry().GetOrCreateRegistry("processor." + name)
+	return parent.NewRegistry(id), func() { parent.Remove(id) }
+}
@@ -0,0 +1,45 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestNewMetricsRegistry(t *testing.T) {
+	first, removeFirst := NewMetricsRegistry("test")
+	second, removeSecond := NewMetricsRegistry("test")
+	defer removeSecond()
+	monitoring.NewInt(first, "count").Set(1)
+	monitoring.NewInt(second, "count").Set(2)
+
+	stats := monitoring.GetNamespace("stats").GetRegistry()
+	snapshot := monitoring.CollectFlatSnapshot(stats, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["processor.test.1.count"])
+	assert.Equal(t, int64(2), snapshot.Ints["processor.test.2.count"])
+
+	removeFirst()
+	snapshot = monitoring.CollectFlatSnapshot(stats, monitoring.Full, false)
+	assert.NotContains(t, snapshot.Ints, "processor.test.1.count")
+	assert.Equal(t, int64(2), snapshot.Ints["processor.test.2.count"])
+}
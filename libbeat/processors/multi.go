@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package processors
+
+import (
+	"errors"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// SplitsEvents reports whether p can turn one event into several events.
+func SplitsEvents(p beat.Processor) bool {
+	mp, ok := p.(beat.MultiProcessor)
+	return ok && mp.SplitsEvents()
+}
+
+// RunMulti runs p on event. Processors that do not split events return at
+// most one event.
+func RunMulti(p beat.Processor, event *beat.Event) ([]*beat.Event, error) {
+	if mp, ok := p.(beat.MultiProcessor); ok && mp.SplitsEvents() {
+		return mp.RunMulti(event)
+	}
+	event, err := p.Run(event)
+	if event == nil {
+		return nil, err
+	}
+	return []*beat.Event{event}, err
+}
+
+// RunMultiList applies the processors of list serially to event and to every
+// event created from it by processors splitting events. The resulting events
+// are returned in order.
+//
+// handleError is called with every processor error. If it returns an error,
+// the remaining processors are not applied to the failing event, which is
+// returned as is, and the error is returned after all other events have been
+// processed.
+func RunMultiList(list []beat.Processor, event *beat.Event, handleError func(beat.Processor, error) error) ([]*beat.Event, error) {
+	return runMultiList(list, event, handleError, nil)
+}
+
+func runMultiList(list []beat.Processor, event *beat.Event, handleError func(beat.Processor, error) error, out []*beat.Event) ([]*beat.Event, error) {
+	for i, p := range list {
+		if !SplitsEvents(p) {
+			var err error
+			event, err = p.Run(event)
+			if err != nil {
+				if err = handleError(p, err); err != nil {
+					if event != nil {
+						out = append(out, event)
+					}
+					return out, err
+				}
+			}
+			if event == nil {
+				return out, nil
+			}
+			continue
+		}
+
+		events, err := p.(beat.MultiProcessor).RunMulti(event)
+		if err != nil {
+			if err = handleError(p, err); err != nil {
+				return append(out, events...), err
+			}
+		}
+
+		var errs []error
+		for _, e := range events {
+			out, err = runMultiList(list[i+1:], e, handleError, out)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return out, errors.Join(errs...)
+	}
+	return append(out, event), nil
+}
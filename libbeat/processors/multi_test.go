@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package processors
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// duplicate splits events into one event per value of its field.
+type duplicate struct {
+	field  string
+	values []int
+}
+
+func (d *duplicate) String() string { return "duplicate" }
+
+func (d *duplicate) Run(e *beat.Event) (*beat.Event, error) {
+	return e, errors.New("not supported")
+}
+
+func (d *duplicate) RunMulti(e *beat.Event) ([]*beat.Event, error) {
+	events := make([]*beat.Event, len(d.values))
+	for i, v := range d.values {
+		events[i] = e.Clone()
+		events[i].Fields[d.field] = v
+	}
+	return events, nil
+}
+
+func (d *duplicate) SplitsEvents() bool { return true }
+
+type runFunc func(*beat.Event) (*beat.Event, error)
+
+func (f runFunc) String() string                         { return "func" }
+func (f runFunc) Run(e *beat.Event) (*beat.Event, error) { return f(e) }
+
+func fieldValues(events []*beat.Event, keys ...string) [][]interface{} {
+	var out [][]interface{}
+	for _, e := range events {
+		var values []interface{}
+		for _, k := range keys {
+			values = append(values, e.Fields[k])
+		}
+		out = append(out, values)
+	}
+	return out
+}
+
+func TestRunMultiList(t *testing.T) {
+	dropOdd := runFunc(func(e *beat.Event) (*beat.Event, error) {
+		if e.Fields["b"].(int)%2 == 1 {
+			return nil, nil
+		}
+		return e, nil
+	})
+	failOn := func(v int) runFunc {
+		return func(e *beat.Event) (*beat.Event, error) {
+			if e.Fields["b"] == v {
+				return e, errors.New("failed")
+			}
+			e.Fields["ok"] = true
+			return e, nil
+		}
+	}
+
+	t.Run("processors after a split apply to every event", func(t *testing.T) {
+		list := []beat.Processor{
+			&duplicate{field: "a", values: []int{1, 2}},
+			&duplicate{field: "b", values: []int{1, 2, 3, 4}},
+			dropOdd,
+		}
+		events, err := RunMultiList(list, &beat.Event{Fields: mapstr.M{}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, [][]interface{}{{1, 2}, {1, 4}, {2, 2}, {2, 4}}, fieldValues(events, "a", "b"))
+	})
+
+	t.Run("errors are handled per event", func(t *testing.T) {
+		list := []beat.Processor{
+			&duplicate{field: "b", values: []int{1, 2, 3}},
+			failOn(2),
+		}
+
+		var handled []error
+		events, err := RunMultiList(list, &beat.Event{Fields: mapstr.M{}}, func(_ beat.Processor, err error) error {
+			handled = append(handled, err)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Len(t, handled, 1)
+		assert.Equal(t, [][]interface{}{{1, true}, {2, nil}, {3, true}}, fieldValues(events, "b", "ok"))
+
+		events, err = RunMultiList(list, &beat.Event{Fields: mapstr.M{}}, func(_ beat.Processor, err error) error {
+			return err
+		})
+		assert.Error(t, err)
+		assert.Equal(t, [][]interface{}{{1, true}, {2, nil}, {3, true}}, fieldValues(events, "b", "ok"))
+	})
+}
+
+func TestSplitsEventsWrappers(t *testing.T) {
+	split := &duplicate{field: "b", values: []int{1, 2}}
+	when, err := NewConditionRule(conditions.Config{HasFields: []string{"split"}}, split)
+	require.NoError(t, err)
+	require.True(t, SplitsEvents(when))
+
+	events, err := RunMulti(when, &beat.Event{Fields: mapstr.M{"split": true}})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	events, err = RunMulti(when, &beat.Event{Fields: mapstr.M{}})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	when, err = NewConditionRule(conditions.Config{HasFields: []string{"split"}}, runFunc(nil))
+	require.NoError(t, err)
+	assert.False(t, SplitsEvents(when))
+
+	safe := &SafeProcessor{Processor: split}
+	assert.True(t, SplitsEvents(safe))
+	require.NoError(t, safe.Close())
+	_, err = safe.RunMulti(&beat.Event{Fields: mapstr.M{}})
+	assert.ErrorIs(t, err, ErrClosed)
+}
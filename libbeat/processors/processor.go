@@ -175,6 +175,27 @@ func (procs *Processors) Run(event *beat.Event) (*beat.Event, error) {
 	return event, nil
 }
 
+// RunMulti executes all processors serially like Run, applying the processors
+// following one that splits events to every event it creates.
+func (procs *Processors) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	return RunMultiList(procs.List, event, func(p beat.Processor, err error) error {
+		return fmt.Errorf("failed applying processor %v: %w", p, err)
+	})
+}
+
+// SplitsEvents reports whether one of the processors can split events.
+func (procs *Processors) SplitsEvents() bool {
+	if procs == nil {
+		return false
+	}
+	for _, p := range procs.List {
+		if SplitsEvents(p) {
+			return true
+		}
+	}
+	return false
+}
+
 func (procs Processors) String() string {
 	var s []string
 	for _, p := range procs.List {
@@ -0,0 +1,129 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type config struct {
+	Fields    []string        `config:"fields"`
+	Detectors *[]string       `config:"detectors"` // Built-in detectors, all by default.
+	Patterns  []patternConfig `config:"patterns"`
+	Action    action          `config:"action"`
+	Mask      maskConfig      `config:"mask"`
+	Hash      hashConfig      `config:"hash"`
+}
+
+type patternConfig struct {
+	Name    string `config:"name" validate:"required"`
+	Pattern string `config:"pattern" validate:"required"`
+}
+
+type maskConfig struct {
+	Char     string `config:"char"`
+	KeepLast int    `config:"keep_last" validate:"min=0"`
+}
+
+type hashConfig struct {
+	Key string `config:"key"`
+}
+
+type action uint8
+
+const (
+	actionMask action = iota
+	actionHash
+	actionDrop
+)
+
+var actionNames = map[action]string{
+	actionMask: "mask",
+	actionHash: "hash",
+	actionDrop: "drop",
+}
+
+// Unpack the action from a string.
+func (a *action) Unpack(v string) error {
+	for k, name := range actionNames {
+		if strings.EqualFold(v, name) {
+			*a = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported action '%s'. Must be one of [mask, hash, drop]", v)
+}
+
+func (a action) String() string {
+	return actionNames[a]
+}
+
+func defaultConfig() config {
+	return config{
+		Action: actionMask,
+		Mask:   maskConfig{Char: "*"},
+	}
+}
+
+// detectors returns the names of the configured built-in detectors.
+func (c *config) detectors() []string {
+	if c.Detectors == nil {
+		return builtinNames()
+	}
+	return *c.Detectors
+}
+
+// Validate validates the data contained in the config.
+func (c *config) Validate() error {
+	if len(c.detectors()) == 0 && len(c.Patterns) == 0 {
+		return errors.New("at least one detector or pattern must be configured")
+	}
+
+	names := map[string]bool{}
+	for _, name := range c.detectors() {
+		if _, ok := builtinDetectors[name]; !ok {
+			return fmt.Errorf("unknown detector '%s'. Must be one of [%s]", name, strings.Join(builtinNames(), ", "))
+		}
+		names[name] = true
+	}
+	for _, p := range c.Patterns {
+		if names[p.Name] {
+			return fmt.Errorf("pattern name '%s' is used more than once", p.Name)
+		}
+		names[p.Name] = true
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("failed to compile pattern '%s': %w", p.Name, err)
+		}
+	}
+
+	switch c.Action {
+	case actionMask:
+		if utf8.RuneCountInString(c.Mask.Char) != 1 {
+			return fmt.Errorf("mask.char must be a single character, got '%s'", c.Mask.Char)
+		}
+	case actionHash:
+		if c.Hash.Key == "" {
+			return errors.New("hash.key is required by the hash action")
+		}
+	}
+	return nil
+}
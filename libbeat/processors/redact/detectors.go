@@ -0,0 +1,170 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// detector finds sensitive values in strings.
+type detector struct {
+	name string
+	re   *regexp.Regexp
+	// group is the submatch of re holding the sensitive value.
+	group int
+	// valid, if set, rejects matches that are not sensitive values.
+	valid func(string) bool
+}
+
+var builtinDetectors = map[string]detector{
+	"credit_card": {
+		// 13 to 19 digits, either contiguous or in groups of 4 digits, or in
+		// the 4-6-5 grouping used by American Express.
+		re:    regexp.MustCompile(`\b(?:\d{13,19}|\d{4}[ \-]\d{4}[ \-]\d{4}[ \-]\d{1,7}|\d{4}[ \-]\d{6}[ \-]\d{4,5})\b`),
+		valid: validLuhn,
+	},
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+	"iban": {
+		// Contiguous, or in groups of 4 characters separated by spaces.
+		re:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?:[A-Z0-9]{11,30}|(?: [A-Z0-9]{4}){2,7}(?: [A-Z0-9]{1,3})?)\b`),
+		valid: validIBAN,
+	},
+	"bearer_token": {
+		re:    regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+		group: 1,
+	},
+}
+
+func init() {
+	for name, d := range builtinDetectors {
+		d.name = name
+		builtinDetectors[name] = d
+	}
+}
+
+func builtinNames() []string {
+	names := make([]string, 0, len(builtinDetectors))
+	for name := range builtinDetectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newDetectors returns the configured built-in detectors followed by the
+// custom patterns.
+func newDetectors(c config) []detector {
+	var detectors []detector
+	for _, name := range c.detectors() {
+		detectors = append(detectors, builtinDetectors[name])
+	}
+	for _, p := range c.Patterns {
+		detectors = append(detectors, detector{name: p.Name, re: regexp.MustCompile(p.Pattern)})
+	}
+	return detectors
+}
+
+// span is a sensitive value found in a string.
+type span struct {
+	start, end int
+	detector   int
+}
+
+// find returns the non-overlapping sensitive values of s, in order. When
+// values overlap, the one starting first, or the longest, is kept.
+func find(detectors []detector, s string) []span {
+	var spans []span
+	for i, d := range detectors {
+		for _, m := range d.re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[2*d.group], m[2*d.group+1]
+			if start < 0 || start == end {
+				continue
+			}
+			if d.valid != nil && !d.valid(s[start:end]) {
+				continue
+			}
+			spans = append(spans, span{start, end, i})
+		}
+	}
+	if len(spans) < 2 {
+		return spans
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+	kept := spans[:1]
+	for _, sp := range spans[1:] {
+		if sp.start >= kept[len(kept)-1].end {
+			kept = append(kept, sp)
+		}
+	}
+	return kept
+}
+
+// validLuhn reports whether the digits of s have a valid Luhn check digit.
+// Characters other than digits are ignored.
+func validLuhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// validIBAN reports whether s, ignoring spaces, is an IBAN with valid check
+// digits, as defined by ISO 13616.
+func validIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+
+	// Move the country code and check digits to the end, convert letters to
+	// numbers, A = 10 to Z = 35, and compute the remainder digit by digit.
+	rem := 0
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			rem = (rem*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			rem = (rem*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return rem == 1
+}
@@ -0,0 +1,97 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidLuhn(t *testing.T) {
+	for s, want := range map[string]bool{
+		"4111111111111111":     true,
+		"4111 1111 1111 1111":  true,
+		"3782-822463-10005":    true,
+		"6011111111111117":     true,
+		"4111111111111112":     false,
+		"0000000000":           false, // too short
+		"12345678901234567890": false, // too long
+	} {
+		assert.Equal(t, want, validLuhn(s), s)
+	}
+}
+
+func TestValidIBAN(t *testing.T) {
+	for s, want := range map[string]bool{
+		"DE89370400440532013000":      true,
+		"DE89 3704 0044 0532 0130 00": true,
+		"GB82WEST12345698765432":      true,
+		"NO9386011117947":             true,
+		"DE89370400440532013001":      false,
+		"DE8937040044":                false,
+		"de89370400440532013000":      false,
+	} {
+		assert.Equal(t, want, validIBAN(s), s)
+	}
+}
+
+func TestFind(t *testing.T) {
+	c := defaultConfig()
+	c.Patterns = []patternConfig{{Name: "ticket", Pattern: `TICKET-\d+`}}
+	detectors := newDetectors(c)
+
+	cases := map[string][]string{
+		"card 4111 1111 1111 1111 exp 12/30":                          {"4111 1111 1111 1111"},
+		"amex 3782 822463 10005, visa 4111111111111111.":              {"3782 822463 10005", "4111111111111111"},
+		"order 4111111111111112 is not a card":                        {},
+		"mail jane.doe+test@example.co.uk now":                        {"jane.doe+test@example.co.uk"},
+		"iban DE89 3704 0044 0532 0130 00 and GB82WEST12345698765432": {"DE89 3704 0044 0532 0130 00", "GB82WEST12345698765432"},
+		"iban DE89370400440532013001":                                 {},
+		"Authorization: Bearer eyJhbGciOi.J9-_x== next":               {"eyJhbGciOi.J9-_x=="},
+		"see TICKET-1234":                                             {"TICKET-1234"},
+		"nothing here":                                                {},
+	}
+	for s, want := range cases {
+		var got []string
+		for _, sp := range find(detectors, s) {
+			got = append(got, s[sp.start:sp.end])
+		}
+		if len(want) == 0 {
+			assert.Empty(t, got, s)
+			continue
+		}
+		assert.Equal(t, want, got, s)
+	}
+}
+
+func TestFindOverlapping(t *testing.T) {
+	c := defaultConfig()
+	c.Patterns = []patternConfig{
+		{Name: "digits", Pattern: `\d{4}`},
+		{Name: "card_prefix", Pattern: `card \d+`},
+	}
+	detectors := newDetectors(c)
+
+	s := "card 4111111111111111"
+	spans := find(detectors, s)
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "card 4111111111111111", s[spans[0].start:spans[0].end])
+		assert.Equal(t, "card_prefix", detectors[spans[0].detector].name)
+	}
+}
@@ -0,0 +1,215 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func init() {
+	processors.RegisterPlugin("redact", New)
+	jsprocessor.RegisterPlugin("Redact", New)
+}
+
+type processor struct {
+	config
+	detectors []detector
+	mask      string
+	key       []byte
+	metrics   *monitoring.Registry
+	stats     processorStats
+}
+
+type processorStats struct {
+	Redactions    *monitoring.Int   // Number of redacted values.
+	FieldsDropped *monitoring.Int   // Number of fields dropped by the drop action.
+	Detectors     []*monitoring.Int // Number of values found by each detector.
+}
+
+// New constructs a new redact processor.
+func New(cfg *conf.C) (beat.Processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the redact configuration: %w", err)
+	}
+
+	// The processor can't be closed, as it can be used in scripts, so its
+	// metrics are kept until the beat stops.
+	metrics, _ := processors.NewMetricsRegistry("redact")
+
+	p := &processor{
+		config:    c,
+		detectors: newDetectors(c),
+		mask:      c.Mask.Char,
+		key:       []byte(c.Hash.Key),
+		metrics:   metrics,
+		stats: processorStats{
+			Redactions:    monitoring.NewInt(metrics, "redactions"),
+			FieldsDropped: monitoring.NewInt(metrics, "fields_dropped"),
+		},
+	}
+	for _, d := range p.detectors {
+		p.stats.Detectors = append(p.stats.Detectors, monitoring.NewInt(metrics, "detectors."+d.name))
+	}
+	return p, nil
+}
+
+// Run redacts the sensitive values of the configured fields, or of all
+// string fields if no fields are configured.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	if len(p.Fields) == 0 {
+		p.redactMap(event.Fields)
+		return event, nil
+	}
+
+	for _, field := range p.Fields {
+		v, err := event.GetValue(field)
+		if err != nil {
+			if errors.Is(err, mapstr.ErrKeyNotFound) {
+				continue
+			}
+			return event, fmt.Errorf("could not fetch value for field %s: %w", field, err)
+		}
+
+		redacted, found := p.redactValue(v)
+		if !found {
+			continue
+		}
+		if p.Action == actionDrop {
+			if err := event.Delete(field); err != nil {
+				return event, fmt.Errorf("failed to drop field %s: %w", field, err)
+			}
+			p.stats.FieldsDropped.Inc()
+			continue
+		}
+		if _, err := event.PutValue(field, redacted); err != nil {
+			return event, fmt.Errorf("failed to put redacted value of field %s: %w", field, err)
+		}
+	}
+	return event, nil
+}
+
+// redactMap redacts all string values of m, dropping the keys holding
+// sensitive values for the drop action.
+func (p *processor) redactMap(m map[string]interface{}) {
+	for k, v := range m {
+		redacted, found := p.redactValue(v)
+		switch {
+		case !found:
+		case p.Action == actionDrop:
+			delete(m, k)
+			p.stats.FieldsDropped.Inc()
+		default:
+			m[k] = redacted
+		}
+	}
+}
+
+// redactValue returns v with its sensitive values redacted, and reports
+// whether sensitive values have been found. Objects and arrays are redacted
+// in place. Objects are never reported, because the drop action drops the
+// keys holding sensitive values inside them instead.
+func (p *processor) redactValue(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return p.redactString(v)
+	case []string:
+		found := false
+		for i, s := range v {
+			if r, ok := p.redactString(s); ok {
+				v[i], found = r, true
+			}
+		}
+		return v, found
+	case []interface{}:
+		found := false
+		for i, e := range v {
+			if r, ok := p.redactValue(e); ok {
+				v[i], found = r, true
+			}
+		}
+		return v, found
+	case mapstr.M:
+		p.redactMap(v)
+		return v, false
+	case map[string]interface{}:
+		p.redactMap(v)
+		return v, false
+	default:
+		return v, false
+	}
+}
+
+// redactString replaces the sensitive values of s.
+func (p *processor) redactString(s string) (string, bool) {
+	spans := find(p.detectors, s)
+	if len(spans) == 0 {
+		return s, false
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	last := 0
+	for _, sp := range spans {
+		p.stats.Redactions.Inc()
+		p.stats.Detectors[sp.detector].Inc()
+
+		b.WriteString(s[last:sp.start])
+		p.replace(&b, s[sp.start:sp.end])
+		last = sp.end
+	}
+	b.WriteString(s[last:])
+	return b.String(), true
+}
+
+// replace writes the replacement of the sensitive value v.
+func (p *processor) replace(b *strings.Builder, v string) {
+	switch p.Action {
+	case actionHash:
+		h := hmac.New(sha256.New, p.key)
+		h.Write([]byte(v))
+		b.WriteString(hex.EncodeToString(h.Sum(nil)))
+	default:
+		// Only the last characters are kept, so the mask covers all runes
+		// but KeepLast.
+		runes := []rune(v)
+		keep := min(p.Mask.KeepLast, len(runes))
+		b.WriteString(strings.Repeat(p.mask, len(runes)-keep))
+		b.WriteString(string(runes[len(runes)-keep:]))
+	}
+}
+
+func (p *processor) String() string {
+	names := make([]string, len(p.detectors))
+	for i, d := range p.detectors {
+		names[i] = d.name
+	}
+	return fmt.Sprintf("redact=[fields=%v, detectors=%v, action=%v]", p.Fields, names, p.Action)
+}
@@ -0,0 +1,177 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func hmacHex(key, v string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(v))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestRedact(t *testing.T) {
+	cases := map[string]struct {
+		config mapstr.M
+		input  mapstr.M
+		want   mapstr.M
+	}{
+		"mask all string fields": {
+			input: mapstr.M{
+				"message": "paid with 4111 1111 1111 1111 by jane@example.com",
+				"http": mapstr.M{
+					"request": mapstr.M{
+						"headers": map[string]interface{}{"authorization": "Bearer abc123"},
+					},
+				},
+				"ibans": []interface{}{"DE89370400440532013000", 42, "none"},
+				"count": 1,
+			},
+			want: mapstr.M{
+				"message": "paid with ******************* by ****************",
+				"http": mapstr.M{
+					"request": mapstr.M{
+						"headers": map[string]interface{}{"authorization": "Bearer ******"},
+					},
+				},
+				"ibans": []interface{}{"**********************", 42, "none"},
+				"count": 1,
+			},
+		},
+		"mask field list keeping last characters": {
+			config: mapstr.M{
+				"fields":    []string{"card", "cards", "missing"},
+				"detectors": []string{"credit_card"},
+				"mask":      mapstr.M{"char": "#", "keep_last": 4},
+			},
+			input: mapstr.M{
+				"card":  "4111111111111111",
+				"cards": []string{"4111111111111111", "no"},
+				"other": "4111111111111111",
+			},
+			want: mapstr.M{
+				"card":  "############1111",
+				"cards": []string{"############1111", "no"},
+				"other": "4111111111111111",
+			},
+		},
+		"hash": {
+			config: mapstr.M{
+				"fields": []string{"user"},
+				"action": "hash",
+				"hash":   mapstr.M{"key": "secret"},
+			},
+			input: mapstr.M{"user": "contact: jane@example.com"},
+			want:  mapstr.M{"user": "contact: " + hmacHex("secret", "jane@example.com")},
+		},
+		"drop": {
+			config: mapstr.M{"action": "drop"},
+			input: mapstr.M{
+				"email":  "jane@example.com",
+				"nested": mapstr.M{"token": "bearer x", "ok": "fine"},
+				"list":   []interface{}{"a", "DE89370400440532013000"},
+				"plain":  "hello",
+			},
+			want: mapstr.M{
+				"nested": mapstr.M{"ok": "fine"},
+				"plain":  "hello",
+			},
+		},
+		"drop field list": {
+			config: mapstr.M{"action": "drop", "fields": []string{"user.email", "user.name"}},
+			input:  mapstr.M{"user": mapstr.M{"email": "jane@example.com", "name": "jane"}},
+			want:   mapstr.M{"user": mapstr.M{"name": "jane"}},
+		},
+		"custom patterns only": {
+			config: mapstr.M{
+				"detectors": []string{},
+				"patterns":  []mapstr.M{{"name": "ssn", "pattern": `\b\d{3}-\d{2}-\d{4}\b`}},
+			},
+			input: mapstr.M{"message": "ssn 123-45-6789, mail jane@example.com"},
+			want:  mapstr.M{"message": "ssn ***********, mail jane@example.com"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := tc.config
+			if config == nil {
+				config = mapstr.M{}
+			}
+			p, err := New(conf.MustNewConfigFrom(config))
+			require.NoError(t, err)
+
+			event, err := p.Run(&beat.Event{Fields: tc.input})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, event.Fields)
+		})
+	}
+}
+
+func TestRedactMetrics(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{
+		"detectors": []string{"email", "credit_card"},
+	}))
+	require.NoError(t, err)
+
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{
+		"a": "jane@example.com, john@example.com",
+		"b": "4111111111111111",
+		"c": "nothing",
+	}})
+	require.NoError(t, err)
+
+	snapshot := monitoring.CollectFlatSnapshot(p.(*processor).metrics, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"redactions":            3,
+		"fields_dropped":        0,
+		"detectors.email":       2,
+		"detectors.credit_card": 1,
+	}, snapshot.Ints)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for name, config := range map[string]mapstr.M{
+		"unknown detector":   {"detectors": []string{"ssn"}},
+		"no detectors":       {"detectors": []string{}},
+		"bad pattern":        {"patterns": []mapstr.M{{"name": "x", "pattern": "("}}},
+		"duplicate name":     {"patterns": []mapstr.M{{"name": "email", "pattern": "x"}}},
+		"unknown action":     {"action": "encrypt"},
+		"hash without key":   {"action": "hash"},
+		"long mask char":     {"mask": mapstr.M{"char": "**"}},
+		"negative keep last": {"mask": mapstr.M{"keep_last": -1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(config))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -41,6 +41,19 @@ func (p *SafeProcessor) Run(event *beat.Event) (*beat.Event, error) {
 	return p.Processor.Run(event)
 }
 
+// RunMulti allows to run processor only when `Close` was not called prior
+func (p *SafeProcessor) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	if atomic.LoadUint32(&p.closed) == 1 {
+		return nil, ErrClosed
+	}
+	return RunMulti(p.Processor, event)
+}
+
+// SplitsEvents reports whether the wrapped processor can split events.
+func (p *SafeProcessor) SplitsEvents() bool {
+	return SplitsEvents(p.Processor)
+}
+
 // Close makes sure the underlying `Close` function is called only once.
 func (p *SafeProcessor) Close() (err error) {
 	if atomic.CompareAndSwapUint32(&p.closed, 0, 1) {
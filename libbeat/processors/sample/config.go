@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sample
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sampling modes.
+const (
+	modeRandom = "random"
+	modeHash   = "hash"
+	modePerKey = "per_key"
+)
+
+// config holds the sample settings.
+type config struct {
+	Mode   string   `config:"mode"`
+	Rate   *float64 `config:"rate"`   // Probability of keeping an event, unset in per_key mode means 0.
+	Fields []string `config:"fields"` // Fields forming the key of the hash and per_key modes.
+
+	// MinEvents is the number of events per key and interval that the per_key
+	// mode always keeps.
+	MinEvents int           `config:"min_events"`
+	Interval  time.Duration `config:"interval"`
+
+	TargetField string `config:"target_field"` // Field recording the applied sample rate.
+}
+
+func defaultConfig() config {
+	return config{
+		Mode:        modeRandom,
+		Interval:    time.Minute,
+		TargetField: "sample.rate",
+	}
+}
+
+// Validate validates the data contained in the config.
+func (c *config) Validate() error {
+	switch c.Mode {
+	case modeRandom, modeHash:
+		if c.Rate == nil {
+			return fmt.Errorf("rate is required by the %s mode", c.Mode)
+		}
+		if *c.Rate <= 0 || *c.Rate > 1 {
+			return errors.New("rate must be greater than 0 and at most 1")
+		}
+		if c.Mode == modeHash && len(c.Fields) == 0 {
+			return errors.New("fields are required by the hash mode")
+		}
+	case modePerKey:
+		if c.Rate != nil && (*c.Rate < 0 || *c.Rate > 1) {
+			return errors.New("rate must be between 0 and 1")
+		}
+		if c.MinEvents <= 0 {
+			return errors.New("min_events must be positive in the per_key mode")
+		}
+		if c.Interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s', must be one of %s, %s or %s", c.Mode, modeRandom, modeHash, modePerKey)
+	}
+	if c.TargetField == "" {
+		return errors.New("target_field must not be empty")
+	}
+	return nil
+}
+
+// rate returns the configured rate, 0 if unset.
+func (c *config) rate() float64 {
+	if c.Rate == nil {
+		return 0
+	}
+	return *c.Rate
+}
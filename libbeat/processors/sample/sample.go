@@ -0,0 +1,186 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sample
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const logName = "processor.sample"
+
+func init() {
+	processors.RegisterPlugin("sample", New)
+}
+
+type processor struct {
+	config
+	log           *logp.Logger
+	metrics       *monitoring.Registry
+	removeMetrics func()
+	stats         processorStats
+
+	// threshold is the highest key hash kept by the hash mode.
+	threshold uint64
+	windows   *windows
+
+	now    func() time.Time
+	random func() float64
+}
+
+type processorStats struct {
+	Kept    *monitoring.Int
+	Dropped *monitoring.Int
+}
+
+// New constructs a new sample processor.
+func New(cfg *conf.C) (beat.Processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the sample configuration: %w", err)
+	}
+
+	metrics, removeMetrics := processors.NewMetricsRegistry("sample")
+
+	p := &processor{
+		config:        c,
+		log:           logp.NewLogger(logName),
+		metrics:       metrics,
+		removeMetrics: removeMetrics,
+		stats: processorStats{
+			Kept:    monitoring.NewInt(metrics, "kept"),
+			Dropped: monitoring.NewInt(metrics, "dropped"),
+		},
+		now:    time.Now,
+		random: rand.Float64,
+	}
+	switch c.Mode {
+	case modeHash:
+		p.threshold = hashThreshold(c.rate())
+	case modePerKey:
+		p.windows = newWindows(c.Interval)
+	}
+	return p, nil
+}
+
+// hashThreshold returns the highest hash kept with the given rate, so that a
+// key is kept if its hash is at most the threshold.
+func hashThreshold(rate float64) uint64 {
+	if rate >= 1 {
+		return 1<<64 - 1
+	}
+	return uint64(rate * (1 << 64))
+}
+
+// Run keeps or drops the event, and records the sample rate of kept events.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	rate, keep := p.sample(event)
+	if !keep {
+		p.stats.Dropped.Inc()
+		return nil, nil
+	}
+	p.stats.Kept.Inc()
+
+	// The rates of chained samplers are multiplied, so the recorded rate is
+	// the probability of the event having been kept.
+	if v, err := event.GetValue(p.TargetField); err == nil {
+		if prev, ok := v.(float64); ok && prev > 0 && prev <= 1 {
+			rate *= prev
+		}
+	}
+	if _, err := event.PutValue(p.TargetField, rate); err != nil {
+		return event, fmt.Errorf("failed to record the sample rate in '%s': %w", p.TargetField, err)
+	}
+	return event, nil
+}
+
+// sample returns the sample rate applied to the event and whether it is kept.
+func (p *processor) sample(event *beat.Event) (float64, bool) {
+	rate := p.rate()
+	switch p.Mode {
+	case modeHash:
+		key, found := p.key(event)
+		if !found {
+			// Events without a key are sampled randomly.
+			return rate, p.random() < rate
+		}
+		return rate, key <= p.threshold
+	case modePerKey:
+		key, _ := p.key(event)
+		if p.windows.inc(key, p.now()) <= p.MinEvents {
+			return 1, true
+		}
+		return rate, rate > 0 && p.random() < rate
+	default:
+		return rate, p.random() < rate
+	}
+}
+
+// key returns the hash of the key fields of the event and whether one of
+// them was found. Missing fields are hashed as empty values.
+func (p *processor) key(event *beat.Event) (uint64, bool) {
+	var (
+		h     xxhash.Digest
+		found bool
+	)
+	h.Reset()
+	for _, field := range p.Fields {
+		v, err := event.GetValue(field)
+		switch {
+		case err == nil:
+			found = true
+			if s, ok := v.(string); ok {
+				_, _ = h.WriteString(s)
+			} else {
+				_, _ = fmt.Fprint(&h, v)
+			}
+		case !errors.Is(err, mapstr.ErrKeyNotFound):
+			p.log.Debugw("Failed to get sample key field", "field", field, "error", err)
+		}
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64(), found
+}
+
+// Close removes the metrics of the processor.
+func (p *processor) Close() error {
+	p.removeMetrics()
+	return nil
+}
+
+func (p *processor) String() string {
+	s := fmt.Sprintf("sample=[mode=%s, rate=%v", p.Mode, p.rate())
+	if len(p.Fields) > 0 {
+		s += fmt.Sprintf(", fields=%v", p.Fields)
+	}
+	if p.Mode == modePerKey {
+		s += fmt.Sprintf(", min_events=%d, interval=%v", p.MinEvents, p.Interval)
+	}
+	return s + "]"
+}
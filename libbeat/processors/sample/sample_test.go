@@ -0,0 +1,182 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sample
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func newProcessor(t *testing.T, settings mapstr.M) *processor {
+	t.Helper()
+	p, err := New(conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	t.Cleanup(func() { p.(*processor).Close() })
+	return p.(*processor)
+}
+
+func run(t *testing.T, p beat.Processor, fields mapstr.M) *beat.Event {
+	t.Helper()
+	e, err := p.Run(&beat.Event{Fields: fields})
+	require.NoError(t, err)
+	return e
+}
+
+func TestConfigValidation(t *testing.T) {
+	for name, settings := range map[string]mapstr.M{
+		"missing rate":       {"mode": "random"},
+		"zero rate":          {"rate": 0},
+		"rate above 1":       {"rate": 1.5},
+		"hash without field": {"mode": "hash", "rate": 0.5},
+		"per_key min events": {"mode": "per_key"},
+		"per_key interval":   {"mode": "per_key", "min_events": 1, "interval": "0s"},
+		"per_key rate":       {"mode": "per_key", "min_events": 1, "rate": -0.1},
+		"unknown mode":       {"mode": "reservoir", "rate": 0.5},
+		"empty target":       {"rate": 0.5, "target_field": ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(settings))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	p := newProcessor(t, mapstr.M{"rate": 0.1})
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if e := run(t, p, mapstr.M{"n": i}); e != nil {
+			kept++
+			assert.Equal(t, mapstr.M{"n": i, "sample": mapstr.M{"rate": 0.1}}, e.Fields)
+		}
+	}
+	assert.InDelta(t, 1000, kept, 200)
+	snapshot := monitoring.CollectFlatSnapshot(p.metrics, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{"kept": int64(kept), "dropped": int64(10000 - kept)}, snapshot.Ints)
+}
+
+func TestHash(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"mode":         "hash",
+		"rate":         0.25,
+		"fields":       []string{"trace.id"},
+		"target_field": "event.sample_rate",
+	})
+	// A second instance takes the same decisions.
+	other := newProcessor(t, mapstr.M{"mode": "hash", "rate": 0.25, "fields": []string{"trace.id"}})
+
+	keptTraces := 0
+	for i := 0; i < 2000; i++ {
+		id := fmt.Sprintf("trace-%d", i)
+		var kept []bool
+		for span := 0; span < 3; span++ {
+			e := run(t, p, mapstr.M{"trace": mapstr.M{"id": id}, "span": span})
+			kept = append(kept, e != nil)
+			if e != nil {
+				rate, err := e.GetValue("event.sample_rate")
+				require.NoError(t, err)
+				assert.Equal(t, 0.25, rate)
+			}
+		}
+		assert.Equal(t, []bool{kept[0], kept[0], kept[0]}, kept, "spans of %s", id)
+		assert.Equal(t, kept[0], run(t, other, mapstr.M{"trace": mapstr.M{"id": id}}) != nil)
+		if kept[0] {
+			keptTraces++
+		}
+	}
+	assert.InDelta(t, 500, keptTraces, 100)
+}
+
+func TestHashMissingKey(t *testing.T) {
+	p := newProcessor(t, mapstr.M{"mode": "hash", "rate": 0.5, "fields": []string{"trace.id"}})
+	p.random = func() float64 { return 0.4 }
+	assert.NotNil(t, run(t, p, mapstr.M{"message": "a"}))
+	p.random = func() float64 { return 0.6 }
+	assert.Nil(t, run(t, p, mapstr.M{"message": "a"}))
+}
+
+func TestPerKey(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"mode":       "per_key",
+		"fields":     []string{"host"},
+		"min_events": 2,
+		"interval":   "1m",
+		"rate":       0.5,
+	})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	random := 0.0
+	p.random = func() float64 { return random }
+
+	rateOf := func(e *beat.Event) any {
+		if e == nil {
+			return nil
+		}
+		v, _ := e.GetValue("sample.rate")
+		return v
+	}
+
+	// The first events of each key are always kept.
+	assert.Equal(t, 1.0, rateOf(run(t, p, mapstr.M{"host": "a"})))
+	assert.Equal(t, 1.0, rateOf(run(t, p, mapstr.M{"host": "a"})))
+	assert.Equal(t, 1.0, rateOf(run(t, p, mapstr.M{"host": "b"})))
+
+	// Events beyond min_events are sampled.
+	assert.Equal(t, 0.5, rateOf(run(t, p, mapstr.M{"host": "a"})))
+	random = 0.7
+	assert.Nil(t, rateOf(run(t, p, mapstr.M{"host": "a"})))
+
+	// The counts restart with the next window, and the windows of idle
+	// keys are removed.
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1.0, rateOf(run(t, p, mapstr.M{"host": "a"})))
+	assert.Equal(t, 1, p.windows.len())
+}
+
+func TestPerKeyDropsRest(t *testing.T) {
+	p := newProcessor(t, mapstr.M{"mode": "per_key", "min_events": 1})
+	assert.NotNil(t, run(t, p, mapstr.M{"message": "a"}))
+	assert.Nil(t, run(t, p, mapstr.M{"message": "b"}))
+}
+
+func TestChainedRates(t *testing.T) {
+	first := newProcessor(t, mapstr.M{"rate": 0.5})
+	second := newProcessor(t, mapstr.M{"rate": 0.2})
+	first.random = func() float64 { return 0 }
+	second.random = func() float64 { return 0 }
+
+	e := run(t, second, run(t, first, mapstr.M{}).Fields)
+	rate, err := e.GetValue("sample.rate")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.1, rate, 1e-9)
+}
+
+func TestString(t *testing.T) {
+	p := newProcessor(t, mapstr.M{"mode": "per_key", "fields": []string{"host"}, "min_events": 5})
+	assert.Equal(t, "sample=[mode=per_key, rate=0, fields=[host], min_events=5, interval=1m0s]", p.String())
+}
@@ -0,0 +1,78 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sample
+
+import (
+	"sync"
+	"time"
+)
+
+// windows counts the events of each key in fixed time windows.
+type windows struct {
+	mu       sync.Mutex
+	interval time.Duration
+	counts   map[uint64]*window
+	nextGC   time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func newWindows(interval time.Duration) *windows {
+	return &windows{
+		interval: interval,
+		counts:   map[uint64]*window{},
+	}
+}
+
+// inc counts an event of key at now and returns the number of events of key
+// in the current window, including this one.
+func (w *windows) inc(key uint64, now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Windows of keys that stopped receiving events are removed once per
+	// interval.
+	if !now.Before(w.nextGC) {
+		for k, win := range w.counts {
+			if now.Sub(win.start) >= w.interval {
+				delete(w.counts, k)
+			}
+		}
+		w.nextGC = now.Add(w.interval)
+	}
+
+	win, ok := w.counts[key]
+	if !ok {
+		win = &window{start: now}
+		w.counts[key] = win
+	} else if now.Sub(win.start) >= w.interval {
+		win.start, win.count = now, 0
+	}
+	win.count++
+	return win.count
+}
+
+// len returns the number of tracked keys.
+func (w *windows) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.counts)
+}
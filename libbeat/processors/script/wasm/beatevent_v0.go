@@ -0,0 +1,272 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/jsontransform"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// IMPORTANT:
+// This is the ABI used by WebAssembly processors. Do not make breaking
+// changes to the functions of the host module. If you must make breaking
+// changes then create a new host module (e.g. beat_v1) so that existing
+// modules keep working.
+//
+// The functions take strings and values as pointers and lengths into the
+// memory of the calling module, values are encoded as JSON. They return a
+// non-negative result on success or one of the status codes below.
+
+const hostModuleName = "beat"
+
+// Status codes returned by the host functions.
+const (
+	statusNotFound int32 = -1 // The field does not exist.
+	statusError    int32 = -2 // The arguments are invalid or the operation failed.
+)
+
+// call holds the state of the module function being executed.
+type call struct {
+	event     *beat.Event
+	cancelled bool
+	params    []byte
+
+	// fuel is the number of function calls left, used only if the fuel is
+	// limited.
+	fuel      uint64
+	exhausted bool
+	stop      context.CancelFunc
+}
+
+type callKey struct{}
+
+func withCall(ctx context.Context, c *call) context.Context {
+	return context.WithValue(ctx, callKey{}, c)
+}
+
+func getCall(ctx context.Context) *call {
+	c, _ := ctx.Value(callKey{}).(*call)
+	return c
+}
+
+// instantiateHostModule instantiates the beat host module in the runtime.
+func instantiateHostModule(ctx context.Context, r wazero.Runtime) error {
+	i32 := api.ValueTypeI32
+	b := r.NewHostModuleBuilder(hostModuleName)
+	for _, f := range []struct {
+		name    string
+		fn      api.GoModuleFunc
+		params  []api.ValueType
+		results []api.ValueType
+	}{
+		{"get", get, []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}},
+		{"put", put, []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}},
+		{"delete", del, []api.ValueType{i32, i32}, []api.ValueType{i32}},
+		{"tag", tag, []api.ValueType{i32, i32}, []api.ValueType{i32}},
+		{"cancel", cancel, nil, nil},
+		{"params", params, []api.ValueType{i32, i32}, []api.ValueType{i32}},
+	} {
+		b.NewFunctionBuilder().
+			WithGoModuleFunction(f.fn, f.params, f.results).
+			Export(f.name)
+	}
+	_, err := b.Instantiate(ctx)
+	return err
+}
+
+// get writes the JSON encoded value of a field to a buffer and returns its
+// length. Nothing is written if the buffer is too small, the module can call
+// get again with a buffer of the returned length. An empty key returns all
+// the fields of the event.
+//
+//	get(key_ptr, key_len, buf_ptr, buf_len) -> len
+func get(ctx context.Context, m api.Module, stack []uint64) {
+	c, key, ok := eventCall(ctx, m, stack[0], stack[1])
+	if !ok {
+		stack[0] = status(statusError)
+		return
+	}
+
+	var v interface{} = c.event.Fields
+	if key != "" {
+		var err error
+		if v, err = c.event.GetValue(key); err != nil {
+			stack[0] = status(statusNotFound)
+			return
+		}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		stack[0] = status(statusError)
+		return
+	}
+	stack[0] = status(writeBuffer(m, b, stack[2], stack[3]))
+}
+
+// put writes a JSON encoded value to a field.
+//
+//	put(key_ptr, key_len, value_ptr, value_len) -> 0
+func put(ctx context.Context, m api.Module, stack []uint64) {
+	c, key, ok := eventCall(ctx, m, stack[0], stack[1])
+	raw, found := m.Memory().Read(api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+	if !ok || !found || key == "" {
+		stack[0] = status(statusError)
+		return
+	}
+
+	v, err := decodeValue(raw)
+	if err != nil {
+		stack[0] = status(statusError)
+		return
+	}
+	if _, err = c.event.PutValue(key, v); err != nil {
+		stack[0] = status(statusError)
+		return
+	}
+	stack[0] = 0
+}
+
+// del deletes a field.
+//
+//	delete(key_ptr, key_len) -> 0
+func del(ctx context.Context, m api.Module, stack []uint64) {
+	c, key, ok := eventCall(ctx, m, stack[0], stack[1])
+	if !ok {
+		stack[0] = status(statusError)
+		return
+	}
+	if err := c.event.Delete(key); err != nil {
+		stack[0] = status(statusNotFound)
+		return
+	}
+	stack[0] = 0
+}
+
+// tag adds a tag to the event if it does not already have it.
+//
+//	tag(tag_ptr, tag_len) -> 0
+func tag(ctx context.Context, m api.Module, stack []uint64) {
+	c, t, ok := eventCall(ctx, m, stack[0], stack[1])
+	if !ok || t == "" {
+		stack[0] = status(statusError)
+		return
+	}
+	if err := mapstr.AddTags(c.event.Fields, []string{t}); err != nil {
+		stack[0] = status(statusError)
+		return
+	}
+	stack[0] = 0
+}
+
+// cancel marks the event as cancelled, it is dropped when process returns.
+//
+//	cancel()
+func cancel(ctx context.Context, _ api.Module, _ []uint64) {
+	if c := getCall(ctx); c != nil {
+		c.cancelled = true
+	}
+}
+
+// params writes the JSON encoded params of the processor configuration to a
+// buffer, like get. It can also be called when the module is initialized.
+//
+//	params(buf_ptr, buf_len) -> len
+func params(ctx context.Context, m api.Module, stack []uint64) {
+	c := getCall(ctx)
+	if c == nil {
+		stack[0] = status(statusError)
+		return
+	}
+	stack[0] = status(writeBuffer(m, c.params, stack[0], stack[1]))
+}
+
+// eventCall returns the call processing an event and the string at ptr.
+func eventCall(ctx context.Context, m api.Module, ptr, length uint64) (*call, string, bool) {
+	c := getCall(ctx)
+	if c == nil || c.event == nil {
+		return nil, "", false
+	}
+	b, ok := m.Memory().Read(api.DecodeU32(ptr), api.DecodeU32(length))
+	if !ok {
+		return nil, "", false
+	}
+	return c, string(b), true
+}
+
+// writeBuffer copies b to the buffer of the module if it is large enough, and
+// returns the length of b.
+func writeBuffer(m api.Module, b []byte, ptr, length uint64) int32 {
+	if len(b) > int(^uint32(0)>>1) {
+		return statusError
+	}
+	if uint64(len(b)) <= uint64(api.DecodeU32(length)) {
+		if !m.Memory().Write(api.DecodeU32(ptr), b) {
+			return statusError
+		}
+	}
+	return int32(len(b))
+}
+
+// decodeValue decodes a JSON value, converting numbers to int64 or float64
+// and objects to mapstr.M.
+func decodeValue(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+
+	wrapper := mapstr.M{"v": v}
+	jsontransform.TransformNumbers(wrapper)
+	return toMapStr(wrapper["v"]), nil
+}
+
+// toMapStr converts the objects of a decoded JSON value to mapstr.M.
+func toMapStr(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(mapstr.M, len(v))
+		for k, e := range v {
+			m[k] = toMapStr(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = toMapStr(e)
+		}
+	}
+	return v
+}
+
+func status(s int32) uint64 {
+	return api.EncodeI32(s)
+}
@@ -0,0 +1,252 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	logName = "processor.wasm"
+
+	entryPointFunction = "process"
+	initializeFunction = "_initialize"
+)
+
+var (
+	errTimeout       = errors.New("wasm processor execution timeout")
+	errFuelExhausted = errors.New("wasm processor ran out of fuel")
+)
+
+// session is an instance of the WebAssembly module. An instance is used by
+// one event at a time.
+type session struct {
+	mod            api.Module
+	processFunc    api.Function
+	params         []byte
+	log            *logp.Logger
+	timeout        time.Duration
+	fuel           uint64
+	tagOnException string
+
+	// broken is set when the instance may be in an inconsistent state, or
+	// has been closed, after a failed call.
+	broken bool
+}
+
+func newSession(r wazero.Runtime, m wazero.CompiledModule, params []byte, conf Config) (*session, error) {
+	logger := logp.NewLogger(logName)
+	if conf.Tag != "" {
+		logger = logger.With("instance_id", conf.Tag)
+	}
+
+	// Instances have no access to the file system, arguments or environment.
+	modConf := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions(initializeFunction).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+
+	// Reactor modules can read the params from their _initialize function.
+	ctx := withCall(context.Background(), &call{params: params})
+	mod, err := r.InstantiateModule(ctx, m, modConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate wasm module: %w", err)
+	}
+
+	return &session{
+		mod:            mod,
+		processFunc:    mod.ExportedFunction(entryPointFunction),
+		params:         params,
+		log:            logger,
+		timeout:        conf.Timeout,
+		fuel:           conf.Fuel,
+		tagOnException: conf.TagOnException,
+	}, nil
+}
+
+// runProcessFunc executes process() from the WebAssembly module.
+func (s *session) runProcessFunc(b *beat.Event) (*beat.Event, error) {
+	c := &call{event: b, params: s.params, fuel: s.fuel}
+
+	// The runtime closes the instance when the context is done, stopping
+	// modules running over the timeout or out of fuel.
+	ctx := context.Background()
+	switch {
+	case s.timeout > 0:
+		ctx, c.stop = context.WithTimeout(ctx, s.timeout)
+	case s.fuel > 0:
+		ctx, c.stop = context.WithCancel(ctx)
+	}
+	if c.stop != nil {
+		defer c.stop()
+	}
+
+	results, err := s.processFunc.Call(withCall(ctx, c))
+	if err != nil {
+		s.broken = true
+		switch {
+		case c.exhausted:
+			err = errFuelExhausted
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			err = errTimeout
+		}
+		return b, s.fail(b, fmt.Errorf("failed in process function: %w", err))
+	}
+	if rc := api.DecodeI32(results[0]); rc != 0 {
+		return b, s.fail(b, fmt.Errorf("process function returned error code %d", rc))
+	}
+
+	if c.cancelled {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// fail tags the event with the exception and returns err.
+func (s *session) fail(b *beat.Event, err error) error {
+	if s.tagOnException != "" {
+		_ = mapstr.AddTags(b.Fields, []string{s.tagOnException})
+	}
+	_, _ = b.PutValue("error.message", err.Error())
+	return err
+}
+
+func (s *session) close() {
+	_ = s.mod.Close(context.Background())
+}
+
+// fuelListener consumes one unit of fuel per function call of the module,
+// and stops the call when it runs out of fuel.
+var fuelListener = experimental.FunctionListenerFactoryFunc(func(api.FunctionDefinition) experimental.FunctionListener {
+	return experimental.FunctionListenerFunc(consumeFuel)
+})
+
+func consumeFuel(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	c := getCall(ctx)
+	if c == nil || c.event == nil || c.exhausted {
+		return
+	}
+	if c.fuel == 0 {
+		c.exhausted = true
+		c.stop()
+		return
+	}
+	c.fuel--
+}
+
+type sessionPool struct {
+	log                *logp.Logger
+	New                func() (*session, error)
+	C                  chan *session
+	NewSessionsAllowed bool
+}
+
+func newSessionPool(r wazero.Runtime, m wazero.CompiledModule, params []byte, c Config) (*sessionPool, error) {
+	s, err := newSession(r, m, params, c)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := sessionPool{
+		log: s.log,
+		New: func() (*session, error) {
+			return newSession(r, m, params, c)
+		},
+		C:                  make(chan *session, c.MaxCachedSessions),
+		NewSessionsAllowed: !c.OnlyCachedSessions,
+	}
+	pool.Put(s)
+
+	// If we are not allowed to create new sessions, pre-cache requested sessions
+	if !pool.NewSessionsAllowed {
+		for i := 0; i < c.MaxCachedSessions-1; i++ {
+			s, err := pool.New()
+			if err != nil {
+				pool.close()
+				return nil, err
+			}
+			pool.Put(s)
+		}
+	}
+
+	return &pool, nil
+}
+
+func (p *sessionPool) Get() (*session, error) {
+	if !p.NewSessionsAllowed {
+		return <-p.C, nil
+	}
+
+	// Try to get a session from the pool, if none is available, create a new one
+	select {
+	case s := <-p.C:
+		return s, nil
+	default:
+		return p.New()
+	}
+}
+
+// Put returns a session to the pool. Broken sessions are replaced by new ones
+// if new sessions cannot be created on demand.
+func (p *sessionPool) Put(s *session) {
+	if s == nil {
+		return
+	}
+	if s.broken {
+		s.close()
+		if p.NewSessionsAllowed {
+			return
+		}
+		var err error
+		if s, err = p.New(); err != nil {
+			p.log.Errorw("Failed to replace wasm module instance", "error", err)
+			return
+		}
+	}
+	select {
+	case p.C <- s:
+	default:
+		s.close()
+	}
+}
+
+// close closes the cached sessions.
+func (p *sessionPool) close() {
+	for {
+		select {
+		case s := <-p.C:
+			s.close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/monitoring/adapter"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+type wasmProcessor struct {
+	Config
+	runtime     wazero.Runtime
+	sessionPool *sessionPool
+	stats       *processorStats
+}
+
+// New constructs a new WebAssembly processor.
+func New(c *config.C) (beat.Processor, error) {
+	conf := defaultConfig()
+	if err := c.Unpack(&conf); err != nil {
+		return nil, err
+	}
+
+	return NewFromConfig(conf, monitoring.Default)
+}
+
+// NewFromConfig constructs a new WebAssembly processor from the given config
+// object. It loads and compiles the module, and validates the entry point.
+func NewFromConfig(c Config, reg *monitoring.Registry) (beat.Processor, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	path := paths.Resolve(paths.Config, c.File)
+	binary, err := loadModule(path)
+	if err != nil {
+		return nil, annotateError(c.Tag, err)
+	}
+
+	params, err := json.Marshal(c.Params)
+	if err != nil {
+		return nil, annotateError(c.Tag, fmt.Errorf("failed to encode params: %w", err))
+	}
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(c.memoryLimitPages())).
+		WithCloseOnContextDone(c.Timeout > 0 || c.Fuel > 0))
+
+	pool, err := func() (*sessionPool, error) {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+			return nil, err
+		}
+		if err := instantiateHostModule(ctx, r); err != nil {
+			return nil, err
+		}
+
+		compileCtx := ctx
+		if c.Fuel > 0 {
+			compileCtx = experimental.WithFunctionListenerFactory(ctx, fuelListener)
+		}
+		m, err := r.CompileModule(compileCtx, binary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile wasm module %v: %w", path, err)
+		}
+		if err = validateEntryPoint(m); err != nil {
+			return nil, err
+		}
+		return newSessionPool(r, m, params, c)
+	}()
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, annotateError(c.Tag, err)
+	}
+
+	return &wasmProcessor{
+		Config:      c,
+		runtime:     r,
+		sessionPool: pool,
+		stats:       getStats(c.Tag, reg),
+	}, nil
+}
+
+// loadModule reads the WebAssembly module binary.
+func loadModule(path string) ([]byte, error) {
+	if common.IsStrictPerms() {
+		if err := common.OwnerHasExclusiveWritePerms(path); err != nil {
+			return nil, err
+		}
+	}
+
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %v: %w", path, err)
+	}
+	return binary, nil
+}
+
+// validateEntryPoint checks that the module exports a process function without
+// parameters returning an i32.
+func validateEntryPoint(m wazero.CompiledModule) error {
+	def, found := m.ExportedFunctions()[entryPointFunction]
+	if !found {
+		return fmt.Errorf("%s function not found", entryPointFunction)
+	}
+	if results := def.ResultTypes(); len(def.ParamTypes()) != 0 || len(results) != 1 || results[0] != api.ValueTypeI32 {
+		return fmt.Errorf("%s function must have the signature () -> i32", entryPointFunction)
+	}
+	return nil
+}
+
+func annotateError(id string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if id != "" {
+		return fmt.Errorf("failed in processor.wasm with id=%v: %w", id, err)
+	}
+	return fmt.Errorf("failed in processor.wasm: %w", err)
+}
+
+// Run executes the processor on the given it event. It invokes the
+// process function exported by the WebAssembly module.
+func (p *wasmProcessor) Run(event *beat.Event) (*beat.Event, error) {
+	s, err := p.sessionPool.Get()
+	if err != nil {
+		return event, annotateError(p.Tag, err)
+	}
+	defer p.sessionPool.Put(s)
+
+	var rtn *beat.Event
+	if p.stats == nil {
+		rtn, err = s.runProcessFunc(event)
+	} else {
+		rtn, err = p.runWithStats(s, event)
+	}
+	return rtn, annotateError(p.Tag, err)
+}
+
+func (p *wasmProcessor) runWithStats(s *session, event *beat.Event) (*beat.Event, error) {
+	start := time.Now()
+	event, err := s.runProcessFunc(event)
+	elapsed := time.Since(start)
+
+	p.stats.processTime.Update(int64(elapsed))
+	if err != nil {
+		p.stats.exceptions.Inc()
+	}
+	return event, err
+}
+
+// Close releases the module instances and the runtime.
+func (p *wasmProcessor) Close() error {
+	p.sessionPool.close()
+	return p.runtime.Close(context.Background())
+}
+
+func (p *wasmProcessor) String() string {
+	return "script=[type=wasm, id=" + p.Tag + ", file=" + p.File + "]"
+}
+
+type processorStats struct {
+	exceptions  *monitoring.Int
+	processTime metrics.Sample
+}
+
+func getStats(id string, reg *monitoring.Registry) *processorStats {
+	if id == "" || reg == nil {
+		return nil
+	}
+
+	namespace := logName + "." + id
+	processorReg := reg.GetRegistry(namespace)
+	if processorReg != nil {
+		// If a module is reloaded then the namespace could already exist.
+		_ = processorReg.Clear()
+	} else {
+		processorReg = reg.NewRegistry(namespace, monitoring.DoNotReport)
+	}
+
+	stats := &processorStats{
+		exceptions:  monitoring.NewInt(processorReg, "exceptions"),
+		processTime: metrics.NewUniformSample(2048),
+	}
+	_ = adapter.NewGoMetrics(processorReg, "histogram", adapter.Accept).
+		Register("process_time", metrics.NewHistogram(stats.processTime))
+
+	return stats
+}
@@ -0,0 +1,356 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package wasm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// Index of the functions of the host module imported by the test modules.
+const (
+	fnGet = iota
+	fnPut
+	fnDelete
+	fnTag
+	fnCancel
+	fnParams
+	numImports
+)
+
+// fnProcess is the index of the process function, the first function defined
+// by the test modules.
+const fnProcess = numImports
+
+var hostFunctions = []struct {
+	name      string
+	signature []byte
+}{
+	{"get", []byte{4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f}},
+	{"put", []byte{4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f}},
+	{"delete", []byte{2, 0x7f, 0x7f, 1, 0x7f}},
+	{"tag", []byte{2, 0x7f, 0x7f, 1, 0x7f}},
+	{"cancel", []byte{0, 0}},
+	{"params", []byte{2, 0x7f, 0x7f, 1, 0x7f}},
+}
+
+// testModule describes a WebAssembly module importing all the host functions.
+// Its first function is exported as process.
+type testModule struct {
+	funcs []testFunc
+	pages uint32            // Initial memory size.
+	data  map[uint32]string // Memory content by offset.
+}
+
+type testFunc struct {
+	signature []byte // Encoded parameter and result types.
+	locals    uint32 // Number of i32 locals.
+	code      []byte
+}
+
+// processFunc returns a process function, the code must leave an i32 on the
+// stack.
+func processFunc(locals uint32, code ...[]byte) testFunc {
+	return testFunc{signature: []byte{0, 1, 0x7f}, locals: locals, code: bytes.Join(code, nil)}
+}
+
+func (m testModule) encode() []byte {
+	vec := func(items ...[]byte) []byte {
+		return append(uleb(uint32(len(items))), bytes.Join(items, nil)...)
+	}
+	name := func(s string) []byte {
+		return append(uleb(uint32(len(s))), s...)
+	}
+	section := func(id byte, items ...[]byte) []byte {
+		payload := vec(items...)
+		return append(append([]byte{id}, uleb(uint32(len(payload)))...), payload...)
+	}
+
+	var types, imports, funcs, code, data [][]byte
+	for i, f := range hostFunctions {
+		types = append(types, append([]byte{0x60}, f.signature...))
+		imports = append(imports, bytes.Join([][]byte{name(hostModuleName), name(f.name), {0x00}, uleb(uint32(i))}, nil))
+	}
+	for _, f := range m.funcs {
+		funcs = append(funcs, uleb(uint32(len(types))))
+		types = append(types, append([]byte{0x60}, f.signature...))
+
+		body := []byte{0}
+		if f.locals > 0 {
+			body = append(append([]byte{1}, uleb(f.locals)...), 0x7f)
+		}
+		body = append(append(body, f.code...), 0x0b)
+		code = append(code, append(uleb(uint32(len(body))), body...))
+	}
+	for offset, s := range m.data {
+		data = append(data, bytes.Join([][]byte{{0x00}, i32(int32(offset)), {0x0b}, name(s)}, nil))
+	}
+
+	pages := m.pages
+	if pages == 0 {
+		pages = 1
+	}
+	return bytes.Join([][]byte{
+		[]byte("\x00asm\x01\x00\x00\x00"),
+		section(1, types...),
+		section(2, imports...),
+		section(3, funcs...),
+		section(5, append([]byte{0x00}, uleb(pages)...)),
+		section(7,
+			append(name("memory"), 0x02, 0),
+			append(name(entryPointFunction), append([]byte{0x00}, uleb(fnProcess)...)...)),
+		section(10, code...),
+		section(11, data...),
+	}, nil)
+}
+
+func uleb(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+// Instructions used by the test modules.
+
+func i32(v int32) []byte {
+	b := []byte{0x41}
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func callFn(fn uint32) []byte    { return append([]byte{0x10}, uleb(fn)...) }
+func localGet(i uint32) []byte   { return append([]byte{0x20}, uleb(i)...) }
+func localTee(i uint32) []byte   { return append([]byte{0x22}, uleb(i)...) }
+func str(offset, n int32) []byte { return append(i32(offset), i32(n)...) }
+
+var (
+	drop        = []byte{0x1a}
+	i32Ne       = []byte{0x47}
+	i32LtS      = []byte{0x48}
+	memoryGrow  = []byte{0x40, 0x00}
+	returnIf    = []byte{0x04, 0x40, 0x41, 0x01, 0x0f, 0x0b} // if (return 1) end
+	loopForever = []byte{0x03, 0x40}                         // loop, closed by brLoop
+	brLoop      = []byte{0x0c, 0x00, 0x0b}                   // br 0 end
+)
+
+func newTestProcessor(t *testing.T, m testModule, settings mapstr.M) beat.Processor {
+	t.Helper()
+	p, err := newTestProcessorErr(t, m, settings)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.(*wasmProcessor).Close() })
+	return p
+}
+
+func newTestProcessorErr(t *testing.T, m testModule, settings mapstr.M) (beat.Processor, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "processor.wasm")
+	require.NoError(t, os.WriteFile(path, m.encode(), 0o600))
+
+	c := mapstr.M{"file": path}
+	c.DeepUpdate(settings)
+	return New(config.MustNewConfigFrom(c))
+}
+
+func TestPutAndTag(t *testing.T) {
+	p := newTestProcessor(t, testModule{
+		data: map[uint32]string{0: "wasm.ok", 16: `{"n":1,"f":1.5,"s":"x"}`, 64: "done"},
+		funcs: []testFunc{processFunc(0,
+			str(0, 7), str(16, 23), callFn(fnPut), drop,
+			str(64, 4), callFn(fnTag), drop,
+			i32(0),
+		)},
+	}, nil)
+
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{
+		"message": "hello",
+		"wasm":    mapstr.M{"ok": mapstr.M{"n": int64(1), "f": 1.5, "s": "x"}},
+		"tags":    []string{"done"},
+	}, evt.Fields)
+}
+
+func TestGet(t *testing.T) {
+	// copy = get(message), with an empty key copying the whole event.
+	copyField := func(key string) testModule {
+		return testModule{
+			data: map[uint32]string{0: key, 32: "copy"},
+			funcs: []testFunc{processFunc(1,
+				str(0, int32(len(key))), str(256, 1024), callFn(fnGet), localTee(0), i32(0), i32LtS, returnIf,
+				str(32, 4), i32(256), localGet(0), callFn(fnPut),
+			)},
+		}
+	}
+
+	p := newTestProcessor(t, copyField("source.ip"), nil)
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"source": mapstr.M{"ip": "10.0.0.1"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", evt.Fields["copy"])
+
+	// Missing fields return statusNotFound.
+	evt, err = p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.ErrorContains(t, err, "process function returned error code 1")
+	assert.Equal(t, []string{"_wasm_exception"}, evt.Fields["tags"])
+
+	p = newTestProcessor(t, copyField(""), nil)
+	evt, err = p.Run(&beat.Event{Fields: mapstr.M{"a": mapstr.M{"b": 1}}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{"a": mapstr.M{"b": int64(1)}}, evt.Fields["copy"])
+}
+
+func TestGetBufferTooSmall(t *testing.T) {
+	// The length of the value is returned as the result of process.
+	p := newTestProcessor(t, testModule{
+		data:  map[uint32]string{0: "message", 256: "unchanged"},
+		funcs: []testFunc{processFunc(0, str(0, 7), str(256, 2), callFn(fnGet))},
+	}, nil)
+
+	_, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "hello"}})
+	assert.ErrorContains(t, err, "error code 7")
+}
+
+func TestDeleteAndCancel(t *testing.T) {
+	p := newTestProcessor(t, testModule{
+		data:  map[uint32]string{0: "message"},
+		funcs: []testFunc{processFunc(0, str(0, 7), callFn(fnDelete))},
+	}, nil)
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{"message": "hello", "n": 1}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{"n": 1}, evt.Fields)
+
+	p = newTestProcessor(t, testModule{
+		funcs: []testFunc{processFunc(0, callFn(fnCancel), i32(0))},
+	}, nil)
+	evt, err = p.Run(&beat.Event{Fields: mapstr.M{"message": "hello"}})
+	require.NoError(t, err)
+	assert.Nil(t, evt)
+}
+
+func TestParams(t *testing.T) {
+	p := newTestProcessor(t, testModule{
+		data: map[uint32]string{0: "params"},
+		funcs: []testFunc{processFunc(1,
+			str(0, 6),
+			i32(256), str(256, 1024), callFn(fnParams), localTee(0), drop,
+			localGet(0), callFn(fnPut),
+		)},
+	}, mapstr.M{"params": mapstr.M{"threshold": 15}})
+
+	evt, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+	require.NoError(t, err)
+	assert.Equal(t, mapstr.M{"threshold": int64(15)}, evt.Fields["params"])
+}
+
+func TestTimeout(t *testing.T) {
+	p := newTestProcessor(t, testModule{
+		funcs: []testFunc{processFunc(0, loopForever, brLoop, i32(0))},
+	}, mapstr.M{"timeout": "50ms", "tag": "loop"})
+
+	// A new instance replaces the one stopped by the timeout.
+	for i := 0; i < 2; i++ {
+		evt, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+		assert.ErrorIs(t, err, errTimeout)
+		assert.NotNil(t, evt)
+	}
+	assert.EqualValues(t, 2, p.(*wasmProcessor).stats.exceptions.Get())
+}
+
+func TestFuel(t *testing.T) {
+	m := testModule{
+		funcs: []testFunc{
+			processFunc(0, loopForever, callFn(fnProcess+1), brLoop, i32(0)),
+			{signature: []byte{0, 0}},
+		},
+	}
+	p := newTestProcessor(t, m, mapstr.M{"fuel": 1000, "only_cached_sessions": true, "max_cached_sessions": 1})
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+		assert.ErrorIs(t, err, errFuelExhausted)
+	}
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	// The loop makes no function calls, so it never runs out of fuel.
+	p := newTestProcessor(t, testModule{
+		funcs: []testFunc{processFunc(0, loopForever, brLoop, i32(0))},
+	}, mapstr.M{"fuel": 1000})
+
+	start := time.Now()
+	_, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.ErrorIs(t, err, errTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// process returns 1 if the memory can grow by 1000 pages.
+	m := testModule{
+		funcs: []testFunc{processFunc(0, i32(1000), memoryGrow, i32(-1), i32Ne)},
+	}
+
+	p := newTestProcessor(t, m, nil)
+	_, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.ErrorContains(t, err, "error code 1")
+
+	p = newTestProcessor(t, m, mapstr.M{"max_memory": "1MiB"})
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.NoError(t, err)
+
+	// The initial memory must fit in the limit.
+	m.pages = 32
+	_, err = newTestProcessorErr(t, m, mapstr.M{"max_memory": "1MiB"})
+	assert.Error(t, err)
+}
+
+func TestInvalidModule(t *testing.T) {
+	_, err := newTestProcessorErr(t, testModule{
+		funcs: []testFunc{{signature: []byte{0, 0}}},
+	}, nil)
+	assert.ErrorContains(t, err, "process function must have the signature () -> i32")
+
+	_, err = New(config.MustNewConfigFrom(mapstr.M{"file": filepath.Join(t.TempDir(), "missing.wasm")}))
+	assert.Error(t, err)
+
+	_, err = newTestProcessorErr(t, testModule{funcs: []testFunc{processFunc(0, i32(0))}}, mapstr.M{"max_memory": "1KiB"})
+	assert.ErrorContains(t, err, "max_memory")
+}
@@ -0,0 +1,81 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package user_agent
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// resultCache is a least recently used cache of parsed user agents.
+type resultCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List // Front is the most recently used entry.
+}
+
+type cacheEntry struct {
+	key    string
+	fields mapstr.M
+}
+
+// newResultCache returns a cache holding up to maxSize results. A zero size
+// disables caching.
+func newResultCache(maxSize int) *resultCache {
+	return &resultCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *resultCache) get(key string) (mapstr.M, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).fields, true
+}
+
+func (c *resultCache) set(key string, fields mapstr.M) {
+	if c.maxSize == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, found := c.entries[key]; found {
+		e.Value.(*cacheEntry).fields = fields
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.maxSize {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, fields: fields})
+}
@@ -0,0 +1,208 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package user_agent
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// bundledRegexes is the default parsing database. It currently holds a
+// subset of rules in the uap-core regexes.yaml format covering common
+// browsers, operating systems, devices and bots. The go:generate directive
+// below replaces it with the full regexes.yaml of uap-core.
+//
+//go:generate go run ../../../dev-tools/cmd/uap_regexes/uap_regexes.go -version v0.18.0
+//go:embed regexes.yaml
+var bundledRegexes []byte
+
+// unknown is the name reported when no rule matches.
+const unknown = "Other"
+
+// regexes is the structure of a uap-core regexes.yaml file.
+type regexes struct {
+	UserAgentParsers []struct {
+		Regex             string `yaml:"regex"`
+		RegexFlag         string `yaml:"regex_flag"`
+		FamilyReplacement string `yaml:"family_replacement"`
+		V1Replacement     string `yaml:"v1_replacement"`
+		V2Replacement     string `yaml:"v2_replacement"`
+		V3Replacement     string `yaml:"v3_replacement"`
+	} `yaml:"user_agent_parsers"`
+	OSParsers []struct {
+		Regex           string `yaml:"regex"`
+		RegexFlag       string `yaml:"regex_flag"`
+		OSReplacement   string `yaml:"os_replacement"`
+		OSV1Replacement string `yaml:"os_v1_replacement"`
+		OSV2Replacement string `yaml:"os_v2_replacement"`
+		OSV3Replacement string `yaml:"os_v3_replacement"`
+		OSV4Replacement string `yaml:"os_v4_replacement"`
+	} `yaml:"os_parsers"`
+	DeviceParsers []struct {
+		Regex             string `yaml:"regex"`
+		RegexFlag         string `yaml:"regex_flag"`
+		DeviceReplacement string `yaml:"device_replacement"`
+	} `yaml:"device_parsers"`
+}
+
+// rule extracts a list of values from a user agent string. Each value is
+// taken from its replacement, with $1 to $9 referencing capture groups, or
+// from the capture group at the same position when there is no replacement.
+type rule struct {
+	re           *regexp.Regexp
+	replacements []string
+}
+
+func (r rule) match(s string) ([]string, bool) {
+	m := r.re.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	values := make([]string, len(r.replacements))
+	for i, repl := range r.replacements {
+		switch {
+		case repl != "":
+			values[i] = strings.TrimSpace(expand(repl, m))
+		case i+1 < len(m):
+			values[i] = m[i+1]
+		}
+	}
+	return values, true
+}
+
+// expand replaces the $1 to $9 references in repl with the capture groups of
+// m. References to groups that did not participate are removed.
+func expand(repl string, m []string) string {
+	if !strings.Contains(repl, "$") {
+		return repl
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		if repl[i] == '$' && i+1 < len(repl) && repl[i+1] >= '1' && repl[i+1] <= '9' {
+			if n := int(repl[i+1] - '0'); n < len(m) {
+				b.WriteString(m[n])
+			}
+			i++
+			continue
+		}
+		b.WriteByte(repl[i])
+	}
+	return b.String()
+}
+
+// parser parses user agent strings with the rules of a uap-core database.
+type parser struct {
+	userAgents []rule
+	oses       []rule
+	devices    []rule
+
+	// skipped counts the rules that could not be compiled.
+	skipped int
+}
+
+// newParser loads the rules of a uap-core database. Rules whose regular
+// expression is not supported by Go are skipped.
+func newParser(data []byte) (*parser, error) {
+	var r regexes
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse user agent regexes: %w", err)
+	}
+
+	p := &parser{}
+	add := func(rules *[]rule, regex, flag string, replacements ...string) {
+		if flag == "i" {
+			regex = "(?i)" + regex
+		}
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			p.skipped++
+			return
+		}
+		*rules = append(*rules, rule{re: re, replacements: replacements})
+	}
+	for _, u := range r.UserAgentParsers {
+		add(&p.userAgents, u.Regex, u.RegexFlag, u.FamilyReplacement, u.V1Replacement, u.V2Replacement, u.V3Replacement)
+	}
+	for _, o := range r.OSParsers {
+		add(&p.oses, o.Regex, o.RegexFlag, o.OSReplacement, o.OSV1Replacement, o.OSV2Replacement, o.OSV3Replacement, o.OSV4Replacement)
+	}
+	for _, d := range r.DeviceParsers {
+		add(&p.devices, d.Regex, d.RegexFlag, d.DeviceReplacement)
+	}
+
+	if len(p.userAgents)+len(p.oses)+len(p.devices) == 0 {
+		return nil, fmt.Errorf("no user agent rules found (%d rules skipped)", p.skipped)
+	}
+	return p, nil
+}
+
+// parse returns the ECS user_agent fields for s, except original.
+func (p *parser) parse(s string) mapstr.M {
+	fields := mapstr.M{"name": unknown}
+	if name, version := firstMatch(p.userAgents, s); name != "" {
+		fields["name"] = name
+		if version != "" {
+			fields["version"] = version
+		}
+	}
+
+	if name, version := firstMatch(p.oses, s); name != "" {
+		os := mapstr.M{"name": name, "full": name}
+		if version != "" {
+			os["version"] = version
+			os["full"] = name + " " + version
+		}
+		fields["os"] = os
+	}
+
+	device := unknown
+	if name, _ := firstMatch(p.devices, s); name != "" {
+		device = name
+	}
+	fields["device"] = mapstr.M{"name": device}
+
+	return fields
+}
+
+// firstMatch returns the name and the dotted version extracted by the first
+// matching rule.
+func firstMatch(rules []rule, s string) (name, version string) {
+	for _, r := range rules {
+		values, ok := r.match(s)
+		if !ok {
+			continue
+		}
+		var parts []string
+		for _, v := range values[1:] {
+			if v == "" {
+				break
+			}
+			parts = append(parts, v)
+		}
+		return values[0], strings.Join(parts, ".")
+	}
+	return "", ""
+}
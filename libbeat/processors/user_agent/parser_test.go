@@ -0,0 +1,179 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package user_agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestBundledRegexes(t *testing.T) {
+	p, err := newParser(bundledRegexes)
+	require.NoError(t, err)
+	assert.Zero(t, p.skipped)
+
+	tests := []struct {
+		ua   string
+		want mapstr.M
+	}{
+		{
+			ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36",
+			want: mapstr.M{
+				"name":    "Chrome",
+				"version": "120.0.6099",
+				"os":      mapstr.M{"name": "Windows", "version": "10", "full": "Windows 10"},
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			want: mapstr.M{
+				"name":    "Edge",
+				"version": "120.0.2210",
+				"os":      mapstr.M{"name": "Windows", "version": "10", "full": "Windows 10"},
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			want: mapstr.M{
+				"name":    "Safari",
+				"version": "17.1",
+				"os":      mapstr.M{"name": "Mac OS X", "version": "10.15.7", "full": "Mac OS X 10.15.7"},
+				"device":  mapstr.M{"name": "Mac"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want: mapstr.M{
+				"name":    "Firefox",
+				"version": "121.0",
+				"os":      mapstr.M{"name": "Ubuntu", "full": "Ubuntu"},
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1",
+			want: mapstr.M{
+				"name":    "Mobile Safari",
+				"version": "17.1.2",
+				"os":      mapstr.M{"name": "iOS", "version": "17.1.2", "full": "iOS 17.1.2"},
+				"device":  mapstr.M{"name": "iPhone"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			want: mapstr.M{
+				"name":    "Chrome Mobile",
+				"version": "120.0.6099",
+				"os":      mapstr.M{"name": "Android", "version": "13", "full": "Android 13"},
+				"device":  mapstr.M{"name": "Samsung SM-S918B"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (Linux; Android 14; Pixel 8 Pro Build/UD1A.230803.041; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/119.0.6045.193 Mobile Safari/537.36",
+			want: mapstr.M{
+				"name":    "Chrome Mobile WebView",
+				"version": "119.0.6045",
+				"os":      mapstr.M{"name": "Android", "version": "14", "full": "Android 14"},
+				"device":  mapstr.M{"name": "Pixel 8 Pro"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			want: mapstr.M{
+				"name":    "IE",
+				"version": "11.0",
+				"os":      mapstr.M{"name": "Windows", "version": "7", "full": "Windows 7"},
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: mapstr.M{
+				"name":    "Googlebot",
+				"version": "2.1",
+				"device":  mapstr.M{"name": "Spider"},
+			},
+		},
+		{
+			ua: "curl/8.4.0",
+			want: mapstr.M{
+				"name":    "curl",
+				"version": "8.4.0",
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "Elastic-Heartbeat/9.1.0 (linux; amd64; 1234abcd; 2025-01-01 00:00:00 +0000 UTC)",
+			want: mapstr.M{
+				"name":    "Elastic-Heartbeat",
+				"version": "9.1.0",
+				"device":  mapstr.M{"name": "Other"},
+			},
+		},
+		{
+			ua: "something unknown",
+			want: mapstr.M{
+				"name":   "Other",
+				"device": mapstr.M{"name": "Other"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ua, func(t *testing.T) {
+			assert.Equal(t, test.want, p.parse(test.ua))
+		})
+	}
+}
+
+func TestCustomRegexes(t *testing.T) {
+	p, err := newParser([]byte(`
+user_agent_parsers:
+  - regex: '(?<=x)unsupported'
+  - regex: '(MyApp)/(\d+)\.(\d+)'
+    family_replacement: 'My App'
+    v2_replacement: '$3-beta'
+os_parsers:
+  - regex: '(AmigaOS) (\d+)'
+    os_v1_replacement: '$2.1'
+device_parsers:
+  - regex: 'device=(\w+)'
+    regex_flag: 'i'
+    device_replacement: 'Box $1$9'
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.skipped)
+
+	assert.Equal(t, mapstr.M{
+		"name":    "My App",
+		"version": "2.3-beta",
+		"os":      mapstr.M{"name": "AmigaOS", "version": "4.1", "full": "AmigaOS 4.1"},
+		"device":  mapstr.M{"name": "Box a1"},
+	}, p.parse("MyApp/2.3 (AmigaOS 4; DEVICE=a1)"))
+
+	_, err = newParser([]byte("user_agent_parsers: [{regex: '(?!x)'}]"))
+	assert.Error(t, err)
+	_, err = newParser([]byte("{"))
+	assert.Error(t, err)
+}
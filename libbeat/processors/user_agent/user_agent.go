@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package user_agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+const processorName = "user_agent"
+
+func init() {
+	processors.RegisterPlugin(processorName, New)
+	jsprocessor.RegisterPlugin("UserAgent", New)
+}
+
+type processor struct {
+	config
+	parser *parser
+	cache  *resultCache
+}
+
+// New constructs a new user_agent processor.
+func New(cfg *conf.C) (beat.Processor, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, fmt.Errorf("fail to unpack the %v configuration: %w", processorName, err)
+	}
+
+	data := bundledRegexes
+	if c.RegexesFile != "" {
+		c.RegexesFile = paths.Resolve(paths.Config, c.RegexesFile)
+		var err error
+		if data, err = os.ReadFile(c.RegexesFile); err != nil {
+			return nil, fmt.Errorf("failed to read user agent regexes file: %w", err)
+		}
+	}
+
+	parser, err := newParser(data)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case parser.skipped > 0 && c.RegexesFile != "":
+		logp.NewLogger(processorName).Warnf("Skipped %d user agent rules of %s "+
+			"using regular expression features not supported by Go", parser.skipped, c.RegexesFile)
+	case parser.skipped > 0:
+		// The skipped rules of the bundled database are listed in its header.
+		logp.NewLogger(processorName).Debugf("Skipped %d bundled user agent rules "+
+			"using regular expression features not supported by Go", parser.skipped)
+	}
+
+	return &processor{
+		config: c,
+		parser: parser,
+		cache:  newResultCache(c.CacheSize),
+	}, nil
+}
+
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	v, err := event.GetValue(p.Field)
+	if err != nil {
+		if p.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return event, nil
+		}
+		return event, fmt.Errorf("could not fetch value for key: %s, Error: %w", p.Field, err)
+	}
+	original, ok := v.(string)
+	if !ok {
+		return event, fmt.Errorf("field %s is not a string but %T", p.Field, v)
+	}
+
+	fields, found := p.cache.get(original)
+	if !found {
+		fields = p.parser.parse(original)
+		p.cache.set(original, fields)
+	}
+
+	// Cached results are shared between events.
+	fields = fields.Clone()
+	if p.TargetField+".original" != p.Field {
+		fields["original"] = original
+	}
+
+	for k, v := range fields {
+		key := k
+		if p.TargetField != "" {
+			key = p.TargetField + "." + k
+		}
+		if _, err := event.PutValue(key, v); err != nil {
+			return event, fmt.Errorf("failed to put field %s: %w", key, err)
+		}
+	}
+	return event, nil
+}
+
+func (p *processor) String() string {
+	return fmt.Sprintf("%v=[field=%v, target_field=%v, regexes_file=%v]",
+		processorName, p.Field, p.TargetField, p.RegexesFile)
+}
@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package user_agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const testUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15"
+
+var testUserAgentFields = mapstr.M{
+	"name":    "Safari",
+	"version": "17.1",
+	"os":      mapstr.M{"name": "Mac OS X", "version": "10.15.7", "full": "Mac OS X 10.15.7"},
+	"device":  mapstr.M{"name": "Mac"},
+}
+
+func newTestProcessor(t *testing.T, c mapstr.M) *processor {
+	t.Helper()
+	p, err := New(conf.MustNewConfigFrom(c))
+	require.NoError(t, err)
+	return p.(*processor)
+}
+
+func TestProcessor(t *testing.T) {
+	withOriginal := testUserAgentFields.Clone()
+	withOriginal["original"] = testUserAgent
+
+	tests := map[string]struct {
+		config mapstr.M
+		fields mapstr.M
+		want   mapstr.M
+	}{
+		"ecs field": {
+			fields: mapstr.M{"user_agent": mapstr.M{"original": testUserAgent}},
+			want:   mapstr.M{"user_agent": withOriginal},
+		},
+		"custom field": {
+			config: mapstr.M{"field": "http.user_agent"},
+			fields: mapstr.M{"http": mapstr.M{"user_agent": testUserAgent}},
+			want: mapstr.M{
+				"http":       mapstr.M{"user_agent": testUserAgent},
+				"user_agent": withOriginal,
+			},
+		},
+		"root target": {
+			config: mapstr.M{"field": "ua", "target_field": ""},
+			fields: mapstr.M{"ua": testUserAgent},
+			want: func() mapstr.M {
+				m := withOriginal.Clone()
+				m["ua"] = testUserAgent
+				return m
+			}(),
+		},
+		"missing field ignored": {
+			config: mapstr.M{"ignore_missing": true},
+			fields: mapstr.M{"message": "hello"},
+			want:   mapstr.M{"message": "hello"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newTestProcessor(t, test.config)
+			event, err := p.Run(&beat.Event{Fields: test.fields})
+			require.NoError(t, err)
+			assert.Equal(t, test.want, event.Fields)
+		})
+	}
+}
+
+func TestProcessorErrors(t *testing.T) {
+	p := newTestProcessor(t, nil)
+
+	_, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.Error(t, err)
+
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{"user_agent": mapstr.M{"original": 1}}})
+	assert.ErrorContains(t, err, "is not a string")
+}
+
+func TestProcessorCache(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{"cache_size": 10})
+
+	run := func() mapstr.M {
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{"user_agent": mapstr.M{"original": testUserAgent}}})
+		require.NoError(t, err)
+		return event.Fields
+	}
+
+	first := run()
+	cached, found := p.cache.get(testUserAgent)
+	require.True(t, found)
+	assert.Equal(t, testUserAgentFields, cached)
+
+	// Events must not share the cached fields.
+	_, _ = first.Put("user_agent.os.name", "changed")
+	v, _ := run().GetValue("user_agent.os.name")
+	assert.Equal(t, "Mac OS X", v)
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	c := newResultCache(2)
+	c.set("a", mapstr.M{})
+	c.set("b", mapstr.M{})
+	_, found := c.get("a")
+	require.True(t, found)
+
+	// b is now the least recently used entry.
+	c.set("c", mapstr.M{})
+	_, found = c.get("b")
+	assert.False(t, found)
+	_, found = c.get("a")
+	assert.True(t, found)
+
+	disabled := newResultCache(0)
+	disabled.set("a", mapstr.M{})
+	_, found = disabled.get("a")
+	assert.False(t, found)
+}
+
+func TestRegexesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "regexes.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+user_agent_parsers:
+  - regex: '(InternalTool)/(\d+)'
+`), 0o600))
+
+	p := newTestProcessor(t, mapstr.M{"regexes_file": path})
+	event, err := p.Run(&beat.Event{Fields: mapstr.M{"user_agent": mapstr.M{"original": "InternalTool/7 " + testUserAgent}}})
+	require.NoError(t, err)
+	name, _ := event.GetValue("user_agent.name")
+	version, _ := event.GetValue("user_agent.version")
+	device, _ := event.GetValue("user_agent.device.name")
+	assert.Equal(t, "InternalTool", name)
+	assert.Equal(t, "7", version)
+	assert.Equal(t, "Other", device, "the custom file replaces the bundled rules")
+
+	_, err = New(conf.MustNewConfigFrom(mapstr.M{"regexes_file": filepath.Join(dir, "missing.yaml")}))
+	assert.Error(t, err)
+}
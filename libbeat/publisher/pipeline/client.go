@@ -40,6 +40,11 @@ type client struct {
 	eventFlags publisher.EventFlags
 	canDrop    bool
 
+	// splitter is set if the processors can split events, and splitACKer
+	// then translates the queue ACKs for the event listener.
+	splitter   beat.MultiProcessor
+	splitACKer *splitACKer
+
 	// Open state, signaling, and sync primitives for coordinating client Close.
 	isOpen atomic.Bool // set to false during shutdown, such that no new events will be accepted anymore.
 
@@ -87,6 +92,11 @@ func (c *client) publish(e beat.Event) {
 		return
 	}
 
+	if c.splitter != nil {
+		c.publishSplit(e)
+		return
+	}
+
 	if c.processors != nil {
 		var err error
 
@@ -115,18 +125,60 @@ func (c *client) publish(e beat.Event) {
 		Flags:   c.eventFlags,
 	}
 
-	var published bool
-	if c.canDrop {
-		_, published = c.producer.TryPublish(pubEvent)
+	if c.queue(pubEvent) {
+		c.onPublished()
 	} else {
-		_, published = c.producer.Publish(pubEvent)
+		c.onDroppedOnPublish(e)
 	}
+}
 
-	if published {
-		c.onPublished()
+// publishSplit runs processors that can split the event and publishes the
+// resulting events. The event listener only sees the first resulting event,
+// which is ACKed once all resulting events are ACKed.
+func (c *client) publishSplit(e beat.Event) {
+	events, err := c.splitter.RunMulti(&e)
+	if err != nil {
+		// If we introduce a dead-letter queue, this is where we should
+		// route the event to it.
+		c.logger.Errorf("Failed to publish event: %v", err)
+	}
+
+	if len(events) == 0 {
+		c.eventListener.AddEvent(e, false)
+		c.onFilteredOut()
+		return
+	}
+
+	// Every additional event goes through the client metrics like a new event.
+	for range events[1:] {
+		c.onNewEvent()
+	}
+
+	c.eventListener.AddEvent(*events[0], true)
+	if c.splitACKer != nil {
+		c.splitACKer.add(len(events))
+	}
+
+	for _, event := range events {
+		if c.queue(publisher.Event{Content: *event, Flags: c.eventFlags}) {
+			c.onPublished()
+			continue
+		}
+		if c.splitACKer != nil {
+			c.splitACKer.dropped()
+		}
+		c.onDroppedOnPublish(*event)
+	}
+}
+
+func (c *client) queue(e publisher.Event) bool {
+	var published bool
+	if c.canDrop {
+		_, published = c.producer.TryPublish(e)
 	} else {
-		c.onDroppedOnPublish(e)
+		_, published = c.producer.Publish(e)
 	}
+	return published
 }
 
 func (c *client) Close() error {
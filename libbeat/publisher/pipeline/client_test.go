@@ -191,6 +191,94 @@ func TestClient(t *testing.T) {
 	})
 }
 
+func TestClientSplitEvents(t *testing.T) {
+	l := logptest.NewTestingLogger(t, "")
+	q := memqueue.NewQueue(l, nil, memqueue.Settings{
+		Events:        10,
+		MaxGetRequest: 10,
+		FlushTimeout:  time.Millisecond,
+	}, 10, nil)
+
+	// split events into as many events as their "n" field.
+	split := &testSplitProcessor{fn: func(in *beat.Event) []*beat.Event {
+		n, _ := in.Fields["n"].(int)
+		events := make([]*beat.Event, n)
+		for i := range events {
+			events[i] = in.Clone()
+			events[i].Fields["i"] = i
+		}
+		return events
+	}}
+	pipeline := makePipeline(t, Settings{
+		Processors: testProcessorSupporter{Processor: split},
+	}, q)
+	defer pipeline.Close()
+
+	var (
+		mu    sync.Mutex
+		added []beat.Event
+		acked int
+	)
+	listener := &mockClientListener{}
+	client, err := pipeline.ConnectWith(beat.ClientConfig{
+		ClientListener: listener,
+		EventListener: &testEventListener{
+			addEvent: func(e beat.Event, published bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				if published {
+					added = append(added, e)
+				}
+			},
+			ackEvents: func(n int) {
+				mu.Lock()
+				acked += n
+				mu.Unlock()
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	client.PublishAll([]beat.Event{
+		{Fields: mapstr.M{"n": 3}},
+		{Fields: mapstr.M{"n": 0}},
+		{Fields: mapstr.M{"n": 1}},
+	})
+
+	assert.Equal(t, 5, listener.eventsTotal)
+	assert.Equal(t, 1, listener.eventsFiltered)
+	assert.Equal(t, 4, listener.eventsPublished)
+	mu.Lock()
+	require.Len(t, added, 2)
+	mu.Unlock()
+
+	var queued []beat.Event
+	for len(queued) < 4 {
+		batch, err := q.Get(10)
+		require.NoError(t, err)
+		for i := 0; i < batch.Count(); i++ {
+			//nolint:errcheck // it always succeeds
+			queued = append(queued, batch.Entry(i).(publisher.Event).Content)
+		}
+		batch.Done()
+	}
+	for i, want := range []mapstr.M{
+		{"n": 3, "i": 0},
+		{"n": 3, "i": 1},
+		{"n": 3, "i": 2},
+		{"n": 1, "i": 0},
+	} {
+		assert.Equal(t, want, queued[i].Fields)
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return acked == 2
+	}, 10*time.Second, time.Millisecond, "the listener should be ACKed once per published event")
+}
+
 func TestClientWaitClose(t *testing.T) {
 	logger := logptest.NewTestingLogger(t, "")
 	makePipeline := func(settings Settings, qu queue.Queue) *Pipeline {
@@ -444,6 +532,35 @@ func (p *testProcessor) Run(in *beat.Event) (event *beat.Event, err error) {
 	return p.processorFn(in)
 }
 
+type testSplitProcessor struct {
+	fn func(in *beat.Event) []*beat.Event
+}
+
+func (p *testSplitProcessor) String() string {
+	return "testSplitProcessor"
+}
+
+func (p *testSplitProcessor) Run(in *beat.Event) (*beat.Event, error) {
+	return in, nil
+}
+
+func (p *testSplitProcessor) RunMulti(in *beat.Event) ([]*beat.Event, error) {
+	return p.fn(in), nil
+}
+
+func (p *testSplitProcessor) SplitsEvents() bool {
+	return true
+}
+
+type testEventListener struct {
+	addEvent  func(beat.Event, bool)
+	ackEvents func(int)
+}
+
+func (l *testEventListener) AddEvent(e beat.Event, published bool) { l.addEvent(e, published) }
+func (l *testEventListener) ACKEvents(n int)                       { l.ackEvents(n) }
+func (l *testEventListener) ClientClosed()                         {}
+
 type processorList struct {
 	processors []beat.Processor
 }
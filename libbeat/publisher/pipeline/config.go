@@ -35,6 +35,35 @@ type Config struct {
 
 	// Event queue
 	Queue config.Namespace `config:"queue"`
+
+	// Named queues clients can be routed to, and the routing table selecting
+	// the queue of a client. Clients without a matching route use the
+	// default queue.
+	Queues []QueueConfig `config:"pipeline.queues"`
+	Routes []RouteConfig `config:"pipeline.routes"`
+}
+
+// QueueConfig configures a named queue with its own output.
+type QueueConfig struct {
+	Name string `config:"name" validate:"required"`
+
+	// Queue configures the type and settings of the queue, the memory queue
+	// is used if not set.
+	Queue config.Namespace `config:"queue"`
+
+	// Output configures the output of the queue. If not set a new instance
+	// of the default output is used.
+	Output config.Namespace `config:"output"`
+}
+
+// RouteConfig selects the clients publishing to a named queue. A client
+// matches a route if its input ID is one of InputIDs and if all Meta entries
+// are set in the processing metadata of the client. Empty settings match all
+// clients, but a route must have at least one condition.
+type RouteConfig struct {
+	Queue    string            `config:"queue" validate:"required"`
+	InputIDs []string          `config:"input_ids"`
+	Meta     map[string]string `config:"meta"`
 }
 
 // validateClientConfig checks a ClientConfig can be used with (*Pipeline).ConnectWith.
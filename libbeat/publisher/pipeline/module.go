@@ -101,6 +101,10 @@ func LoadWithSettings(
 	if err != nil {
 		return nil, err
 	}
+	if err := p.configureRoutes(config, makeOutput, settings); err != nil {
+		p.Close()
+		return nil, err
+	}
 
 	log.Infof("Beat name: %s", name)
 	return p, err
@@ -60,6 +60,10 @@ type Pipeline struct {
 
 	outputController *outputController
 
+	// router selects the named queue of new clients, nil if the pipeline has
+	// no routes configured.
+	router *router
+
 	observer observer
 
 	// If waitCloseTimeout is positive, then the pipeline will wait up to the
@@ -80,6 +84,10 @@ type Settings struct {
 	Processors processing.Supporter
 
 	InputQueueSize int
+
+	// OutputFactory creates the outputs of named queues with their own
+	// output configuration.
+	OutputFactory func(outputs.Observer, conf.Namespace) (outputs.Group, error)
 }
 
 // WaitCloseMode enumerates the possible behaviors of WaitClose in a pipeline.
@@ -167,6 +175,7 @@ func (p *Pipeline) Close() error {
 
 	// Note: active clients are not closed / disconnected.
 	p.outputController.WaitClose(p.waitCloseTimeout)
+	p.router.waitClose(p.waitCloseTimeout)
 
 	p.observer.cleanup()
 	return nil
@@ -213,13 +222,19 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 		clientListener = noopClientListener{}
 	}
 
+	// Clients matching a route publish to the named queue of the route.
+	controller, observer := p.outputController, p.observer
+	if q := p.router.route(cfg); q != nil {
+		controller, observer = q.controller, q.clientObserver
+	}
+
 	client := &client{
 		logger:         p.monitors.Logger,
 		clientListener: clientListener,
 		processors:     processors,
 		eventFlags:     eventFlags,
 		canDrop:        canDrop,
-		observer:       p.observer,
+		observer:       observer,
 	}
 
 	client.isOpen.Store(true)
@@ -236,11 +251,26 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 		}
 	}
 
+	var ackEvents func(int)
+	if ackHandler != nil {
+		ackEvents = ackHandler.ACKEvents
+	}
+
+	// Processors splitting events create more queued events than the client
+	// has published, the ACKs must be translated for the ackHandler.
+	if mp, ok := processors.(beat.MultiProcessor); ok && mp.SplitsEvents() {
+		client.splitter = mp
+		if ackEvents != nil {
+			client.splitACKer = newSplitACKer(ackEvents)
+			ackEvents = client.splitACKer.ACKEvents
+		}
+	}
+
 	producerCfg := queue.ProducerConfig{
 		ACK: func(count int) {
 			client.observer.eventsACKed(count)
-			if ackHandler != nil {
-				ackHandler.ACKEvents(count)
+			if ackEvents != nil {
+				ackEvents(count)
 			}
 		},
 	}
@@ -251,14 +281,14 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 
 	client.eventListener = ackHandler
 	client.waiter = waiter
-	client.producer = p.outputController.queueProducer(producerCfg)
+	client.producer = controller.queueProducer(producerCfg)
 	if client.producer == nil {
 		// This can only happen if the pipeline was shut down while clients
 		// were still waiting to connect.
 		return nil, fmt.Errorf("client failed to connect because the pipeline is shutting down")
 	}
 
-	p.observer.clientConnected()
+	observer.clientConnected()
 	return client, nil
 }
 
@@ -271,7 +301,7 @@ func (p *Pipeline) createEventProcessing(cfg beat.ProcessingConfig, noPublish bo
 
 // OutputReloader returns a reloadable object for the output section of this pipeline
 func (p *Pipeline) OutputReloader() OutputReloader {
-	return p.outputController
+	return p.newOutputReloader()
 }
 
 // Parses the given config and returns a QueueFactory based on it.
@@ -0,0 +1,343 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/reload"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+// router selects the named queue of a client using the routing table of the
+// pipeline configuration.
+type router struct {
+	queues []*routeQueue
+	rules  []routeRule
+}
+
+// routeQueue is a named queue with its own output controller, so events and
+// ACKs of the clients routed to it are independent of the other queues.
+type routeQueue struct {
+	name       string
+	controller *outputController
+
+	// observer reports the metrics of the route, nilObserver if the pipeline
+	// has no metrics registry.
+	observer observer
+
+	// clientObserver reports the events of the clients routed to the queue
+	// to both the pipeline and the route observer.
+	clientObserver observer
+
+	// defaultOutput is true if the queue uses an instance of the default
+	// output, that is replaced when the output is reloaded.
+	defaultOutput bool
+}
+
+type routeRule struct {
+	queue    *routeQueue
+	inputIDs map[string]struct{}
+	meta     map[string]string
+}
+
+// configureRoutes creates the named queues and the routing table.
+// makeOutput creates the default output, used by the queues without their
+// own output configuration.
+func (p *Pipeline) configureRoutes(
+	config Config,
+	makeOutput outputFactory,
+	settings Settings,
+) error {
+	if len(config.Queues) == 0 && len(config.Routes) == 0 {
+		return nil
+	}
+
+	if err := validateRoutes(config); err != nil {
+		return err
+	}
+
+	r := &router{}
+	queues := map[string]*routeQueue{}
+	for _, queueCfg := range config.Queues {
+		q, err := p.newRouteQueue(queueCfg, makeOutput, settings)
+		if err != nil {
+			r.waitClose(0)
+			return fmt.Errorf("failed to create pipeline queue '%v': %w", queueCfg.Name, err)
+		}
+		queues[q.name] = q
+		r.queues = append(r.queues, q)
+	}
+
+	for _, routeCfg := range config.Routes {
+		rule := routeRule{queue: queues[routeCfg.Queue], meta: routeCfg.Meta}
+		if len(routeCfg.InputIDs) > 0 {
+			rule.inputIDs = make(map[string]struct{}, len(routeCfg.InputIDs))
+			for _, id := range routeCfg.InputIDs {
+				rule.inputIDs[id] = struct{}{}
+			}
+		}
+		r.rules = append(r.rules, rule)
+	}
+
+	p.router = r
+	return nil
+}
+
+// validateRoutes checks that the queue names are unique and that all routes
+// have a condition and use a configured queue.
+func validateRoutes(config Config) error {
+	names := map[string]bool{}
+	for _, queueCfg := range config.Queues {
+		if names[queueCfg.Name] {
+			return fmt.Errorf("duplicate pipeline queue '%v'", queueCfg.Name)
+		}
+		names[queueCfg.Name] = true
+	}
+	for i, routeCfg := range config.Routes {
+		if !names[routeCfg.Queue] {
+			return fmt.Errorf("pipeline route %d uses unknown queue '%v'", i, routeCfg.Queue)
+		}
+		if len(routeCfg.InputIDs) == 0 && len(routeCfg.Meta) == 0 {
+			return fmt.Errorf("pipeline route %d for queue '%v' requires input_ids or meta", i, routeCfg.Queue)
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) newRouteQueue(
+	cfg QueueConfig,
+	makeOutput outputFactory,
+	settings Settings,
+) (*routeQueue, error) {
+	var err error
+	queueType := defaultQueueType
+	if b := cfg.Queue.Name(); b != "" {
+		queueType = b
+	}
+	queueConfig := cfg.Queue.Config()
+	if queueType == diskqueue.QueueType && !queueConfig.HasField("path") {
+		// Each disk queue needs its own directory, next to the default
+		// queue directory rather than inside it, as the default queue
+		// treats every file in its directory as its own.
+		queueConfig, err = conf.NewConfigFrom(queueConfig)
+		if err == nil {
+			err = queueConfig.SetString("path", -1, paths.Resolve(paths.Data, "diskqueue-"+cfg.Name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	queueFactory, err := queueFactoryForUserConfig(queueType, queueConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The metrics of the route are reported in the registry
+	// 'pipeline.routes.<name>', with the same layout as the pipeline and
+	// output metrics of the beat.
+	monitors := Monitors{
+		Logger: p.monitors.Logger.With("queue", cfg.Name),
+		Tracer: p.monitors.Tracer,
+	}
+	q := &routeQueue{
+		name:          cfg.Name,
+		observer:      nilObserver,
+		defaultOutput: !cfg.Output.IsSet(),
+	}
+	if p.monitors.Metrics != nil {
+		pipelineMetrics := p.monitors.Metrics.GetRegistry("pipeline")
+		if pipelineMetrics == nil {
+			pipelineMetrics = p.monitors.Metrics.NewRegistry("pipeline")
+		}
+		routesMetrics := pipelineMetrics.GetRegistry("routes")
+		if routesMetrics == nil {
+			routesMetrics = pipelineMetrics.NewRegistry("routes")
+		}
+		monitors.Metrics = routesMetrics.GetRegistry(cfg.Name)
+		if monitors.Metrics != nil {
+			if err := monitors.Metrics.Clear(); err != nil {
+				return nil, err
+			}
+		} else {
+			monitors.Metrics = routesMetrics.NewRegistry(cfg.Name)
+		}
+		q.observer = newMetricsObserver(monitors.Metrics)
+	}
+	q.clientObserver = &routeObserver{pipeline: p.observer, route: q.observer}
+
+	q.controller, err = newOutputController(p.beatInfo, monitors, q.clientObserver, queueFactory, settings.InputQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			q.controller.WaitClose(0)
+			q.observer.cleanup()
+		}
+	}()
+
+	if !q.defaultOutput {
+		if settings.OutputFactory == nil {
+			return nil, fmt.Errorf("the pipeline does not support queues with their own output")
+		}
+		makeOutput = func(stats outputs.Observer) (string, outputs.Group, error) {
+			out, err := settings.OutputFactory(stats, cfg.Output)
+			return cfg.Output.Name(), out, err
+		}
+	}
+	out, err := loadOutput(monitors, makeOutput)
+	if err != nil {
+		return nil, err
+	}
+	q.controller.Set(out)
+
+	ok = true
+	return q, nil
+}
+
+// route returns the queue selected by the first matching route, or nil if
+// the client uses the default queue.
+func (r *router) route(cfg beat.ClientConfig) *routeQueue {
+	if r == nil {
+		return nil
+	}
+	for _, rule := range r.rules {
+		if rule.match(cfg) {
+			return rule.queue
+		}
+	}
+	return nil
+}
+
+func (r routeRule) match(cfg beat.ClientConfig) bool {
+	if r.inputIDs != nil {
+		if _, ok := r.inputIDs[cfg.InputID]; !ok {
+			return false
+		}
+	}
+	for key, want := range r.meta {
+		value, err := cfg.Processing.Meta.GetValue(key)
+		if err != nil || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// waitClose closes the named queues and their outputs, waiting up to
+// timeout for each queue to drain.
+func (r *router) waitClose(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+	for _, q := range r.queues {
+		q.controller.WaitClose(timeout)
+		q.observer.cleanup()
+	}
+}
+
+// routerOutputReloader reloads the default output and the outputs of the
+// named queues using an instance of the default output.
+type routerOutputReloader struct {
+	controllers []*outputController
+}
+
+func (p *Pipeline) newOutputReloader() OutputReloader {
+	if p.router == nil {
+		return p.outputController
+	}
+	reloader := &routerOutputReloader{controllers: []*outputController{p.outputController}}
+	for _, q := range p.router.queues {
+		if q.defaultOutput {
+			reloader.controllers = append(reloader.controllers, q.controller)
+		}
+	}
+	return reloader
+}
+
+func (r *routerOutputReloader) Reload(
+	cfg *reload.ConfigWithMeta,
+	factory func(outputs.Observer, conf.Namespace) (outputs.Group, error),
+) error {
+	for _, c := range r.controllers {
+		if err := c.Reload(cfg, factory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeObserver reports the events of the clients and outputs of a named
+// queue to the pipeline observer and to the observer of the route.
+type routeObserver struct {
+	pipeline, route observer
+}
+
+func (o *routeObserver) cleanup() {}
+
+func (o *routeObserver) clientConnected() {
+	o.pipeline.clientConnected()
+	o.route.clientConnected()
+}
+
+func (o *routeObserver) clientClosed() {
+	o.pipeline.clientClosed()
+	o.route.clientClosed()
+}
+
+func (o *routeObserver) newEvent() {
+	o.pipeline.newEvent()
+	o.route.newEvent()
+}
+
+func (o *routeObserver) filteredEvent() {
+	o.pipeline.filteredEvent()
+	o.route.filteredEvent()
+}
+
+func (o *routeObserver) publishedEvent() {
+	o.pipeline.publishedEvent()
+	o.route.publishedEvent()
+}
+
+func (o *routeObserver) failedPublishEvent() {
+	o.pipeline.failedPublishEvent()
+	o.route.failedPublishEvent()
+}
+
+func (o *routeObserver) eventsACKed(n int) {
+	o.pipeline.eventsACKed(n)
+	o.route.eventsACKed(n)
+}
+
+func (o *routeObserver) eventsDropped(n int) {
+	o.pipeline.eventsDropped(n)
+	o.route.eventsDropped(n)
+}
+
+func (o *routeObserver) eventsRetry(n int) {
+	o.pipeline.eventsRetry(n)
+	o.route.eventsRetry(n)
+}
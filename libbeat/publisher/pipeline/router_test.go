@@ -0,0 +1,196 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+func TestPipelineRoutes(t *testing.T) {
+	var config Config
+	queueConfig := map[string]interface{}{"events": 100, "flush.min_events": 1, "flush.timeout": 0}
+	require.NoError(t, conf.MustNewConfigFrom(map[string]interface{}{
+		"queue.mem": queueConfig,
+		"pipeline.queues": []map[string]interface{}{
+			{"name": "audit", "queue.mem": queueConfig},
+		},
+		"pipeline.routes": []map[string]interface{}{
+			{"queue": "audit", "input_ids": []string{"audit-input"}},
+			{"queue": "audit", "meta": map[string]interface{}{"route": "audit"}},
+		},
+	}).Unpack(&config))
+
+	// Every call of the output factory creates a new output, the first one
+	// is the default output and the second the output of the audit queue.
+	var published []*atomic.Int64
+	makeOutput := func(outputs.Observer) (string, outputs.Group, error) {
+		count := &atomic.Int64{}
+		published = append(published, count)
+		client := newMockClient(func(batch publisher.Batch) error {
+			count.Add(int64(len(batch.Events())))
+			batch.ACK()
+			return nil
+		})
+		return "mock", outputs.Group{Clients: []outputs.Client{client}}, nil
+	}
+
+	// The queue readers of the pipeline may still log after the test
+	// completed, don't use a testing logger.
+	logger := logp.NewLogger("")
+	reg := monitoring.NewRegistry()
+	p, err := LoadWithSettings(beat.Info{Logger: logger}, Monitors{Logger: logger, Metrics: reg}, config, makeOutput, Settings{})
+	require.NoError(t, err)
+	defer p.Close()
+	require.Len(t, published, 2)
+
+	clientConfigs := []beat.ClientConfig{
+		{InputID: "audit-input"},
+		{Processing: beat.ProcessingConfig{Meta: mapstr.M{"route": "audit"}}},
+		{InputID: "other-input"},
+		{Processing: beat.ProcessingConfig{Meta: mapstr.M{"route": "other"}}},
+		{},
+	}
+	for _, cfg := range clientConfigs {
+		client, err := p.ConnectWith(cfg)
+		require.NoError(t, err)
+		client.Publish(beat.Event{Timestamp: time.Now()})
+		require.NoError(t, client.Close())
+	}
+
+	require.Eventually(t, func() bool {
+		return published[0].Load() == 3 && published[1].Load() == 2
+	}, 10*time.Second, 10*time.Millisecond,
+		"default output got %d events, audit output %d", published[0].Load(), published[1].Load())
+
+	assertUint := func(name string, expected uint64) {
+		t.Helper()
+		v, ok := reg.Get(name).(*monitoring.Uint)
+		require.True(t, ok, "metric %v must exist", name)
+		assert.Equal(t, expected, v.Get(), name)
+	}
+	assertUint("pipeline.events.total", 5)
+	assertUint("pipeline.routes.audit.pipeline.events.total", 2)
+	assertUint("pipeline.routes.audit.pipeline.events.published", 2)
+	assertUint("pipeline.routes.audit.pipeline.queue.max_events", 100)
+	assert.NotNil(t, reg.Get("pipeline.routes.audit.output.type"))
+}
+
+func TestPipelineRoutesConfigErrors(t *testing.T) {
+	testCases := map[string]Config{
+		"duplicate queue": {
+			Queues: []QueueConfig{{Name: "a"}, {Name: "a"}},
+		},
+		"unknown queue": {
+			Queues: []QueueConfig{{Name: "a"}},
+			Routes: []RouteConfig{{Queue: "b", InputIDs: []string{"id"}}},
+		},
+		"route without condition": {
+			Queues: []QueueConfig{{Name: "a"}},
+			Routes: []RouteConfig{{Queue: "a"}},
+		},
+	}
+
+	for name, config := range testCases {
+		t.Run(name, func(t *testing.T) {
+			logger := logp.NewLogger("")
+			_, err := LoadWithSettings(beat.Info{Logger: logger}, Monitors{Logger: logger}, config, nil, Settings{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPipelineRoutesOutputErrors(t *testing.T) {
+	testCases := map[string]struct {
+		queue      map[string]interface{}
+		makeOutput outputFactory
+	}{
+		"output factory fails": {
+			queue: map[string]interface{}{"name": "audit"},
+			makeOutput: func(outputs.Observer) (string, outputs.Group, error) {
+				return "", outputs.Group{}, errors.New("output failure")
+			},
+		},
+		"own output without factory": {
+			queue: map[string]interface{}{"name": "audit", "output.mock": nil},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var config Config
+			require.NoError(t, conf.MustNewConfigFrom(map[string]interface{}{
+				"pipeline.queues": []map[string]interface{}{tc.queue},
+				"pipeline.routes": []map[string]interface{}{
+					{"queue": "audit", "input_ids": []string{"audit-input"}},
+				},
+			}).Unpack(&config))
+
+			logger := logp.NewLogger("")
+			p := &Pipeline{beatInfo: beat.Info{Logger: logger}, monitors: Monitors{Logger: logger, Metrics: monitoring.NewRegistry()}, observer: nilObserver}
+			_, err := p.newRouteQueue(config.Queues[0], tc.makeOutput, Settings{})
+			require.Error(t, err)
+
+			// The output controller of the queue is closed along with its
+			// metrics.
+			assert.Nil(t, p.monitors.Metrics.Get("pipeline.routes.audit.pipeline"))
+		})
+	}
+}
+
+func TestPipelineRoutesDiskQueuePath(t *testing.T) {
+	origDataPath := paths.Paths.Data
+	defer func() {
+		paths.Paths.Data = origDataPath
+	}()
+	paths.Paths.Data = t.TempDir()
+
+	config := Config{
+		Queues: []QueueConfig{{Name: "audit"}},
+		Routes: []RouteConfig{{Queue: "audit", InputIDs: []string{"audit-input"}}},
+	}
+	require.NoError(t, config.Queues[0].Queue.Unpack(conf.MustNewConfigFrom(map[string]interface{}{
+		"disk.max_size": "10MB",
+	})))
+
+	makeOutput := func(outputs.Observer) (string, outputs.Group, error) {
+		return "mock", outputs.Group{Clients: []outputs.Client{newMockClient(nil)}}, nil
+	}
+	logger := logp.NewLogger("")
+	p, err := LoadWithSettings(beat.Info{Logger: logger}, Monitors{Logger: logger}, config, makeOutput, Settings{})
+	require.NoError(t, err)
+	defer p.Close()
+
+	assert.DirExists(t, filepath.Join(paths.Paths.Data, "diskqueue-audit"))
+	assert.NoDirExists(t, filepath.Join(paths.Paths.Data, "diskqueue", "audit"))
+}
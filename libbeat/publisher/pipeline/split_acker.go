@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import "sync"
+
+// splitACKer translates the queue ACKs of a client whose processors split
+// events into ACKs of the events published by the client. A published event
+// is ACKed once all the events created from it have been ACKed. The queue
+// ACKs events in order, so only the number of events created from each
+// published event needs to be tracked.
+type splitACKer struct {
+	mu sync.Mutex
+	fn func(n int)
+
+	groups []splitGroup
+	// acked counts the ACKed events of the oldest published event that is not
+	// ACKed yet.
+	acked int
+}
+
+// splitGroup is a sequence of count published events, each split into size
+// queued events.
+type splitGroup struct {
+	count, size int
+}
+
+func newSplitACKer(fn func(n int)) *splitACKer {
+	return &splitACKer{fn: fn}
+}
+
+// add registers a published event split into size events. It must be called
+// before the events are queued.
+func (a *splitACKer) add(size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n := len(a.groups); n > 0 && a.groups[n-1].size == size {
+		a.groups[n-1].count++
+		return
+	}
+	a.groups = append(a.groups, splitGroup{count: 1, size: size})
+}
+
+// dropped removes an event of the most recently published event, because it
+// could not be queued.
+func (a *splitACKer) dropped() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last := &a.groups[len(a.groups)-1]
+	if last.count > 1 {
+		last.count--
+		a.groups = append(a.groups, splitGroup{count: 1, size: last.size - 1})
+	} else {
+		last.size--
+	}
+
+	// Events whose created events have all been dropped are ACKed in order.
+	a.ack(0)
+}
+
+// ACKEvents handles the ACK of n queued events.
+func (a *splitACKer) ACKEvents(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.ack(n)
+}
+
+func (a *splitACKer) ack(n int) {
+	n += a.acked
+	total := 0
+	for len(a.groups) > 0 {
+		g := &a.groups[0]
+		if g.size == 0 {
+			total += g.count
+			a.groups = a.groups[1:]
+			continue
+		}
+
+		k := min(n/g.size, g.count)
+		total += k
+		n -= k * g.size
+		g.count -= k
+		if g.count > 0 {
+			break
+		}
+		a.groups = a.groups[1:]
+	}
+	a.acked = n
+
+	if total > 0 {
+		a.fn(total)
+	}
+}
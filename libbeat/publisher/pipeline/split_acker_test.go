@@ -0,0 +1,78 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitACKer(t *testing.T) {
+	var acked []int
+	a := newSplitACKer(func(n int) { acked = append(acked, n) })
+
+	// Published events split into 1, 1, 3 and 2 events.
+	a.add(1)
+	a.add(1)
+	a.add(3)
+	a.add(2)
+	assert.Equal(t, []splitGroup{{2, 1}, {1, 3}, {1, 2}}, a.groups)
+
+	a.ACKEvents(1)
+	assert.Equal(t, []int{1}, acked)
+
+	// Partial ACKs of a split event are not forwarded.
+	a.ACKEvents(3)
+	assert.Equal(t, []int{1, 1}, acked)
+	a.ACKEvents(1)
+	assert.Equal(t, []int{1, 1, 1}, acked)
+
+	a.ACKEvents(2)
+	assert.Equal(t, []int{1, 1, 1, 1}, acked)
+	assert.Empty(t, a.groups)
+	assert.Zero(t, a.acked)
+}
+
+func TestSplitACKerDropped(t *testing.T) {
+	var acked []int
+	a := newSplitACKer(func(n int) { acked = append(acked, n) })
+
+	a.add(2)
+	a.add(2)
+	// The second event of the second published event is dropped.
+	a.dropped()
+	assert.Equal(t, []splitGroup{{1, 2}, {1, 1}}, a.groups)
+
+	// All events of the third published event are dropped, it is only ACKed
+	// after the events published before it.
+	a.add(1)
+	a.dropped()
+	assert.Empty(t, acked)
+
+	a.ACKEvents(2)
+	assert.Equal(t, []int{1}, acked)
+	a.ACKEvents(1)
+	assert.Equal(t, []int{1, 2}, acked)
+	assert.Empty(t, a.groups)
+
+	// Without pending events, a fully dropped event is ACKed immediately.
+	a.add(1)
+	a.dropped()
+	assert.Equal(t, []int{1, 2, 1}, acked)
+}
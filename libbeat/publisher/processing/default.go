@@ -380,8 +380,8 @@ func (b *builder) Create(cfg beat.ProcessingConfig, drop bool) (beat.Processor,
 
 	// setup 8: pipeline processors list
 	if b.processors != nil {
-		// Add the global pipeline as a function processor, so clients cannot close it
-		processors.add(newProcessor(b.processors.title, b.processors.Run))
+		// Add the global pipeline wrapped, so clients cannot close it
+		processors.add(sharedGroup{b.processors})
 	}
 
 	// setup 9: time series metadata
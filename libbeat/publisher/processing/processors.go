@@ -142,6 +142,43 @@ func (p *group) Run(event *beat.Event) (*beat.Event, error) {
 	return event, nil
 }
 
+// RunMulti is like Run, applying the processors following one that splits
+// events to every event it creates.
+func (p *group) RunMulti(event *beat.Event) ([]*beat.Event, error) {
+	if p == nil || len(p.list) == 0 {
+		return []*beat.Event{event}, nil
+	}
+
+	return processors.RunMultiList(p.list, event, func(_ beat.Processor, err error) error {
+		p.log.Debugf("Fail to apply processor %s: %s", p, err)
+		return nil
+	})
+}
+
+// SplitsEvents reports whether one of the processors can split events.
+func (p *group) SplitsEvents() bool {
+	if p == nil {
+		return false
+	}
+	for _, sub := range p.list {
+		if processors.SplitsEvents(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedGroup runs a group shared by all clients, without allowing them to
+// close it.
+type sharedGroup struct {
+	group *group
+}
+
+func (s sharedGroup) String() string                                { return s.group.title }
+func (s sharedGroup) Run(e *beat.Event) (*beat.Event, error)        { return s.group.Run(e) }
+func (s sharedGroup) RunMulti(e *beat.Event) ([]*beat.Event, error) { return s.group.RunMulti(e) }
+func (s sharedGroup) SplitsEvents() bool                            { return s.group.SplitsEvents() }
+
 func newProcessor(name string, fn func(*beat.Event) (*beat.Event, error)) *processorFn {
 	return &processorFn{name: name, fn: fn}
 }
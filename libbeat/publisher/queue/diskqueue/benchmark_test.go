@@ -75,11 +75,11 @@ func makePublisherEvent(r *rand.Rand) publisher.Event {
 // hold the queue.  Location of the temporary directory is stored in
 // the queue settings.  Call `cleanup` when done with the queue to
 // close the queue and remove the temp dir.
-func setup(b *testing.B, compress bool, protobuf bool) (*diskQueue, queue.Producer) {
+func setup(b *testing.B, compression CompressionCodec, protobuf bool) (*diskQueue, queue.Producer) {
 	s := DefaultSettings()
 	s.Path = b.TempDir()
 
-	s.UseCompression = compress
+	s.Compression = compression
 	logger, err := logp.NewDevelopmentLogger("")
 	require.NoError(b, err)
 	q, err := NewQueue(logger, nil, s, nil)
@@ -140,14 +140,14 @@ func produceThenConsume(r *rand.Rand, p queue.Producer, q *diskQueue, num_events
 
 // benchmarkQueue is a wrapper for produceAndConsume, it tries to limit
 // timers to just produceAndConsume
-func benchmarkQueue(num_events int, batch_size int, compress bool, async bool, protobuf bool, b *testing.B) {
+func benchmarkQueue(num_events int, batch_size int, compression CompressionCodec, async bool, protobuf bool, b *testing.B) {
 	b.ResetTimer()
 	var err error
 
 	for n := 0; n < b.N; n++ {
 		b.StopTimer()
 		r := rand.New(rand.NewPCG(1, 2))
-		q, p := setup(b, compress, protobuf)
+		q, p := setup(b, compression, protobuf)
 		b.StartTimer()
 		if async {
 			if err = produceAndConsume(r, p, q, num_events, batch_size); err != nil {
@@ -166,50 +166,66 @@ func benchmarkQueue(num_events int, batch_size int, compress bool, async bool, p
 
 // Async benchmarks
 func BenchmarkAsync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, false, true, false, b)
+	benchmarkQueue(1000, 10, CompressionNone, true, false, b)
 }
 
 func BenchmarkAsync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, false, true, false, b)
+	benchmarkQueue(100000, 1000, CompressionNone, true, false, b)
 }
 
 func BenchmarkCompressAsync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, true, true, false, b)
+	benchmarkQueue(1000, 10, CompressionLZ4, true, false, b)
 }
 
 func BenchmarkCompressAsync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, true, true, false, b)
+	benchmarkQueue(100000, 1000, CompressionLZ4, true, false, b)
+}
+
+func BenchmarkZstdAsync1k(b *testing.B) {
+	benchmarkQueue(1000, 10, CompressionZSTD, true, false, b)
+}
+
+func BenchmarkZstdAsync100k(b *testing.B) {
+	benchmarkQueue(100000, 1000, CompressionZSTD, true, false, b)
 }
 
 func BenchmarkProtoAsync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, false, true, true, b)
+	benchmarkQueue(1000, 10, CompressionNone, true, true, b)
 }
 
 func BenchmarkProtoAsync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, false, true, true, b)
+	benchmarkQueue(100000, 1000, CompressionNone, true, true, b)
 }
 
 // Sync Benchmarks
 func BenchmarkSync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, false, false, false, b)
+	benchmarkQueue(1000, 10, CompressionNone, false, false, b)
 }
 
 func BenchmarkSync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, false, false, false, b)
+	benchmarkQueue(100000, 1000, CompressionNone, false, false, b)
 }
 
 func BenchmarkCompressSync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, true, false, false, b)
+	benchmarkQueue(1000, 10, CompressionLZ4, false, false, b)
 }
 
 func BenchmarkCompressSync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, true, false, false, b)
+	benchmarkQueue(100000, 1000, CompressionLZ4, false, false, b)
+}
+
+func BenchmarkZstdSync1k(b *testing.B) {
+	benchmarkQueue(1000, 10, CompressionZSTD, false, false, b)
+}
+
+func BenchmarkZstdSync100k(b *testing.B) {
+	benchmarkQueue(100000, 1000, CompressionZSTD, false, false, b)
 }
 
 func BenchmarkProtoSync1k(b *testing.B) {
-	benchmarkQueue(1000, 10, false, false, true, b)
+	benchmarkQueue(1000, 10, CompressionNone, false, true, b)
 }
 
 func BenchmarkProtoSync100k(b *testing.B) {
-	benchmarkQueue(100000, 1000, false, false, true, b)
+	benchmarkQueue(100000, 1000, CompressionNone, false, true, b)
 }
@@ -18,62 +18,142 @@
 package diskqueue
 
 import (
+	"fmt"
 	"io"
 
+	"github.com/klauspost/compress/zstd"
 	lz4V4 "github.com/pierrec/lz4/v4"
 )
 
-// CompressionReader allows reading a stream compressed with LZ4
+// CompressionCodec selects the algorithm used to compress segments.
+type CompressionCodec string
+
+const (
+	CompressionNone CompressionCodec = "none"
+	CompressionLZ4  CompressionCodec = "lz4"
+	CompressionZSTD CompressionCodec = "zstd"
+)
+
+// Maximum compression levels accepted for each codec, a level of 0
+// selects the codec's default.
+const (
+	maxLZ4CompressionLevel  = 9
+	maxZSTDCompressionLevel = 22
+)
+
+var lz4Levels = [...]lz4V4.CompressionLevel{
+	lz4V4.Fast, lz4V4.Level1, lz4V4.Level2, lz4V4.Level3, lz4V4.Level4,
+	lz4V4.Level5, lz4V4.Level6, lz4V4.Level7, lz4V4.Level8, lz4V4.Level9,
+}
+
+// compressionDecoder is implemented by the decoders of all codecs.
+type compressionDecoder interface {
+	io.Reader
+	reset(r io.Reader) error
+	close()
+}
+
+// compressionEncoder is implemented by the encoders of all codecs.
+type compressionEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type lz4Decoder struct{ *lz4V4.Reader }
+
+func (d lz4Decoder) reset(r io.Reader) error { d.Reset(r); return nil }
+func (d lz4Decoder) close()                  {}
+
+type zstdDecoder struct{ *zstd.Decoder }
+
+func (d zstdDecoder) reset(r io.Reader) error { return d.Reset(r) }
+func (d zstdDecoder) close()                  { d.Close() }
+
+// CompressionReader allows reading a stream compressed with LZ4 or
+// Zstandard
 type CompressionReader struct {
-	src        io.ReadCloser
-	pLZ4Reader *lz4V4.Reader
+	src     io.ReadCloser
+	decoder compressionDecoder
 }
 
-// NewCompressionReader returns a new LZ4 frame decoder
-func NewCompressionReader(r io.ReadCloser) *CompressionReader {
-	zr := lz4V4.NewReader(r)
-	return &CompressionReader{
-		src:        r,
-		pLZ4Reader: zr,
+// NewCompressionReader returns a new decoder for the given codec
+func NewCompressionReader(r io.ReadCloser, codec CompressionCodec) (*CompressionReader, error) {
+	cr := &CompressionReader{src: r}
+	switch codec {
+	case CompressionLZ4:
+		cr.decoder = lz4Decoder{lz4V4.NewReader(r)}
+	case CompressionZSTD:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("could not create zstd decoder: %w", err)
+		}
+		cr.decoder = zstdDecoder{zr}
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %q", codec)
 	}
+	return cr, nil
 }
 
 func (r *CompressionReader) Read(buf []byte) (int, error) {
-	return r.pLZ4Reader.Read(buf)
+	return r.decoder.Read(buf)
 }
 
 func (r *CompressionReader) Close() error {
+	r.decoder.close()
 	return r.src.Close()
 }
 
 // Reset Sets up compression again, assumes that caller has already set
 // the src to the correct position
 func (r *CompressionReader) Reset() error {
-	r.pLZ4Reader.Reset(r.src)
-	return nil
+	return r.decoder.reset(r.src)
 }
 
-// CompressionWriter allows writing an LZ4 stream
+// CompressionWriter allows writing an LZ4 or Zstandard stream
 type CompressionWriter struct {
-	dst        WriteCloseSyncer
-	pLZ4Writer *lz4V4.Writer
+	dst     WriteCloseSyncer
+	encoder compressionEncoder
 }
 
-// NewCompressionWriter returns a new LZ4 frame encoder
-func NewCompressionWriter(w WriteCloseSyncer) *CompressionWriter {
-	zw := lz4V4.NewWriter(w)
-	return &CompressionWriter{
-		dst:        w,
-		pLZ4Writer: zw,
+// NewCompressionWriter returns a new encoder for the given codec. A
+// level of 0 selects the default level of the codec.
+func NewCompressionWriter(w WriteCloseSyncer, codec CompressionCodec, level int) (*CompressionWriter, error) {
+	cw := &CompressionWriter{dst: w}
+	switch codec {
+	case CompressionLZ4:
+		if level < 0 || level > maxLZ4CompressionLevel {
+			return nil, fmt.Errorf("invalid lz4 compression level %d", level)
+		}
+		zw := lz4V4.NewWriter(w)
+		if err := zw.Apply(lz4V4.CompressionLevelOption(lz4Levels[level])); err != nil {
+			return nil, fmt.Errorf("could not set lz4 compression level: %w", err)
+		}
+		cw.encoder = zw
+	case CompressionZSTD:
+		if level < 0 || level > maxZSTDCompressionLevel {
+			return nil, fmt.Errorf("invalid zstd compression level %d", level)
+		}
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zw, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create zstd encoder: %w", err)
+		}
+		cw.encoder = zw
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %q", codec)
 	}
+	return cw, nil
 }
 
 func (w *CompressionWriter) Write(p []byte) (int, error) {
-	return w.pLZ4Writer.Write(p)
+	return w.encoder.Write(p)
 }
 
 func (w *CompressionWriter) Close() error {
-	err := w.pLZ4Writer.Close()
+	err := w.encoder.Close()
 	if err != nil {
 		return err
 	}
@@ -81,6 +161,8 @@ func (w *CompressionWriter) Close() error {
 }
 
 func (w *CompressionWriter) Sync() error {
-	w.pLZ4Writer.Flush()
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
 	return w.dst.Sync()
 }
@@ -23,6 +23,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
 )
 
 type nopWriteCloser struct {
@@ -66,7 +69,8 @@ func TestCompressionReader(t *testing.T) {
 	for name, tc := range tests {
 		dst := make([]byte, len(tc.plaintext))
 		src := bytes.NewReader(tc.compressed)
-		cr := NewCompressionReader(io.NopCloser(src))
+		cr, err := NewCompressionReader(io.NopCloser(src), CompressionLZ4)
+		require.NoError(t, err, name)
 		n, err := cr.Read(dst)
 		assert.Nil(t, err, name)
 		assert.Equal(t, len(tc.plaintext), n, name)
@@ -94,7 +98,8 @@ func TestCompressionWriter(t *testing.T) {
 
 	for name, tc := range tests {
 		var dst bytes.Buffer
-		cw := NewCompressionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)))
+		cw, err := NewCompressionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), CompressionLZ4, 0)
+		require.NoError(t, err, name)
 		n, err := cw.Write(tc.plaintext)
 		cw.Close()
 		assert.Nil(t, err, name)
@@ -113,6 +118,20 @@ type nopWriteCloseSyncer struct {
 
 func (nopWriteCloseSyncer) Sync() error { return nil }
 
+// testCompressionCodecs are the codecs and levels covered by the
+// round trip tests.
+var testCompressionCodecs = map[string]struct {
+	codec CompressionCodec
+	level int
+}{
+	"lz4":           {codec: CompressionLZ4},
+	"lz4 level 9":   {codec: CompressionLZ4, level: 9},
+	"zstd":          {codec: CompressionZSTD},
+	"zstd level 1":  {codec: CompressionZSTD, level: 1},
+	"zstd level 6":  {codec: CompressionZSTD, level: 6},
+	"zstd level 22": {codec: CompressionZSTD, level: 22},
+}
+
 func TestCompressionRoundTrip(t *testing.T) {
 	tests := map[string]struct {
 		plaintext []byte
@@ -120,22 +139,28 @@ func TestCompressionRoundTrip(t *testing.T) {
 		"no repeat":  {plaintext: []byte("abcdefghijklmnopqrstuvwxzy01234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ")},
 		"256 repeat": {plaintext: []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
 	}
-	for name, tc := range tests {
-		pr, pw := io.Pipe()
-		src := bytes.NewReader(tc.plaintext)
-		var dst bytes.Buffer
+	for codecName, codec := range testCompressionCodecs {
+		for name, tc := range tests {
+			name := codecName + " " + name
+			pr, pw := io.Pipe()
+			src := bytes.NewReader(tc.plaintext)
+			var dst bytes.Buffer
 
-		go func() {
-			cw := NewCompressionWriter(NopWriteCloseSyncer(pw))
-			_, err := io.Copy(cw, src)
-			assert.Nil(t, err, name)
-			cw.Close()
-		}()
+			go func() {
+				cw, err := NewCompressionWriter(NopWriteCloseSyncer(pw), codec.codec, codec.level)
+				assert.Nil(t, err, name)
+				_, err = io.Copy(cw, src)
+				assert.Nil(t, err, name)
+				cw.Close()
+			}()
 
-		cr := NewCompressionReader(pr)
-		_, err := io.Copy(&dst, cr)
-		assert.Nil(t, err, name)
-		assert.Equal(t, tc.plaintext, dst.Bytes(), name)
+			cr, err := NewCompressionReader(pr, codec.codec)
+			require.NoError(t, err, name)
+			_, err = io.Copy(&dst, cr)
+			assert.Nil(t, err, name)
+			assert.Equal(t, tc.plaintext, dst.Bytes(), name)
+			cr.Close()
+		}
 	}
 }
 
@@ -146,28 +171,123 @@ func TestCompressionSync(t *testing.T) {
 		"no repeat":  {plaintext: []byte("abcdefghijklmnopqrstuvwxzy01234567890ABCDEFGHIJKLMNOPQRSTUVWXYZ")},
 		"256 repeat": {plaintext: []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
 	}
-	for name, tc := range tests {
-		pr, pw := io.Pipe()
-		var dst bytes.Buffer
-		go func() {
-			cw := NewCompressionWriter(NopWriteCloseSyncer(pw))
-			src1 := bytes.NewReader(tc.plaintext)
-			_, err := io.Copy(cw, src1)
-			assert.Nil(t, err, name)
-			// prior to v4.1.15 of pierrec/lz4 there was a
-			// bug that prevented writing after a Flush.
-			// The call to Sync here exercises Flush.
-			err = cw.Sync()
-			assert.Nil(t, err, name)
-			src2 := bytes.NewReader(tc.plaintext)
-			_, err = io.Copy(cw, src2)
+	for codecName, codec := range testCompressionCodecs {
+		for name, tc := range tests {
+			name := codecName + " " + name
+			pr, pw := io.Pipe()
+			var dst bytes.Buffer
+			go func() {
+				cw, err := NewCompressionWriter(NopWriteCloseSyncer(pw), codec.codec, codec.level)
+				assert.Nil(t, err, name)
+				src1 := bytes.NewReader(tc.plaintext)
+				_, err = io.Copy(cw, src1)
+				assert.Nil(t, err, name)
+				// prior to v4.1.15 of pierrec/lz4 there was a
+				// bug that prevented writing after a Flush.
+				// The call to Sync here exercises Flush.
+				err = cw.Sync()
+				assert.Nil(t, err, name)
+				src2 := bytes.NewReader(tc.plaintext)
+				_, err = io.Copy(cw, src2)
+				assert.Nil(t, err, name)
+				cw.Close()
+			}()
+			cr, err := NewCompressionReader(pr, codec.codec)
+			require.NoError(t, err, name)
+			_, err = io.Copy(&dst, cr)
 			assert.Nil(t, err, name)
-			cw.Close()
-		}()
-		cr := NewCompressionReader(pr)
-		_, err := io.Copy(&dst, cr)
-		assert.Nil(t, err, name)
-		assert.Equal(t, tc.plaintext, dst.Bytes()[:len(tc.plaintext)], name)
-		assert.Equal(t, tc.plaintext, dst.Bytes()[len(tc.plaintext):], name)
+			assert.Equal(t, tc.plaintext, dst.Bytes()[:len(tc.plaintext)], name)
+			assert.Equal(t, tc.plaintext, dst.Bytes()[len(tc.plaintext):], name)
+			cr.Close()
+		}
+	}
+}
+
+func TestCompressionInvalidLevel(t *testing.T) {
+	var dst bytes.Buffer
+	_, err := NewCompressionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), CompressionLZ4, 10)
+	assert.Error(t, err)
+	_, err = NewCompressionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), CompressionZSTD, 23)
+	assert.Error(t, err)
+	_, err = NewCompressionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), "gzip", 0)
+	assert.Error(t, err)
+}
+
+func TestCompressionUserConfig(t *testing.T) {
+	testCases := map[string]struct {
+		compression map[string]interface{}
+		expectCodec CompressionCodec
+		expectLevel int
+		wantErr     bool
+	}{
+		"none": {
+			compression: map[string]interface{}{"codec": "none"},
+			expectCodec: CompressionNone,
+		},
+		"lz4": {
+			compression: map[string]interface{}{"codec": "lz4"},
+			expectCodec: CompressionLZ4,
+		},
+		"zstd with level": {
+			compression: map[string]interface{}{"codec": "zstd", "level": 19},
+			expectCodec: CompressionZSTD,
+			expectLevel: 19,
+		},
+		"unknown codec": {
+			compression: map[string]interface{}{"codec": "gzip"},
+			wantErr:     true,
+		},
+		"lz4 level too high": {
+			compression: map[string]interface{}{"codec": "lz4", "level": 12},
+			wantErr:     true,
+		},
+		"negative level": {
+			compression: map[string]interface{}{"codec": "zstd", "level": -1},
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(map[string]interface{}{
+				"max_size":    "1GB",
+				"compression": tc.compression,
+			})
+			settings, err := SettingsForUserConfig(cfg)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectCodec, settings.Compression)
+			assert.Equal(t, tc.expectLevel, settings.CompressionLevel)
+		})
+	}
+}
+
+func TestMixedCompressionCodecs(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	// Segments keep the codec they were written with when the
+	// compression setting changes.
+	codecs := []CompressionCodec{CompressionNone, CompressionLZ4, CompressionZSTD}
+	for i, codec := range codecs {
+		settings.Compression = codec
+		writeTestSegment(t, &queueSegment{id: segmentID(i)}, settings, testFrame([]byte(codec)))
+	}
+
+	settings.Compression = CompressionNone
+	for i, codec := range codecs {
+		data := readTestSegment(t, &queueSegment{id: segmentID(i)}, settings)
+		assert.Equal(t, testFrame([]byte(codec)), data)
+	}
+
+	segments, err := InspectSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, len(codecs))
+	for i, codec := range codecs {
+		assert.Equal(t, codec, segments[i].Compression)
+		assert.Equal(t, uint32(1), segments[i].FrameCount)
 	}
 }
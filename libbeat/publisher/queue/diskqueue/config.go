@@ -64,8 +64,22 @@ type Settings struct {
 	RetryInterval    time.Duration
 	MaxRetryInterval time.Duration
 
-	// UseCompression enables or disables LZ4 compression
-	UseCompression bool
+	// Compression selects the codec used to compress new segments. If
+	// empty, new segments are not compressed. Existing segments are read
+	// with the codec recorded in their header.
+	Compression CompressionCodec
+
+	// CompressionLevel is the level used by the compression codec, 0
+	// selects the default level of the codec.
+	CompressionLevel int
+
+	// EncryptionKey enables AES-256 encryption of new segments when set.
+	EncryptionKey *EncryptionKey
+
+	// PreviousEncryptionKeys are the keys that were used to encrypt
+	// existing segments before the encryption key was rotated. They are
+	// only used for reading.
+	PreviousEncryptionKeys []EncryptionKey
 }
 
 // userConfig holds the parameters for a disk queue that are configurable
@@ -80,8 +94,32 @@ type userConfig struct {
 
 	RetryInterval    *time.Duration `config:"retry_interval" validate:"positive"`
 	MaxRetryInterval *time.Duration `config:"max_retry_interval" validate:"positive"`
+
+	Compression *compressionConfig `config:"compression"`
+	Encryption  *encryptionConfig  `config:"encryption"`
+}
+
+// compressionConfig selects how new segments are compressed.
+type compressionConfig struct {
+	Codec string `config:"codec"`
+	Level int    `config:"level"`
 }
 
+// encryptionConfig holds the secrets used to encrypt segments, they are
+// expected to be references to the beats keystore.
+type encryptionConfig struct {
+	// Key is used to encrypt new segments. If it is empty, new segments
+	// are not encrypted but existing ones can still be read.
+	Key string `config:"key"`
+
+	// PreviousKeys allows reading segments encrypted before a key rotation.
+	PreviousKeys []string `config:"previous_keys"`
+}
+
+// minEncryptionKeyLength is the minimum length of the secrets used to
+// derive encryption keys.
+const minEncryptionKeyLength = 16
+
 func (c *userConfig) Validate() error {
 	// If the segment size is explicitly specified, the total queue size must
 	// be at least twice as large.
@@ -115,6 +153,41 @@ func (c *userConfig) Validate() error {
 	return nil
 }
 
+func (c *compressionConfig) Validate() error {
+	var maxLevel int
+	switch CompressionCodec(c.Codec) {
+	case "", CompressionNone:
+		return nil
+	case CompressionLZ4:
+		maxLevel = maxLZ4CompressionLevel
+	case CompressionZSTD:
+		maxLevel = maxZSTDCompressionLevel
+	default:
+		return fmt.Errorf("disk queue compression codec %q is not supported", c.Codec)
+	}
+	if c.Level < 0 || c.Level > maxLevel {
+		return fmt.Errorf(
+			"disk queue %s compression level (%d) must be between 0 and %d", c.Codec, c.Level, maxLevel)
+	}
+	return nil
+}
+
+func (c *encryptionConfig) Validate() error {
+	if c.Key == "" && len(c.PreviousKeys) == 0 {
+		return errors.New("disk queue encryption requires key or previous_keys")
+	}
+	if c.Key != "" && len(c.Key) < minEncryptionKeyLength {
+		return fmt.Errorf(
+			"disk queue encryption key must be at least %d characters long", minEncryptionKeyLength)
+	}
+	for _, key := range c.PreviousKeys {
+		if key == "" {
+			return errors.New("disk queue encryption previous_keys cannot contain empty keys")
+		}
+	}
+	return nil
+}
+
 // DefaultSettings returns a Settings object with reasonable default values
 // for all important fields.
 func DefaultSettings() Settings {
@@ -163,6 +236,28 @@ func SettingsForUserConfig(config *config.C) (Settings, error) {
 		settings.MaxRetryInterval = *userConfig.MaxRetryInterval
 	}
 
+	if userConfig.Compression != nil {
+		settings.Compression = CompressionCodec(userConfig.Compression.Codec)
+		settings.CompressionLevel = userConfig.Compression.Level
+	}
+
+	if userConfig.Encryption != nil {
+		if userConfig.Encryption.Key != "" {
+			key, err := NewEncryptionKey(userConfig.Encryption.Key)
+			if err != nil {
+				return Settings{}, err
+			}
+			settings.EncryptionKey = &key
+		}
+		for _, secret := range userConfig.Encryption.PreviousKeys {
+			key, err := NewEncryptionKey(secret)
+			if err != nil {
+				return Settings{}, err
+			}
+			settings.PreviousEncryptionKeys = append(settings.PreviousEncryptionKeys, key)
+		}
+	}
+
 	return settings, nil
 }
 
@@ -187,6 +282,32 @@ func (settings Settings) segmentPath(segmentID segmentID) string {
 		fmt.Sprintf("%v.seg", segmentID))
 }
 
+// segmentOptions returns the header options of the segments written
+// with these settings.
+func (settings Settings) segmentOptions() uint32 {
+	var options uint32
+	switch settings.Compression {
+	case CompressionLZ4:
+		options = options | ENABLE_COMPRESSION
+	case CompressionZSTD:
+		options = options | ENABLE_ZSTD_COMPRESSION
+	}
+	if settings.EncryptionKey != nil {
+		options = options | ENABLE_ENCRYPTION | ENABLE_AES_GCM
+	}
+	return options
+}
+
+// decryptionKeys returns all the keys that can be used to read
+// encrypted segments.
+func (settings Settings) decryptionKeys() []EncryptionKey {
+	keys := make([]EncryptionKey, 0, len(settings.PreviousEncryptionKeys)+1)
+	if settings.EncryptionKey != nil {
+		keys = append(keys, *settings.EncryptionKey)
+	}
+	return append(keys, settings.PreviousEncryptionKeys...)
+}
+
 // maxValidFrameSize returns the size of the largest possible frame that
 // can be stored with the current queue settings.
 func (settings Settings) maxValidFrameSize() uint64 {
@@ -0,0 +1,390 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// encryptionKeySize is the size of the AES-256 keys derived from the
+	// configured secrets.
+	encryptionKeySize = 32
+
+	// encryptionKeyIDSize is the size of the identifier written to each
+	// encrypted segment, it allows finding the key needed to decrypt it
+	// after the key has been rotated.
+	encryptionKeyIDSize = 8
+
+	// encryptionNonceSize is the size of the random nonce written to each
+	// encrypted segment. The nonce of each record is derived from it and
+	// the index of the record.
+	encryptionNonceSize = 12
+
+	// encryptionHeaderSize is the size of the data written by the
+	// EncryptionWriter before the encrypted records: the key ID and the
+	// segment nonce.
+	encryptionHeaderSize = encryptionKeyIDSize + encryptionNonceSize
+
+	// encryptionRecordHeaderSize is the size of the plaintext length
+	// written before each record.
+	encryptionRecordHeaderSize = 4
+
+	// encryptionTagSize is the size of the GCM authentication tag that
+	// follows the ciphertext of each record.
+	encryptionTagSize = 16
+
+	// encryptionMaxRecordSize is the maximum plaintext size of a record,
+	// longer writes are split in several records.
+	encryptionMaxRecordSize = 1 << 20
+
+	encryptionKeyInfo = "beats disk queue segment encryption"
+)
+
+var (
+	// ErrUnknownEncryptionKey is returned when a segment was encrypted
+	// with a key that is neither the current encryption key nor one of
+	// the previous ones.
+	ErrUnknownEncryptionKey = errors.New("segment is encrypted with an unknown key")
+
+	// ErrEncryptionAuthentication is returned when encrypted segment data
+	// fails authentication, because it was modified or corrupted.
+	ErrEncryptionAuthentication = errors.New("encrypted segment data failed authentication")
+)
+
+// encryptionKeyID identifies the key used to encrypt a segment. It is the
+// beginning of the SHA-256 hash of the key.
+type encryptionKeyID [encryptionKeyIDSize]byte
+
+func (id encryptionKeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// EncryptionKey is an AES-256 key used to encrypt and decrypt segments.
+type EncryptionKey struct {
+	id  encryptionKeyID
+	key []byte
+}
+
+// NewEncryptionKey derives an AES-256 key from the given secret, which is
+// typically taken from the beats keystore.
+func NewEncryptionKey(secret string) (EncryptionKey, error) {
+	if secret == "" {
+		return EncryptionKey{}, errors.New("encryption key cannot be empty")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, encryptionKeyInfo, encryptionKeySize)
+	if err != nil {
+		return EncryptionKey{}, fmt.Errorf("could not derive encryption key: %w", err)
+	}
+
+	k := EncryptionKey{key: key}
+	sum := sha256.Sum256(key)
+	copy(k.id[:], sum[:encryptionKeyIDSize])
+	return k, nil
+}
+
+// ID returns the hex encoded identifier of the key, as recorded in the
+// segments it encrypts.
+func (k EncryptionKey) ID() string {
+	return k.id.String()
+}
+
+func (k EncryptionKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCM cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// recordNonce returns the nonce of the record with the given index, the
+// index is XORed into the last 8 bytes of the segment nonce. Deriving it
+// from the index also prevents records from being reordered.
+func recordNonce(nonce [encryptionNonceSize]byte, index uint64) []byte {
+	low := binary.BigEndian.Uint64(nonce[4:])
+	binary.BigEndian.PutUint64(nonce[4:], low^index)
+	return nonce[:]
+}
+
+// EncryptionReader decrypts a segment stream written by an
+// EncryptionWriter. The stream is a sequence of records encrypted with
+// AES-256-GCM, each of them is authenticated when it is read.
+type EncryptionReader struct {
+	src       io.ReadSeekCloser
+	aead      cipher.AEAD
+	nonce     [encryptionNonceSize]byte
+	dataStart int64
+	keyID     encryptionKeyID
+
+	// record is the index of the next record to read.
+	record uint64
+
+	// buf holds the current record, plaintext the part of its decrypted
+	// data that hasn't been read yet.
+	buf       []byte
+	plaintext []byte
+}
+
+// NewEncryptionReader reads the key ID and nonce at the current position
+// of r and returns a reader decrypting the records that follow them. The
+// key used is looked up by ID in keys, ErrUnknownEncryptionKey is returned
+// if none of them matches.
+func NewEncryptionReader(r io.ReadSeekCloser, keys []EncryptionKey) (*EncryptionReader, error) {
+	er := &EncryptionReader{src: r}
+
+	if _, err := io.ReadFull(r, er.keyID[:]); err != nil {
+		return nil, fmt.Errorf("could not read encryption key ID: %w", err)
+	}
+	if _, err := io.ReadFull(r, er.nonce[:]); err != nil {
+		return nil, fmt.Errorf("could not read encryption nonce: %w", err)
+	}
+
+	var key *EncryptionKey
+	for i := range keys {
+		if keys[i].id == er.keyID {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w (key ID %v)", ErrUnknownEncryptionKey, er.keyID)
+	}
+
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+	er.aead = aead
+
+	er.dataStart, err = r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("could not get position of encrypted data: %w", err)
+	}
+	return er, nil
+}
+
+func (r *EncryptionReader) Read(buf []byte) (int, error) {
+	if len(r.plaintext) == 0 {
+		if err := r.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buf, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+// readRecord reads and decrypts the next record. io.EOF is returned if
+// the stream ends at a record boundary.
+func (r *EncryptionReader) readRecord() error {
+	length, err := r.readRecordLength()
+	if err != nil {
+		return err
+	}
+
+	size := encryptionRecordHeaderSize + int(length) + encryptionTagSize
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	record := r.buf[:size]
+	binary.LittleEndian.PutUint32(record, length)
+	if _, err := io.ReadFull(r.src, record[encryptionRecordHeaderSize:]); err != nil {
+		return fmt.Errorf("could not read encrypted record: %w", truncatedFrameError(err))
+	}
+
+	header := record[:encryptionRecordHeaderSize]
+	ciphertext := record[encryptionRecordHeaderSize:]
+	r.plaintext, err = r.aead.Open(ciphertext[:0], recordNonce(r.nonce, r.record), ciphertext, header)
+	if err != nil {
+		return fmt.Errorf("%w (record %d)", ErrEncryptionAuthentication, r.record)
+	}
+	r.record++
+	return nil
+}
+
+// readRecordLength reads the plaintext length of the next record.
+func (r *EncryptionReader) readRecordLength() (uint32, error) {
+	var header [encryptionRecordHeaderSize]byte
+	if _, err := io.ReadFull(r.src, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("could not read encrypted record header: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header[:])
+	if length == 0 || length > encryptionMaxRecordSize {
+		return 0, fmt.Errorf("%w: invalid record length %d (record %d)",
+			ErrEncryptionAuthentication, length, r.record)
+	}
+	return length, nil
+}
+
+// SeekData positions the reader at the given offset of the decrypted data.
+// The records before the offset are skipped using their length, the record
+// containing it is decrypted.
+func (r *EncryptionReader) SeekData(offset int64) error {
+	if offset < 0 {
+		return fmt.Errorf("illegal encrypted data offset %d", offset)
+	}
+	if _, err := r.src.Seek(r.dataStart, io.SeekStart); err != nil {
+		return err
+	}
+	r.record = 0
+	r.plaintext = nil
+
+	for offset > 0 {
+		length, err := r.readRecordLength()
+		if err != nil {
+			return truncatedFrameError(err)
+		}
+		if offset < int64(length) {
+			if _, err := r.src.Seek(-encryptionRecordHeaderSize, io.SeekCurrent); err != nil {
+				return err
+			}
+			if err := r.readRecord(); err != nil {
+				return err
+			}
+			r.plaintext = r.plaintext[offset:]
+			return nil
+		}
+		if _, err := r.src.Seek(int64(length)+encryptionTagSize, io.SeekCurrent); err != nil {
+			return err
+		}
+		offset -= int64(length)
+		r.record++
+	}
+	return nil
+}
+
+func (r *EncryptionReader) Close() error {
+	return r.src.Close()
+}
+
+// EncryptionWriter encrypts a segment stream with AES-256-GCM. The key ID
+// and a random nonce are written before the encrypted data. The data of
+// each Write call is sealed in its own records, each of them made of the
+// plaintext length, the ciphertext and the authentication tag, so the
+// frames written to a segment can be read back as soon as they are written.
+type EncryptionWriter struct {
+	dst    WriteCloseSyncer
+	aead   cipher.AEAD
+	nonce  [encryptionNonceSize]byte
+	record uint64
+	buf    []byte
+
+	// pending holds the records that could not be written by the last
+	// Write call, and pendingLength the length of the data they hold. They
+	// must not be sealed again when the caller retries the write.
+	pending       []byte
+	pendingLength int
+}
+
+// NewEncryptionWriter writes the key ID and a random nonce to w and
+// returns a writer encrypting everything written to it with key.
+func NewEncryptionWriter(w WriteCloseSyncer, key EncryptionKey) (*EncryptionWriter, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &EncryptionWriter{dst: w, aead: aead}
+	if _, err := rand.Read(ew.nonce[:]); err != nil {
+		return nil, fmt.Errorf("could not generate encryption nonce: %w", err)
+	}
+
+	if _, err := w.Write(key.id[:]); err != nil {
+		return nil, fmt.Errorf("could not write encryption key ID: %w", err)
+	}
+	if _, err := w.Write(ew.nonce[:]); err != nil {
+		return nil, fmt.Errorf("could not write encryption nonce: %w", err)
+	}
+	return ew, nil
+}
+
+// Write encrypts p and writes it to the destination. If a previous call
+// returned an error, p is expected to start with the same data, as done
+// by callbackRetryWriter.
+func (w *EncryptionWriter) Write(p []byte) (int, error) {
+	if w.pendingLength > 0 {
+		if len(p) < w.pendingLength {
+			return 0, errors.New("encrypted write retried with less data than pending")
+		}
+		n, err := w.dst.Write(w.pending)
+		w.pending = w.pending[n:]
+		if err != nil && len(w.pending) > 0 {
+			return 0, err
+		}
+		written := w.pendingLength
+		w.pending = nil
+		w.pendingLength = 0
+		if err != nil || written == len(p) {
+			return written, err
+		}
+		n, err = w.Write(p[written:])
+		return written + n, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	w.buf = w.buf[:0]
+	for data := p; len(data) > 0; {
+		length := min(len(data), encryptionMaxRecordSize)
+		w.buf = w.seal(w.buf, data[:length])
+		data = data[length:]
+	}
+
+	n, err := w.dst.Write(w.buf)
+	if n < len(w.buf) {
+		w.pending = append([]byte(nil), w.buf[n:]...)
+		w.pendingLength = len(p)
+		return 0, err
+	}
+	return len(p), err
+}
+
+// seal appends the record holding data to dst.
+func (w *EncryptionWriter) seal(dst []byte, data []byte) []byte {
+	var header [encryptionRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(data)))
+	dst = append(dst, header[:]...)
+	dst = w.aead.Seal(dst, recordNonce(w.nonce, w.record), data, header[:])
+	w.record++
+	return dst
+}
+
+func (w *EncryptionWriter) Close() error {
+	return w.dst.Close()
+}
+
+func (w *EncryptionWriter) Sync() error {
+	return w.dst.Sync()
+}
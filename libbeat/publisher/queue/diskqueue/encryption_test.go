@@ -0,0 +1,348 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func testEncryptionKey(t *testing.T, secret string) *EncryptionKey {
+	key, err := NewEncryptionKey(secret)
+	require.NoError(t, err)
+	return &key
+}
+
+func TestEncryptionKey(t *testing.T) {
+	k1 := testEncryptionKey(t, "first-secret-key")
+	k2 := testEncryptionKey(t, "first-secret-key")
+	k3 := testEncryptionKey(t, "second-secret-key")
+
+	assert.Equal(t, k1.ID(), k2.ID(), "the same secret must derive the same key")
+	assert.NotEqual(t, k1.ID(), k3.ID())
+	assert.Len(t, k1.key, encryptionKeySize)
+
+	_, err := NewEncryptionKey("")
+	assert.Error(t, err)
+}
+
+type seekableBuffer struct {
+	*bytes.Reader
+}
+
+func (seekableBuffer) Close() error { return nil }
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := testEncryptionKey(t, "test-encryption-key")
+	plaintext := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 10)
+
+	var dst bytes.Buffer
+	ew, err := NewEncryptionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), *key)
+	require.NoError(t, err)
+	_, err = ew.Write(plaintext[:17])
+	require.NoError(t, err)
+	_, err = ew.Write(plaintext[17:])
+	require.NoError(t, err)
+
+	ciphertext := dst.Bytes()
+	recordOverhead := encryptionRecordHeaderSize + encryptionTagSize
+	require.Len(t, ciphertext, encryptionHeaderSize+2*recordOverhead+len(plaintext))
+	assert.False(t, bytes.Contains(ciphertext, plaintext[:16]), "data must not be written in clear")
+
+	er, err := NewEncryptionReader(seekableBuffer{bytes.NewReader(ciphertext)}, []EncryptionKey{*key})
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(er)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	for _, offset := range []int64{0, 1, 15, 16, 17, 100, int64(len(plaintext)) - 1} {
+		require.NoError(t, er.SeekData(offset))
+		rest, err := io.ReadAll(er)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext[offset:], rest, "offset %d", offset)
+	}
+}
+
+func TestEncryptionReaderTampering(t *testing.T) {
+	key := testEncryptionKey(t, "test-encryption-key")
+
+	var dst bytes.Buffer
+	ew, err := NewEncryptionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), *key)
+	require.NoError(t, err)
+	for _, data := range []string{"first record", "second record"} {
+		_, err = ew.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	firstRecordEnd := encryptionHeaderSize + encryptionRecordHeaderSize + len("first record") + encryptionTagSize
+
+	testCases := map[string]func(data []byte) []byte{
+		"flipped bit": func(data []byte) []byte {
+			data[encryptionHeaderSize+encryptionRecordHeaderSize] ^= 1
+			return data
+		},
+		"modified length": func(data []byte) []byte {
+			data[encryptionHeaderSize]++
+			return data
+		},
+		"swapped records": func(data []byte) []byte {
+			first := append([]byte(nil), data[encryptionHeaderSize:firstRecordEnd]...)
+			second := append([]byte(nil), data[firstRecordEnd:]...)
+			return append(append(data[:encryptionHeaderSize], second...), first...)
+		},
+	}
+	for name, tamper := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := tamper(bytes.Clone(dst.Bytes()))
+			er, err := NewEncryptionReader(seekableBuffer{bytes.NewReader(data)}, []EncryptionKey{*key})
+			require.NoError(t, err)
+			_, err = io.ReadAll(er)
+			assert.ErrorIs(t, err, ErrEncryptionAuthentication)
+		})
+	}
+}
+
+func TestEncryptionReaderUnknownKey(t *testing.T) {
+	var dst bytes.Buffer
+	_, err := NewEncryptionWriter(NopWriteCloseSyncer(NopWriteCloser(&dst)), *testEncryptionKey(t, "test-encryption-key"))
+	require.NoError(t, err)
+
+	_, err = NewEncryptionReader(
+		seekableBuffer{bytes.NewReader(dst.Bytes())},
+		[]EncryptionKey{*testEncryptionKey(t, "another-encryption-key")})
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+}
+
+type failingWriter struct {
+	bytes.Buffer
+	failAfter int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.failAfter >= 0 && len(p) > w.failAfter {
+		n, _ := w.Buffer.Write(p[:w.failAfter])
+		w.failAfter = -1
+		return n, errors.New("short write")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestEncryptionWriterRetry(t *testing.T) {
+	key := testEncryptionKey(t, "test-encryption-key")
+	plaintext := []byte("some data that is written in two attempts")
+
+	dst := &failingWriter{failAfter: -1}
+	ew, err := NewEncryptionWriter(NopWriteCloseSyncer(NopWriteCloser(dst)), *key)
+	require.NoError(t, err)
+
+	dst.failAfter = 5
+	n, err := ew.Write(plaintext)
+	require.Error(t, err)
+	// callbackRetryWriter retries with the data that was not written
+	_, err = ew.Write(plaintext[n:])
+	require.NoError(t, err)
+
+	er, err := NewEncryptionReader(seekableBuffer{bytes.NewReader(dst.Bytes())}, []EncryptionKey{*key})
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(er)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := testEncryptionKey(t, "old-encryption-key")
+	newKey := testEncryptionKey(t, "new-encryption-key")
+
+	settings := DefaultSettings()
+	settings.Path = dir
+	settings.EncryptionKey = oldKey
+	oldSegment := &queueSegment{id: 0}
+	writeTestSegment(t, oldSegment, settings, []byte("old data"))
+
+	// After the rotation new segments use the new key, the old one is
+	// only needed for reading.
+	settings.EncryptionKey = newKey
+	settings.PreviousEncryptionKeys = []EncryptionKey{*oldKey}
+	newSegment := &queueSegment{id: 1}
+	writeTestSegment(t, newSegment, settings, []byte("new data"))
+
+	assert.Equal(t, []byte("old data"), readTestSegment(t, oldSegment, settings))
+	assert.Equal(t, []byte("new data"), readTestSegment(t, newSegment, settings))
+
+	settings.PreviousEncryptionKeys = nil
+	_, err := oldSegment.getReader(settings)
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+}
+
+func TestScanEncryptedSegmentWithoutFrameCount(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	settings.EncryptionKey = testEncryptionKey(t, "test-encryption-key")
+
+	// Write two frames without updating the frame count in the header,
+	// as happens when a segment is not closed cleanly.
+	segment := &queueSegment{id: 0}
+	sw, err := segment.getWriter(settings)
+	require.NoError(t, err)
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		_, err = sw.Write(testFrame(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, sw.Close())
+
+	segments, _, err := scanExistingSegments(logptest.NewTestingLogger(t, ""), settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, uint32(2), segments[0].frameCount)
+}
+
+func TestScanSegmentWithUnknownKeyAfterUncleanShutdown(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	settings.EncryptionKey = testEncryptionKey(t, "old-encryption-key")
+
+	// The frame count of the last segment isn't updated, as happens when
+	// the queue is not closed cleanly.
+	writeTestSegment(t, &queueSegment{id: 0}, settings, testFrame([]byte("first")))
+	sw, err := (&queueSegment{id: 1}).getWriter(settings)
+	require.NoError(t, err)
+	_, err = sw.Write(testFrame([]byte("second")))
+	require.NoError(t, err)
+	require.NoError(t, sw.Close())
+	segmentPath := settings.segmentPath(1)
+	before, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+
+	// The key is rotated without listing the old one in previous_keys.
+	settings.EncryptionKey = testEncryptionKey(t, "new-encryption-key")
+
+	logger := logptest.NewTestingLogger(t, "")
+	_, _, err = scanExistingSegments(logger, settings)
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+	_, err = NewQueue(logger, nil, settings, nil)
+	assert.ErrorIs(t, err, ErrUnknownEncryptionKey)
+
+	after, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "the segment must not be overwritten")
+}
+
+func TestScanSegmentsSkipsUnreadableIDs(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	writeTestSegment(t, &queueSegment{id: 0}, settings, testFrame([]byte("data")))
+	// A segment with a truncated header can't be loaded, but its ID must
+	// not be reused.
+	require.NoError(t, os.WriteFile(settings.segmentPath(3), []byte{2, 0}, 0600))
+
+	segments, nextID, err := scanExistingSegments(logptest.NewTestingLogger(t, ""), settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.Equal(t, segmentID(4), nextID)
+}
+
+func TestEncryptionUserConfig(t *testing.T) {
+	testCases := map[string]struct {
+		encryption     map[string]interface{}
+		expectEncrypt  bool
+		expectKeyCount int
+		wantErr        bool
+	}{
+		"key": {
+			encryption:     map[string]interface{}{"key": "a-long-enough-key"},
+			expectEncrypt:  true,
+			expectKeyCount: 1,
+		},
+		"key and previous keys": {
+			encryption: map[string]interface{}{
+				"key":           "a-long-enough-key",
+				"previous_keys": []string{"the-old-encryption-key"},
+			},
+			expectEncrypt:  true,
+			expectKeyCount: 2,
+		},
+		"previous keys only": {
+			encryption:     map[string]interface{}{"previous_keys": []string{"the-old-encryption-key"}},
+			expectEncrypt:  false,
+			expectKeyCount: 1,
+		},
+		"short key": {
+			encryption: map[string]interface{}{"key": "short"},
+			wantErr:    true,
+		},
+		"no keys": {
+			encryption: map[string]interface{}{},
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(map[string]interface{}{
+				"max_size":   "1GB",
+				"encryption": tc.encryption,
+			})
+			settings, err := SettingsForUserConfig(cfg)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectEncrypt, settings.EncryptionKey != nil)
+			assert.Len(t, settings.decryptionKeys(), tc.expectKeyCount)
+		})
+	}
+}
+
+func writeTestSegment(t *testing.T, segment *queueSegment, settings Settings, data []byte) {
+	sw, err := segment.getWriter(settings)
+	require.NoError(t, err)
+	_, err = sw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, sw.Close())
+}
+
+func readTestSegment(t *testing.T, segment *queueSegment, settings Settings) []byte {
+	sr, err := segment.getReader(settings)
+	require.NoError(t, err)
+	defer sr.Close()
+	data, err := io.ReadAll(sr)
+	require.NoError(t, err)
+	return data
+}
+
+// testFrame returns the on-disk representation of a frame with the given data.
+func testFrame(data []byte) []byte {
+	var buf bytes.Buffer
+	frameLength := uint32(len(data) + frameMetadataSize)
+	_ = binary.Write(&buf, binary.LittleEndian, frameLength)
+	buf.Write(data)
+	_ = binary.Write(&buf, binary.LittleEndian, computeChecksum(data))
+	_ = binary.Write(&buf, binary.LittleEndian, frameLength)
+	return buf.Bytes()
+}
@@ -0,0 +1,309 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+// SegmentInfo describes a segment file of a disk queue, as reported by
+// InspectSegments.
+type SegmentInfo struct {
+	// ID is the segment ID, taken from the segment file name.
+	ID uint64
+
+	// Path is the full path of the segment file.
+	Path string
+
+	// Size is the size of the segment file on disk, in bytes.
+	Size int64
+
+	// Version is the schema version recorded in the segment header.
+	Version uint32
+
+	// FrameCount is the number of frames in the segment. If the header
+	// doesn't record it, the segment is scanned to count them.
+	FrameCount uint32
+
+	// Compression is the codec the segment data is compressed with.
+	Compression CompressionCodec
+
+	// Encrypted is true if the segment data is encrypted.
+	Encrypted bool
+
+	// KeyID is the ID of the key the segment was encrypted with, empty if
+	// the segment isn't encrypted.
+	KeyID string
+
+	// KeyAvailable is true if the key the segment was encrypted with is
+	// one of the configured encryption keys.
+	KeyAvailable bool
+
+	// Err is set if the segment couldn't be read completely.
+	Err error
+}
+
+// InspectSegments reads the headers of all the segment files in the queue
+// directory given by settings, and returns them ordered by segment ID.
+// Segments that can't be read are reported with Err set rather than
+// failing the whole inspection.
+func InspectSegments(settings Settings) ([]SegmentInfo, error) {
+	pathStr := settings.directoryPath()
+	dirEntries, err := os.ReadDir(pathStr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read queue directory '%s': %w", pathStr, err)
+	}
+
+	segments := []SegmentInfo{}
+	for _, dirEntry := range dirEntries {
+		components := strings.Split(dirEntry.Name(), ".")
+		if len(components) != 2 || strings.ToLower(components[1]) != "seg" {
+			continue
+		}
+		id, err := strconv.ParseUint(components[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments,
+			inspectSegment(settings, id, path.Join(pathStr, dirEntry.Name())))
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].ID < segments[j].ID
+	})
+	return segments, nil
+}
+
+func inspectSegment(settings Settings, id uint64, path string) SegmentInfo {
+	info := SegmentInfo{ID: id, Path: path}
+
+	file, err := os.Open(path)
+	if err != nil {
+		info.Err = err
+		return info
+	}
+	defer file.Close()
+
+	if stat, err := file.Stat(); err == nil {
+		info.Size = stat.Size()
+	}
+
+	header, err := readSegmentHeader(file)
+	if err != nil {
+		info.Err = err
+		return info
+	}
+	info.Version = header.version
+	info.FrameCount = header.frameCount
+	info.Compression, err = header.compressionCodec()
+	if err != nil {
+		info.Err = err
+		return info
+	}
+	info.Encrypted, err = header.encrypted()
+	if err != nil {
+		info.Err = err
+		return info
+	}
+
+	if info.Encrypted {
+		var keyID encryptionKeyID
+		if _, err := io.ReadFull(file, keyID[:]); err != nil {
+			info.Err = fmt.Errorf("could not read encryption key ID: %w", err)
+			return info
+		}
+		info.KeyID = keyID.String()
+		for _, key := range settings.decryptionKeys() {
+			if key.id == keyID {
+				info.KeyAvailable = true
+				break
+			}
+		}
+	}
+
+	if header.frameCount == 0 {
+		// The segment wasn't closed cleanly, count its frames.
+		_, check, err := scanSegment(settings, path, false, nil)
+		if err != nil {
+			info.Err = err
+			return info
+		}
+		info.FrameCount = uint32(check.ValidFrames)
+		info.Err = check.Err
+	}
+	return info
+}
+
+// QueuePosition is the position of the oldest event that hasn't been
+// acknowledged, as recorded in the queue state file.
+type QueuePosition struct {
+	// SegmentID is the segment containing the event.
+	SegmentID uint64
+
+	// ByteIndex is the logical offset of the event in the segment, as if
+	// the segment was neither encrypted nor compressed. 0 means the first
+	// event of the segment.
+	ByteIndex uint64
+
+	// FrameIndex is the index of the event in the segment.
+	FrameIndex uint64
+}
+
+// ReadQueuePosition reads the ACK position from the state file of the
+// queue. If the queue has no state file, the returned error wraps
+// os.ErrNotExist.
+func ReadQueuePosition(settings Settings) (QueuePosition, error) {
+	position, err := queuePositionFromPath(settings.stateFilePath())
+	if err != nil {
+		return QueuePosition{}, err
+	}
+	return QueuePosition{
+		SegmentID:  uint64(position.segmentID),
+		ByteIndex:  position.byteIndex,
+		FrameIndex: position.frameIndex,
+	}, nil
+}
+
+// Frame is a data frame read from a segment by ReadSegmentFrames.
+type Frame struct {
+	// Index is the index of the frame in the segment.
+	Index uint64
+
+	// Offset is the logical offset of the frame in the segment, as if the
+	// segment was neither encrypted nor compressed.
+	Offset uint64
+
+	// Size is the size of the frame, including its header and footer.
+	Size uint64
+
+	// Event is the event stored in the frame.
+	Event publisher.Event
+
+	// Err is set if the event couldn't be decoded. The frame itself is
+	// valid, so the following frames can still be read.
+	Err error
+}
+
+// SegmentCheck is the result of checking the frames of a segment with
+// CheckSegment.
+type SegmentCheck struct {
+	// ValidFrames is the number of frames before the first invalid one.
+	ValidFrames uint64
+
+	// ValidEnd is the logical offset right after the last valid frame.
+	ValidEnd uint64
+
+	// Err describes the first invalid frame, it is nil if the segment ends
+	// right after a valid frame.
+	Err error
+}
+
+// ReadSegmentFrames calls fn with each valid frame of the segment with the
+// given ID, in order. Reading stops at the first invalid frame, for example
+// a truncated frame or one with a bad checksum, and the returned
+// SegmentCheck describes it. The returned error is set if the segment
+// couldn't be opened or fn returned an error.
+func ReadSegmentFrames(settings Settings, id uint64, fn func(Frame) error) (SegmentCheck, error) {
+	_, check, err := scanSegment(settings, settings.segmentPath(segmentID(id)), true, fn)
+	return check, err
+}
+
+// CheckSegment checks the frames of the segment with the given ID, without
+// decoding the events.
+func CheckSegment(settings Settings, id uint64) (SegmentCheck, error) {
+	_, check, err := scanSegment(settings, settings.segmentPath(segmentID(id)), false, nil)
+	return check, err
+}
+
+// scanSegment reads the frames of the segment at path, calling fn with
+// each of them if it is not nil. Events are only decoded if decode is
+// true. The segment header is returned along with the result of the scan.
+func scanSegment(
+	settings Settings, path string, decode bool, fn func(Frame) error,
+) (*segmentHeader, SegmentCheck, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, SegmentCheck{}, fmt.Errorf("couldn't open segment file '%s': %w", path, err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, SegmentCheck{}, fmt.Errorf("couldn't stat segment file '%s': %w", path, err)
+	}
+	header, err := readSegmentHeader(autoRetryReader{file})
+	if err != nil {
+		file.Close()
+		return nil, SegmentCheck{}, err
+	}
+	sr, err := newSegmentReader(file, header, settings)
+	if err != nil {
+		file.Close()
+		return header, SegmentCheck{}, err
+	}
+	defer sr.Close()
+
+	decoder := newEventDecoder()
+	decoder.serializationFormat = sr.serializationFormat
+	// Corrupted lengths are bounded to avoid huge allocations. Frames can't
+	// be larger than a segment, but the segment may have been written with
+	// a bigger segment size, so the file size is used if it is larger.
+	maxLength := max(settings.maxValidFrameSize(), uint64(stat.Size()))
+
+	reader := autoRetryReader{sr}
+	check := SegmentCheck{
+		ValidEnd: (&queueSegment{schemaVersion: &header.version}).headerSize(),
+	}
+	for {
+		frameLength, err := readFrameData(reader, decoder, maxLength)
+		if err != nil {
+			// EOF at a frame boundary means we successfully read all frames.
+			if !errors.Is(err, io.EOF) {
+				check.Err = err
+			}
+			return header, check, nil
+		}
+		if fn != nil {
+			frame := Frame{
+				Index:  check.ValidFrames,
+				Offset: check.ValidEnd,
+				Size:   uint64(frameLength),
+			}
+			if decode {
+				event, err := decoder.Decode()
+				if err != nil {
+					frame.Err = fmt.Errorf("couldn't decode data frame: %w", err)
+				} else if e, ok := event.(publisher.Event); ok {
+					frame.Event = e
+				}
+			}
+			if err := fn(frame); err != nil {
+				return header, check, err
+			}
+		}
+		check.ValidFrames++
+		check.ValidEnd += uint64(frameLength)
+	}
+}
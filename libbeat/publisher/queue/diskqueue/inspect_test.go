@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectSegments(t *testing.T) {
+	oldKey := testEncryptionKey(t, "old-encryption-key")
+	newKey := testEncryptionKey(t, "new-encryption-key")
+
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	plain := &queueSegment{id: 0}
+	writeTestSegment(t, plain, settings, testFrame([]byte("plain")))
+
+	settings.EncryptionKey = oldKey
+	encrypted := &queueSegment{id: 1}
+	writeTestSegment(t, encrypted, settings, testFrame([]byte("encrypted")))
+
+	settings.EncryptionKey = newKey
+	segments, err := InspectSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+
+	assert.Equal(t, uint64(0), segments[0].ID)
+	assert.Equal(t, uint32(currentSegmentVersion), segments[0].Version)
+	assert.Equal(t, uint32(1), segments[0].FrameCount)
+	assert.False(t, segments[0].Encrypted)
+	assert.Empty(t, segments[0].KeyID)
+	assert.NoError(t, segments[0].Err)
+
+	assert.Equal(t, uint64(1), segments[1].ID)
+	assert.True(t, segments[1].Encrypted)
+	assert.Equal(t, oldKey.ID(), segments[1].KeyID)
+	assert.False(t, segments[1].KeyAvailable)
+	assert.Positive(t, segments[1].Size)
+
+	settings.PreviousEncryptionKeys = []EncryptionKey{*oldKey}
+	segments, err = InspectSegments(settings)
+	require.NoError(t, err)
+	assert.True(t, segments[1].KeyAvailable)
+	assert.Equal(t, uint32(1), segments[1].FrameCount)
+	assert.NoError(t, segments[1].Err)
+}
@@ -161,17 +161,12 @@ func NewQueue(
 	}
 
 	// Index any existing data segments to be placed in segments.reading.
-	initialSegments, err :=
-		scanExistingSegments(logger, settings.directoryPath())
+	// nextSegmentID is the first ID after the existing segment files.
+	initialSegments, nextSegmentID, err :=
+		scanExistingSegments(logger, settings)
 	if err != nil {
 		return nil, err
 	}
-	var nextSegmentID segmentID
-	if len(initialSegments) > 0 {
-		// Initialize nextSegmentID to the first ID after the existing segments.
-		lastID := initialSegments[len(initialSegments)-1].id
-		nextSegmentID = lastID + 1
-	}
 	// Check the initial contents to report to the metrics observer.
 	initialEventCount := 0
 	initialByteCount := 0
@@ -69,14 +69,21 @@ func TestProduceConsumer(t *testing.T) {
 		}
 	}
 
-	t.Run("direct", testWith(makeTestQueue()))
+	t.Run("direct", testWith(makeTestQueue(nil)))
+	t.Run("encrypted", testWith(makeTestQueue(func(s *Settings) {
+		key, _ := NewEncryptionKey("test-encryption-key")
+		s.EncryptionKey = &key
+	})))
 }
 
-func makeTestQueue() queuetest.QueueFactory {
+func makeTestQueue(configure func(*Settings)) queuetest.QueueFactory {
 	return func(t *testing.T) queue.Queue {
 		dir := t.TempDir()
 		settings := DefaultSettings()
 		settings.Path = dir
+		if configure != nil {
+			configure(&settings)
+		}
 		logger := logptest.NewTestingLogger(t, "")
 		queue, _ := NewQueue(logger, nil, settings, nil)
 		return testQueue{
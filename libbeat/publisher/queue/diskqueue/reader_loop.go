@@ -19,6 +19,7 @@ package diskqueue
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
@@ -186,79 +187,100 @@ func (rl *readerLoop) processRequest(request readerLoopRequest) readerLoopRespon
 // segment and frame IDs unset.
 // The returned error will be set if and only if the returned frame is nil.
 func (rl *readerLoop) nextFrame(handle *segmentReader, maxLength uint64) (*readFrame, error) {
+	// Wrap the handle to retry non-fatal errors and always return the full
+	// requested data length if possible.
+	frameLength, err := readFrameData(autoRetryReader{handle}, rl.decoder, maxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := rl.decoder.Decode()
+	if err != nil {
+		// Unlike errors in the segment or frame metadata, this is entirely
+		// a problem in the event [de]serialization which may be isolated (i.e.
+		// may not indicate data corruption in the segment).
+		// TODO: Rather than pass this error back to the read request, which
+		// discards the rest of the segment, we should just log the error and
+		// advance to the next frame, which is likely still valid.
+		return nil, fmt.Errorf("couldn't decode data frame: %w", err)
+	}
+
+	frame := &readFrame{
+		event:       event,
+		bytesOnDisk: uint64(frameLength),
+	}
+
+	return frame, nil
+}
+
+// readFrameData reads one frame from the given reader into the decoder
+// buffer, as long as it does not exceed the given length bound, and checks
+// its footer. It returns the length of the frame including its header and
+// footer. If the reader is at the end of the segment, the returned error
+// wraps io.EOF.
+func readFrameData(reader io.Reader, decoder *eventDecoder, maxLength uint64) (uint32, error) {
 	// Ensure we are allowed to read the frame header.
 	if maxLength < frameHeaderSize {
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"can't read next frame: remaining length %d is too low", maxLength)
 	}
-	// Wrap the handle to retry non-fatal errors and always return the full
-	// requested data length if possible.
-	reader := autoRetryReader{handle}
 	var frameLength uint32
 	err := binary.Read(reader, binary.LittleEndian, &frameLength)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't read data frame header: %w", err)
+		return 0, fmt.Errorf("couldn't read data frame header: %w", err)
 	}
 
 	// If the frame extends past the area we were told to read, return an error.
 	// This should never happen unless the segment file is corrupted.
 	if maxLength < uint64(frameLength) {
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"can't read next frame: frame size is %d but remaining data is only %d",
 			frameLength, maxLength)
 	}
 	if frameLength <= frameMetadataSize {
 		// Valid enqueued data must have positive length
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"data frame with no data (length %d)", frameLength)
 	}
 
 	// Read the actual frame data
 	dataLength := frameLength - frameMetadataSize
-	bytes := rl.decoder.Buffer(int(dataLength))
+	bytes := decoder.Buffer(int(dataLength))
 	_, err = reader.Read(bytes)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't read data frame content: %w", err)
+		return 0, fmt.Errorf("couldn't read data frame content: %w", truncatedFrameError(err))
 	}
 
 	// Read the footer (checksum + duplicate length)
 	var checksum uint32
 	err = binary.Read(reader, binary.LittleEndian, &checksum)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't read data frame checksum: %w", err)
+		return 0, fmt.Errorf("couldn't read data frame checksum: %w", truncatedFrameError(err))
 	}
 	expected := computeChecksum(bytes)
 	if checksum != expected {
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"data frame checksum mismatch (%x != %x)", checksum, expected)
 	}
 
 	var duplicateLength uint32
 	err = binary.Read(reader, binary.LittleEndian, &duplicateLength)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't read data frame footer: %w", err)
+		return 0, fmt.Errorf("couldn't read data frame footer: %w", truncatedFrameError(err))
 	}
 	if duplicateLength != frameLength {
-		return nil, fmt.Errorf(
+		return 0, fmt.Errorf(
 			"inconsistent data frame length (%d vs %d)",
 			frameLength, duplicateLength)
 	}
+	return frameLength, nil
+}
 
-	event, err := rl.decoder.Decode()
-	if err != nil {
-		// Unlike errors in the segment or frame metadata, this is entirely
-		// a problem in the event [de]serialization which may be isolated (i.e.
-		// may not indicate data corruption in the segment).
-		// TODO: Rather than pass this error back to the read request, which
-		// discards the rest of the segment, we should just log the error and
-		// advance to the next frame, which is likely still valid.
-		return nil, fmt.Errorf("couldn't decode data frame: %w", err)
-	}
-
-	frame := &readFrame{
-		event:       event,
-		bytesOnDisk: uint64(frameLength),
+// truncatedFrameError converts io.EOF to io.ErrUnexpectedEOF, since the
+// segment ending after a frame header means the frame is truncated.
+func truncatedFrameError(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
 	}
-
-	return frame, nil
+	return err
 }
@@ -0,0 +1,294 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RepairAction is the change made to a segment by RepairQueue.
+type RepairAction string
+
+const (
+	// RepairNone means the segment was valid and left untouched.
+	RepairNone RepairAction = "none"
+
+	// RepairFrameCount means the frames were valid but the frame count in
+	// the segment header was wrong, usually because the segment wasn't
+	// closed cleanly, and it was updated.
+	RepairFrameCount RepairAction = "frame count updated"
+
+	// RepairTruncated means the segment was truncated after its last
+	// valid frame.
+	RepairTruncated RepairAction = "truncated"
+
+	// RepairRewritten means the valid frames of a compressed segment were
+	// written to a new segment file replacing it, since compressed data
+	// can't be truncated.
+	RepairRewritten RepairAction = "rewritten"
+
+	// RepairRemoved means the segment had no valid frames and was removed.
+	RepairRemoved RepairAction = "removed"
+)
+
+// SegmentRepair describes the changes made to a segment by RepairQueue.
+type SegmentRepair struct {
+	// ID is the segment ID.
+	ID uint64
+
+	// Action is the change made to the segment.
+	Action RepairAction
+
+	// Frames is the number of frames left in the segment.
+	Frames uint64
+
+	// Err describes the invalid data that was removed from the segment, if
+	// any.
+	Err error
+}
+
+// RepairResult describes the changes made to a queue by RepairQueue.
+type RepairResult struct {
+	// Segments lists the segments of the queue, ordered by ID.
+	Segments []SegmentRepair
+
+	// Position is the ACK position written to the state file, nil if the
+	// state file was left untouched.
+	Position *QueuePosition
+}
+
+// RepairQueue truncates the segments of the queue after their last valid
+// frame and removes the segments without valid frames. If the ACK
+// position in the state file points to data that was removed, or the
+// state file can't be read, the state file is rewritten to point to the
+// first remaining event. RepairQueue must not be used while the queue is
+// open.
+func RepairQueue(settings Settings) (RepairResult, error) {
+	segments, err := InspectSegments(settings)
+	if err != nil {
+		return RepairResult{}, err
+	}
+
+	result := RepairResult{}
+	checks := map[uint64]SegmentCheck{}
+	var kept []uint64
+	for _, info := range segments {
+		repair, check, err := repairSegment(settings, info.ID, info.Path)
+		if err != nil {
+			return result, fmt.Errorf("couldn't repair segment %d: %w", info.ID, err)
+		}
+		result.Segments = append(result.Segments, repair)
+		if repair.Action != RepairRemoved {
+			checks[info.ID] = check
+			kept = append(kept, info.ID)
+		}
+	}
+
+	result.Position, err = repairQueuePosition(settings, kept, checks)
+	return result, err
+}
+
+func repairSegment(settings Settings, id uint64, path string) (SegmentRepair, SegmentCheck, error) {
+	repair := SegmentRepair{ID: id, Action: RepairNone}
+	header, check, err := scanSegment(settings, path, false, nil)
+	if err != nil {
+		if header != nil || !(errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			// Other errors, like an unknown encryption key, don't mean the
+			// segment is corrupted.
+			return repair, check, err
+		}
+		// The header itself is truncated.
+		repair.Err = err
+		repair.Action = RepairRemoved
+		return repair, check, os.Remove(path)
+	}
+	repair.Frames = check.ValidFrames
+	repair.Err = check.Err
+
+	if check.ValidFrames == 0 {
+		repair.Action = RepairRemoved
+		return repair, check, os.Remove(path)
+	}
+
+	if check.Err == nil {
+		// Segments written by schema version 0 don't have a frame count.
+		if header.version >= 1 && uint64(header.frameCount) != check.ValidFrames {
+			repair.Action = RepairFrameCount
+			return repair, check, writeSegmentFrameCount(path, uint32(check.ValidFrames))
+		}
+		return repair, check, nil
+	}
+
+	codec, err := header.compressionCodec()
+	if err != nil {
+		return repair, check, err
+	}
+	encrypted, err := header.encrypted()
+	if err != nil {
+		return repair, check, err
+	}
+	if codec != CompressionNone || encrypted {
+		// The logical offset of the data can't be mapped to an offset in
+		// the file, so the valid frames are copied to a new segment.
+		repair.Action = RepairRewritten
+		return repair, check, rewriteSegment(settings, path, header, check)
+	}
+
+	repair.Action = RepairTruncated
+	if err := os.Truncate(path, int64(check.ValidEnd)); err != nil {
+		return repair, check, fmt.Errorf("couldn't truncate segment file: %w", err)
+	}
+	if header.version >= 1 {
+		return repair, check, writeSegmentFrameCount(path, uint32(check.ValidFrames))
+	}
+	return repair, check, nil
+}
+
+// writeSegmentFrameCount updates the frame count in the header of the
+// segment file at path.
+func writeSegmentFrameCount(path string, count uint32) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't open segment file: %w", err)
+	}
+	defer file.Close()
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], count)
+	if _, err := file.WriteAt(buf[:], 4); err != nil {
+		return fmt.Errorf("couldn't write frame count: %w", err)
+	}
+	return file.Sync()
+}
+
+// rewriteSegment replaces the segment file at path with a new one, with
+// the same options, holding the valid frames of the segment.
+func rewriteSegment(settings Settings, path string, header *segmentHeader, check SegmentCheck) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open segment file: %w", err)
+	}
+	if _, err := readSegmentHeader(file); err != nil {
+		file.Close()
+		return err
+	}
+	sr, err := newSegmentReader(file, header, settings)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	defer sr.Close()
+
+	tmpPath := path + ".repair"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't create segment file: %w", err)
+	}
+	sw, err := newSegmentWriter(out, header.options, settings)
+	if err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dataLength := int64(check.ValidEnd - segmentHeaderSize)
+	if _, err := io.CopyN(sw, autoRetryReader{sr}, dataLength); err != nil {
+		sw.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("couldn't copy valid frames: %w", err)
+	}
+	err = sw.Sync()
+	if err == nil {
+		err = sw.UpdateCount(uint32(check.ValidFrames))
+	}
+	if closeErr := sw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("couldn't write segment file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// repairQueuePosition rewrites the state file if it can't be read or its
+// position points to data that was removed. kept lists the IDs of the
+// remaining segments in order, and checks their frames.
+func repairQueuePosition(
+	settings Settings, kept []uint64, checks map[uint64]SegmentCheck,
+) (*QueuePosition, error) {
+	position, err := queuePositionFromPath(settings.stateFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	var repaired queuePosition
+	if err != nil {
+		// Restart from the oldest remaining segment.
+		if len(kept) > 0 {
+			repaired.segmentID = segmentID(kept[0])
+		}
+	} else if check, ok := checks[uint64(position.segmentID)]; ok {
+		if position.frameIndex <= check.ValidFrames && position.byteIndex <= check.ValidEnd {
+			return nil, nil
+		}
+		// The position is past the end of the valid frames, so all the
+		// remaining frames of the segment were acknowledged.
+		repaired = queuePosition{
+			segmentID:  position.segmentID,
+			byteIndex:  check.ValidEnd,
+			frameIndex: check.ValidFrames,
+		}
+	} else {
+		// The segment was removed, move to the next remaining one. If
+		// there is none, the remaining segments were all acknowledged and
+		// the position is left as is.
+		next := -1
+		for i, id := range kept {
+			if id > uint64(position.segmentID) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, nil
+		}
+		repaired.segmentID = segmentID(kept[next])
+	}
+
+	file, err := os.OpenFile(settings.stateFilePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open state file: %w", err)
+	}
+	defer file.Close()
+	if err := writeQueuePositionToHandle(file, repaired); err != nil {
+		return nil, fmt.Errorf("couldn't write state file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("couldn't sync state file: %w", err)
+	}
+	return &QueuePosition{
+		SegmentID:  uint64(repaired.segmentID),
+		ByteIndex:  repaired.byteIndex,
+		FrameIndex: repaired.frameIndex,
+	}, nil
+}
@@ -0,0 +1,196 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestReadSegmentFrames(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	data := append(eventFrame(t, "first"), eventFrame(t, "second")...)
+	// A valid frame holding a CBOR string instead of an event.
+	data = append(data, testFrame([]byte{0x63, 0x61, 0x62, 0x63})...)
+	writeTestSegment(t, &queueSegment{id: 0}, settings, data)
+
+	var frames []Frame
+	check, err := ReadSegmentFrames(settings, 0, func(frame Frame) error {
+		frames = append(frames, frame)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NoError(t, check.Err)
+	assert.Equal(t, uint64(3), check.ValidFrames)
+	assert.Equal(t, uint64(segmentHeaderSize+len(data)), check.ValidEnd)
+
+	require.Len(t, frames, 3)
+	assert.Equal(t, "first", frames[0].Event.Content.Fields["message"])
+	assert.Equal(t, uint64(segmentHeaderSize), frames[0].Offset)
+	assert.Equal(t, "second", frames[1].Event.Content.Fields["message"])
+	assert.Equal(t, uint64(1), frames[1].Index)
+	assert.Equal(t, frames[0].Offset+frames[0].Size, frames[1].Offset)
+	// A frame that can't be decoded doesn't stop the reading.
+	assert.Error(t, frames[2].Err)
+}
+
+func TestRepairQueue(t *testing.T) {
+	testCases := map[string]struct {
+		compression CompressionCodec
+		encrypt     bool
+		action      RepairAction
+	}{
+		"plain":      {action: RepairTruncated},
+		"encrypted":  {encrypt: true, action: RepairRewritten},
+		"lz4":        {compression: CompressionLZ4, action: RepairRewritten},
+		"zstd":       {compression: CompressionZSTD, action: RepairRewritten},
+		"zstd+crypt": {compression: CompressionZSTD, encrypt: true, action: RepairRewritten},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			settings := DefaultSettings()
+			settings.Path = t.TempDir()
+			settings.Compression = tc.compression
+			if tc.encrypt {
+				settings.EncryptionKey = testEncryptionKey(t, "test-encryption-key")
+			}
+
+			// A frame with a bad checksum follows two valid ones.
+			valid := append(eventFrame(t, "first"), eventFrame(t, "second")...)
+			corrupted := eventFrame(t, "third")
+			corrupted[len(corrupted)-5] ^= 0xff
+			writeTestSegment(t, &queueSegment{id: 0}, settings, append(valid, corrupted...))
+			// A segment without any valid frame.
+			writeTestSegment(t, &queueSegment{id: 1}, settings, corrupted)
+			// A valid segment that wasn't closed cleanly.
+			writeTestSegment(t, &queueSegment{id: 2}, settings, eventFrame(t, "fourth"))
+
+			// The ACK position is past the valid frames of segment 0.
+			writeTestQueuePosition(t, settings, queuePosition{
+				segmentID:  0,
+				byteIndex:  uint64(segmentHeaderSize + len(valid) + len(corrupted)),
+				frameIndex: 3,
+			})
+
+			result, err := RepairQueue(settings)
+			require.NoError(t, err)
+			require.Len(t, result.Segments, 3)
+
+			assert.Equal(t, tc.action, result.Segments[0].Action)
+			assert.Equal(t, uint64(2), result.Segments[0].Frames)
+			assert.Error(t, result.Segments[0].Err)
+			assert.Equal(t, RepairRemoved, result.Segments[1].Action)
+			assert.Equal(t, RepairFrameCount, result.Segments[2].Action)
+
+			require.NotNil(t, result.Position)
+			assert.Equal(t, QueuePosition{
+				SegmentID:  0,
+				ByteIndex:  uint64(segmentHeaderSize + len(valid)),
+				FrameIndex: 2,
+			}, *result.Position)
+			position, err := ReadQueuePosition(settings)
+			require.NoError(t, err)
+			assert.Equal(t, *result.Position, position)
+
+			// The repaired queue is valid.
+			segments, err := InspectSegments(settings)
+			require.NoError(t, err)
+			require.Len(t, segments, 2)
+			assert.Equal(t, uint32(2), segments[0].FrameCount)
+			assert.Equal(t, uint32(1), segments[1].FrameCount)
+			for _, segment := range segments {
+				check, err := CheckSegment(settings, segment.ID)
+				require.NoError(t, err)
+				assert.NoError(t, check.Err)
+			}
+			assert.Equal(t, valid, readTestSegment(t, &queueSegment{id: 0}, settings))
+
+			result, err = RepairQueue(settings)
+			require.NoError(t, err)
+			for _, segment := range result.Segments {
+				assert.Equal(t, RepairNone, segment.Action)
+			}
+			assert.Nil(t, result.Position)
+		})
+	}
+}
+
+func TestRepairQueueTruncatedFrame(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	valid := eventFrame(t, "first")
+	truncated := eventFrame(t, "second")
+	truncated = truncated[:len(truncated)-6]
+	writeTestSegment(t, &queueSegment{id: 0}, settings, append(valid, truncated...))
+
+	result, err := RepairQueue(settings)
+	require.NoError(t, err)
+	require.Len(t, result.Segments, 1)
+	assert.Equal(t, RepairTruncated, result.Segments[0].Action)
+	assert.Nil(t, result.Position, "no state file to repair")
+
+	stat, err := os.Stat(settings.segmentPath(0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(segmentHeaderSize+len(valid)), stat.Size())
+}
+
+func TestRepairQueueCorruptedStateFile(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	writeTestSegment(t, &queueSegment{id: 3}, settings, eventFrame(t, "first"))
+	writeTestSegment(t, &queueSegment{id: 4}, settings, eventFrame(t, "second"))
+	require.NoError(t, os.WriteFile(settings.stateFilePath(), []byte{0xff, 0xff, 0xff, 0xff}, 0600))
+
+	result, err := RepairQueue(settings)
+	require.NoError(t, err)
+	require.NotNil(t, result.Position)
+	assert.Equal(t, QueuePosition{SegmentID: 3}, *result.Position)
+}
+
+// eventFrame returns the on-disk representation of a frame holding an
+// event with the given message.
+func eventFrame(t *testing.T, message string) []byte {
+	data, err := newEventEncoder(SerializationCBOR).encode(publisher.Event{
+		Content: beat.Event{
+			Timestamp: time.Now(),
+			Fields:    mapstr.M{"message": message},
+		},
+	})
+	require.NoError(t, err)
+	return testFrame(data)
+}
+
+func writeTestQueuePosition(t *testing.T, settings Settings, position queuePosition) {
+	file, err := os.Create(settings.stateFilePath())
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, writeQueuePositionToHandle(file, position))
+}
@@ -149,12 +149,50 @@ const currentSegmentVersion = 2
 // version of the target segment.
 const segmentHeaderSize = 12
 
+// ENABLE_COMPRESSION selects LZ4 compression and ENABLE_ZSTD_COMPRESSION
+// selects Zstandard, so the codec of each segment is recorded in its
+// header and segments written with different settings can be read back.
+// ENABLE_ENCRYPTION is always combined with ENABLE_AES_GCM, which records
+// that the data is encrypted and authenticated with AES-256-GCM.
 const (
-	_                  uint32 = 1 << iota // 0x1
-	ENABLE_COMPRESSION                    // 0x2
-	ENABLE_PROTOBUF                       // 0x4
+	ENABLE_ENCRYPTION       uint32 = 1 << iota // 0x1
+	ENABLE_COMPRESSION                         // 0x2
+	ENABLE_PROTOBUF                            // 0x4
+	ENABLE_ZSTD_COMPRESSION                    // 0x8
+	ENABLE_AES_GCM                             // 0x10
 )
 
+// encrypted returns whether the segment data is encrypted. Segments with
+// ENABLE_ENCRYPTION but not ENABLE_AES_GCM were encrypted without
+// authentication and can't be read.
+func (header *segmentHeader) encrypted() (bool, error) {
+	encryption := (header.options & ENABLE_ENCRYPTION) == ENABLE_ENCRYPTION
+	gcm := (header.options & ENABLE_AES_GCM) == ENABLE_AES_GCM
+	switch {
+	case encryption && !gcm:
+		return false, errors.New("segment uses an unsupported unauthenticated encryption")
+	case gcm && !encryption:
+		return false, errors.New("segment options select AES-GCM without encryption")
+	}
+	return encryption, nil
+}
+
+// compressionCodec returns the compression codec recorded in the
+// segment options.
+func (header *segmentHeader) compressionCodec() (CompressionCodec, error) {
+	lz4 := (header.options & ENABLE_COMPRESSION) == ENABLE_COMPRESSION
+	zstd := (header.options & ENABLE_ZSTD_COMPRESSION) == ENABLE_ZSTD_COMPRESSION
+	switch {
+	case lz4 && zstd:
+		return "", errors.New("segment options select more than one compression codec")
+	case lz4:
+		return CompressionLZ4, nil
+	case zstd:
+		return CompressionZSTD, nil
+	}
+	return CompressionNone, nil
+}
+
 // Sort order: we store loaded segments in ascending order by their id.
 type bySegmentID []*queueSegment
 
@@ -163,14 +201,20 @@ func (s bySegmentID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s bySegmentID) Less(i, j int) bool { return s[i].id < s[j].id }
 
 // Scan the given path for segment files, and return them in a list
-// ordered by segment id.
-func scanExistingSegments(logger *logp.Logger, pathStr string) ([]*queueSegment, error) {
+// ordered by segment id, along with the first segment id that is not used
+// by any segment file, including the ones that couldn't be loaded, so
+// their files are never overwritten. An error is returned if a segment is
+// encrypted with a key that is not configured, since its data would
+// otherwise be silently dropped.
+func scanExistingSegments(logger *logp.Logger, settings Settings) ([]*queueSegment, segmentID, error) {
+	pathStr := settings.directoryPath()
 	dirEntries, err := os.ReadDir(pathStr)
 	if err != nil {
-		return nil, fmt.Errorf("could not read queue directory '%s': %w", pathStr, err)
+		return nil, 0, fmt.Errorf("could not read queue directory '%s': %w", pathStr, err)
 	}
 
 	segments := []*queueSegment{}
+	var nextID segmentID
 	for _, dirEntry := range dirEntries {
 		file, err := dirEntry.Info()
 		if err != nil {
@@ -183,8 +227,16 @@ func scanExistingSegments(logger *logp.Logger, pathStr string) ([]*queueSegment,
 			// Parse the id as base-10 64-bit unsigned int. We ignore file names that
 			// don't match the "[uint64].seg" pattern.
 			if id, err := strconv.ParseUint(components[0], 10, 64); err == nil {
+				if segmentID(id) >= nextID {
+					nextID = segmentID(id) + 1
+				}
 				fullPath := path.Join(pathStr, file.Name())
-				header, err := readSegmentHeaderWithFrameCount(fullPath)
+				header, err := readSegmentHeaderWithFrameCount(settings, fullPath)
+				if errors.Is(err, ErrUnknownEncryptionKey) {
+					return nil, 0, fmt.Errorf(
+						"couldn't load segment file '%v', add its key to encryption.previous_keys: %w",
+						fullPath, err)
+				}
 				if header == nil {
 					logger.Errorf("couldn't load segment file '%v': %v", fullPath, err)
 					continue
@@ -207,7 +259,7 @@ func scanExistingSegments(logger *logp.Logger, pathStr string) ([]*queueSegment,
 		}
 	}
 	sort.Sort(bySegmentID(segments))
-	return segments, nil
+	return segments, nextID, nil
 }
 
 // headerSize returns the logical size ("logical" because it may not have
@@ -238,10 +290,23 @@ func (segment *queueSegment) getReader(queueSettings Settings) (*segmentReader,
 
 	header, err := readSegmentHeader(file)
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf(
 			"couldn't read header for segment %d: %w", segment.id, err)
 	}
 
+	sr, err := newSegmentReader(file, header, queueSettings)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf(
+			"couldn't open data of segment %d: %w", segment.id, err)
+	}
+	return sr, nil
+}
+
+// newSegmentReader creates a segmentReader for the segment file whose
+// header has already been read, file must be positioned right after it.
+func newSegmentReader(file *os.File, header *segmentHeader, queueSettings Settings) (*segmentReader, error) {
 	sr := &segmentReader{}
 	sr.src = file
 
@@ -255,8 +320,29 @@ func (segment *queueSegment) getReader(queueSettings Settings) (*segmentReader,
 		sr.serializationFormat = SerializationCBOR
 	}
 
-	if (header.options & ENABLE_COMPRESSION) == ENABLE_COMPRESSION {
-		sr.cr = NewCompressionReader(sr.src)
+	encrypted, err := header.encrypted()
+	if err != nil {
+		return nil, err
+	}
+	var data io.ReadCloser = sr.src
+	if encrypted {
+		er, err := NewEncryptionReader(sr.src, queueSettings.decryptionKeys())
+		if err != nil {
+			return nil, err
+		}
+		sr.er = er
+		data = er
+	}
+
+	codec, err := header.compressionCodec()
+	if err != nil {
+		return nil, err
+	}
+	if codec != CompressionNone {
+		sr.cr, err = NewCompressionReader(data, codec)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return sr, nil
 }
@@ -265,17 +351,24 @@ func (segment *queueSegment) getReader(queueSettings Settings) (*segmentReader,
 // getWriter should only be called.
 // from the writer loop.
 func (segment *queueSegment) getWriter(queueSettings Settings) (*segmentWriter, error) {
-	var options uint32
 	path := queueSettings.segmentPath(segment.id)
 	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return nil, err
 	}
 
-	if queueSettings.UseCompression {
-		options = options | ENABLE_COMPRESSION
+	sw, err := newSegmentWriter(file, queueSettings.segmentOptions(), queueSettings)
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
+	return sw, nil
+}
 
+// newSegmentWriter writes a segment header with the given options to
+// file and returns a segmentWriter for the data that follows it. The
+// encryption key and compression level are taken from queueSettings.
+func newSegmentWriter(file *os.File, options uint32, queueSettings Settings) (*segmentWriter, error) {
 	sw := &segmentWriter{}
 	sw.dst = file
 
@@ -283,8 +376,37 @@ func (segment *queueSegment) getWriter(queueSettings Settings) (*segmentWriter,
 		return nil, err
 	}
 
-	if (options & ENABLE_COMPRESSION) == ENABLE_COMPRESSION {
-		sw.cw = NewCompressionWriter(sw.dst)
+	encrypted, err := (&segmentHeader{options: options}).encrypted()
+	if err != nil {
+		return nil, err
+	}
+	var data WriteCloseSyncer = sw.dst
+	if encrypted {
+		if queueSettings.EncryptionKey == nil {
+			return nil, errors.New("segment encryption requires an encryption key")
+		}
+		ew, err := NewEncryptionWriter(sw.dst, *queueSettings.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		sw.ew = ew
+		data = ew
+	}
+
+	codec, err := (&segmentHeader{options: options}).compressionCodec()
+	if err != nil {
+		return nil, err
+	}
+	if codec != CompressionNone {
+		// The level only applies to the configured codec.
+		level := 0
+		if codec == queueSettings.Compression {
+			level = queueSettings.CompressionLevel
+		}
+		sw.cw, err = NewCompressionWriter(data, codec, level)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return sw, nil
@@ -316,7 +438,7 @@ func (segment *queueSegment) getWriterWithRetry(
 // file was not closed cleanly), it attempts to calculate it manually
 // by scanning the file, and returns a struct with the "correct"
 // frame count.
-func readSegmentHeaderWithFrameCount(path string) (*segmentHeader, error) {
+func readSegmentHeaderWithFrameCount(settings Settings, path string) (*segmentHeader, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -325,14 +447,18 @@ func readSegmentHeaderWithFrameCount(path string) (*segmentHeader, error) {
 	defer file.Close()
 	// Wrap the handle to retry non-fatal errors and always return the full
 	// requested data length if possible, then read the raw header.
-	reader := autoRetryReader{file}
-	header, err := readSegmentHeader(reader)
+	header, err := readSegmentHeader(autoRetryReader{file})
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := header.encrypted()
 	if err != nil {
 		return nil, err
 	}
 	// If the header has a positive frame count then there is
-	// no more work to do, so return immediately.
-	if header.frameCount > 0 {
+	// no more work to do, so return immediately. Encrypted segments
+	// still go through newSegmentReader to check that their key is known.
+	if header.frameCount > 0 && !encrypted {
 		return header, nil
 	}
 	// If we made it here, we loaded a valid header but the frame count is
@@ -342,7 +468,16 @@ func readSegmentHeaderWithFrameCount(path string) (*segmentHeader, error) {
 	// - The segment file was not closed cleanly during the previous session
 	//   and still has the placeholder value of 0.
 	// In either case, the right thing to do is to scan the file
-	// and fill in the frame count manually.
+	// and fill in the frame count manually. The frames are read through
+	// a segmentReader so encrypted and compressed segments can be scanned.
+	sr, err := newSegmentReader(file, header, settings)
+	if err != nil {
+		return nil, err
+	}
+	if header.frameCount > 0 {
+		return header, nil
+	}
+	reader := autoRetryReader{sr}
 	for {
 		var frameLength uint32
 		err = binary.Read(reader, binary.LittleEndian, &frameLength)
@@ -355,11 +490,15 @@ func readSegmentHeaderWithFrameCount(path string) (*segmentHeader, error) {
 			break
 		}
 		// Length is encoded in both the first and last four bytes of a frame. To
-		// detect truncated / corrupted frames, seek to the last four bytes of
+		// detect truncated / corrupted frames, skip to the last four bytes of
 		// the current frame to make sure the trailing length matches before
 		// advancing to the next frame (otherwise we might accept an impossible
 		// length).
-		_, err = file.Seek(int64(frameLength-8), io.SeekCurrent)
+		if frameLength < frameMetadataSize {
+			err = fmt.Errorf("invalid frame length: %v", frameLength)
+			break
+		}
+		_, err = io.CopyN(io.Discard, reader, int64(frameLength-8))
 		if err != nil {
 			break
 		}
@@ -443,6 +582,7 @@ func (segments *diskQueueSegments) sizeOnDisk() uint64 {
 // less compressable.
 type segmentReader struct {
 	src                 io.ReadSeekCloser
+	er                  *EncryptionReader
 	cr                  *CompressionReader
 	serializationFormat SerializationFormat
 }
@@ -451,6 +591,9 @@ func (r *segmentReader) Read(p []byte) (int, error) {
 	if r.cr != nil {
 		return r.cr.Read(p)
 	}
+	if r.er != nil {
+		return r.er.Read(p)
+	}
 	return r.src.Read(p)
 }
 
@@ -458,16 +601,35 @@ func (r *segmentReader) Close() error {
 	if r.cr != nil {
 		return r.cr.Close()
 	}
+	if r.er != nil {
+		return r.er.Close()
+	}
 	return r.src.Close()
 }
 
+// Seek positions the reader at the given logical offset, as if the segment
+// data was neither encrypted nor compressed.
 func (r *segmentReader) Seek(offset int64, whence int) (int64, error) {
-	if r.cr != nil {
+	if r.cr != nil || r.er != nil {
 		//can't seek before segment header
 		if (offset + int64(whence)) < segmentHeaderSize {
 			return 0, fmt.Errorf("illegal seek offset %d, whence %d", offset, whence)
 		}
-		if _, err := r.src.Seek(segmentHeaderSize, io.SeekStart); err != nil {
+	}
+	if r.er != nil && r.cr == nil {
+		// Encrypted data can be decrypted from any offset.
+		dataOffset := (offset + int64(whence)) - segmentHeaderSize
+		if err := r.er.SeekData(dataOffset); err != nil {
+			return 0, fmt.Errorf("could not seek in encrypted data: %w", err)
+		}
+		return dataOffset + segmentHeaderSize, nil
+	}
+	if r.cr != nil {
+		if r.er != nil {
+			if err := r.er.SeekData(0); err != nil {
+				return 0, fmt.Errorf("could not seek to start of encrypted data: %w", err)
+			}
+		} else if _, err := r.src.Seek(segmentHeaderSize, io.SeekStart); err != nil {
 			return 0, fmt.Errorf("could not seek past segment header: %w", err)
 		}
 		if err := r.cr.Reset(); err != nil {
@@ -488,6 +650,7 @@ func (r *segmentReader) Seek(offset int64, whence int) (int64, error) {
 // data less compressable.
 type segmentWriter struct {
 	dst *os.File
+	ew  *EncryptionWriter
 	cw  *CompressionWriter
 }
 
@@ -495,6 +658,9 @@ func (w *segmentWriter) Write(p []byte) (int, error) {
 	if w.cw != nil {
 		return w.cw.Write(p)
 	}
+	if w.ew != nil {
+		return w.ew.Write(p)
+	}
 	return w.dst.Write(p)
 }
 
@@ -502,6 +668,9 @@ func (w *segmentWriter) Close() error {
 	if w.cw != nil {
 		return w.cw.Close()
 	}
+	if w.ew != nil {
+		return w.ew.Close()
+	}
 	return w.dst.Close()
 }
 
@@ -509,6 +678,9 @@ func (w *segmentWriter) Sync() error {
 	if w.cw != nil {
 		return w.cw.Sync()
 	}
+	if w.ew != nil {
+		return w.ew.Sync()
+	}
 	return w.dst.Sync()
 }
 
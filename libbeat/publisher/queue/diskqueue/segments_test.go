@@ -26,19 +26,41 @@ import (
 
 func TestSegmentsRoundTrip(t *testing.T) {
 	tests := map[string]struct {
-		id        segmentID
-		compress  bool
-		plaintext []byte
+		id          segmentID
+		compression CompressionCodec
+		encrypt     bool
+		plaintext   []byte
 	}{
 		"No Compression": {
 			id:        0,
-			compress:  false,
 			plaintext: []byte("no encryption or compression"),
 		},
 		"With Compression": {
-			id:        2,
-			compress:  true,
-			plaintext: []byte("compression only"),
+			id:          2,
+			compression: CompressionLZ4,
+			plaintext:   []byte("compression only"),
+		},
+		"With Encryption": {
+			id:        3,
+			encrypt:   true,
+			plaintext: []byte("encryption only"),
+		},
+		"With Encryption and Compression": {
+			id:          4,
+			compression: CompressionLZ4,
+			encrypt:     true,
+			plaintext:   []byte("encryption and compression"),
+		},
+		"With Zstd Compression": {
+			id:          5,
+			compression: CompressionZSTD,
+			plaintext:   []byte("zstd compression only"),
+		},
+		"With Encryption and Zstd Compression": {
+			id:          6,
+			compression: CompressionZSTD,
+			encrypt:     true,
+			plaintext:   []byte("encryption and zstd compression"),
 		},
 	}
 	dir := t.TempDir()
@@ -46,7 +68,10 @@ func TestSegmentsRoundTrip(t *testing.T) {
 		dst := make([]byte, len(tc.plaintext))
 		settings := DefaultSettings()
 		settings.Path = dir
-		settings.UseCompression = tc.compress
+		settings.Compression = tc.compression
+		if tc.encrypt {
+			settings.EncryptionKey = testEncryptionKey(t, "test-encryption-key")
+		}
 		qs := &queueSegment{
 			id: tc.id,
 		}
@@ -82,26 +107,51 @@ func TestSegmentsRoundTrip(t *testing.T) {
 
 func TestSegmentReaderSeek(t *testing.T) {
 	tests := map[string]struct {
-		id         segmentID
-		compress   bool
-		plaintexts [][]byte
+		id          segmentID
+		compression CompressionCodec
+		encrypt     bool
+		plaintexts  [][]byte
 	}{
 		"No Compression": {
 			id:         0,
-			compress:   false,
 			plaintexts: [][]byte{[]byte("abc"), []byte("defg")},
 		},
 		"With Compression": {
-			id:         2,
-			compress:   true,
-			plaintexts: [][]byte{[]byte("abc"), []byte("defg")},
+			id:          2,
+			compression: CompressionLZ4,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
+		},
+		"With Encryption": {
+			id:         3,
+			encrypt:    true,
+			plaintexts: [][]byte{[]byte("a block and a bit more"), []byte("defg")},
+		},
+		"With Encryption and Compression": {
+			id:          4,
+			compression: CompressionLZ4,
+			encrypt:     true,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
+		},
+		"With Zstd Compression": {
+			id:          5,
+			compression: CompressionZSTD,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
+		},
+		"With Encryption and Zstd Compression": {
+			id:          6,
+			compression: CompressionZSTD,
+			encrypt:     true,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
 		},
 	}
 	dir := t.TempDir()
 	for name, tc := range tests {
 		settings := DefaultSettings()
 		settings.Path = dir
-		settings.UseCompression = tc.compress
+		settings.Compression = tc.compression
+		if tc.encrypt {
+			settings.EncryptionKey = testEncryptionKey(t, "test-encryption-key")
+		}
 
 		qs := &queueSegment{
 			id: tc.id,
@@ -134,20 +184,32 @@ func TestSegmentReaderSeek(t *testing.T) {
 
 func TestSegmentReaderSeekLocations(t *testing.T) {
 	tests := map[string]struct {
-		id         segmentID
-		compress   bool
-		plaintexts [][]byte
-		location   int64
+		id          segmentID
+		compression CompressionCodec
+		encrypt     bool
+		plaintexts  [][]byte
+		location    int64
 	}{
 		"No Compression": {
 			id:         0,
-			compress:   false,
 			plaintexts: [][]byte{[]byte("abc"), []byte("defg")},
 			location:   -1,
 		},
 		"Compression": {
-			id:         1,
-			compress:   true,
+			id:          1,
+			compression: CompressionLZ4,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
+			location:    2,
+		},
+		"Zstd Compression": {
+			id:          3,
+			compression: CompressionZSTD,
+			plaintexts:  [][]byte{[]byte("abc"), []byte("defg")},
+			location:    2,
+		},
+		"Encryption": {
+			id:         2,
+			encrypt:    true,
 			plaintexts: [][]byte{[]byte("abc"), []byte("defg")},
 			location:   2,
 		},
@@ -156,7 +218,10 @@ func TestSegmentReaderSeekLocations(t *testing.T) {
 	for name, tc := range tests {
 		settings := DefaultSettings()
 		settings.Path = dir
-		settings.UseCompression = tc.compress
+		settings.Compression = tc.compression
+		if tc.encrypt {
+			settings.EncryptionKey = testEncryptionKey(t, "test-encryption-key")
+		}
 		qs := &queueSegment{
 			id: tc.id,
 		}
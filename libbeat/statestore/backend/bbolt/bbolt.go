@@ -0,0 +1,122 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bbolt
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Registry configures access to bbolt based stores.
+type Registry struct {
+	log *logp.Logger
+
+	mu     sync.Mutex
+	active bool
+
+	settings Settings
+}
+
+// Settings configures a new Registry.
+type Settings struct {
+	// Registry root directory. Stores will be single files in this directory.
+	Root string
+
+	// FileMode is used to configure the file mode for new files generated by the
+	// registry. File mode 0600 will be used if this field is not set.
+	FileMode os.FileMode
+
+	// Timeout is the time to wait for the lock on a database file that is
+	// used by another process. Defaults to 1s if not set.
+	Timeout time.Duration
+
+	// FlushInterval is the maximum time updates are kept in memory before
+	// being committed to the database file. Updates are committed before
+	// returning if not set.
+	FlushInterval time.Duration
+}
+
+const defaultFileMode os.FileMode = 0600
+
+const defaultTimeout = time.Second
+
+// New configures a bbolt Registry that can be used to open stores.
+func New(log *logp.Logger, settings Settings) (*Registry, error) {
+	if settings.FileMode == 0 {
+		settings.FileMode = defaultFileMode
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = defaultTimeout
+	}
+
+	root, err := filepath.Abs(settings.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.Root = root
+	return &Registry{
+		log:      log,
+		active:   true,
+		settings: settings,
+	}, nil
+}
+
+// Access creates or opens a store. The database file of the store is
+// created if it does not exist, migrating the state of a memlog store with
+// the same name if there is one.
+// Returns an error if any file access fails.
+func (r *Registry) Access(name string) (backend.Store, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.active {
+		return nil, errRegClosed
+	}
+
+	logger := r.log.With("store", name)
+
+	if err := os.MkdirAll(r.settings.Root, os.ModeDir|0770); err != nil {
+		return nil, err
+	}
+
+	path := StorePath(r.settings.Root, name)
+	if err := migrateMemlog(logger, r.settings, name, path); err != nil {
+		return nil, err
+	}
+	return openStore(logger, path, r.settings)
+}
+
+// Close closes the registry. No new store can be accessed after close.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = false
+	return nil
+}
+
+// StorePath returns the path of the database file of the store name in the
+// registry root directory.
+func StorePath(root, name string) string {
+	return filepath.Join(root, name+".db")
+}
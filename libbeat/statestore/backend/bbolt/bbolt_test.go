@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bbolt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend/memlog"
+	"github.com/elastic/beats/v7/libbeat/statestore/internal/storecompliance"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestCompliance(t *testing.T) {
+	storecompliance.TestBackendCompliance(t, func(testPath string) (backend.Registry, error) {
+		logger := logptest.NewTestingLogger(t, "")
+		return New(logger.Named("test"), Settings{Root: testPath})
+	})
+}
+
+func TestComplianceFlushInterval(t *testing.T) {
+	storecompliance.TestBackendCompliance(t, func(testPath string) (backend.Registry, error) {
+		logger := logptest.NewTestingLogger(t, "")
+		return New(logger.Named("test"), Settings{Root: testPath, FlushInterval: time.Hour})
+	})
+}
+
+func TestFlushInterval(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	root := t.TempDir()
+	reg, err := New(logger, Settings{Root: root, FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer reg.Close()
+
+	s, err := reg.Access("test")
+	require.NoError(t, err)
+	store := s.(*store)
+	require.NoError(t, store.Set("a", mapstr.M{"offset": 1}))
+	require.NoError(t, store.Set("b", mapstr.M{"offset": 2}))
+	require.NoError(t, store.Remove("b"))
+
+	// Pending updates are visible before they are committed.
+	var value mapstr.M
+	require.NoError(t, store.Get("a", &value))
+	assert.Equal(t, mapstr.M{"offset": float64(1)}, value)
+	has, err := store.Has("b")
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	assert.Eventually(t, func() bool {
+		var found bool
+		_ = store.db.View(func(tx *bolt.Tx) error {
+			found = tx.Bucket(bucketName).Get([]byte("a")) != nil
+			return nil
+		})
+		return found
+	}, 5*time.Second, 10*time.Millisecond, "update must be committed after the flush interval")
+
+	// Updates still pending are committed on close.
+	require.NoError(t, store.Set("c", mapstr.M{"offset": 3}))
+	require.NoError(t, store.Close())
+
+	s, err = reg.Access("test")
+	require.NoError(t, err)
+	defer s.Close()
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		has, err := s.Has(key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, has, "key %v", key)
+	}
+}
+
+// BenchmarkSustainedUpdates updates the cursors of many files in turn, as
+// filestream does when events are acknowledged.
+func BenchmarkSustainedUpdates(b *testing.B) {
+	const files = 1000
+
+	backends := map[string]func(logger *logp.Logger, root string) (backend.Registry, error){
+		"memlog": func(logger *logp.Logger, root string) (backend.Registry, error) {
+			return memlog.New(logger, memlog.Settings{Root: root})
+		},
+		"bbolt": func(logger *logp.Logger, root string) (backend.Registry, error) {
+			return New(logger, Settings{Root: root, FlushInterval: time.Second})
+		},
+		"bbolt-no-flush-interval": func(logger *logp.Logger, root string) (backend.Registry, error) {
+			return New(logger, Settings{Root: root})
+		},
+	}
+	for name, newRegistry := range backends {
+		b.Run(name, func(b *testing.B) {
+			reg, err := newRegistry(logptest.NewTestingLogger(b, ""), b.TempDir())
+			require.NoError(b, err)
+			defer reg.Close()
+			store, err := reg.Access("test")
+			require.NoError(b, err)
+			defer store.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := fmt.Sprintf("filestream::input::native::%d-%d", i%files, 2049)
+				err := store.Set(key, mapstr.M{
+					"cursor": mapstr.M{"offset": i},
+					"meta":   mapstr.M{"source": key, "identifier_name": "native"},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateMemlog(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	root := t.TempDir()
+
+	// Always checkpoint the first update, so that the state is read from both
+	// the checkpoint and the log file.
+	checkpoint := true
+	memReg, err := memlog.New(logger, memlog.Settings{
+		Root: root,
+		Checkpoint: func(uint64) bool {
+			defer func() { checkpoint = false }()
+			return checkpoint
+		},
+	})
+	require.NoError(t, err)
+	memStore, err := memReg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, memStore.Set("a", mapstr.M{"offset": 1, "name": "a"}))
+	require.NoError(t, memStore.Set("b", mapstr.M{"offset": 2}))
+	require.NoError(t, memStore.Set("c", mapstr.M{"offset": 3}))
+	require.NoError(t, memStore.Remove("b"))
+	require.NoError(t, memStore.Close())
+	require.NoError(t, memReg.Close())
+
+	reg, err := New(logger, Settings{Root: root})
+	require.NoError(t, err)
+	defer reg.Close()
+
+	store, err := reg.Access("test")
+	require.NoError(t, err)
+
+	got := map[string]mapstr.M{}
+	err = store.Each(func(key string, dec backend.ValueDecoder) (bool, error) {
+		var value mapstr.M
+		err := dec.Decode(&value)
+		got[key] = value
+		return true, err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]mapstr.M{
+		"a": {"offset": float64(1), "name": "a"},
+		"c": {"offset": float64(3)},
+	}, got)
+
+	assert.NoDirExists(t, filepath.Join(root, "test"))
+	assert.DirExists(t, filepath.Join(root, "test"+memlogMigratedSuffix))
+	assert.FileExists(t, StorePath(root, "test"))
+
+	// Updates after the migration must not be overwritten by a second
+	// migration of a memlog store.
+	require.NoError(t, store.Set("d", mapstr.M{"offset": 4}))
+	require.NoError(t, store.Close())
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "test"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "test", "meta.json"), []byte(`{"version": "1"}`), 0o600))
+
+	store, err = reg.Access("test")
+	require.NoError(t, err)
+	defer store.Close()
+	for _, key := range []string{"a", "c", "d"} {
+		has, err := store.Has(key)
+		require.NoError(t, err)
+		assert.True(t, has, "key %v", key)
+	}
+}
+
+func TestFilePermissions(t *testing.T) {
+	if filepath.Separator == '\\' {
+		t.Skip("file permissions are not enforced on Windows")
+	}
+
+	root := t.TempDir()
+	reg, err := New(logptest.NewTestingLogger(t, ""), Settings{Root: root, FileMode: 0o600})
+	require.NoError(t, err)
+	store, err := reg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	path := StorePath(root, "test")
+	require.NoError(t, os.Chmod(path, 0o644))
+	store, err = reg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+}
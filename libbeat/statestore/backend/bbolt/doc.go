@@ -0,0 +1,32 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package bbolt implements a statestore backend that keeps the key-value
+// pairs in a bbolt database on disk.
+//
+// Each store is a single database file named `<store>.db` in the registry
+// root directory. All key-value pairs are kept in one bucket, the values are
+// serialized to JSON. Unlike memlog, the store does not hold the full state
+// in memory and every Set or Remove operation is committed to disk in its
+// own transaction, so there is no update log or checkpoint operation.
+//
+// When a store is accessed for the first time and a memlog store with the
+// same name exists in the registry root directory, the memlog state is
+// copied into the new database. The memlog directory is renamed to
+// `<store>.memlog` once the migration has succeeded, so the migration is only
+// run once.
+package bbolt
@@ -0,0 +1,130 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bbolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend/memlog"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// memlogMigratedSuffix is appended to the directory of a memlog store after
+// its state has been migrated.
+const memlogMigratedSuffix = ".memlog"
+
+// migrateMemlog copies the state of the memlog store name into a new
+// database file at path. Nothing is done if the database file already
+// exists or if there is no memlog store.
+//
+// The state is first written to a temporary file that is renamed once
+// complete, so a failed migration is retried the next time the store is
+// accessed. After the migration the memlog store directory is renamed so it
+// isn't migrated again.
+func migrateMemlog(log *logp.Logger, settings Settings, name, path string) error {
+	if _, err := os.Stat(path); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	home := filepath.Join(settings.Root, name)
+	if _, err := os.Stat(filepath.Join(home, "meta.json")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	log.Infof("Migrating memlog store in '%v' to '%v'", home, path)
+
+	tmpPath := path + ".migrate"
+	if err := os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	count, err := copyMemlogStore(log, settings, name, tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to migrate memlog store '%v': %w", home, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := os.Rename(home, home+memlogMigratedSuffix); err != nil {
+		// The new store is complete, the migration is not run again even if
+		// the old directory is still around.
+		log.Warnf("Failed to rename migrated memlog store '%v': %v", home, err)
+	}
+
+	log.Infof("Migrated %v entries from memlog store '%v'", count, home)
+	return nil
+}
+
+// copyMemlogStore writes all key-value pairs of the memlog store name to a
+// new database file at path, in a single transaction.
+func copyMemlogStore(log *logp.Logger, settings Settings, name, path string) (int, error) {
+	reg, err := memlog.New(log, memlog.Settings{
+		Root:     settings.Root,
+		FileMode: settings.FileMode,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer reg.Close()
+
+	src, err := reg.Access(name)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	// The state is copied in a single transaction, no need to buffer it.
+	dst, err := openStore(log, path, Settings{FileMode: settings.FileMode, Timeout: settings.Timeout})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = dst.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		return src.Each(func(key string, dec backend.ValueDecoder) (bool, error) {
+			var value mapstr.M
+			if err := dec.Decode(&value); err != nil {
+				return false, fmt.Errorf("failed to decode key '%v': %w", key, err)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return false, err
+			}
+			count++
+			return true, bucket.Put([]byte(key), data)
+		})
+	})
+	if err != nil {
+		_ = dst.Close()
+		return 0, err
+	}
+	return count, dst.Close()
+}
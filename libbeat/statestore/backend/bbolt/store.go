@@ -0,0 +1,284 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package bbolt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/elastic/beats/v7/libbeat/common/transform/typeconv"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// bucketName is the bucket holding all key-value pairs of a store.
+var bucketName = []byte("state")
+
+// store implements a bbolt based store.
+// Values are converted to a map[string]interface{} and serialized to JSON
+// before being written, so that no references into the data structures
+// passed via Set are held.
+//
+// Every bbolt transaction syncs the database file, so with a flush
+// interval the updates are kept in memory and committed together in a
+// single transaction once per interval, as the memlog store only syncs its
+// log file on checkpoints.
+type store struct {
+	log *logp.Logger
+	db  *bolt.DB
+
+	mu sync.Mutex
+	// pending holds the updates that have not been committed yet, removed
+	// keys are set to nil. It is nil if updates are committed immediately.
+	pending map[string][]byte
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// entry is the ValueDecoder of a serialized value.
+type entry []byte
+
+// openStore opens the database file at path, creating it if it does not
+// exist.
+func openStore(log *logp.Logger, path string, settings Settings) (*store, error) {
+	if err := pathEnsurePermissions(path, settings.FileMode); err != nil {
+		return nil, fmt.Errorf("failed to update store file permissions: %w", err)
+	}
+
+	db, err := bolt.Open(path, settings.FileMode, &bolt.Options{Timeout: settings.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store file %q: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &store{log: log, db: db, done: make(chan struct{})}
+	if settings.FlushInterval > 0 {
+		s.pending = map[string][]byte{}
+		s.wg.Add(1)
+		go s.flushLoop(settings.FlushInterval)
+	}
+	return s, nil
+}
+
+// flushLoop commits the pending updates every interval until the store is
+// closed. Updates failing to be committed are kept for the next attempt.
+func (s *store) flushLoop(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				s.log.Errorf("Failed to write registry updates: %v", err)
+			}
+		}
+	}
+}
+
+// flush commits the pending updates in a single transaction.
+func (s *store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		for key, data := range s.pending {
+			var err error
+			if data == nil {
+				err = bucket.Delete([]byte(key))
+			} else {
+				err = bucket.Put([]byte(key), data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	clear(s.pending)
+	return nil
+}
+
+// Close commits the pending updates and closes the database file. Access
+// to the store after close returns an error.
+func (s *store) Close() error {
+	if s.pending != nil {
+		close(s.done)
+		s.wg.Wait()
+	}
+	err := s.flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Has checks if the key is known.
+func (s *store) Has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.pending[key]; ok {
+		return data != nil, nil
+	}
+
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(bucketName).Get([]byte(key)) != nil
+		return nil
+	})
+	return found, err
+}
+
+// Get retrieves and decodes the key-value pair into to.
+func (s *store) Get(key string, to interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.pending[key]; ok {
+		if data == nil {
+			return errKeyUnknown
+		}
+		return entry(data).Decode(to)
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get([]byte(key))
+		if data == nil {
+			return errKeyUnknown
+		}
+		return entry(data).Decode(to)
+	})
+}
+
+// Set inserts or overwrites a key-value pair. Without a flush interval the
+// update is committed to disk before Set returns.
+func (s *store) Set(key string, value interface{}) error {
+	var tmp mapstr.M
+	if err := typeconv.Convert(&tmp, value); err != nil {
+		return err
+	}
+	data, err := json.Marshal(tmp)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.pending[key] = data
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(key), data)
+	})
+}
+
+// Remove removes a key from the store. The operation does not check if the
+// key exists.
+func (s *store) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.pending[key] = nil
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(key))
+	})
+}
+
+// Each iterates over all key-value pairs in the store, in key order. The
+// pending updates are committed first.
+// The store must not be updated from within fn.
+func (s *store) Each(fn func(string, backend.ValueDecoder) (bool, error)) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			// The data returned by the cursor is only valid during the
+			// transaction, copy it in case fn holds on to the decoder.
+			cont, err := fn(string(k), entry(append([]byte(nil), v...)))
+			if !cont || err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) SetID(_ string) {
+	// NOOP
+}
+
+func (e entry) Decode(to interface{}) error {
+	var value map[string]interface{}
+	if err := json.Unmarshal(e, &value); err != nil {
+		return err
+	}
+	return typeconv.Convert(to, value)
+}
+
+// pathEnsurePermissions checks if the file permissions for the given file
+// match wantPerm. The permissions are updated using chmod if needed.
+// No file will be created if the file does not yet exist.
+func pathEnsurePermissions(path string, wantPerm os.FileMode) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wantPerm = wantPerm & os.ModePerm
+	if fi.Mode()&os.ModePerm == wantPerm {
+		return nil
+	}
+	return os.Chmod(path, (fi.Mode()&^os.ModePerm)|wantPerm)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 
+	"github.com/elastic/beats/v7/packetbeat/capture"
 	"github.com/elastic/beats/v7/packetbeat/config"
 	"github.com/elastic/beats/v7/packetbeat/flows"
 	"github.com/elastic/beats/v7/packetbeat/procs"
@@ -44,15 +45,17 @@ type processor struct {
 	wg              sync.WaitGroup
 	publisher       *publish.TransactionPublisher
 	flows           *flows.Flows
+	capture         *capture.Capturer
 	sniffer         *sniffer.Sniffer
 	shutdownTimeout time.Duration
 	err             chan error
 }
 
-func newProcessor(shutdownTimeout time.Duration, publisher *publish.TransactionPublisher, flows *flows.Flows, sniffer *sniffer.Sniffer, err chan error) *processor {
+func newProcessor(shutdownTimeout time.Duration, publisher *publish.TransactionPublisher, flows *flows.Flows, capturer *capture.Capturer, sniffer *sniffer.Sniffer, err chan error) *processor {
 	return &processor{
 		publisher:       publisher,
 		flows:           flows,
+		capture:         capturer,
 		sniffer:         sniffer,
 		err:             err,
 		shutdownTimeout: shutdownTimeout,
@@ -92,6 +95,11 @@ func (p *processor) Stop() {
 		time.Sleep(p.shutdownTimeout)
 	}
 	p.publisher.Stop()
+	if p.capture != nil {
+		if err := p.capture.Close(); err != nil {
+			logp.Err("Failed to close packet capture: %v", err)
+		}
+	}
 }
 
 // processorFactory controls construction of modules runners.
@@ -169,12 +177,19 @@ func (p *processorFactory) Create(pipeline beat.PipelineConnector, cfg *conf.C)
 	if err != nil {
 		return nil, err
 	}
+	capturer, err := setupCapture(config, flows)
+	if err != nil {
+		return nil, err
+	}
+	if capturer != nil {
+		publisher.SetCapturer(capturer)
+	}
 	sniffer, err := setupSniffer(id, config, publisher, &watch, flows)
 	if err != nil {
 		return nil, err
 	}
 
-	return newProcessor(config.ShutdownTimeout, publisher, flows, sniffer, p.err), nil
+	return newProcessor(config.ShutdownTimeout, publisher, flows, capturer, sniffer, p.err), nil
 }
 
 // setupFlows returns a *flows.Flows that will publish to the provided pipeline,
@@ -208,6 +223,15 @@ func setupFlows(pipeline beat.Pipeline, watch *procs.ProcessesWatcher, cfg confi
 	return flows.NewFlows(client.PublishAll, watch, cfg.Flows)
 }
 
+// setupCapture returns a *capture.Capturer saving the packets of transactions
+// matching the capture condition, or nil if packet capture is not enabled.
+func setupCapture(cfg config.Config, flows *flows.Flows) (*capture.Capturer, error) {
+	if !cfg.Capture.IsEnabled() {
+		return nil, nil
+	}
+	return capture.New(cfg.Capture, flows)
+}
+
 func setupSniffer(id string, cfg config.Config, pub *publish.TransactionPublisher, watch *procs.ProcessesWatcher, flows *flows.Flows) (*sniffer.Sniffer, error) {
 	icmp, err := cfg.ICMP()
 	if err != nil {
@@ -0,0 +1,175 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package capture saves the recent packets of flows whose transactions
+// match a condition to rotating pcapng files.
+package capture
+
+import (
+	"net"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/packetbeat/config"
+	"github.com/elastic/beats/v7/packetbeat/flows"
+	"github.com/elastic/beats/v7/packetbeat/pb"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+const (
+	defaultName     = "packetbeat"
+	defaultMaxSize  = 10 * 1024 * 1024
+	defaultMaxFiles = 7
+	defaultPackets  = 32
+)
+
+// Capturer writes the packets of the flow of matching transaction events
+// and adds the path of the written file to the event.
+type Capturer struct {
+	cond   conditions.Condition
+	flows  *flows.Flows
+	writer *Writer
+	log    *logp.Logger
+}
+
+// New returns a Capturer configured by cfg taking packets from f. It
+// enables packet recording in f.
+func New(cfg *config.Capture, f *flows.Flows) (*Capturer, error) {
+	cond, err := conditions.NewCondition(cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cfg.Path
+	if dir == "" {
+		dir = paths.Resolve(paths.Data, "pcap")
+	}
+	name := cfg.Name
+	if name == "" {
+		name = defaultName
+	}
+	maxSize := int64(cfg.MaxSize)
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+	maxFiles := cfg.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultMaxFiles
+	}
+	packets := cfg.Packets
+	if packets == 0 {
+		packets = defaultPackets
+	}
+
+	writer, err := NewWriter(dir, name, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	f.EnablePacketCapture(packets)
+
+	return &Capturer{
+		cond:   cond,
+		flows:  f,
+		writer: writer,
+		log:    logp.NewLogger("capture"),
+	}, nil
+}
+
+// Capture checks event against the condition and, on a match, saves the
+// recorded packets of the flow described by fields. The file path and the
+// number of packets saved are added to the event.
+//
+// The packets are written and flushed to the file synchronously, while the
+// event is being published, so that the file holds them once the event
+// with their path is published. A slow capture directory delays the
+// publishing of events.
+func (c *Capturer) Capture(event *beat.Event, fields *pb.Fields) {
+	if !c.cond.Check(event) {
+		return
+	}
+
+	proto, tuple, ok := flowOf(fields)
+	if !ok {
+		return
+	}
+	linkType, packets := c.flows.TakePackets(proto, &tuple)
+	if len(packets) == 0 {
+		return
+	}
+
+	path, err := c.writer.Write(linkType, packets)
+	if err != nil {
+		c.log.Errorf("Failed to save %d packets of %s: %v", len(packets), tuple.String(), err)
+		return
+	}
+	if _, err := event.PutValue("capture.path", path); err != nil {
+		c.log.Errorf("Failed to add the capture path %s to the event: %v", path, err)
+		return
+	}
+	if _, err := event.PutValue("capture.packets", len(packets)); err != nil {
+		c.log.Errorf("Failed to add the number of captured packets to the event: %v", err)
+	}
+}
+
+// Close closes the capture file.
+func (c *Capturer) Close() error {
+	return c.writer.Close()
+}
+
+// flowOf returns the transport and addresses of the flow of a transaction.
+func flowOf(fields *pb.Fields) (layers.IPProtocol, common.IPPortTuple, bool) {
+	if fields.Source == nil || fields.Destination == nil {
+		return 0, common.IPPortTuple{}, false
+	}
+
+	var proto layers.IPProtocol
+	switch fields.Network.Transport {
+	case "tcp":
+		proto = layers.IPProtocolTCP
+	case "udp":
+		proto = layers.IPProtocolUDP
+	default:
+		return 0, common.IPPortTuple{}, false
+	}
+
+	src, ipLength := parseIP(fields.Source.IP)
+	dst, dstLength := parseIP(fields.Destination.IP)
+	if src == nil || dst == nil || ipLength != dstLength {
+		return 0, common.IPPortTuple{}, false
+	}
+
+	tuple := common.NewIPPortTuple(ipLength,
+		src, uint16(fields.Source.Port),
+		dst, uint16(fields.Destination.Port))
+	return proto, tuple, true
+}
+
+// parseIP parses s in the form the decoder records addresses in.
+func parseIP(s string) (net.IP, int) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, 0
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, 4
+	}
+	return ip, 16
+}
@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package capture
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/conditions"
+	"github.com/elastic/beats/v7/packetbeat/config"
+	"github.com/elastic/beats/v7/packetbeat/flows"
+	"github.com/elastic/beats/v7/packetbeat/pb"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestCapture(t *testing.T) {
+	var when conditions.Config
+	err := conf.MustNewConfigFrom(mapstr.M{
+		"equals.http.response.status_code": 500,
+	}).Unpack(&when)
+	require.NoError(t, err)
+
+	f, err := flows.NewFlows(nil, &procs.ProcessesWatcher{}, &config.Flows{})
+	require.NoError(t, err)
+	c, err := New(&config.Capture{When: &when, Path: t.TempDir(), Packets: 4}, f)
+	require.NoError(t, err)
+	defer c.Close()
+
+	client := net.ParseIP("192.168.0.1").To4()
+	server := net.ParseIP("192.168.0.2").To4()
+	request := common.NewIPPortTuple(4, client, 40000, server, 80)
+	response := common.NewIPPortTuple(4, server, 80, client, 40000)
+	for i, tuple := range []*common.IPPortTuple{&request, &response, &request, &response} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000+int64(i), 0), Length: 60}
+		f.RecordPacket(layers.IPProtocolTCP, tuple, layers.LinkTypeEthernet, &ci, make([]byte, 60))
+	}
+
+	event := func(status int) (*beat.Event, *pb.Fields) {
+		evt, fields := pb.NewBeatEvent(time.Now())
+		fields.SetSource(&common.Endpoint{IP: client.String(), Port: 40000})
+		fields.SetDestination(&common.Endpoint{IP: server.String(), Port: 80})
+		fields.Network.Transport = "tcp"
+		evt.Fields.Put("http.response.status_code", status)
+		return &evt, fields
+	}
+
+	// Non-matching transactions leave the packets in place.
+	evt, fields := event(200)
+	c.Capture(evt, fields)
+	assert.NotContains(t, evt.Fields, "capture")
+
+	evt, fields = event(500)
+	c.Capture(evt, fields)
+	path, err := evt.GetValue("capture.path")
+	require.NoError(t, err)
+	assert.Equal(t, 4, evt.Fields["capture"].(mapstr.M)["packets"])
+
+	linkType, packets := readPackets(t, path.(string))
+	assert.Equal(t, layers.LinkTypeEthernet, linkType)
+	assert.Len(t, packets, 4)
+
+	// Packets are saved only once.
+	evt, fields = event(500)
+	c.Capture(evt, fields)
+	assert.NotContains(t, evt.Fields, "capture")
+}
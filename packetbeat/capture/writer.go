@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/elastic/beats/v7/packetbeat/flows"
+)
+
+const (
+	fileExt          = ".pcapng"
+	timeSuffixFormat = "20060102-150405.000000"
+
+	// Size of an enhanced packet block without packet data and padding.
+	packetBlockSize = 32
+)
+
+var errWriterClosed = errors.New("pcapng writer is closed")
+
+// Writer writes packets to pcapng files in a directory. A new file is started
+// when writing would grow the current file past the size limit, and the
+// oldest files are removed when there are more files than the count limit.
+// File names are made of the name prefix and the creation time so the path
+// of already written packets stays valid across rotations.
+type Writer struct {
+	mutex    sync.Mutex
+	dir      string
+	name     string
+	maxSize  int64
+	maxFiles int
+
+	file   *os.File
+	path   string
+	out    *countWriter
+	ng     *pcapgo.NgWriter
+	ifaces map[layers.LinkType]int
+	closed bool
+}
+
+// countWriter counts the bytes written to the underlying file.
+type countWriter struct {
+	f *os.File
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer creating files named after name in dir. The
+// directory is created if it does not exist.
+func NewWriter(dir, name string, maxSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	return &Writer{
+		dir:      dir,
+		name:     name,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}, nil
+}
+
+// Write writes packets captured on an interface with the given link type
+// and returns the path of the file they were written to.
+func (w *Writer) Write(linkType layers.LinkType, packets []flows.Packet) (string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return "", errWriterClosed
+	}
+
+	size := int64(0)
+	for _, p := range packets {
+		size += packetBlockSize + int64(len(p.Data)+3)&^3
+	}
+	if w.file != nil && w.out.n+size > w.maxSize {
+		if err := w.closeFile(); err != nil {
+			return "", err
+		}
+	}
+	if w.file == nil {
+		if err := w.openFile(linkType); err != nil {
+			return "", err
+		}
+	}
+
+	iface, ok := w.ifaces[linkType]
+	if !ok {
+		intf := pcapgo.DefaultNgInterface
+		intf.Name = fmt.Sprintf("intf%d", len(w.ifaces))
+		intf.LinkType = linkType
+		id, err := w.ng.AddInterface(intf)
+		if err != nil {
+			return "", err
+		}
+		iface = id
+		w.ifaces[linkType] = iface
+	}
+
+	for _, p := range packets {
+		ci := p.Info
+		ci.InterfaceIndex = iface
+		if ci.Length < ci.CaptureLength {
+			ci.Length = ci.CaptureLength
+		}
+		if err := w.ng.WritePacket(ci, p.Data); err != nil {
+			return "", err
+		}
+	}
+	if err := w.ng.Flush(); err != nil {
+		return "", err
+	}
+	return w.path, nil
+}
+
+// Close closes the current file. Subsequent writes fail.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	return w.closeFile()
+}
+
+func (w *Writer) openFile(linkType layers.LinkType) error {
+	path := filepath.Join(w.dir, w.name+"-"+time.Now().UTC().Format(timeSuffixFormat)+fileExt)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %w", err)
+	}
+	out := &countWriter{f: f}
+	intf := pcapgo.DefaultNgInterface
+	intf.LinkType = linkType
+	ng, err := pcapgo.NewNgWriterInterface(out, intf, pcapgo.DefaultNgWriterOptions)
+	if err == nil {
+		err = ng.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write capture file header to %s: %w", path, err)
+	}
+
+	w.file, w.path, w.out, w.ng = f, path, out, ng
+	w.ifaces = map[layers.LinkType]int{linkType: 0}
+	return w.removeOld()
+}
+
+func (w *Writer) closeFile() error {
+	err := w.file.Close()
+	w.file, w.out, w.ng, w.ifaces = nil, nil, nil, nil
+	return err
+}
+
+// removeOld removes the oldest capture files until at most maxFiles remain.
+func (w *Writer) removeOld() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, w.name+"-") && strings.HasSuffix(name, fileExt) {
+			files = append(files, name)
+		}
+	}
+	if len(files) <= w.maxFiles {
+		return nil
+	}
+	sort.Strings(files)
+	for _, name := range files[:len(files)-w.maxFiles] {
+		if err := os.Remove(filepath.Join(w.dir, name)); err != nil {
+			return fmt.Errorf("failed to remove old capture file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/packetbeat/flows"
+)
+
+func testPackets(n, size int) []flows.Packet {
+	packets := make([]flows.Packet, n)
+	for i := range packets {
+		data := make([]byte, size)
+		data[0] = byte(i)
+		packets[i].Data = data
+		packets[i].Info.Timestamp = time.Unix(1700000000, int64(i)*1000)
+		packets[i].Info.CaptureLength = size
+		packets[i].Info.Length = size
+	}
+	return packets
+}
+
+func readPackets(t *testing.T, path string) (layers.LinkType, [][]byte) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	var packets [][]byte
+	for {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		packets = append(packets, data)
+	}
+	return r.LinkType(), packets
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 1000, 2)
+	require.NoError(t, err)
+
+	first, err := w.Write(layers.LinkTypeEthernet, testPackets(2, 100))
+	require.NoError(t, err)
+	second, err := w.Write(layers.LinkTypeEthernet, testPackets(3, 100))
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "writes within the size limit share a file")
+	assert.Equal(t, dir, filepath.Dir(first))
+
+	linkType, packets := readPackets(t, first)
+	assert.Equal(t, layers.LinkTypeEthernet, linkType)
+	if assert.Len(t, packets, 5) {
+		assert.Equal(t, byte(1), packets[1][0])
+		assert.Equal(t, byte(0), packets[2][0])
+	}
+
+	// File names have microsecond resolution.
+	time.Sleep(time.Millisecond)
+	third, err := w.Write(layers.LinkTypeEthernet, testPackets(4, 100))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third, "write beyond the size limit starts a new file")
+	_, packets = readPackets(t, third)
+	assert.Len(t, packets, 4)
+
+	time.Sleep(time.Millisecond)
+	fourth, err := w.Write(layers.LinkTypeEthernet, testPackets(8, 100))
+	require.NoError(t, err)
+	assert.NotEqual(t, third, fourth)
+
+	require.NoError(t, w.Close())
+	_, err = w.Write(layers.LinkTypeEthernet, testPackets(1, 100))
+	assert.ErrorIs(t, err, errWriterClosed)
+
+	files, err := filepath.Glob(filepath.Join(dir, "test-*"+fileExt))
+	require.NoError(t, err)
+	assert.Equal(t, []string{third, fourth}, files, "oldest files beyond the count limit are removed")
+}
@@ -24,18 +24,25 @@ import (
 	"strings"
 	"time"
 
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
+	"github.com/elastic/beats/v7/libbeat/conditions"
 	"github.com/elastic/beats/v7/libbeat/processors"
 	"github.com/elastic/beats/v7/packetbeat/procs"
 	conf "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 )
 
-var errFanoutGroupAFPacketOnly = errors.New("fanout_group is only valid with af_packet type")
+var (
+	errFanoutGroupAFPacketOnly = errors.New("fanout_group is only valid with af_packet type")
+	errCaptureNeedsFlows       = errors.New("packet capture requires flows to be enabled")
+	errCaptureNeedsCondition   = errors.New("packet capture requires a 'when' condition")
+)
 
 type Config struct {
 	Interface          *InterfaceConfig   `config:"interfaces"`
 	Interfaces         []InterfaceConfig  `config:"interfaces"`
 	Flows              *Flows             `config:"flows"`
+	Capture            *Capture           `config:"capture"`
 	Protocols          map[string]*conf.C `config:"protocols"`
 	ProtocolsList      []*conf.C          `config:"protocols"`
 	Procs              procs.ProcsConfig  `config:"procs"`
@@ -57,6 +64,9 @@ func (c Config) FromStatic(cfg *conf.C) (Config, error) {
 		}
 	}
 	c.Interface = nil
+	if c.Capture.IsEnabled() && !c.Flows.IsEnabled() {
+		return c, errCaptureNeedsFlows
+	}
 	counts := make(map[string]int)
 	for i, iface := range c.Interfaces {
 		name := iface.Device
@@ -148,6 +158,21 @@ type Flows struct {
 	EnableDeltaFlowReports bool `config:"enable_delta_flow_reports"`
 }
 
+// Capture configures the saving of the recent packets of flows whose
+// transactions match a condition to rotating pcapng files.
+type Capture struct {
+	Enabled *bool              `config:"enabled"`
+	When    *conditions.Config `config:"when"`
+	// Path is the directory the pcapng files are written to.
+	Path string `config:"path"`
+	// Name is the prefix of the pcapng file names.
+	Name     string           `config:"name"`
+	MaxSize  cfgtype.ByteSize `config:"max_size" validate:"min=0"`
+	MaxFiles int              `config:"max_files" validate:"min=0"`
+	// Packets is the number of recent packets kept for each flow.
+	Packets int `config:"packets" validate:"min=0"`
+}
+
 type ProtocolCommon struct {
 	Ports              []int         `config:"ports"`
 	SendRequest        bool          `config:"send_request"`
@@ -159,6 +184,17 @@ func (f *Flows) IsEnabled() bool {
 	return f != nil && (f.Enabled == nil || *f.Enabled)
 }
 
+func (c *Capture) IsEnabled() bool {
+	return c != nil && (c.Enabled == nil || *c.Enabled)
+}
+
+func (c *Capture) Validate() error {
+	if c.IsEnabled() && c.When == nil {
+		return errCaptureNeedsCondition
+	}
+	return nil
+}
+
 func (i InterfaceConfig) Validate() error {
 	if i.Type != "af_packet" && i.FanoutGroup != nil {
 		return errFanoutGroupAFPacketOnly
@@ -472,6 +472,24 @@ interfaces:
 interfaces:
   device: any
   fanout_group: 1
+`,
+	},
+	{
+		name:    "capture_without_flows",
+		wantErr: errCaptureNeedsFlows,
+		config: `
+interfaces.device: any
+capture:
+  when.equals.http.response.status_code: 500
+`,
+	},
+	{
+		name:    "capture_without_condition",
+		wantErr: fmt.Errorf("%w accessing 'capture'", errCaptureNeedsCondition),
+		config: `
+interfaces.device: any
+flows.enabled: true
+capture.path: /tmp
 `,
 	},
 }
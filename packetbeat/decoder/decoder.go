@@ -37,6 +37,7 @@ type Decoder struct {
 	decoders         map[gopacket.LayerType]gopacket.DecodingLayer
 	linkLayerDecoder gopacket.DecodingLayer
 	linkLayerType    gopacket.LayerType
+	linkType         layers.LinkType
 
 	sll       layers.LinuxSLL
 	lo        layers.Loopback
@@ -69,6 +70,10 @@ type Decoder struct {
 	flowID              *flows.FlowID // buffer flowID among many calls
 	flowIDBufferBacking [flows.SizeFlowIDMax]byte
 
+	// raw packet currently being decoded, kept for packet capture
+	data []byte
+	ci   *gopacket.CaptureInfo
+
 	logger *logp.Logger
 }
 
@@ -83,6 +88,7 @@ const (
 func New(f *flows.Flows, datalink layers.LinkType, icmp4 icmp.ICMPv4Processor, icmp6 icmp.ICMPv6Processor, tcp tcp.Processor, udp udp.Processor) (*Decoder, error) {
 	d := Decoder{
 		flows:     f,
+		linkType:  datalink,
 		decoders:  make(map[gopacket.LayerType]gopacket.DecodingLayer),
 		icmp4Proc: icmp4, icmp6Proc: icmp6, tcpProc: tcp, udpProc: udp,
 		fragments: fragmentCache{collected: make(map[uint16]fragments)},
@@ -171,6 +177,7 @@ func (d *Decoder) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
 
 	if d.flowID != nil {
 		d.flowID.Reset(d.flowIDBufferBacking[:0])
+		d.data, d.ci = data, ci
 
 		// suppress flow stats snapshots while processing packet
 		d.flows.Lock()
@@ -475,6 +482,9 @@ func (d *Decoder) onUDP(packet *protos.Packet) {
 	packet.Tuple.DstPort = dst
 	packet.Payload = d.udp.Payload
 	packet.Tuple.ComputeHashables()
+	if id != nil {
+		d.flows.RecordPacket(layers.IPProtocolUDP, &packet.Tuple, d.linkType, d.ci, d.data)
+	}
 
 	d.udpProc.Process(id, packet)
 }
@@ -498,5 +508,10 @@ func (d *Decoder) onTCP(packet *protos.Packet) {
 		return
 	}
 	packet.Tuple.ComputeHashables()
+	if id != nil {
+		// Record before processing so the packet completing a
+		// transaction is available when its event is published.
+		d.flows.RecordPacket(layers.IPProtocolTCP, &packet.Tuple, d.linkType, d.ci, d.data)
+	}
 	d.tcpProc.Process(id, &d.tcp, packet)
 }
@@ -0,0 +1,131 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package flows
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+)
+
+// Packet is a copy of a raw packet recorded for a flow.
+type Packet struct {
+	Info gopacket.CaptureInfo
+	Data []byte
+}
+
+// packetKey identifies a TCP or UDP flow independent of its direction.
+type packetKey struct {
+	proto layers.IPProtocol
+	tuple common.HashableIPPortTuple
+}
+
+func makePacketKey(proto layers.IPProtocol, tuple *common.IPPortTuple) packetKey {
+	k := packetKey{proto: proto, tuple: tuple.Hashable()}
+	if rev := tuple.RevHashable(); bytes.Compare(rev[:], k.tuple[:]) < 0 {
+		k.tuple = rev
+	}
+	return k
+}
+
+// packetRings holds a short ring buffer of the most recent packets of
+// each flow. Rings are dropped when their flow has been idle for the flow
+// timeout.
+type packetRings struct {
+	mutex sync.Mutex
+	size  int
+	rings map[packetKey]*packetRing
+}
+
+type packetRing struct {
+	linkType layers.LinkType
+	ts       time.Time
+	packets  []Packet
+	next     int // index of the oldest packet once the ring is full
+}
+
+func newPacketRings(size int) *packetRings {
+	return &packetRings{
+		size:  size,
+		rings: make(map[packetKey]*packetRing),
+	}
+}
+
+func (r *packetRings) record(key packetKey, linkType layers.LinkType, ci *gopacket.CaptureInfo, data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ring := r.rings[key]
+	if ring == nil {
+		ring = &packetRing{packets: make([]Packet, 0, r.size)}
+		r.rings[key] = ring
+	}
+	ring.linkType = linkType
+	ring.ts = time.Now()
+	ring.add(ci, data)
+}
+
+// take removes and returns the packets recorded for key, oldest first, so
+// packets are saved at most once.
+func (r *packetRings) take(key packetKey) (layers.LinkType, []Packet) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ring := r.rings[key]
+	if ring == nil {
+		return layers.LinkTypeNull, nil
+	}
+	delete(r.rings, key)
+	return ring.linkType, ring.ordered()
+}
+
+// expire drops the rings of flows without packets since ts.
+func (r *packetRings) expire(ts time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, ring := range r.rings {
+		if ring.ts.Before(ts) {
+			delete(r.rings, key)
+		}
+	}
+}
+
+func (r *packetRing) add(ci *gopacket.CaptureInfo, data []byte) {
+	info := *ci
+	info.CaptureLength = len(data)
+	if len(r.packets) < cap(r.packets) {
+		r.packets = append(r.packets, Packet{Info: info, Data: append([]byte(nil), data...)})
+		return
+	}
+
+	// Reuse the buffer of the oldest packet.
+	p := &r.packets[r.next]
+	p.Info = info
+	p.Data = append(p.Data[:0], data...)
+	r.next = (r.next + 1) % len(r.packets)
+}
+
+func (r *packetRing) ordered() []Packet {
+	return append(r.packets[r.next:len(r.packets):len(r.packets)], r.packets[:r.next]...)
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package flows
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/config"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+)
+
+func TestFlowsPacketCapture(t *testing.T) {
+	module, err := NewFlows(nil, &procs.ProcessesWatcher{}, &config.Flows{})
+	assert.NoError(t, err)
+
+	client := net.IPv4(10, 0, 0, 1).To4()
+	server := net.IPv4(10, 0, 0, 2).To4()
+	request := common.NewIPPortTuple(4, client, 34567, server, 80)
+	response := common.NewIPPortTuple(4, server, 80, client, 34567)
+
+	// Nothing is recorded before capture is enabled.
+	ci := gopacket.CaptureInfo{Timestamp: time.Now()}
+	module.RecordPacket(layers.IPProtocolTCP, &request, layers.LinkTypeEthernet, &ci, []byte{0})
+	_, packets := module.TakePackets(layers.IPProtocolTCP, &request)
+	assert.Empty(t, packets)
+
+	module.EnablePacketCapture(3)
+	for i := 1; i <= 5; i++ {
+		tuple := &request
+		if i%2 == 0 {
+			tuple = &response
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), Length: 100}
+		module.RecordPacket(layers.IPProtocolTCP, tuple, layers.LinkTypeEthernet, &ci, []byte{byte(i), 0xff})
+	}
+
+	// Same addresses with another transport are another flow.
+	_, packets = module.TakePackets(layers.IPProtocolUDP, &request)
+	assert.Empty(t, packets)
+
+	linkType, packets := module.TakePackets(layers.IPProtocolTCP, &response)
+	assert.Equal(t, layers.LinkTypeEthernet, linkType)
+	if assert.Len(t, packets, 3) {
+		for i, p := range packets {
+			assert.Equal(t, []byte{byte(i + 3), 0xff}, p.Data)
+			assert.Equal(t, time.Unix(int64(i+3), 0), p.Info.Timestamp)
+			assert.Equal(t, 2, p.Info.CaptureLength)
+			assert.Equal(t, 100, p.Info.Length)
+		}
+	}
+
+	// Packets are handed out only once.
+	_, packets = module.TakePackets(layers.IPProtocolTCP, &request)
+	assert.Empty(t, packets)
+}
+
+func TestPacketRingsExpire(t *testing.T) {
+	rings := newPacketRings(2)
+	tuple := common.NewIPPortTuple(4, net.IPv4(10, 0, 0, 1).To4(), 53, net.IPv4(10, 0, 0, 2).To4(), 5353)
+	key := makePacketKey(layers.IPProtocolUDP, &tuple)
+
+	rings.record(key, layers.LinkTypeEthernet, &gopacket.CaptureInfo{}, []byte{1})
+	rings.expire(time.Now().Add(-time.Minute))
+	assert.Len(t, rings.rings, 1)
+
+	rings.expire(time.Now().Add(time.Second))
+	assert.Empty(t, rings.rings)
+}
@@ -20,7 +20,11 @@ package flows
 import (
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
 	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/packetbeat/config"
 	"github.com/elastic/beats/v7/packetbeat/procs"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -103,6 +107,30 @@ func (f *Flows) Get(id *FlowID) *Flow {
 	return &id.flow
 }
 
+// EnablePacketCapture makes the flows keep the last n packets of each TCP
+// and UDP flow. It must be called before packets are processed.
+func (f *Flows) EnablePacketCapture(n int) {
+	f.table.packets = newPacketRings(n)
+}
+
+// RecordPacket records a copy of the raw packet data for the flow
+// identified by proto and tuple. The tuple hashables must be computed.
+func (f *Flows) RecordPacket(proto layers.IPProtocol, tuple *common.IPPortTuple, linkType layers.LinkType, ci *gopacket.CaptureInfo, data []byte) {
+	if f.table.packets == nil {
+		return
+	}
+	f.table.packets.record(makePacketKey(proto, tuple), linkType, ci, data)
+}
+
+// TakePackets returns and forgets the packets recorded for the flow
+// identified by proto and tuple, along with their link type.
+func (f *Flows) TakePackets(proto layers.IPProtocol, tuple *common.IPPortTuple) (layers.LinkType, []Packet) {
+	if f.table.packets == nil {
+		return layers.LinkTypeNull, nil
+	}
+	return f.table.packets.take(makePacketKey(proto, tuple))
+}
+
 func (f *Flows) Start() {
 	f.worker.start()
 }
@@ -37,6 +37,9 @@ type flowMetaTable struct {
 
 	tables flowTableList
 
+	// packets holds recent packets of flows if packet capture is enabled.
+	packets *packetRings
+
 	// TODO: create snapshot of table for concurrent iteration
 	// tablesSnapshot flowTableList
 }
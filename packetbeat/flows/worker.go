@@ -279,6 +279,10 @@ func (fw *flowsProcessor) execute(w *worker, checkTimeout, handleReports, lastRe
 		}
 	}
 
+	if checkTimeout && fw.table.packets != nil {
+		fw.table.packets.expire(ts.Add(-fw.timeout))
+	}
+
 	fw.spool.flush()
 }
 
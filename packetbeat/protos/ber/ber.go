@@ -0,0 +1,217 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package ber implements a minimal decoder for the Basic Encoding Rules
+// used by LDAP and Kerberos.
+//
+// Decoded values reference the input buffer and lengths are validated
+// against the available data before anything is consumed, so malformed
+// packets can not make the decoder allocate more memory than the packet
+// itself holds.
+package ber
+
+import (
+	"errors"
+	"strings"
+)
+
+// Classes of a tag.
+const (
+	ClassUniversal   uint8 = 0
+	ClassApplication uint8 = 1
+	ClassContext     uint8 = 2
+	ClassPrivate     uint8 = 3
+)
+
+// Tags of the universal class used by the protocol analyzers.
+const (
+	TagBoolean     uint32 = 0x01
+	TagInteger     uint32 = 0x02
+	TagBitString   uint32 = 0x03
+	TagOctetString uint32 = 0x04
+	TagNull        uint32 = 0x05
+	TagEnumerated  uint32 = 0x0a
+	TagSequence    uint32 = 0x10
+	TagSet         uint32 = 0x11
+)
+
+// maxTag limits the size of high tag numbers.
+const maxTag = 1 << 24
+
+var (
+	// ErrShortData is returned if the value exceeds the available data.
+	ErrShortData = errors.New("ber: value exceeds available data")
+
+	errIndefiniteLength = errors.New("ber: indefinite length is not supported")
+	errInvalidLength    = errors.New("ber: invalid length")
+	errInvalidTag       = errors.New("ber: invalid tag")
+	errInvalidInteger   = errors.New("ber: invalid integer")
+	errUnexpectedType   = errors.New("ber: unexpected type")
+)
+
+// Value is a decoded BER type-length-value triple.
+type Value struct {
+	Class       uint8
+	Constructed bool
+	Tag         uint32
+
+	// Content holds the contents octets of the value.
+	Content []byte
+}
+
+// Header decodes the identifier and length octets at the start of data. It
+// returns the size of the header and of the contents. ErrShortData is
+// returned if data ends within the header.
+func Header(data []byte) (hdrLen, contentLen int, err error) {
+	if len(data) < 2 {
+		return 0, 0, ErrShortData
+	}
+
+	pos := 1
+	if data[0]&0x1f == 0x1f {
+		// high tag number form
+		var tag uint32
+		for {
+			if pos >= len(data) {
+				return 0, 0, ErrShortData
+			}
+			b := data[pos]
+			pos++
+			tag = tag<<7 | uint32(b&0x7f)
+			if tag >= maxTag {
+				return 0, 0, errInvalidTag
+			}
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if pos >= len(data) {
+		return 0, 0, ErrShortData
+	}
+	b := data[pos]
+	pos++
+	switch {
+	case b < 0x80:
+		return pos, int(b), nil
+	case b == 0x80:
+		return 0, 0, errIndefiniteLength
+	case b == 0xff:
+		return 0, 0, errInvalidLength
+	}
+
+	// long form, some implementations do not use the minimal number of
+	// length octets.
+	n := int(b & 0x7f)
+	if pos+n > len(data) {
+		return 0, 0, ErrShortData
+	}
+	length := 0
+	for _, b := range data[pos : pos+n] {
+		if length >= 1<<23 {
+			return 0, 0, errInvalidLength
+		}
+		length = length<<8 | int(b)
+	}
+	return pos + n, length, nil
+}
+
+// Decode decodes the first value in data and returns the remaining bytes.
+func Decode(data []byte) (Value, []byte, error) {
+	hdrLen, contentLen, err := Header(data)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	if contentLen > len(data)-hdrLen {
+		return Value{}, nil, ErrShortData
+	}
+
+	v := Value{
+		Class:       data[0] >> 6,
+		Constructed: data[0]&0x20 != 0,
+		Tag:         uint32(data[0] & 0x1f),
+		Content:     data[hdrLen : hdrLen+contentLen],
+	}
+	if v.Tag == 0x1f {
+		v.Tag = 0
+		for _, b := range data[1:hdrLen] {
+			v.Tag = v.Tag<<7 | uint32(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	return v, data[hdrLen+contentLen:], nil
+}
+
+// Is checks the class and tag of the value.
+func (v Value) Is(class uint8, tag uint32) bool {
+	return v.Class == class && v.Tag == tag
+}
+
+// Children decodes the values of a constructed value.
+func (v Value) Children() ([]Value, error) {
+	if !v.Constructed {
+		return nil, errUnexpectedType
+	}
+
+	var children []Value
+	for data := v.Content; len(data) > 0; {
+		child, rest, err := Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		data = rest
+	}
+	return children, nil
+}
+
+// Explicit returns the value wrapped by an explicitly tagged value.
+func (v Value) Explicit() (Value, error) {
+	if !v.Constructed {
+		return Value{}, errUnexpectedType
+	}
+	inner, _, err := Decode(v.Content)
+	return inner, err
+}
+
+// Int decodes the contents as a two's complement integer, as used by
+// INTEGER and ENUMERATED values.
+func (v Value) Int() (int64, error) {
+	if v.Constructed || len(v.Content) == 0 || len(v.Content) > 8 {
+		return 0, errInvalidInteger
+	}
+
+	i := int64(int8(v.Content[0]))
+	for _, b := range v.Content[1:] {
+		i = i<<8 | int64(b)
+	}
+	return i, nil
+}
+
+// Bool decodes the contents as a BOOLEAN.
+func (v Value) Bool() bool {
+	return len(v.Content) > 0 && v.Content[0] != 0
+}
+
+// Text returns the contents as a string. Invalid UTF-8 sequences are
+// replaced by the Unicode replacement character.
+func (v Value) Text() string {
+	return strings.ToValidUTF8(string(v.Content), "\uFFFD")
+}
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package ber
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSequence(t *testing.T) {
+	// SEQUENCE { INTEGER 5, [APPLICATION 0] { OCTET STRING "cn" } }, "tail"
+	data := []byte{
+		0x30, 0x09,
+		0x02, 0x01, 0x05,
+		0x60, 0x04, 0x04, 0x02, 'c', 'n',
+		't', 'a', 'i', 'l',
+	}
+
+	v, rest, err := Decode(data)
+	require.NoError(t, err)
+	assert.True(t, v.Is(ClassUniversal, TagSequence))
+	assert.True(t, v.Constructed)
+	assert.Equal(t, []byte("tail"), rest)
+
+	children, err := v.Children()
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+
+	i, err := children[0].Int()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), i)
+
+	assert.True(t, children[1].Is(ClassApplication, 0))
+	inner, err := children[1].Explicit()
+	require.NoError(t, err)
+	assert.Equal(t, "cn", inner.Text())
+}
+
+func TestDecodeLongForms(t *testing.T) {
+	// Non-minimal long form length as sent by Active Directory.
+	v, rest, err := Decode([]byte{0x04, 0x84, 0x00, 0x00, 0x00, 0x01, 'x'})
+	require.NoError(t, err)
+	assert.Equal(t, "x", v.Text())
+	assert.Empty(t, rest)
+
+	// High tag number form, [APPLICATION 200].
+	v, _, err = Decode([]byte{0x5f, 0x81, 0x48, 0x00})
+	require.NoError(t, err)
+	assert.True(t, v.Is(ClassApplication, 200))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":             {},
+		"short header":      {0x30},
+		"short length":      {0x04, 0x82, 0x01},
+		"short content":     {0x04, 0x05, 'a'},
+		"huge length":       {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"indefinite length": {0x30, 0x80, 0x00, 0x00},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := Decode(data)
+			assert.Error(t, err)
+		})
+	}
+
+	_, _, err := Decode([]byte{0x04, 0x05, 'a'})
+	assert.ErrorIs(t, err, ErrShortData)
+}
+
+func TestInt(t *testing.T) {
+	for _, tc := range []struct {
+		content []byte
+		want    int64
+	}{
+		{[]byte{0x00}, 0},
+		{[]byte{0x7f}, 127},
+		{[]byte{0x00, 0x80}, 128},
+		{[]byte{0xff}, -1},
+		{[]byte{0xff, 0x80}, -128},
+		{[]byte{0x12, 0x34, 0x56, 0x78}, 0x12345678},
+	} {
+		got, err := Value{Content: tc.content}.Int()
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+
+	_, err := Value{}.Int()
+	assert.Error(t, err)
+}
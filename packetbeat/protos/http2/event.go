@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http2
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/ecs"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/beats/v7/packetbeat/pb"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// ProtocolFields contains the HTTP fields of an HTTP/2 transaction.
+type ProtocolFields struct {
+	Version            string   `ecs:"version"`
+	RequestMethod      string   `ecs:"request.method"`
+	RequestMIMEType    string   `ecs:"request.mime_type"`
+	RequestBytes       int64    `ecs:"request.bytes"`
+	RequestBodyBytes   int64    `ecs:"request.body.bytes"`
+	RequestHeaders     mapstr.M `packetbeat:"request.headers"`
+	ResponseStatusCode int64    `ecs:"response.status_code"`
+	ResponseMIMEType   string   `ecs:"response.mime_type"`
+	ResponseBytes      int64    `ecs:"response.bytes"`
+	ResponseBodyBytes  int64    `ecs:"response.body.bytes"`
+	ResponseHeaders    mapstr.M `packetbeat:"response.headers"`
+}
+
+func (p *plugin) newTransaction(conn *connection, s *stream) beat.Event {
+	requ, resp := s.request, s.response
+
+	source, destination := common.MakeEndpointPair(conn.tuple.BaseTuple, conn.cmdlineTuple)
+	src, dst := &source, &destination
+	if requ.dir == tcp.TCPDirectionReverse {
+		src, dst = dst, src
+	}
+
+	evt, pbf := pb.NewBeatEvent(requ.ts)
+	pbf.SetSource(src)
+	pbf.SetDestination(dst)
+	pbf.AddIP(src.IP)
+	pbf.AddIP(dst.IP)
+	pbf.Source.Bytes = int64(requ.size)
+	pbf.Event.Dataset = "http2"
+	pbf.Event.Start = requ.ts
+	pbf.Network.Transport = "tcp"
+	pbf.Network.Protocol = "http"
+
+	fields := evt.Fields
+	fields["type"] = pbf.Event.Dataset
+
+	method := requ.headers[":method"]
+	path, query, _ := strings.Cut(requ.headers[":path"], "?")
+	httpFields := ProtocolFields{
+		Version:          "2",
+		RequestMethod:    method,
+		RequestMIMEType:  requ.headers["content-type"],
+		RequestBytes:     int64(requ.size),
+		RequestBodyBytes: int64(requ.bodySize),
+		RequestHeaders:   p.collectHeaders(requ),
+	}
+	fields["method"] = method
+	fields["query"] = method + " " + path
+	evt.PutValue("http2.stream_id", int64(s.id))
+	if p.sendRequest {
+		fields["request"] = rawHeaders(requ)
+	}
+
+	u := newURL(requ.headers[":scheme"], requ.headers[":authority"], path, query)
+	if u.Domain != "" {
+		if net.ParseIP(u.Domain) == nil {
+			pbf.Destination.Domain = u.Domain
+			pbf.AddHost(u.Domain)
+		} else {
+			pbf.AddIP(u.Domain)
+		}
+	}
+	pb.MarshalStruct(evt.Fields, "url", u)
+	if ua := requ.headers["user-agent"]; ua != "" {
+		evt.PutValue("user_agent.original", ua)
+	}
+
+	var (
+		failed   bool
+		trailers map[string]string
+	)
+	if resp == nil {
+		failed = true
+		pbf.Error.Message = append(pbf.Error.Message, "Unmatched request")
+	} else {
+		trailers = resp.headers
+		pbf.Destination.Bytes = int64(resp.size)
+		pbf.Event.End = resp.endTs
+
+		httpFields.ResponseStatusCode = int64(resp.status)
+		httpFields.ResponseMIMEType = resp.headers["content-type"]
+		httpFields.ResponseBytes = int64(resp.size)
+		httpFields.ResponseBodyBytes = int64(resp.bodySize)
+		httpFields.ResponseHeaders = p.collectHeaders(resp)
+		failed = resp.status >= 400 || resp.status == 0
+		if p.sendResponse {
+			fields["response"] = rawHeaders(resp)
+		}
+	}
+	pb.MarshalStruct(evt.Fields, "http", httpFields)
+
+	if s.reset {
+		failed = true
+		code := errorCodeName(s.errorCode)
+		evt.PutValue("http2.error_code", code)
+		by := "client"
+		if s.resetDir != requ.dir {
+			by = "server"
+		}
+		pbf.Error.Message = append(pbf.Error.Message, "Stream reset by "+by+": "+code)
+	}
+
+	if isGRPC(requ.headers["content-type"]) {
+		grpc := grpcFields(path, trailers)
+		if code, ok := grpc["status_code"].(int); ok && code != 0 {
+			failed = true
+		}
+		fields["grpc"] = grpc
+		pbf.Event.Action = "grpc.call"
+	} else {
+		pbf.Event.Action = "http2.request"
+	}
+
+	if failed {
+		fields["status"] = common.ERROR_STATUS
+		pbf.Event.Outcome = "failure"
+	} else {
+		fields["status"] = common.OK_STATUS
+	}
+	return evt
+}
+
+// collectHeaders returns the configured headers of a message.
+func (p *plugin) collectHeaders(m *message) mapstr.M {
+	if !p.sendAllHeaders && len(p.sendHeaders) == 0 {
+		return nil
+	}
+	hdrs := mapstr.M{}
+	for name, value := range m.headers {
+		if strings.HasPrefix(name, ":") {
+			continue
+		}
+		if p.sendAllHeaders || p.sendHeaders[name] {
+			hdrs[name] = value
+		}
+	}
+	return hdrs
+}
+
+// rawHeaders returns the header fields of a message, one per line.
+func rawHeaders(m *message) string {
+	var b strings.Builder
+	for _, f := range m.fields {
+		b.WriteString(f.Name)
+		b.WriteString(": ")
+		b.WriteString(f.Value)
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// newURL returns the URL of a request from its pseudo-header fields.
+func newURL(scheme, authority, path, query string) *ecs.Url {
+	u := &ecs.Url{
+		Scheme: scheme,
+		Path:   path,
+		Query:  query,
+	}
+	u.Domain = authority
+	if host, port, err := net.SplitHostPort(authority); err == nil {
+		u.Domain = host
+		u.Port, _ = strconv.ParseInt(port, 10, 64)
+	}
+	if path != "" {
+		if i := strings.LastIndexByte(path, '.'); i > strings.LastIndexByte(path, '/') {
+			u.Extension = path[i+1:]
+		}
+	}
+	if scheme != "" && authority != "" {
+		full := url.URL{Scheme: scheme, Host: authority, Path: path, RawQuery: query}
+		u.Full = full.String()
+	}
+	return u
+}
@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http2
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// clientPreface starts the connection of a client using prior knowledge of
+// HTTP/2 (RFC 9113, section 3.4).
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const frameHeaderLen = 9
+
+type frameType uint8
+
+// Frame types.
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+// Frame flags.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// settingHeaderTableSize is the identifier of the SETTINGS_HEADER_TABLE_SIZE
+// setting.
+const settingHeaderTableSize = 0x1
+
+type frameHeader struct {
+	length   int
+	typ      frameType
+	flags    uint8
+	streamID uint32
+}
+
+func parseFrameHeader(b []byte) frameHeader {
+	return frameHeader{
+		length:   int(b[0])<<16 | int(b[1])<<8 | int(b[2]),
+		typ:      frameType(b[3]),
+		flags:    b[4],
+		streamID: binary.BigEndian.Uint32(b[5:]) & 0x7fffffff,
+	}
+}
+
+func (h frameHeader) has(flag uint8) bool {
+	return h.flags&flag != 0
+}
+
+// errorCodeNames are the names of the error codes of RST_STREAM and GOAWAY
+// frames.
+var errorCodeNames = []string{
+	"NO_ERROR",
+	"PROTOCOL_ERROR",
+	"INTERNAL_ERROR",
+	"FLOW_CONTROL_ERROR",
+	"SETTINGS_TIMEOUT",
+	"STREAM_CLOSED",
+	"FRAME_SIZE_ERROR",
+	"REFUSED_STREAM",
+	"CANCEL",
+	"COMPRESSION_ERROR",
+	"CONNECT_ERROR",
+	"ENHANCE_YOUR_CALM",
+	"INADEQUATE_SECURITY",
+	"HTTP_1_1_REQUIRED",
+}
+
+func errorCodeName(code uint32) string {
+	if int(code) < len(errorCodeNames) {
+		return errorCodeNames[code]
+	}
+	return "0x" + strconv.FormatUint(uint64(code), 16)
+}
@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http2
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// grpcStatusNames are the names of the gRPC status codes.
+var grpcStatusNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+func isGRPC(contentType string) bool {
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// grpcFields returns the gRPC fields of a call to path. The status is read
+// from the trailers of the response, which is nil if there is no response.
+func grpcFields(path string, trailers map[string]string) mapstr.M {
+	fields := mapstr.M{}
+
+	// Paths are in the form /package.Service/Method.
+	if service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/"); ok {
+		fields["service"] = service
+		fields["method"] = method
+	}
+
+	if code, err := strconv.Atoi(trailers["grpc-status"]); err == nil {
+		fields["status_code"] = code
+		if code >= 0 && code < len(grpcStatusNames) {
+			fields["status"] = grpcStatusNames[code]
+		}
+	}
+
+	// The message is percent-encoded.
+	if msg := trailers["grpc-message"]; msg != "" {
+		if decoded, err := url.PathUnescape(msg); err == nil {
+			msg = decoded
+		}
+		fields["message"] = msg
+	}
+	return fields
+}
@@ -0,0 +1,611 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// initialHeaderTableSize is the initial size of the HPACK dynamic table.
+const initialHeaderTableSize = 4096
+
+var (
+	debugf  = logp.MakeDebug("http2")
+	isDebug = false
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "http2.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "http2.unmatched_responses")
+	droppedStreams     = monitoring.NewInt(nil, "http2.dropped_streams")
+)
+
+var errNotHTTP2 = errors.New("stream does not start with an HTTP/2 connection preface")
+
+func init() {
+	protos.Register("http2", New)
+}
+
+// HTTP/2 protocol plugin. Only cleartext HTTP/2 (h2c) with prior knowledge
+// is supported, connections upgraded from HTTP/1.1 or using TLS are ignored.
+type plugin struct {
+	// config
+	ports              []int
+	sendRequest        bool
+	sendResponse       bool
+	sendAllHeaders     bool
+	sendHeaders        map[string]bool
+	maxOpenStreams     int
+	maxHeaderBlockSize int
+	transactionTimeout time.Duration
+
+	watcher *procs.ProcessesWatcher
+	results protos.Reporter
+}
+
+// connection holds the state of an HTTP/2 connection.
+type connection struct {
+	tuple        common.TCPTuple
+	cmdlineTuple *common.ProcessTuple
+
+	dirs    [2]*direction
+	streams map[uint32]*stream
+
+	// failed is set when the connection cannot be decoded, for example because
+	// the capture started in the middle of it. Further data is ignored.
+	failed bool
+}
+
+// direction holds the parser state of one direction of a connection.
+type direction struct {
+	applayer.Stream
+
+	// decoder holds the HPACK dynamic table of the header blocks sent in this
+	// direction.
+	decoder *hpack.Decoder
+	started bool
+
+	// block collects the fragments of a header block that continues in
+	// CONTINUATION frames.
+	block      []byte
+	blockFrame frameHeader
+	blockSize  int
+	promisedID uint32
+	inBlock    bool
+
+	// dataLeft is the number of payload bytes of the current DATA frame that
+	// have not been received yet. DATA payloads are skipped, only their size
+	// is reported.
+	dataLeft int
+}
+
+// stream holds the request and the response of an HTTP/2 stream.
+type stream struct {
+	id       uint32
+	request  *message
+	response *message
+
+	reset     bool
+	errorCode uint32
+	resetDir  uint8
+}
+
+type message struct {
+	ts        time.Time
+	endTs     time.Time
+	dir       uint8
+	size      int
+	bodySize  int
+	fields    []hpack.HeaderField
+	headers   map[string]string
+	status    int
+	complete  bool
+	isRequest bool
+}
+
+func New(
+	testMode bool,
+	results protos.Reporter,
+	watcher *procs.ProcessesWatcher,
+	cfg *conf.C,
+) (protos.Plugin, error) {
+	p := &plugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, watcher, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *plugin) init(results protos.Reporter, watcher *procs.ProcessesWatcher, config *http2Config) error {
+	p.setFromConfig(config)
+
+	p.results = results
+	p.watcher = watcher
+	isDebug = logp.IsDebug("http2")
+
+	return nil
+}
+
+func (p *plugin) setFromConfig(config *http2Config) {
+	p.ports = config.Ports
+	p.sendRequest = config.SendRequest
+	p.sendResponse = config.SendResponse
+	p.sendAllHeaders = config.SendAllHeaders
+	p.maxOpenStreams = config.MaxOpenStreams
+	p.maxHeaderBlockSize = config.MaxHeaderBlockSize
+	p.transactionTimeout = config.TransactionTimeout
+
+	p.sendHeaders = map[string]bool{}
+	for _, name := range config.SendHeaders {
+		p.sendHeaders[strings.ToLower(name)] = true
+	}
+}
+
+func (p *plugin) GetPorts() []int {
+	return p.ports
+}
+
+func (p *plugin) ConnectionTimeout() time.Duration {
+	return p.transactionTimeout
+}
+
+func (p *plugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	conn := p.ensureConnection(private, tcptuple)
+	if conn.failed {
+		return conn
+	}
+
+	d := conn.dirs[dir]
+	if err := d.Append(pkt.Payload); err != nil {
+		if isDebug {
+			debugf("%v, dropping TCP stream", err)
+		}
+		return nil
+	}
+
+	if err := p.parse(conn, d, dir, pkt.Ts); err != nil {
+		if isDebug {
+			debugf("Ignoring HTTP/2 connection %s: %v", tcptuple, err)
+		}
+		conn.fail()
+		return conn
+	}
+	d.Reset()
+	return conn
+}
+
+func (p *plugin) ensureConnection(private protos.ProtocolData, tcptuple *common.TCPTuple) *connection {
+	if conn, ok := private.(*connection); ok && conn != nil {
+		return conn
+	}
+	if private != nil {
+		logp.Warn("http2 connection data type error, create new one")
+	}
+
+	conn := &connection{
+		tuple:        *tcptuple,
+		cmdlineTuple: p.watcher.FindProcessesTupleTCP(tcptuple.IPPort()),
+		streams:      map[uint32]*stream{},
+	}
+	for i := range conn.dirs {
+		d := &direction{decoder: hpack.NewDecoder(initialHeaderTableSize, nil)}
+		d.Init(tcp.TCPMaxDataInStream)
+		conn.dirs[i] = d
+	}
+	return conn
+}
+
+// fail releases the state of a connection that cannot be decoded.
+func (conn *connection) fail() {
+	conn.failed = true
+	conn.dirs = [2]*direction{}
+	conn.streams = nil
+}
+
+// parse processes the complete frames buffered in one direction.
+func (p *plugin) parse(conn *connection, d *direction, dir uint8, ts time.Time) error {
+	if !d.started {
+		b := d.Buf.Bytes()
+		if len(b) < len(clientPreface) && strings.HasPrefix(clientPreface, string(b)) {
+			return nil
+		}
+		if bytes.HasPrefix(b, []byte(clientPreface)) {
+			_ = d.Buf.Advance(len(clientPreface))
+		}
+	}
+
+	for {
+		// Skip the payload of the current DATA frame.
+		if d.dataLeft > 0 {
+			n := min(d.dataLeft, d.Buf.Len())
+			if n == 0 {
+				return nil
+			}
+			_ = d.Buf.Advance(n)
+			d.dataLeft -= n
+			continue
+		}
+
+		b := d.Buf.Bytes()
+		if len(b) < frameHeaderLen {
+			return nil
+		}
+		h := parseFrameHeader(b)
+
+		// Both endpoints start with a SETTINGS frame, the server preface
+		// consists of it only.
+		if !d.started {
+			if h.typ != frameSettings || h.streamID != 0 || h.has(flagAck) {
+				return errNotHTTP2
+			}
+			d.started = true
+		}
+
+		if h.typ == frameData {
+			// The padding length is needed to compute the size of the body.
+			if h.has(flagPadded) && len(b) == frameHeaderLen && h.length > 0 {
+				return nil
+			}
+			body := h.length
+			if h.has(flagPadded) && h.length > 0 {
+				body -= 1 + int(b[frameHeaderLen])
+			}
+			if body < 0 || d.inBlock {
+				return fmt.Errorf("invalid DATA frame on stream %d", h.streamID)
+			}
+			_ = d.Buf.Advance(frameHeaderLen)
+			d.dataLeft = h.length
+			p.onData(conn, h, dir, body, ts)
+			continue
+		}
+
+		if len(b) < frameHeaderLen+h.length {
+			return nil
+		}
+		_ = d.Buf.Advance(frameHeaderLen + h.length)
+		if err := p.onFrame(conn, d, h, b[frameHeaderLen:frameHeaderLen+h.length], dir, ts); err != nil {
+			return err
+		}
+	}
+}
+
+// onFrame processes a frame other than DATA.
+func (p *plugin) onFrame(conn *connection, d *direction, h frameHeader, payload []byte, dir uint8, ts time.Time) error {
+	if d.inBlock && (h.typ != frameContinuation || h.streamID != d.blockFrame.streamID) {
+		return fmt.Errorf("expected CONTINUATION frame on stream %d, got frame type %d", d.blockFrame.streamID, h.typ)
+	}
+
+	switch h.typ {
+	case frameHeaders, framePushPromise:
+		var err error
+		if payload, err = removePadding(h, payload); err != nil {
+			return err
+		}
+		d.promisedID = 0
+		if h.typ == framePushPromise {
+			if len(payload) < 4 {
+				return errors.New("invalid PUSH_PROMISE frame")
+			}
+			d.promisedID = binary.BigEndian.Uint32(payload) & 0x7fffffff
+			payload = payload[4:]
+		} else if h.has(flagPriority) {
+			if len(payload) < 5 {
+				return errors.New("invalid HEADERS frame")
+			}
+			payload = payload[5:]
+		}
+		if len(payload) > p.maxHeaderBlockSize {
+			return fmt.Errorf("header block on stream %d exceeds %d bytes", h.streamID, p.maxHeaderBlockSize)
+		}
+		d.block = append(d.block[:0], payload...)
+		d.blockFrame = h
+		d.blockSize = frameHeaderLen + h.length
+		d.inBlock = true
+	case frameContinuation:
+		if !d.inBlock {
+			return fmt.Errorf("unexpected CONTINUATION frame on stream %d", h.streamID)
+		}
+		// A peer sending CONTINUATION frames without end would otherwise
+		// make the header block grow without bound.
+		if len(d.block)+len(payload) > p.maxHeaderBlockSize {
+			return fmt.Errorf("header block on stream %d exceeds %d bytes", h.streamID, p.maxHeaderBlockSize)
+		}
+		d.block = append(d.block, payload...)
+		d.blockSize += frameHeaderLen + h.length
+		d.blockFrame.flags |= h.flags & flagEndHeaders
+	case frameRSTStream:
+		if len(payload) != 4 {
+			return errors.New("invalid RST_STREAM frame")
+		}
+		p.onReset(conn, h.streamID, binary.BigEndian.Uint32(payload), dir)
+		return nil
+	case frameSettings:
+		if h.has(flagAck) {
+			return nil
+		}
+		if len(payload)%6 != 0 {
+			return errors.New("invalid SETTINGS frame")
+		}
+		for ; len(payload) > 0; payload = payload[6:] {
+			// The setting limits the dynamic table of the peer's encoder.
+			if binary.BigEndian.Uint16(payload) == settingHeaderTableSize {
+				conn.dirs[1-dir].decoder.SetAllowedMaxDynamicTableSize(binary.BigEndian.Uint32(payload[2:]))
+			}
+		}
+		return nil
+	default:
+		// PRIORITY, PING, GOAWAY, WINDOW_UPDATE and unknown frames do not
+		// affect the transactions.
+		return nil
+	}
+
+	if !d.blockFrame.has(flagEndHeaders) {
+		return nil
+	}
+	d.inBlock = false
+
+	// Header blocks must always be decoded to keep the dynamic table in sync,
+	// also if the stream is ignored.
+	fields, err := d.decoder.DecodeFull(d.block)
+	if err != nil {
+		return fmt.Errorf("failed to decode header block on stream %d: %w", d.blockFrame.streamID, err)
+	}
+
+	if d.promisedID != 0 {
+		// The promised request is sent by the server on behalf of the client.
+		p.onPushPromise(conn, d.promisedID, fields, d.blockSize, 1-dir, ts)
+		return nil
+	}
+	p.onHeaders(conn, d.blockFrame, fields, d.blockSize, dir, ts)
+	return nil
+}
+
+func removePadding(h frameHeader, payload []byte) ([]byte, error) {
+	if !h.has(flagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, fmt.Errorf("invalid padding in frame type %d", h.typ)
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// getStream returns the stream with the given ID, creating it if create is
+// set. It returns nil if the stream does not exist or cannot be created.
+func (p *plugin) getStream(conn *connection, id uint32, create bool) *stream {
+	if s := conn.streams[id]; s != nil || !create {
+		return s
+	}
+	if len(conn.streams) >= p.maxOpenStreams {
+		if isDebug {
+			debugf("Too many open streams, ignoring stream %d", id)
+		}
+		droppedStreams.Add(1)
+		return nil
+	}
+	s := &stream{id: id}
+	conn.streams[id] = s
+	return s
+}
+
+func (p *plugin) onHeaders(conn *connection, h frameHeader, fields []hpack.HeaderField, size int, dir uint8, ts time.Time) {
+	s := p.getStream(conn, h.streamID, true)
+	if s == nil {
+		return
+	}
+
+	m := s.message(dir)
+	if m == nil {
+		m = &message{ts: ts, dir: dir}
+		for _, f := range fields {
+			if f.Name == ":method" {
+				m.isRequest = true
+				break
+			}
+		}
+		if m.isRequest {
+			s.request = m
+		} else {
+			s.response = m
+		}
+	}
+
+	m.add(fields)
+	m.size += size
+	m.endTs = ts
+
+	// Drop informational responses, the final response follows them.
+	if !m.isRequest && m.status >= 100 && m.status < 200 && !h.has(flagEndStream) {
+		m.status = 0
+		m.fields = m.fields[:0]
+		m.headers = nil
+	}
+
+	if h.has(flagEndStream) {
+		m.complete = true
+		p.checkComplete(conn, s)
+	}
+}
+
+func (p *plugin) onPushPromise(conn *connection, id uint32, fields []hpack.HeaderField, size int, dir uint8, ts time.Time) {
+	s := p.getStream(conn, id, true)
+	if s == nil || s.request != nil {
+		return
+	}
+	s.request = &message{ts: ts, endTs: ts, dir: dir, size: size, complete: true, isRequest: true}
+	s.request.add(fields)
+}
+
+func (p *plugin) onData(conn *connection, h frameHeader, dir uint8, body int, ts time.Time) {
+	s := p.getStream(conn, h.streamID, false)
+	if s == nil {
+		return
+	}
+	m := s.message(dir)
+	if m == nil {
+		return
+	}
+	m.size += frameHeaderLen + h.length
+	m.bodySize += body
+	m.endTs = ts
+
+	if h.has(flagEndStream) {
+		m.complete = true
+		p.checkComplete(conn, s)
+	}
+}
+
+func (p *plugin) onReset(conn *connection, id, code uint32, dir uint8) {
+	s := p.getStream(conn, id, false)
+	if s == nil {
+		return
+	}
+	s.reset = true
+	s.errorCode = code
+	s.resetDir = dir
+
+	delete(conn.streams, id)
+	if s.request == nil {
+		unmatchedResponses.Add(1)
+		return
+	}
+	p.publish(conn, s)
+}
+
+// checkComplete publishes the transaction of a stream once both the request
+// and the response are complete.
+func (p *plugin) checkComplete(conn *connection, s *stream) {
+	if s.response == nil || !s.response.complete {
+		return
+	}
+	if s.request != nil && !s.request.complete {
+		return
+	}
+
+	delete(conn.streams, s.id)
+	if s.request == nil {
+		if isDebug {
+			debugf("Response from unknown transaction on stream %d. Ignoring", s.id)
+		}
+		unmatchedResponses.Add(1)
+		return
+	}
+	p.publish(conn, s)
+}
+
+func (p *plugin) publish(conn *connection, s *stream) {
+	if p.results == nil {
+		return
+	}
+	p.results(p.newTransaction(conn, s))
+}
+
+// message returns the message of the stream sent in the given direction.
+func (s *stream) message(dir uint8) *message {
+	if s.request != nil && s.request.dir == dir {
+		return s.request
+	}
+	if s.response != nil && s.response.dir == dir {
+		return s.response
+	}
+	return nil
+}
+
+// add adds the fields of a header block to the message. Later header blocks,
+// like trailers, add to the headers of the previous ones.
+func (m *message) add(fields []hpack.HeaderField) {
+	if m.headers == nil {
+		m.headers = map[string]string{}
+	}
+	for _, f := range fields {
+		m.fields = append(m.fields, f)
+		if f.Name == ":status" {
+			_, _ = fmt.Sscan(f.Value, &m.status)
+		}
+		if prev, found := m.headers[f.Name]; found {
+			m.headers[f.Name] = prev + ", " + f.Value
+		} else {
+			m.headers[f.Name] = f.Value
+		}
+	}
+}
+
+func (p *plugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int, private protos.ProtocolData) (priv protos.ProtocolData, drop bool,
+) {
+	// The HPACK dynamic table cannot be recovered after a gap.
+	return private, true
+}
+
+func (p *plugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	return private
+}
+
+// Expired publishes the transactions of the streams still open when the
+// connection expires.
+func (p *plugin) Expired(tuple *common.TCPTuple, private protos.ProtocolData) {
+	conn, ok := private.(*connection)
+	if !ok || conn == nil || conn.failed {
+		return
+	}
+	if isDebug {
+		debugf("expired connection %s", tuple)
+	}
+	for id, s := range conn.streams {
+		delete(conn.streams, id)
+		if s.request == nil {
+			unmatchedResponses.Add(1)
+			continue
+		}
+		if s.response == nil {
+			unmatchedRequests.Add(1)
+		}
+		p.publish(conn, s)
+	}
+}
@@ -0,0 +1,408 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package http2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+	"github.com/elastic/beats/v7/packetbeat/publish"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	clientDir = tcp.TCPDirectionOriginal
+	serverDir = tcp.TCPDirectionReverse
+)
+
+type eventStore struct {
+	events []beat.Event
+}
+
+func (e *eventStore) publish(event beat.Event) {
+	publish.MarshalPacketbeatFields(&event, nil, nil)
+	e.events = append(e.events, event)
+}
+
+func newTestPlugin(t *testing.T, store *eventStore, settings map[string]interface{}) *plugin {
+	t.Helper()
+	p, err := New(false, store.publish, &procs.ProcessesWatcher{}, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p.(*plugin)
+}
+
+func testCreateTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		BaseTuple: common.BaseTuple{
+			SrcIP: net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+			SrcPort: 6512, DstPort: 50051,
+		},
+	}
+	t.ComputeHashables()
+	return t
+}
+
+// frameWriter writes the frames sent by one endpoint.
+type frameWriter struct {
+	t     *testing.T
+	buf   bytes.Buffer
+	fr    *http2.Framer
+	hbuf  bytes.Buffer
+	hpack *hpack.Encoder
+}
+
+func newFrameWriter(t *testing.T, client bool) *frameWriter {
+	w := &frameWriter{t: t}
+	w.fr = http2.NewFramer(&w.buf, nil)
+	w.hpack = hpack.NewEncoder(&w.hbuf)
+	if client {
+		w.buf.WriteString(http2.ClientPreface)
+	}
+	require.NoError(t, w.fr.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100}))
+	return w
+}
+
+func (w *frameWriter) encode(kv ...string) []byte {
+	w.hbuf.Reset()
+	for i := 0; i < len(kv); i += 2 {
+		require.NoError(w.t, w.hpack.WriteField(hpack.HeaderField{Name: kv[i], Value: kv[i+1]}))
+	}
+	return bytes.Clone(w.hbuf.Bytes())
+}
+
+func (w *frameWriter) headers(id uint32, endStream bool, kv ...string) *frameWriter {
+	require.NoError(w.t, w.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: w.encode(kv...),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	}))
+	return w
+}
+
+func (w *frameWriter) data(id uint32, endStream bool, data string) *frameWriter {
+	require.NoError(w.t, w.fr.WriteData(id, endStream, []byte(data)))
+	return w
+}
+
+func (w *frameWriter) bytes() []byte {
+	b := bytes.Clone(w.buf.Bytes())
+	w.buf.Reset()
+	return b
+}
+
+type testConn struct {
+	t       *testing.T
+	p       *plugin
+	tuple   *common.TCPTuple
+	private protos.ProtocolData
+}
+
+func (c *testConn) send(dir uint8, payload []byte) {
+	pkt := protos.Packet{Ts: time.Now(), Payload: payload}
+	c.private = c.p.Parse(&pkt, c.tuple, dir, c.private)
+}
+
+func expectTransaction(t *testing.T, e *eventStore) mapstr.M {
+	t.Helper()
+	if len(e.events) == 0 {
+		t.Fatal("No transaction")
+	}
+	event := e.events[0]
+	e.events = e.events[1:]
+	return event.Fields
+}
+
+func TestGRPCUnaryCall(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"send_headers": []string{"grpc-status"}})
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	c.send(clientDir, client.
+		headers(1, false,
+			":method", "POST", ":scheme", "http", ":authority", "greeter:50051",
+			":path", "/helloworld.Greeter/SayHello",
+			"content-type", "application/grpc", "user-agent", "grpc-go/1.60.0", "te", "trailers").
+		data(1, true, "\x00\x00\x00\x00\x07\n\x05world").
+		bytes())
+	c.send(serverDir, server.
+		headers(1, false, ":status", "200", "content-type", "application/grpc").
+		data(1, false, "\x00\x00\x00\x00\r\n\x0bHello world").
+		bytes())
+	assert.Empty(t, store.events)
+
+	c.send(serverDir, server.headers(1, true, "grpc-status", "0", "grpc-message", "").bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "http2", fields["type"])
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, "POST", fields["method"])
+	assert.Equal(t, "POST /helloworld.Greeter/SayHello", fields["query"])
+	assert.Equal(t, int64(1), fields["http2"].(mapstr.M)["stream_id"])
+	assert.Equal(t, mapstr.M{
+		"service":     "helloworld.Greeter",
+		"method":      "SayHello",
+		"status_code": 0,
+		"status":      "OK",
+	}, fields["grpc"])
+
+	http := fields["http"].(mapstr.M)
+	assert.Equal(t, "2", http["version"])
+	assert.Equal(t, int64(200), http["response"].(mapstr.M)["status_code"])
+	assert.Equal(t, int64(12), http["request"].(mapstr.M)["body"].(mapstr.M)["bytes"])
+	assert.Equal(t, int64(18), http["response"].(mapstr.M)["body"].(mapstr.M)["bytes"])
+	assert.Equal(t, mapstr.M{"grpc-status": "0"}, http["response"].(mapstr.M)["headers"])
+	assert.NotContains(t, http["request"], "headers")
+
+	v, _ := fields.GetValue("url.full")
+	assert.Equal(t, "http://greeter:50051/helloworld.Greeter/SayHello", v)
+	v, _ = fields.GetValue("url.port")
+	assert.Equal(t, int64(50051), v)
+	v, _ = fields.GetValue("source.port")
+	assert.Equal(t, int64(6512), v)
+	v, _ = fields.GetValue("event.action")
+	assert.Equal(t, "grpc.call", v)
+}
+
+func TestGRPCTrailersOnlyError(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	c.send(clientDir, client.
+		headers(1, false, ":method", "POST", ":scheme", "http", ":authority", "greeter",
+			":path", "/helloworld.Greeter/SayHello", "content-type", "application/grpc+proto").
+		data(1, true, "\x00\x00\x00\x00\x00").
+		bytes())
+	c.send(serverDir, server.
+		headers(1, true, ":status", "200", "content-type", "application/grpc",
+			"grpc-status", "5", "grpc-message", "user%20not%20found").
+		bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"service":     "helloworld.Greeter",
+		"method":      "SayHello",
+		"status_code": 5,
+		"status":      "NOT_FOUND",
+		"message":     "user not found",
+	}, fields["grpc"])
+	v, _ := fields.GetValue("event.outcome")
+	assert.Equal(t, "failure", v)
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	// The second request reuses the dynamic table entries of the first one,
+	// and its header block is split across a CONTINUATION frame.
+	client.headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/a.txt")
+	block := client.encode(":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/b?x=1")
+	require.NoError(t, client.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID: 3, BlockFragment: block[:2], EndStream: true, PadLength: 3,
+		Priority: http2.PriorityParam{StreamDep: 1, Weight: 16},
+	}))
+	require.NoError(t, client.fr.WriteContinuation(3, true, block[2:]))
+
+	// Deliver the data byte per byte.
+	for _, b := range client.bytes() {
+		c.send(clientDir, []byte{b})
+	}
+
+	c.send(serverDir, server.
+		headers(3, false, ":status", "404").
+		headers(1, false, ":status", "200", "content-type", "text/plain").
+		data(3, true, "not found").
+		data(1, true, "a").
+		bytes())
+
+	first := expectTransaction(t, &store)
+	assert.Equal(t, "GET /b", first["query"])
+	assert.Equal(t, "Error", first["status"])
+	v, _ := first.GetValue("url.query")
+	assert.Equal(t, "x=1", v)
+	v, _ = first.GetValue("http.response.status_code")
+	assert.Equal(t, int64(404), v)
+
+	second := expectTransaction(t, &store)
+	assert.Equal(t, "GET /a.txt", second["query"])
+	assert.Equal(t, "OK", second["status"])
+	assert.NotContains(t, second, "grpc")
+	v, _ = second.GetValue("url.extension")
+	assert.Equal(t, "txt", v)
+	v, _ = second.GetValue("http.response.mime_type")
+	assert.Equal(t, "text/plain", v)
+	assert.Empty(t, store.events)
+	assert.Empty(t, c.private.(*connection).streams)
+}
+
+func TestInformationalResponse(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"send_response": true})
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	c.send(clientDir, client.
+		headers(1, false, ":method", "PUT", ":scheme", "http", ":authority", "example.com", ":path", "/upload", "expect", "100-continue").
+		bytes())
+	c.send(serverDir, server.headers(1, false, ":status", "100").bytes())
+	c.send(clientDir, client.data(1, true, "payload").bytes())
+	c.send(serverDir, server.headers(1, true, ":status", "201").bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, ":status: 201\r\n", fields["response"])
+	v, _ := fields.GetValue("http.response.status_code")
+	assert.Equal(t, int64(201), v)
+}
+
+func TestStreamReset(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	c.send(clientDir, client.
+		headers(1, false, ":method", "POST", ":scheme", "http", ":authority", "greeter",
+			":path", "/helloworld.Greeter/SayHelloStream", "content-type", "application/grpc").
+		bytes())
+	c.send(serverDir, server.headers(1, false, ":status", "200", "content-type", "application/grpc").bytes())
+	require.NoError(t, client.fr.WriteRSTStream(1, http2.ErrCodeCancel))
+	c.send(clientDir, client.bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, "CANCEL", fields["http2"].(mapstr.M)["error_code"])
+	v, _ := fields.GetValue("error.message")
+	assert.Equal(t, "Stream reset by client: CANCEL", v)
+}
+
+func TestExpiredStreams(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client := newFrameWriter(t, true)
+
+	c.send(clientDir, client.
+		headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/").
+		bytes())
+	assert.Empty(t, store.events)
+
+	p.Expired(c.tuple, c.private)
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	v, _ := fields.GetValue("error.message")
+	assert.Equal(t, "Unmatched request", v)
+}
+
+func TestMaxOpenStreams(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"max_open_streams": 1})
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	c.send(clientDir, client.
+		headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/1").
+		headers(3, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/3").
+		bytes())
+	c.send(serverDir, server.
+		headers(3, true, ":status", "200").
+		headers(1, true, ":status", "200").
+		bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "GET /1", fields["query"])
+	assert.Empty(t, store.events)
+}
+
+func TestMaxHeaderBlockSize(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"max_header_block_size": 1024})
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client := newFrameWriter(t, true)
+
+	// The header block is never ended, the CONTINUATION frames keep coming.
+	require.NoError(t, client.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: client.encode(":method", "GET", ":scheme", "http"),
+		EndHeaders:    false,
+	}))
+	c.send(clientDir, client.bytes())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, client.fr.WriteContinuation(1, false, bytes.Repeat([]byte{0}, 200)))
+		c.send(clientDir, client.bytes())
+	}
+
+	conn := c.private.(*connection)
+	assert.True(t, conn.failed)
+	assert.Nil(t, conn.dirs[clientDir])
+	assert.Empty(t, store.events)
+}
+
+func TestNotHTTP2(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	c.send(serverDir, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+
+	assert.Empty(t, store.events)
+	assert.True(t, c.private.(*connection).failed)
+}
+
+func TestHeaderTableSizeSetting(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{t: t, p: p, tuple: testCreateTCPTuple()}
+	client, server := newFrameWriter(t, true), newFrameWriter(t, false)
+
+	// The client allows the server to use a larger dynamic table.
+	require.NoError(t, client.fr.WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 8192}))
+	c.send(clientDir, client.
+		headers(1, true, ":method", "GET", ":scheme", "http", ":authority", "example.com", ":path", "/").
+		bytes())
+	server.hpack.SetMaxDynamicTableSizeLimit(8192)
+	server.hpack.SetMaxDynamicTableSize(8192)
+	c.send(serverDir, server.headers(1, true, ":status", "200").bytes())
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+}
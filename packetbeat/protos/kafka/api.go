@@ -0,0 +1,514 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import "strconv"
+
+// API keys of the requests whose bodies are decoded.
+const (
+	apiProduce      int16 = 0
+	apiFetch        int16 = 1
+	apiMetadata     int16 = 3
+	apiOffsetCommit int16 = 8
+	apiJoinGroup    int16 = 11
+)
+
+var apiNames = []string{
+	"Produce",
+	"Fetch",
+	"ListOffsets",
+	"Metadata",
+	"LeaderAndIsr",
+	"StopReplica",
+	"UpdateMetadata",
+	"ControlledShutdown",
+	"OffsetCommit",
+	"OffsetFetch",
+	"FindCoordinator",
+	"JoinGroup",
+	"Heartbeat",
+	"LeaveGroup",
+	"SyncGroup",
+	"DescribeGroups",
+	"ListGroups",
+	"SaslHandshake",
+	"ApiVersions",
+	"CreateTopics",
+	"DeleteTopics",
+	"DeleteRecords",
+	"InitProducerId",
+	"OffsetForLeaderEpoch",
+	"AddPartitionsToTxn",
+	"AddOffsetsToTxn",
+	"EndTxn",
+	"WriteTxnMarkers",
+	"TxnOffsetCommit",
+	"DescribeAcls",
+	"CreateAcls",
+	"DeleteAcls",
+	"DescribeConfigs",
+	"AlterConfigs",
+	"AlterReplicaLogDirs",
+	"DescribeLogDirs",
+	"SaslAuthenticate",
+	"CreatePartitions",
+	"CreateDelegationToken",
+	"RenewDelegationToken",
+	"ExpireDelegationToken",
+	"DescribeDelegationToken",
+	"DeleteGroups",
+	"ElectLeaders",
+	"IncrementalAlterConfigs",
+	"AlterPartitionReassignments",
+	"ListPartitionReassignments",
+	"OffsetDelete",
+	"DescribeClientQuotas",
+	"AlterClientQuotas",
+	"DescribeUserScramCredentials",
+	"AlterUserScramCredentials",
+}
+
+// apiName returns the name of an API key, or its number for API keys
+// unknown to this analyzer.
+func apiName(key int16) string {
+	if key >= 0 && int(key) < len(apiNames) {
+		return apiNames[key]
+	}
+	return strconv.Itoa(int(key))
+}
+
+var errorNames = map[int16]string{
+	-1: "UNKNOWN_SERVER_ERROR",
+	1:  "OFFSET_OUT_OF_RANGE",
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	4:  "INVALID_FETCH_SIZE",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	8:  "BROKER_NOT_AVAILABLE",
+	9:  "REPLICA_NOT_AVAILABLE",
+	10: "MESSAGE_TOO_LARGE",
+	11: "STALE_CONTROLLER_EPOCH",
+	12: "OFFSET_METADATA_TOO_LARGE",
+	13: "NETWORK_EXCEPTION",
+	14: "COORDINATOR_LOAD_IN_PROGRESS",
+	15: "COORDINATOR_NOT_AVAILABLE",
+	16: "NOT_COORDINATOR",
+	17: "INVALID_TOPIC_EXCEPTION",
+	18: "RECORD_LIST_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	21: "INVALID_REQUIRED_ACKS",
+	22: "ILLEGAL_GENERATION",
+	23: "INCONSISTENT_GROUP_PROTOCOL",
+	24: "INVALID_GROUP_ID",
+	25: "UNKNOWN_MEMBER_ID",
+	26: "INVALID_SESSION_TIMEOUT",
+	27: "REBALANCE_IN_PROGRESS",
+	28: "INVALID_COMMIT_OFFSET_SIZE",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	30: "GROUP_AUTHORIZATION_FAILED",
+	31: "CLUSTER_AUTHORIZATION_FAILED",
+	32: "INVALID_TIMESTAMP",
+	33: "UNSUPPORTED_SASL_MECHANISM",
+	34: "ILLEGAL_SASL_STATE",
+	35: "UNSUPPORTED_VERSION",
+	36: "TOPIC_ALREADY_EXISTS",
+	37: "INVALID_PARTITIONS",
+	38: "INVALID_REPLICATION_FACTOR",
+	39: "INVALID_REPLICA_ASSIGNMENT",
+	40: "INVALID_CONFIG",
+	41: "NOT_CONTROLLER",
+	42: "INVALID_REQUEST",
+	43: "UNSUPPORTED_FOR_MESSAGE_FORMAT",
+	44: "POLICY_VIOLATION",
+	45: "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	46: "DUPLICATE_SEQUENCE_NUMBER",
+	47: "INVALID_PRODUCER_EPOCH",
+	48: "INVALID_TXN_STATE",
+	49: "INVALID_PRODUCER_ID_MAPPING",
+	50: "INVALID_TRANSACTION_TIMEOUT",
+	51: "CONCURRENT_TRANSACTIONS",
+	52: "TRANSACTION_COORDINATOR_FENCED",
+	53: "TRANSACTIONAL_ID_AUTHORIZATION_FAILED",
+	54: "SECURITY_DISABLED",
+	55: "OPERATION_NOT_ATTEMPTED",
+	56: "KAFKA_STORAGE_ERROR",
+	57: "LOG_DIR_NOT_FOUND",
+	58: "SASL_AUTHENTICATION_FAILED",
+	59: "UNKNOWN_PRODUCER_ID",
+	60: "REASSIGNMENT_IN_PROGRESS",
+	61: "DELEGATION_TOKEN_AUTH_DISABLED",
+	62: "DELEGATION_TOKEN_NOT_FOUND",
+	63: "DELEGATION_TOKEN_OWNER_MISMATCH",
+	64: "DELEGATION_TOKEN_REQUEST_NOT_ALLOWED",
+	65: "DELEGATION_TOKEN_AUTHORIZATION_FAILED",
+	66: "DELEGATION_TOKEN_EXPIRED",
+	67: "INVALID_PRINCIPAL_TYPE",
+	68: "NON_EMPTY_GROUP",
+	69: "GROUP_ID_NOT_FOUND",
+	70: "FETCH_SESSION_ID_NOT_FOUND",
+	71: "INVALID_FETCH_SESSION_EPOCH",
+	72: "LISTENER_NOT_FOUND",
+	73: "TOPIC_DELETION_DISABLED",
+	74: "FENCED_LEADER_EPOCH",
+	75: "UNKNOWN_LEADER_EPOCH",
+	76: "UNSUPPORTED_COMPRESSION_TYPE",
+	77: "STALE_BROKER_EPOCH",
+	78: "OFFSET_NOT_AVAILABLE",
+	79: "MEMBER_ID_REQUIRED",
+	80: "PREFERRED_LEADER_NOT_AVAILABLE",
+	81: "GROUP_MAX_SIZE_REACHED",
+	82: "FENCED_INSTANCE_ID",
+}
+
+// details collects the fields decoded from a request or response body.
+type details struct {
+	topics         []string
+	partitions     []int32
+	groupID        string
+	acks           int16
+	hasAcks        bool
+	errorCode      int16
+	throttleTimeMs int32
+	recordBytes    int64
+}
+
+func (info *details) addTopic(name string) {
+	for _, t := range info.topics {
+		if t == name {
+			return
+		}
+	}
+	info.topics = append(info.topics, name)
+}
+
+func (info *details) addPartition(p int32) {
+	for _, q := range info.partitions {
+		if q == p {
+			return
+		}
+	}
+	info.partitions = append(info.partitions, p)
+}
+
+// merge adds the topics, partitions and record bytes of a response to the
+// ones of its request.
+func (info *details) merge(other *details) {
+	for _, t := range other.topics {
+		info.addTopic(t)
+	}
+	for _, p := range other.partitions {
+		info.addPartition(p)
+	}
+	info.recordBytes += other.recordBytes
+}
+
+// setError records the first non-zero error code of a response.
+func (info *details) setError(code int16) {
+	if info.errorCode == 0 {
+		info.errorCode = code
+	}
+}
+
+type bodyDecoder func(d *decoder, version int16, info *details)
+
+// apiSpec describes the versions of an API whose bodies can be decoded.
+// Versions from flexibleVersion on use the compact encodings and tagged
+// fields. Messages of versions above maxVersion are reported with their
+// headers only.
+type apiSpec struct {
+	maxVersion      int16
+	flexibleVersion int16
+	request         bodyDecoder
+	response        bodyDecoder
+}
+
+var apiSpecs = map[int16]apiSpec{
+	apiProduce:      {12, 9, decodeProduceRequest, decodeProduceResponse},
+	apiFetch:        {12, 12, decodeFetchRequest, decodeFetchResponse},
+	apiMetadata:     {12, 9, decodeMetadataRequest, decodeMetadataResponse},
+	apiOffsetCommit: {9, 8, decodeOffsetCommitRequest, decodeOffsetCommitResponse},
+	apiJoinGroup:    {9, 6, decodeJoinGroupRequest, decodeJoinGroupResponse},
+}
+
+// lookupSpec returns the body decoders of an API version.
+func lookupSpec(key, version int16) (apiSpec, bool) {
+	spec, ok := apiSpecs[key]
+	if !ok || version < 0 || version > spec.maxVersion {
+		return apiSpec{}, false
+	}
+	return spec, true
+}
+
+func (spec apiSpec) isFlexible(version int16) bool {
+	return version >= spec.flexibleVersion
+}
+
+func decodeProduceRequest(d *decoder, version int16, info *details) {
+	if version >= 3 {
+		d.string() // transactional_id
+	}
+	info.acks = d.int16()
+	info.hasAcks = true
+	d.int32() // timeout_ms
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			info.recordBytes += int64(d.bytes())
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	d.taggedFields()
+}
+
+func decodeProduceResponse(d *decoder, version int16, info *details) {
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			info.setError(d.int16())
+			d.int64() // base_offset
+			if version >= 2 {
+				d.int64() // log_append_time_ms
+			}
+			if version >= 5 {
+				d.int64() // log_start_offset
+			}
+			if version >= 8 {
+				d.array(func() {
+					d.int32()  // batch_index
+					d.string() // batch_index_error_message
+					d.taggedFields()
+				})
+				d.string() // error_message
+			}
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	if version >= 1 {
+		info.throttleTimeMs = d.int32()
+	}
+	d.taggedFields()
+}
+
+func decodeFetchRequest(d *decoder, version int16, info *details) {
+	d.int32() // replica_id
+	d.int32() // max_wait_ms
+	d.int32() // min_bytes
+	if version >= 3 {
+		d.int32() // max_bytes
+	}
+	if version >= 4 {
+		d.int8() // isolation_level
+	}
+	if version >= 7 {
+		d.int32() // session_id
+		d.int32() // session_epoch
+	}
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			if version >= 9 {
+				d.int32() // current_leader_epoch
+			}
+			d.int64() // fetch_offset
+			if version >= 12 {
+				d.int32() // last_fetched_epoch
+			}
+			if version >= 5 {
+				d.int64() // log_start_offset
+			}
+			d.int32() // partition_max_bytes
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	// forgotten_topics_data and rack_id are not reported.
+}
+
+func decodeFetchResponse(d *decoder, version int16, info *details) {
+	if version >= 1 {
+		info.throttleTimeMs = d.int32()
+	}
+	if version >= 7 {
+		info.setError(d.int16())
+		d.int32() // session_id
+	}
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			info.setError(d.int16())
+			d.int64() // high_watermark
+			if version >= 4 {
+				d.int64() // last_stable_offset
+			}
+			if version >= 5 {
+				d.int64() // log_start_offset
+			}
+			if version >= 4 {
+				d.array(func() {
+					d.int64() // producer_id
+					d.int64() // first_offset
+					d.taggedFields()
+				})
+			}
+			if version >= 11 {
+				d.int32() // preferred_read_replica
+			}
+			info.recordBytes += int64(d.bytes())
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	d.taggedFields()
+}
+
+func decodeMetadataRequest(d *decoder, version int16, info *details) {
+	d.array(func() {
+		if version >= 10 {
+			d.uuid() // topic_id
+		}
+		if name := d.string(); name != "" {
+			info.addTopic(name)
+		}
+		d.taggedFields()
+	})
+	// The remaining flags are not reported.
+}
+
+func decodeMetadataResponse(d *decoder, version int16, info *details) {
+	if version >= 3 {
+		info.throttleTimeMs = d.int32()
+	}
+	d.array(func() {
+		d.int32()  // node_id
+		d.string() // host
+		d.int32()  // port
+		if version >= 1 {
+			d.string() // rack
+		}
+		d.taggedFields()
+	})
+	if version >= 2 {
+		d.string() // cluster_id
+	}
+	if version >= 1 {
+		d.int32() // controller_id
+	}
+	d.array(func() {
+		info.setError(d.int16())
+		if name := d.string(); name != "" {
+			info.addTopic(name)
+		}
+		if version >= 10 {
+			d.uuid() // topic_id
+		}
+		if version >= 1 {
+			d.bool() // is_internal
+		}
+		d.array(func() {
+			info.setError(d.int16())
+			info.addPartition(d.int32())
+			d.int32() // leader_id
+			if version >= 7 {
+				d.int32() // leader_epoch
+			}
+			d.int32Array() // replica_nodes
+			d.int32Array() // isr_nodes
+			if version >= 5 {
+				d.int32Array() // offline_replicas
+			}
+			d.taggedFields()
+		})
+		if version >= 8 {
+			d.int32() // topic_authorized_operations
+		}
+		d.taggedFields()
+	})
+	if version >= 8 && version <= 10 {
+		d.int32() // cluster_authorized_operations
+	}
+	d.taggedFields()
+}
+
+func decodeOffsetCommitRequest(d *decoder, version int16, info *details) {
+	info.groupID = d.string()
+	if version >= 1 {
+		d.int32()  // generation_id
+		d.string() // member_id
+	}
+	if version >= 7 {
+		d.string() // group_instance_id
+	}
+	if version >= 2 && version <= 4 {
+		d.int64() // retention_time_ms
+	}
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			d.int64() // committed_offset
+			if version >= 6 {
+				d.int32() // committed_leader_epoch
+			}
+			if version == 1 {
+				d.int64() // commit_timestamp
+			}
+			d.string() // committed_metadata
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	d.taggedFields()
+}
+
+func decodeOffsetCommitResponse(d *decoder, version int16, info *details) {
+	if version >= 3 {
+		info.throttleTimeMs = d.int32()
+	}
+	d.array(func() {
+		info.addTopic(d.string())
+		d.array(func() {
+			info.addPartition(d.int32())
+			info.setError(d.int16())
+			d.taggedFields()
+		})
+		d.taggedFields()
+	})
+	d.taggedFields()
+}
+
+func decodeJoinGroupRequest(d *decoder, version int16, info *details) {
+	info.groupID = d.string()
+	// The membership and protocol details are not reported.
+}
+
+func decodeJoinGroupResponse(d *decoder, version int16, info *details) {
+	if version >= 2 {
+		info.throttleTimeMs = d.int32()
+	}
+	info.setError(d.int16())
+	// The assignment details are not reported.
+}
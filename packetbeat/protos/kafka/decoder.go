@@ -0,0 +1,192 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errShortBuffer = errors.New("kafka message too short")
+
+// decoder reads the primitive types of the Kafka protocol from a message
+// body. The first error encountered is sticky, all reads after it return
+// zero values, so a decoding function only needs to check err once at the
+// end.
+//
+// Flexible versions of a message use compact encodings for strings, arrays
+// and bytes and may carry tagged fields at the end of every structure.
+type decoder struct {
+	buf      []byte
+	off      int
+	flexible bool
+	err      error
+}
+
+func newDecoder(buf []byte, flexible bool) *decoder {
+	return &decoder{buf: buf, flexible: flexible}
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf)-d.off < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) skip(n int) {
+	d.take(n)
+}
+
+func (d *decoder) int8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.off:])
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+// length reads the length prefix of a string, an array or a bytes field.
+// Null values are reported as -1. Compact lengths are stored plus one, so
+// that zero can encode null.
+func (d *decoder) length(classic func() int) int {
+	if d.flexible {
+		n := d.uvarint()
+		if n > uint64(len(d.buf)) {
+			d.err = errShortBuffer
+			return -1
+		}
+		return int(n) - 1
+	}
+	return classic()
+}
+
+func (d *decoder) string() string {
+	n := d.length(func() int { return int(d.int16()) })
+	if n <= 0 {
+		return ""
+	}
+	return string(d.take(n))
+}
+
+// bytes skips a bytes field and returns its length. Null values have a
+// length of zero.
+func (d *decoder) bytes() int {
+	n := d.length(func() int { return int(d.int32()) })
+	if n <= 0 {
+		return 0
+	}
+	d.skip(n)
+	return n
+}
+
+// arrayLen returns the number of elements of an array. Null arrays have a
+// length of -1. The length is checked against the remaining bytes, as every
+// element takes at least one byte.
+func (d *decoder) arrayLen() int {
+	n := d.length(func() int { return int(d.int32()) })
+	if d.err == nil && n > len(d.buf)-d.off {
+		d.err = errShortBuffer
+		return -1
+	}
+	return n
+}
+
+// array calls fn for every element of an array.
+func (d *decoder) array(fn func()) {
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		fn()
+	}
+}
+
+func (d *decoder) int32Array() {
+	n := d.arrayLen()
+	if n > 0 {
+		d.skip(4 * n)
+	}
+}
+
+func (d *decoder) uuid() {
+	d.skip(16)
+}
+
+// taggedFields skips the tagged fields at the end of a structure in
+// flexible versions.
+func (d *decoder) taggedFields() {
+	if !d.flexible {
+		return
+	}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		d.uvarint() // tag
+		size := d.uvarint()
+		if size > uint64(len(d.buf)) {
+			d.err = errShortBuffer
+			return
+		}
+		d.skip(int(size))
+	}
+}
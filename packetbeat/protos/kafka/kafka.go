@@ -0,0 +1,222 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// kafka application level protocol analyzer plugin
+type kafka struct {
+	ports        protos.PortsConfig
+	parserConfig parserConfig
+	transConfig  transactionConfig
+	watcher      *procs.ProcessesWatcher
+	pub          transPub
+}
+
+// Application Layer tcp stream data to be stored on tcp connection context.
+type connection struct {
+	// requestDir is the tcp direction of the client requests. It is
+	// derived from the configured broker ports on the first packet.
+	requestDir uint8
+
+	streams [2]*stream
+	trans   transactions
+}
+
+// Uni-directional tcp stream state for parsing messages.
+type stream struct {
+	parser parser
+}
+
+var debugf = logp.MakeDebug("kafka")
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "kafka.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "kafka.unmatched_responses")
+)
+
+func init() {
+	protos.Register("kafka", New)
+}
+
+// New create and initializes a new kafka protocol analyzer instance.
+func New(
+	testMode bool,
+	results protos.Reporter,
+	watcher *procs.ProcessesWatcher,
+	cfg *conf.C,
+) (protos.Plugin, error) {
+	p := &kafka{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, watcher, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (kafka *kafka) init(results protos.Reporter, watcher *procs.ProcessesWatcher, config *kafkaConfig) error {
+	if err := kafka.setFromConfig(config); err != nil {
+		return err
+	}
+	kafka.pub.results = results
+	kafka.watcher = watcher
+	isDebug = logp.IsDebug("kafka")
+	return nil
+}
+
+func (kafka *kafka) setFromConfig(config *kafkaConfig) error {
+	// set module configuration
+	if err := kafka.ports.Set(config.Ports); err != nil {
+		return err
+	}
+
+	// set parser configuration
+	kafka.parserConfig.maxBytes = tcp.TCPMaxDataInStream
+
+	// set transaction correlator configuration
+	trans := &kafka.transConfig
+	trans.transactionTimeout = config.TransactionTimeout
+	trans.maxPending = config.MaxPendingRequests
+
+	return nil
+}
+
+// ConnectionTimeout returns the per stream connection timeout.
+// Return <=0 to set default tcp module transaction timeout.
+func (kafka *kafka) ConnectionTimeout() time.Duration {
+	return kafka.transConfig.transactionTimeout
+}
+
+// GetPorts returns the ports numbers packets shall be processed for.
+func (kafka *kafka) GetPorts() []int {
+	return kafka.ports.Ports
+}
+
+// Parse processes a TCP packet. Return nil if connection
+// state shall be dropped (e.g. parser not in sync with tcp stream)
+func (kafka *kafka) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	conn := getConnection(private)
+	if conn == nil {
+		conn = kafka.newConnection(pkt, dir)
+	}
+
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.parser.init(&kafka.parserConfig, dir == conn.requestDir, dir, func(msg *message) error {
+			return conn.trans.onMessage(tcptuple.IPPort(), msg)
+		})
+		conn.streams[dir] = st
+	}
+
+	if err := st.parser.feed(pkt.Ts, pkt.Payload); err != nil {
+		debugf("%v, dropping TCP stream for error in direction %v.", err, dir)
+		conn.trans.flush()
+		return nil
+	}
+	return conn
+}
+
+// ReceivedFin handles TCP-FIN packet.
+func (kafka *kafka) ReceivedFin(
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	return private
+}
+
+// GapInStream handles lost packets in tcp-stream.
+func (kafka *kafka) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int,
+	private protos.ProtocolData,
+) (protos.ProtocolData, bool) {
+	conn := getConnection(private)
+	if conn != nil {
+		conn.trans.flush()
+	}
+
+	return nil, true
+}
+
+// Expired publishes the requests still waiting for a response when the
+// connection times out.
+func (kafka *kafka) Expired(tuple *common.TCPTuple, private protos.ProtocolData) {
+	conn := getConnection(private)
+	if conn != nil {
+		conn.trans.flush()
+	}
+}
+
+// newConnection creates the state of a new connection. The requests are
+// sent to the configured broker ports. If neither port is a broker port,
+// the first packet seen is assumed to be a request.
+func (kafka *kafka) newConnection(pkt *protos.Packet, dir uint8) *connection {
+	conn := &connection{requestDir: dir}
+	if !kafka.isBrokerPort(pkt.Tuple.DstPort) && kafka.isBrokerPort(pkt.Tuple.SrcPort) {
+		conn.requestDir = 1 - dir
+	}
+	conn.trans.init(&kafka.transConfig, kafka.watcher, kafka.pub.onTransaction)
+	return conn
+}
+
+func (kafka *kafka) isBrokerPort(port uint16) bool {
+	for _, p := range kafka.ports.Ports {
+		if p == int(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func getConnection(private protos.ProtocolData) *connection {
+	if private == nil {
+		return nil
+	}
+
+	priv, ok := private.(*connection)
+	if !ok {
+		logp.Warn("kafka connection type error")
+		return nil
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: kafka connection data not set")
+		return nil
+	}
+	return priv
+}
@@ -0,0 +1,533 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package kafka
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+	"github.com/elastic/beats/v7/packetbeat/publish"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	clientDir = tcp.TCPDirectionOriginal
+	serverDir = tcp.TCPDirectionReverse
+)
+
+type eventStore struct {
+	events []beat.Event
+}
+
+func (e *eventStore) publish(event beat.Event) {
+	publish.MarshalPacketbeatFields(&event, nil, nil)
+	e.events = append(e.events, event)
+}
+
+func newTestPlugin(t *testing.T, store *eventStore, settings map[string]interface{}) *kafka {
+	t.Helper()
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	if _, ok := settings["ports"]; !ok {
+		settings["ports"] = []int{9092}
+	}
+	p, err := New(false, store.publish, &procs.ProcessesWatcher{}, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p.(*kafka)
+}
+
+func testCreateTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		BaseTuple: common.BaseTuple{
+			SrcIP: net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+			SrcPort: 6512, DstPort: 9092,
+		},
+	}
+	t.ComputeHashables()
+	return t
+}
+
+type testConn struct {
+	p       *kafka
+	tuple   *common.TCPTuple
+	private protos.ProtocolData
+}
+
+func (c *testConn) send(dir uint8, payload []byte) {
+	pkt := protos.Packet{Ts: time.Now(), Tuple: *c.tuple.IPPort(), Payload: payload}
+	if dir == tcp.TCPDirectionReverse {
+		pkt.Tuple = common.NewIPPortTuple(4,
+			c.tuple.DstIP, c.tuple.DstPort, c.tuple.SrcIP, c.tuple.SrcPort)
+	}
+	c.private = c.p.Parse(&pkt, c.tuple, dir, c.private)
+}
+
+func expectTransaction(t *testing.T, e *eventStore) mapstr.M {
+	t.Helper()
+	if len(e.events) == 0 {
+		t.Fatal("No transaction")
+	}
+	event := e.events[0]
+	e.events = e.events[1:]
+	return event.Fields
+}
+
+// encoder writes Kafka messages, using the compact encodings when flexible
+// is set.
+type encoder struct {
+	buf      []byte
+	flexible bool
+}
+
+func (e *encoder) i8(v int8) *encoder {
+	e.buf = append(e.buf, byte(v))
+	return e
+}
+
+func (e *encoder) i16(v int16) *encoder {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+	return e
+}
+
+func (e *encoder) i32(v int32) *encoder {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+	return e
+}
+
+func (e *encoder) i64(v int64) *encoder {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+	return e
+}
+
+func (e *encoder) length(n int, classic func(int)) {
+	if e.flexible {
+		e.buf = binary.AppendUvarint(e.buf, uint64(n+1))
+	} else {
+		classic(n)
+	}
+}
+
+func (e *encoder) str(s string) *encoder {
+	e.length(len(s), func(n int) { e.i16(int16(n)) })
+	e.buf = append(e.buf, s...)
+	return e
+}
+
+func (e *encoder) bytes(n int) *encoder {
+	e.length(n, func(n int) { e.i32(int32(n)) })
+	e.buf = append(e.buf, make([]byte, max(n, 0))...)
+	return e
+}
+
+func (e *encoder) array(n int) *encoder {
+	e.length(n, func(n int) { e.i32(int32(n)) })
+	return e
+}
+
+// tags writes the tagged fields of a structure, with one tagged field of
+// the given size if size is positive.
+func (e *encoder) tags(size int) *encoder {
+	if !e.flexible {
+		return e
+	}
+	if size <= 0 {
+		return e.i8(0)
+	}
+	e.buf = append(e.buf, 1, 0)
+	e.buf = binary.AppendUvarint(e.buf, uint64(size))
+	e.buf = append(e.buf, make([]byte, size)...)
+	return e
+}
+
+func request(key, version int16, correlationID int32, flexible bool, body func(e *encoder)) []byte {
+	e := &encoder{}
+	e.i16(key).i16(version).i32(correlationID).str("producer-1")
+	e.flexible = flexible
+	e.tags(0)
+	if body != nil {
+		body(e)
+	}
+	return withSize(e.buf)
+}
+
+func response(correlationID int32, flexible bool, body func(e *encoder)) []byte {
+	e := &encoder{flexible: flexible}
+	e.i32(correlationID).tags(0)
+	if body != nil {
+		body(e)
+	}
+	return withSize(e.buf)
+}
+
+func withSize(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...)
+}
+
+func produceRequest(correlationID int32, acks int16) []byte {
+	return request(apiProduce, 7, correlationID, false, func(e *encoder) {
+		e.str("").i16(acks).i32(30000)
+		e.array(1).str("orders")
+		e.array(2).i32(0).bytes(100).i32(1).bytes(50)
+	})
+}
+
+func produceResponse(correlationID int32, errorCode int16) []byte {
+	return response(correlationID, false, func(e *encoder) {
+		e.array(1).str("orders")
+		e.array(2)
+		e.i32(0).i16(0).i64(42).i64(-1).i64(0)
+		e.i32(1).i16(errorCode).i64(-1).i64(-1).i64(0)
+		e.i32(0)
+	})
+}
+
+func TestProduce(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	requ, resp := produceRequest(7, -1), produceResponse(7, 0)
+	c.send(clientDir, requ)
+	assert.Empty(t, store.events)
+	c.send(serverDir, resp)
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "kafka", fields["type"])
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, "Produce", fields["method"])
+	assert.Equal(t, mapstr.M{
+		"api_key":        "Produce",
+		"api_version":    int16(7),
+		"correlation_id": int32(7),
+		"client_id":      "producer-1",
+		"acks":           int16(-1),
+		"topics":         []string{"orders"},
+		"partitions":     []int32{0, 1},
+		"record_bytes":   int64(150),
+	}, fields["kafka"])
+
+	v, _ := fields.GetValue("source.bytes")
+	assert.Equal(t, int64(len(requ)), v)
+	v, _ = fields.GetValue("destination.bytes")
+	assert.Equal(t, int64(len(resp)), v)
+	v, _ = fields.GetValue("source.port")
+	assert.Equal(t, int64(6512), v)
+	v, _ = fields.GetValue("destination.port")
+	assert.Equal(t, int64(9092), v)
+	v, _ = fields.GetValue("network.protocol")
+	assert.Equal(t, "kafka", v)
+	_, err := fields.GetValue("event.duration")
+	assert.NoError(t, err)
+}
+
+func TestProducePartitionError(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, produceRequest(1, 1))
+	c.send(serverDir, produceResponse(1, 6))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	kafka := fields["kafka"].(mapstr.M)
+	assert.Equal(t, int16(6), kafka["error_code"])
+	assert.Equal(t, "NOT_LEADER_OR_FOLLOWER", kafka["error"])
+}
+
+func TestProduceWithoutAcks(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, produceRequest(3, 0))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, int16(0), fields["kafka"].(mapstr.M)["acks"])
+	_, err := fields.GetValue("destination.bytes")
+	assert.Error(t, err)
+	_, err = fields.GetValue("error.message")
+	assert.Error(t, err)
+}
+
+func TestFetchFlexible(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, request(apiFetch, 12, 21, true, func(e *encoder) {
+		e.i32(-1).i32(500).i32(1).i32(52428800).i8(0).i32(0).i32(-1)
+		e.array(1).str("orders")
+		e.array(2)
+		e.i32(0).i32(-1).i64(42).i32(-1).i64(-1).i32(1048576).tags(0)
+		e.i32(1).i32(-1).i64(10).i32(-1).i64(-1).i32(1048576).tags(0)
+		e.tags(0)
+		e.array(0).str("rack-1").tags(0)
+	}))
+	c.send(serverDir, response(21, true, func(e *encoder) {
+		e.i32(5).i16(0).i32(123)
+		e.array(1).str("orders")
+		e.array(2)
+		e.i32(0).i16(0).i64(100).i64(100).i64(0).array(0).i32(-1).bytes(30).tags(4)
+		e.i32(1).i16(1).i64(-1).i64(-1).i64(-1).array(-1).i32(-1).bytes(-1).tags(0)
+		e.tags(0)
+		e.tags(0)
+	}))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"api_key":          "Fetch",
+		"api_version":      int16(12),
+		"correlation_id":   int32(21),
+		"client_id":        "producer-1",
+		"topics":           []string{"orders"},
+		"partitions":       []int32{0, 1},
+		"record_bytes":     int64(30),
+		"throttle_time_ms": int32(5),
+		"error_code":       int16(1),
+		"error":            "OFFSET_OUT_OF_RANGE",
+	}, fields["kafka"])
+}
+
+func TestMetadataFlexible(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, request(apiMetadata, 9, 2, true, func(e *encoder) {
+		e.array(2).str("orders").tags(0).str("payments").tags(0)
+		e.i8(1).i8(0).tags(0)
+	}))
+	c.send(serverDir, response(2, true, func(e *encoder) {
+		e.i32(0)
+		e.array(1).i32(1).str("broker-1").i32(9092).str("").tags(0)
+		e.str("cluster").i32(1)
+		e.array(2)
+		e.i16(0).str("orders").i8(0)
+		e.array(1).i16(0).i32(0).i32(1).i32(5).array(1).i32(1).array(1).i32(1).array(0).tags(0)
+		e.i32(0).tags(3)
+		e.i16(3).str("payments").i8(0).array(0).i32(0).tags(0)
+		e.i32(0).tags(0)
+	}))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	kafka := fields["kafka"].(mapstr.M)
+	assert.Equal(t, []string{"orders", "payments"}, kafka["topics"])
+	assert.Equal(t, []int32{0}, kafka["partitions"])
+	assert.Equal(t, "UNKNOWN_TOPIC_OR_PARTITION", kafka["error"])
+}
+
+func TestConsumerGroup(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, request(apiJoinGroup, 5, 1, false, func(e *encoder) {
+		e.str("billing").i32(45000).i32(300000).str("").str("").str("consumer")
+		e.array(1).str("range").bytes(20)
+	}))
+	c.send(serverDir, response(1, false, func(e *encoder) {
+		e.i32(0).i16(79).i32(-1).str("").str("").str("consumer-1-abc").array(0)
+	}))
+	c.send(clientDir, request(apiOffsetCommit, 8, 2, true, func(e *encoder) {
+		e.str("billing").i32(3).str("consumer-1-abc").str("")
+		e.array(1).str("orders")
+		e.array(1).i32(4).i64(1000).i32(-1).str("").tags(0)
+		e.tags(0).tags(0)
+	}))
+	c.send(serverDir, response(2, true, func(e *encoder) {
+		e.i32(0).array(1).str("orders").array(1).i32(4).i16(0).tags(0).tags(0).tags(0)
+	}))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"api_key":        "JoinGroup",
+		"api_version":    int16(5),
+		"correlation_id": int32(1),
+		"client_id":      "producer-1",
+		"group_id":       "billing",
+		"error_code":     int16(79),
+		"error":          "MEMBER_ID_REQUIRED",
+	}, fields["kafka"])
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"api_key":        "OffsetCommit",
+		"api_version":    int16(8),
+		"correlation_id": int32(2),
+		"client_id":      "producer-1",
+		"group_id":       "billing",
+		"topics":         []string{"orders"},
+		"partitions":     []int32{4},
+	}, fields["kafka"])
+}
+
+func TestCorrelation(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	// Pipelined requests sent in a single packet. The API version of the
+	// ApiVersions request is not decoded, only its header is reported.
+	var pkt []byte
+	pkt = append(pkt, request(18, 3, 1, true, func(e *encoder) { e.str("kafka-go").str("0.4").tags(0) })...)
+	pkt = append(pkt, request(apiMetadata, 1, 2, false, func(e *encoder) { e.array(1).str("orders") })...)
+	pkt = append(pkt, request(apiMetadata, 1, 3, false, func(e *encoder) { e.array(-1) })...)
+	c.send(clientDir, pkt)
+
+	c.send(serverDir, response(99, false, nil))
+	assert.Empty(t, store.events)
+
+	c.send(serverDir, response(2, false, func(e *encoder) { e.array(0).i32(1).array(0) }))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, "ApiVersions", fields["method"])
+	assert.Equal(t, mapstr.M{
+		"api_key":        "ApiVersions",
+		"api_version":    int16(3),
+		"correlation_id": int32(1),
+		"client_id":      "producer-1",
+	}, fields["kafka"])
+	v, _ := fields.GetValue("error.message")
+	assert.Equal(t, "Unmatched request", v)
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, int32(2), fields["kafka"].(mapstr.M)["correlation_id"])
+	assert.Empty(t, store.events)
+
+	p.Expired(c.tuple, c.private)
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, int32(3), fields["kafka"].(mapstr.M)["correlation_id"])
+	assert.Equal(t, "Error", fields["status"])
+}
+
+func TestMaxPendingRequests(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"max_pending_requests": 2})
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	for id := int32(1); id <= 3; id++ {
+		c.send(clientDir, produceRequest(id, 1))
+	}
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, int32(1), fields["kafka"].(mapstr.M)["correlation_id"])
+	assert.Equal(t, "Error", fields["status"])
+	assert.Empty(t, store.events)
+}
+
+func TestSplitMessages(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	for _, b := range produceRequest(5, -1) {
+		c.send(clientDir, []byte{b})
+	}
+	resp := produceResponse(5, 0)
+	c.send(serverDir, resp[:3])
+	c.send(serverDir, resp[3:20])
+	assert.Empty(t, store.events)
+	c.send(serverDir, resp[20:])
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, int64(150), fields["kafka"].(mapstr.M)["record_bytes"])
+}
+
+func TestOversizedMessage(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	p.parserConfig.maxBytes = 600
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	big := request(apiProduce, 7, 1, false, func(e *encoder) {
+		e.str("").i16(1).i32(30000)
+		e.array(1).str("orders")
+		e.array(1).i32(0).bytes(2000)
+	})
+	c.send(clientDir, big[:1000])
+	c.send(clientDir, append(big[1000:], produceRequest(2, -1)...))
+	c.send(serverDir, append(produceResponse(1, 0), produceResponse(2, 0)...))
+
+	// Only the header of the oversized request is reported.
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"api_key":        "Produce",
+		"api_version":    int16(7),
+		"correlation_id": int32(1),
+		"client_id":      "producer-1",
+		"topics":         []string{"orders"},
+		"partitions":     []int32{0, 1},
+	}, fields["kafka"])
+	v, _ := fields.GetValue("source.bytes")
+	assert.Equal(t, int64(len(big)), v)
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, int64(150), fields["kafka"].(mapstr.M)["record_bytes"])
+}
+
+func TestNotKafka(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Nil(t, c.private)
+	assert.Empty(t, store.events)
+}
+
+func TestResponseFirstOnBrokerPort(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	tuple := testCreateTCPTuple()
+	c := &testConn{p: p, tuple: tuple}
+
+	// The first packet seen is a response sent from the broker port, the
+	// other direction carries the requests.
+	c.send(serverDir, response(1, false, nil))
+	c.send(clientDir, produceRequest(2, -1))
+	c.send(serverDir, produceResponse(2, 0))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, int32(2), fields["kafka"].(mapstr.M)["correlation_id"])
+	v, _ := fields.GetValue("destination.port")
+	assert.Equal(t, int64(9092), v)
+}
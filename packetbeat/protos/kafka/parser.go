@@ -0,0 +1,281 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common/streambuf"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+const (
+	// Size of the length prefix of every message.
+	sizeLen = 4
+
+	// Bytes of an oversized message that are buffered to decode its header.
+	maxHeaderLen = 512
+
+	// Upper bound of the size of a message. Larger sizes are a sign of a
+	// stream not carrying Kafka traffic, brokers limit requests to 100MB by
+	// default.
+	maxMessageSize = 1 << 30
+
+	// Upper bounds of the API keys and versions accepted in request
+	// headers, used to detect streams that do not carry Kafka traffic.
+	maxAPIKey     = 1024
+	maxAPIVersion = 128
+)
+
+type parser struct {
+	buf       streambuf.Buffer
+	config    *parserConfig
+	isRequest bool
+	dir       uint8
+	onMessage func(m *message) error
+
+	// skip is the number of bytes of an oversized message still to be
+	// discarded.
+	skip int
+}
+
+type parserConfig struct {
+	maxBytes int
+}
+
+type message struct {
+	applayer.Message
+
+	// tcp direction the message was received in.
+	dir uint8
+
+	correlationID int32
+	apiKey        int16
+	apiVersion    int16
+	clientID      string
+
+	// noResponse is set on requests the broker does not answer, like
+	// Produce requests with acks set to 0.
+	noResponse bool
+
+	// truncated is set on messages larger than the stream buffer, of which
+	// only the header is decoded.
+	truncated bool
+
+	// body holds the raw response body until the response is correlated
+	// with its request.
+	body []byte
+	info details
+
+	// list element use by 'transactions' for correlation
+	next *message
+}
+
+var (
+	errInvalidSize   = errors.New("invalid kafka message size")
+	errInvalidHeader = errors.New("invalid kafka request header")
+	isDebug          = false
+)
+
+func (p *parser) init(
+	cfg *parserConfig,
+	isRequest bool,
+	dir uint8,
+	onMessage func(*message) error,
+) {
+	*p = parser{
+		buf:       streambuf.Buffer{},
+		config:    cfg,
+		isRequest: isRequest,
+		dir:       dir,
+		onMessage: onMessage,
+	}
+}
+
+func (p *parser) feed(ts time.Time, data []byte) error {
+	if p.skip > 0 {
+		n := min(p.skip, len(data))
+		p.skip -= n
+		data = data[n:]
+	}
+	if len(data) > 0 {
+		if _, err := p.buf.Write(data); err != nil {
+			return err
+		}
+	}
+
+	for p.skip == 0 && p.buf.Avail(sizeLen) {
+		msg, err := p.parse(ts)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			break // wait for more data
+		}
+
+		if err := p.onMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse decodes the next message in the buffer. Messages exceeding the
+// buffer limit are reported once their header is available, the rest of
+// the message is skipped.
+func (p *parser) parse(ts time.Time) (*message, error) {
+	size := int(int32(binary.BigEndian.Uint32(p.buf.Bytes())))
+	minSize := 4 // correlation_id
+	if p.isRequest {
+		minSize = 8 // api_key, api_version, correlation_id
+	}
+	if size < minSize || size > maxMessageSize {
+		return nil, errInvalidSize
+	}
+
+	total := sizeLen + size
+	truncated := p.config.maxBytes > 0 && total > p.config.maxBytes
+	n := total
+	if truncated {
+		n = min(total, sizeLen+maxHeaderLen)
+	}
+	if !p.buf.Avail(n) {
+		return nil, nil
+	}
+
+	raw, err := p.buf.Collect(n)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &message{
+		Message: applayer.Message{
+			Ts:        ts,
+			IsRequest: p.isRequest,
+			Size:      uint64(total),
+		},
+		dir:       p.dir,
+		truncated: truncated,
+	}
+	if p.isRequest {
+		msg.Direction = applayer.NetOriginalDirection
+		err = decodeRequest(msg, raw[sizeLen:])
+	} else {
+		msg.Direction = applayer.NetReverseDirection
+		err = decodeResponseHeader(msg, raw[sizeLen:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		if isDebug {
+			debugf("%s of %d bytes exceeds the buffer limit, skipping its body",
+				messageKind(msg), total)
+		}
+		p.skipBuffered(total - n)
+	}
+	p.buf.Reset()
+	return msg, nil
+}
+
+// skipBuffered discards the rest of an oversized message, first from the
+// buffer and then from the data received later.
+func (p *parser) skipBuffered(n int) {
+	buffered := min(n, p.buf.Len())
+	_ = p.buf.Advance(buffered)
+	p.skip = n - buffered
+}
+
+func messageKind(msg *message) string {
+	if msg.IsRequest {
+		return "request"
+	}
+	return "response"
+}
+
+// decodeRequest decodes the header of a request and, for the supported
+// API versions, the topics and partitions of its body.
+func decodeRequest(msg *message, buf []byte) error {
+	d := newDecoder(buf, false)
+	msg.apiKey = d.int16()
+	msg.apiVersion = d.int16()
+	msg.correlationID = d.int32()
+	if msg.apiKey < 0 || msg.apiKey >= maxAPIKey ||
+		msg.apiVersion < 0 || msg.apiVersion >= maxAPIVersion {
+		return errInvalidHeader
+	}
+
+	// The client_id is a classic nullable string in all header versions.
+	msg.clientID = d.string()
+	if d.err != nil {
+		if msg.truncated {
+			return nil
+		}
+		return errInvalidHeader
+	}
+
+	spec, ok := lookupSpec(msg.apiKey, msg.apiVersion)
+	if !ok || msg.truncated {
+		return nil
+	}
+
+	d.flexible = spec.isFlexible(msg.apiVersion)
+	d.taggedFields()
+	spec.request(d, msg.apiVersion, &msg.info)
+	if d.err != nil {
+		debugf("failed to decode %s v%d request: %v",
+			apiName(msg.apiKey), msg.apiVersion, d.err)
+		return nil
+	}
+
+	if msg.apiKey == apiProduce && msg.info.acks == 0 {
+		msg.noResponse = true
+	}
+	return nil
+}
+
+// decodeResponseHeader decodes the correlation ID of a response. The body
+// can only be decoded once the API key of the matching request is known.
+func decodeResponseHeader(msg *message, buf []byte) error {
+	msg.correlationID = int32(binary.BigEndian.Uint32(buf))
+	if !msg.truncated {
+		msg.body = append([]byte(nil), buf[4:]...)
+	}
+	return nil
+}
+
+// decodeResponse decodes the body of a response using the API version of
+// its request.
+func decodeResponse(requ, resp *message) {
+	spec, ok := lookupSpec(requ.apiKey, requ.apiVersion)
+	if !ok || resp.body == nil {
+		return
+	}
+
+	d := newDecoder(resp.body, spec.isFlexible(requ.apiVersion))
+	d.taggedFields()
+	spec.response(d, requ.apiVersion, &resp.info)
+	if d.err != nil {
+		debugf("failed to decode %s v%d response: %v",
+			apiName(requ.apiKey), requ.apiVersion, d.err)
+	}
+	resp.body = nil
+}
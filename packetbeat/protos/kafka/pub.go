@@ -0,0 +1,115 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/beats/v7/packetbeat/pb"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// Transaction Publisher.
+type transPub struct {
+	results protos.Reporter
+}
+
+func (pub *transPub) onTransaction(requ, resp *message) error {
+	if pub.results == nil {
+		return nil
+	}
+
+	pub.results(pub.createEvent(requ, resp))
+	return nil
+}
+
+func (pub *transPub) createEvent(requ, resp *message) beat.Event {
+	src, dst := common.MakeEndpointPair(requ.Tuple.BaseTuple, requ.CmdlineTuple)
+	if requ.dir == tcp.TCPDirectionReverse {
+		src, dst = dst, src
+	}
+
+	evt, pbf := pb.NewBeatEvent(requ.Ts)
+	pbf.SetSource(&src)
+	pbf.AddIP(src.IP)
+	pbf.SetDestination(&dst)
+	pbf.AddIP(dst.IP)
+	pbf.Event.Dataset = "kafka"
+	pbf.Network.Transport = "tcp"
+	pbf.Network.Protocol = pbf.Event.Dataset
+	pbf.Source.Bytes = int64(requ.Size)
+	pbf.Event.Start = requ.Ts
+
+	fields := evt.Fields
+	fields["type"] = pbf.Event.Dataset
+	fields["method"] = apiName(requ.apiKey)
+
+	kafka := mapstr.M{
+		"api_key":        apiName(requ.apiKey),
+		"api_version":    requ.apiVersion,
+		"correlation_id": requ.correlationID,
+	}
+	if requ.clientID != "" {
+		kafka["client_id"] = requ.clientID
+	}
+	if requ.info.groupID != "" {
+		kafka["group_id"] = requ.info.groupID
+	}
+	if requ.info.hasAcks {
+		kafka["acks"] = requ.info.acks
+	}
+
+	info := requ.info
+	status := common.OK_STATUS
+	switch {
+	case resp != nil:
+		pbf.Destination.Bytes = int64(resp.Size)
+		pbf.Event.End = resp.Ts
+		info.merge(&resp.info)
+		if resp.info.throttleTimeMs > 0 {
+			kafka["throttle_time_ms"] = resp.info.throttleTimeMs
+		}
+		if code := resp.info.errorCode; code != 0 {
+			status = common.ERROR_STATUS
+			kafka["error_code"] = code
+			if name, ok := errorNames[code]; ok {
+				kafka["error"] = name
+			}
+		}
+	case !requ.noResponse:
+		status = common.ERROR_STATUS
+		pbf.Error.Message = append(pbf.Error.Message, "Unmatched request")
+	}
+
+	if len(info.topics) > 0 {
+		kafka["topics"] = info.topics
+	}
+	if len(info.partitions) > 0 {
+		kafka["partitions"] = info.partitions
+	}
+	if info.recordBytes > 0 {
+		kafka["record_bytes"] = info.recordBytes
+	}
+
+	fields["status"] = status
+	fields["kafka"] = kafka
+	return evt
+}
@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// transactions correlates the requests and responses of a connection by
+// their correlation ID. A broker answers the requests of a connection in
+// order, so requests older than the one matching a response will never be
+// answered and are published without response.
+type transactions struct {
+	config *transactionConfig
+
+	requests messageList
+	pending  int
+
+	onTransaction transactionHandler
+
+	watcher *procs.ProcessesWatcher
+}
+
+type transactionConfig struct {
+	transactionTimeout time.Duration
+	maxPending         int
+}
+
+type transactionHandler func(requ, resp *message) error
+
+// List of messages available for correlation
+type messageList struct {
+	head, tail *message
+}
+
+func (trans *transactions) init(c *transactionConfig, watcher *procs.ProcessesWatcher, cb transactionHandler) {
+	trans.config = c
+	trans.watcher = watcher
+	trans.onTransaction = cb
+}
+
+func (trans *transactions) onMessage(
+	tuple *common.IPPortTuple,
+	msg *message,
+) error {
+	msg.Tuple = *tuple
+	msg.Transport = applayer.TransportTCP
+	msg.CmdlineTuple = trans.watcher.FindProcessesTupleTCP(&msg.Tuple)
+
+	if msg.IsRequest {
+		if isDebug {
+			debugf("Received %s request %d with tuple: %s",
+				apiName(msg.apiKey), msg.correlationID, tuple)
+		}
+		return trans.onRequest(msg)
+	}
+
+	if isDebug {
+		debugf("Received response %d with tuple: %s", msg.correlationID, tuple)
+	}
+	return trans.onResponse(msg)
+}
+
+// onRequest adds a request to the correlation list. Requests without
+// response are published right away.
+func (trans *transactions) onRequest(msg *message) error {
+	if msg.noResponse {
+		return trans.onTransaction(msg, nil)
+	}
+
+	trans.requests.append(msg)
+	trans.pending++
+	if trans.pending <= trans.config.maxPending {
+		return nil
+	}
+
+	unmatchedRequests.Inc()
+	return trans.onTransaction(trans.pop(), nil)
+}
+
+// onResponse publishes the transaction of the request matching the
+// response. Responses without request are dropped.
+func (trans *transactions) onResponse(msg *message) error {
+	if !trans.requests.contains(msg.correlationID) {
+		unmatchedResponses.Inc()
+		if isDebug {
+			debugf("Response %d from unknown transaction. Ignoring.", msg.correlationID)
+		}
+		return nil
+	}
+
+	for {
+		requ := trans.pop()
+		if requ.correlationID == msg.correlationID {
+			decodeResponse(requ, msg)
+			return trans.onTransaction(requ, msg)
+		}
+
+		unmatchedRequests.Inc()
+		if err := trans.onTransaction(requ, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// flush publishes all requests still waiting for a response.
+func (trans *transactions) flush() {
+	for !trans.requests.empty() {
+		unmatchedRequests.Inc()
+		_ = trans.onTransaction(trans.pop(), nil)
+	}
+}
+
+func (trans *transactions) pop() *message {
+	trans.pending--
+	return trans.requests.pop()
+}
+
+func (ml *messageList) append(msg *message) {
+	if ml.tail == nil {
+		ml.head = msg
+	} else {
+		ml.tail.next = msg
+	}
+	msg.next = nil
+	ml.tail = msg
+}
+
+func (ml *messageList) empty() bool {
+	return ml.head == nil
+}
+
+func (ml *messageList) pop() *message {
+	if ml.head == nil {
+		return nil
+	}
+
+	msg := ml.head
+	ml.head = ml.head.next
+	if ml.head == nil {
+		ml.tail = nil
+	}
+	return msg
+}
+
+func (ml *messageList) contains(correlationID int32) bool {
+	for msg := ml.head; msg != nil; msg = msg.next {
+		if msg.correlationID == correlationID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kerberos
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// maxMessageSize limits the size of the messages sent over TCP. Tickets
+// carrying large authorization data stay well below.
+const maxMessageSize = 1 << 20
+
+// kerberosPlugin analyzes the messages exchanged with a Kerberos KDC over
+// UDP and TCP.
+type kerberosPlugin struct {
+	ports              protos.PortsConfig
+	transactionTimeout time.Duration
+
+	// Cache of the requests waiting for their response, by transactionKey.
+	transactions *common.Cache
+
+	results protos.Reporter
+	watcher *procs.ProcessesWatcher
+}
+
+// transactionKey identifies a request by the address tuple of the client
+// to the KDC. Kerberos messages have no identifier visible in cleartext,
+// clients wait for the reply before sending another request on the same
+// socket.
+type transactionKey struct {
+	tuple     common.HashableIPPortTuple
+	transport applayer.Transport
+}
+
+type transaction struct {
+	requ *message
+	resp *message
+}
+
+var (
+	debugf  = logp.MakeDebug("kerberos")
+	isDebug = false
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "kerberos.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "kerberos.unmatched_responses")
+)
+
+func init() {
+	protos.Register("kerberos", New)
+}
+
+// New create and initializes a new kerberos protocol analyzer instance.
+func New(
+	testMode bool,
+	results protos.Reporter,
+	watcher *procs.ProcessesWatcher,
+	cfg *conf.C,
+) (protos.Plugin, error) {
+	p := &kerberosPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, watcher, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (krb *kerberosPlugin) init(results protos.Reporter, watcher *procs.ProcessesWatcher, config *kerberosConfig) error {
+	if err := krb.setFromConfig(config); err != nil {
+		return err
+	}
+
+	krb.transactions = common.NewCacheWithRemovalListener(
+		krb.transactionTimeout,
+		protos.DefaultTransactionHashSize,
+		func(k common.Key, v common.Value) {
+			trans, ok := v.(*transaction)
+			if !ok {
+				logp.Err("Expired value is not a *kerberos.transaction.")
+				return
+			}
+			unmatchedRequests.Inc()
+			krb.publishTransaction(trans)
+		})
+	krb.transactions.StartJanitor(krb.transactionTimeout)
+
+	krb.results = results
+	krb.watcher = watcher
+	isDebug = logp.IsDebug("kerberos")
+	return nil
+}
+
+func (krb *kerberosPlugin) setFromConfig(config *kerberosConfig) error {
+	if err := krb.ports.Set(config.Ports); err != nil {
+		return err
+	}
+	krb.transactionTimeout = config.TransactionTimeout
+	return nil
+}
+
+// ConnectionTimeout returns the per stream connection timeout.
+// Return <=0 to set default tcp module transaction timeout.
+func (krb *kerberosPlugin) ConnectionTimeout() time.Duration {
+	return krb.transactionTimeout
+}
+
+// GetPorts returns the ports numbers packets shall be processed for.
+func (krb *kerberosPlugin) GetPorts() []int {
+	return krb.ports.Ports
+}
+
+// onMessage correlates a decoded message with the pending requests.
+func (krb *kerberosPlugin) onMessage(msg *message) {
+	if isDebug {
+		debugf("Received %s message %s with tuple: %s",
+			msg.Transport, messageTypeNames[msg.msgType], &msg.Tuple)
+	}
+
+	if msg.IsRequest {
+		key := transactionKey{tuple: msg.Tuple.Hashable(), transport: msg.Transport}
+		if prev := krb.deleteTransaction(key); prev != nil {
+			// the request was retransmitted or sent again without
+			// waiting for the reply.
+			unmatchedRequests.Inc()
+			krb.publishTransaction(prev)
+		}
+		krb.transactions.Put(key, &transaction{requ: msg})
+		return
+	}
+
+	key := transactionKey{tuple: msg.Tuple.RevHashable(), transport: msg.Transport}
+	trans := krb.deleteTransaction(key)
+	if trans == nil {
+		unmatchedResponses.Inc()
+		return
+	}
+	trans.resp = msg
+	krb.publishTransaction(trans)
+}
+
+// deleteTransaction removes a transaction from the cache and returns it,
+// nil is returned if the key does not exist.
+func (krb *kerberosPlugin) deleteTransaction(k transactionKey) *transaction {
+	v := krb.transactions.Delete(k)
+	if v != nil {
+		return v.(*transaction)
+	}
+	return nil
+}
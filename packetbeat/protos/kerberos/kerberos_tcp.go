@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kerberos
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/common/streambuf"
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// Over TCP, each message is preceded by its length as a 4 byte big endian
+// integer. The high bit is reserved for extensions (RFC 5021).
+const recordMarkLen = 4
+
+var errInvalidRecordMark = errors.New("invalid kerberos record mark")
+
+// Application Layer tcp stream data to be stored on tcp connection context.
+type connection struct {
+	streams [2]*streambuf.Buffer
+}
+
+// Parse processes a TCP packet. Return nil if connection
+// state shall be dropped (e.g. parser not in sync with tcp stream)
+func (krb *kerberosPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	conn := getConnection(private)
+	if conn == nil {
+		conn = &connection{}
+	}
+
+	buf := conn.streams[dir]
+	if buf == nil {
+		buf = &streambuf.Buffer{}
+		conn.streams[dir] = buf
+	}
+
+	if err := krb.feed(buf, pkt); err != nil {
+		debugf("%v, dropping TCP stream for error in direction %v.", err, dir)
+		return nil
+	}
+	return conn
+}
+
+// feed buffers the data of the stream and decodes each complete message.
+func (krb *kerberosPlugin) feed(buf *streambuf.Buffer, pkt *protos.Packet) error {
+	if _, err := buf.Write(pkt.Payload); err != nil {
+		return err
+	}
+
+	for buf.Avail(recordMarkLen) {
+		length := binary.BigEndian.Uint32(buf.Bytes())
+		if length&0x80000000 != 0 || length > maxMessageSize {
+			return errInvalidRecordMark
+		}
+		total := recordMarkLen + int(length)
+		if !buf.Avail(total) {
+			break
+		}
+
+		raw, err := buf.Collect(total)
+		if err != nil {
+			return err
+		}
+		msg, err := decodeMessage(raw[recordMarkLen:])
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		msg.Ts = pkt.Ts
+		msg.Tuple = pkt.Tuple
+		msg.Transport = applayer.TransportTCP
+		msg.CmdlineTuple = krb.watcher.FindProcessesTupleTCP(&pkt.Tuple)
+		msg.Size = uint64(total)
+		krb.onMessage(msg)
+	}
+	return nil
+}
+
+// ReceivedFin handles TCP-FIN packet.
+func (krb *kerberosPlugin) ReceivedFin(
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	return private
+}
+
+// GapInStream handles lost packets in tcp-stream. The requests still
+// waiting for a response are published once they expire.
+func (krb *kerberosPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int,
+	private protos.ProtocolData,
+) (protos.ProtocolData, bool) {
+	return nil, true
+}
+
+func getConnection(private protos.ProtocolData) *connection {
+	if private == nil {
+		return nil
+	}
+
+	priv, ok := private.(*connection)
+	if !ok {
+		logp.Warn("kerberos connection type error")
+		return nil
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: kerberos connection data not set")
+		return nil
+	}
+	return priv
+}
@@ -0,0 +1,326 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package kerberos
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+	"github.com/elastic/beats/v7/packetbeat/publish"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	clientDir = tcp.TCPDirectionOriginal
+	kdcDir    = tcp.TCPDirectionReverse
+)
+
+type eventStore struct {
+	events []beat.Event
+}
+
+func (e *eventStore) publish(event beat.Event) {
+	publish.MarshalPacketbeatFields(&event, nil, nil)
+	e.events = append(e.events, event)
+}
+
+func newTestPlugin(t *testing.T, store *eventStore) *kerberosPlugin {
+	t.Helper()
+	settings := map[string]interface{}{"ports": []int{88}}
+	p, err := New(false, store.publish, &procs.ProcessesWatcher{}, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p.(*kerberosPlugin)
+}
+
+func testCreateTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		BaseTuple: common.BaseTuple{
+			SrcIP: net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+			SrcPort: 6512, DstPort: 88,
+		},
+	}
+	t.ComputeHashables()
+	return t
+}
+
+type testConn struct {
+	p       *kerberosPlugin
+	tuple   *common.TCPTuple
+	private protos.ProtocolData
+}
+
+func newTestConn(p *kerberosPlugin) *testConn {
+	return &testConn{p: p, tuple: testCreateTCPTuple()}
+}
+
+func (c *testConn) packet(dir uint8, payload ...[]byte) *protos.Packet {
+	var data []byte
+	for _, b := range payload {
+		data = append(data, b...)
+	}
+	pkt := &protos.Packet{Ts: time.Now(), Tuple: *c.tuple.IPPort(), Payload: data}
+	if dir == tcp.TCPDirectionReverse {
+		pkt.Tuple = common.NewIPPortTuple(4,
+			c.tuple.DstIP, c.tuple.DstPort, c.tuple.SrcIP, c.tuple.SrcPort)
+	}
+	return pkt
+}
+
+func (c *testConn) send(dir uint8, payload ...[]byte) {
+	c.private = c.p.Parse(c.packet(dir, payload...), c.tuple, dir, c.private)
+}
+
+func (c *testConn) sendUDP(dir uint8, payload ...[]byte) {
+	c.p.ParseUDP(c.packet(dir, payload...))
+}
+
+func expectTransaction(t *testing.T, e *eventStore) mapstr.M {
+	t.Helper()
+	if len(e.events) == 0 {
+		t.Fatal("No transaction")
+	}
+	event := e.events[0]
+	e.events = e.events[1:]
+	return event.Fields
+}
+
+// tlv encodes a BER value from its identifier octet and the concatenated
+// parts of its contents.
+func tlv(id byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, p := range parts {
+		content = append(content, p...)
+	}
+	b := []byte{id}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+func seq(parts ...[]byte) []byte {
+	return tlv(0x30, parts...)
+}
+
+func explicit(tag byte, v []byte) []byte {
+	return tlv(0xa0|tag, v)
+}
+
+func integer(i int) []byte {
+	if i < 0x80 {
+		return tlv(0x02, []byte{byte(i)})
+	}
+	return tlv(0x02, []byte{byte(i >> 8), byte(i)})
+}
+
+func str(s string) []byte {
+	return tlv(0x1b, []byte(s))
+}
+
+func principal(typ int, names ...string) []byte {
+	var list []byte
+	for _, n := range names {
+		list = append(list, str(n)...)
+	}
+	return seq(explicit(0, integer(typ)), explicit(1, seq(list)))
+}
+
+func encryptedData(etype int) []byte {
+	return seq(explicit(0, integer(etype)), explicit(2, tlv(0x04, []byte("cipher"))))
+}
+
+func kdcRequest(msgType byte, padata int, body ...[]byte) []byte {
+	parts := [][]byte{explicit(1, integer(5)), explicit(2, integer(int(msgType)))}
+	if padata != 0 {
+		parts = append(parts, explicit(3, seq(seq(explicit(1, integer(padata)), explicit(2, tlv(0x04, []byte{0}))))))
+	}
+	parts = append(parts, explicit(4, seq(body...)))
+	return tlv(0x60|msgType, seq(parts...))
+}
+
+func kdcReply(msgType byte, realm string, cname []byte, ticketEtype, replyEtype int) []byte {
+	ticket := tlv(0x61, seq(
+		explicit(0, integer(5)),
+		explicit(1, str(realm)),
+		explicit(2, principal(2, "krbtgt", realm)),
+		explicit(3, encryptedData(ticketEtype))))
+	return tlv(0x60|msgType, seq(
+		explicit(0, integer(5)),
+		explicit(1, integer(int(msgType))),
+		explicit(3, str(realm)),
+		explicit(4, cname),
+		explicit(5, ticket),
+		explicit(6, encryptedData(replyEtype))))
+}
+
+func krbError(code int, realm string, text string) []byte {
+	return tlv(0x7e, seq(
+		explicit(0, integer(5)),
+		explicit(1, integer(30)),
+		explicit(4, tlv(0x18, []byte("20240101000000Z"))),
+		explicit(5, integer(0)),
+		explicit(6, integer(code)),
+		explicit(9, str(realm)),
+		explicit(10, principal(2, "krbtgt", realm)),
+		explicit(11, str(text))))
+}
+
+func asRequest(padata int) []byte {
+	return kdcRequest(msgASReq, padata,
+		explicit(0, tlv(0x03, []byte{0, 0x40, 0x81, 0x00, 0x10})),
+		explicit(1, principal(1, "alice")),
+		explicit(2, str("EXAMPLE.COM")),
+		explicit(3, principal(2, "krbtgt", "EXAMPLE.COM")),
+		explicit(5, tlv(0x18, []byte("20370913024805Z"))),
+		explicit(7, integer(42)),
+		explicit(8, seq(integer(18), integer(17), integer(23))))
+}
+
+func recordMark(msg []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...)
+}
+
+func TestASExchange(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.sendUDP(clientDir, asRequest(128))
+	conn.sendUDP(kdcDir, krbError(25, "EXAMPLE.COM", "NEEDED_PREAUTH"))
+	conn.sendUDP(clientDir, asRequest(2))
+	conn.sendUDP(kdcDir, kdcReply(msgASRep, "EXAMPLE.COM", principal(1, "alice"), 18, 18))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "AS-REQ", trans["method"])
+	assert.Equal(t, "Error", trans["status"])
+	assert.Equal(t, "udp", trans["network"].(mapstr.M)["transport"])
+	assert.Equal(t, mapstr.M{
+		"request_type":     "AS-REQ",
+		"response_type":    "KRB-ERROR",
+		"client":           "alice",
+		"realm":            "EXAMPLE.COM",
+		"service":          "krbtgt/EXAMPLE.COM",
+		"kdc_options":      []string{"forwardable", "renewable", "canonicalize", "renewable-ok"},
+		"encryption_types": []string{"aes256-cts-hmac-sha1-96", "aes128-cts-hmac-sha1-96", "rc4-hmac"},
+		"padata_types":     []string{"PA-PAC-REQUEST"},
+		"error_code":       int64(25),
+		"error":            "KDC_ERR_PREAUTH_REQUIRED",
+		"error_text":       "NEEDED_PREAUTH",
+	}, trans["kerberos"])
+	assert.Equal(t, "failure", trans["event"].(mapstr.M)["outcome"])
+
+	trans = expectTransaction(t, store)
+	assert.Equal(t, "OK", trans["status"])
+	krb := trans["kerberos"].(mapstr.M)
+	assert.Equal(t, []string{"PA-ENC-TIMESTAMP"}, krb["padata_types"])
+	assert.Equal(t, "aes256-cts-hmac-sha1-96", krb["ticket_encryption_type"])
+	assert.Equal(t, "aes256-cts-hmac-sha1-96", krb["reply_encryption_type"])
+	assert.Equal(t, mapstr.M{"name": "alice", "domain": "EXAMPLE.COM"}, trans["user"])
+
+	event := trans["event"].(mapstr.M)
+	assert.Equal(t, "success", event["outcome"])
+	assert.Equal(t, "kerberos-as-req", event["action"])
+	assert.Contains(t, event["category"], "authentication")
+	assert.Empty(t, store.events)
+}
+
+func TestTGSExchangeOverTCP(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	requ := recordMark(kdcRequest(msgTGSReq, 1,
+		explicit(0, tlv(0x03, []byte{0, 0x40, 0x81, 0x00, 0x00})),
+		explicit(2, str("EXAMPLE.COM")),
+		explicit(3, principal(2, "MSSQLSvc", "db.example.com:1433")),
+		explicit(5, tlv(0x18, []byte("20370913024805Z"))),
+		explicit(7, integer(7)),
+		explicit(8, seq(integer(23)))))
+	conn.send(clientDir, requ[:2])
+	conn.send(clientDir, requ[2:20])
+	conn.send(clientDir, requ[20:])
+
+	resp := recordMark(kdcReply(msgTGSRep, "EXAMPLE.COM", principal(1, "alice"), 23, 18))
+	conn.send(kdcDir, resp)
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "TGS-REQ", trans["method"])
+	assert.Equal(t, "tcp", trans["network"].(mapstr.M)["transport"])
+	assert.Equal(t, int64(len(requ)), trans["source"].(mapstr.M)["bytes"])
+	assert.Equal(t, int64(len(resp)), trans["destination"].(mapstr.M)["bytes"])
+	krb := trans["kerberos"].(mapstr.M)
+	assert.Equal(t, "alice", krb["client"])
+	assert.Equal(t, "MSSQLSvc/db.example.com:1433", krb["service"])
+	assert.Equal(t, []string{"rc4-hmac"}, krb["encryption_types"])
+	assert.Equal(t, "rc4-hmac", krb["ticket_encryption_type"])
+	assert.Equal(t, "TGS-REP", krb["response_type"])
+	assert.Empty(t, store.events)
+}
+
+func TestUnmatchedRequest(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.sendUDP(clientDir, asRequest(0))
+	conn.sendUDP(clientDir, asRequest(0))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "Error", trans["status"])
+	assert.Equal(t, "Unmatched request", trans["error"].(mapstr.M)["message"])
+	assert.NotContains(t, trans["kerberos"], "response_type")
+	assert.Empty(t, store.events)
+}
+
+func TestInvalidMessages(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, []byte("GET / HTTP/1.1\r\n\r\n"))
+	assert.Nil(t, conn.private)
+
+	conn.send(clientDir, recordMark(tlv(0x30)))
+	assert.Nil(t, conn.private)
+
+	// wrong protocol version
+	conn.sendUDP(clientDir, tlv(0x6a, seq(explicit(1, integer(4)), explicit(2, integer(10)))))
+	assert.Empty(t, store.events)
+}
+
+func TestKDCOptions(t *testing.T) {
+	v, _, err := ber.Decode(tlv(0x03, []byte{0, 0x00, 0x02, 0x00, 0x03}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cname-in-addl-tkt", "renew", "validate"}, decodeKDCOptions(v))
+}
@@ -0,0 +1,39 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kerberos
+
+import (
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// ParseUDP processes a datagram holding a single Kerberos message.
+func (krb *kerberosPlugin) ParseUDP(pkt *protos.Packet) {
+	debugf("Parsing packet addressed with %s of length %d.", &pkt.Tuple, len(pkt.Payload))
+
+	msg, err := decodeMessage(pkt.Payload)
+	if err != nil {
+		debugf("%v", err)
+		return
+	}
+	msg.Ts = pkt.Ts
+	msg.Tuple = pkt.Tuple
+	msg.Transport = applayer.TransportUDP
+	msg.CmdlineTuple = krb.watcher.FindProcessesTupleUDP(&pkt.Tuple)
+	krb.onMessage(msg)
+}
@@ -0,0 +1,461 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kerberos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+)
+
+// Application tags of the messages exchanged with the KDC (RFC 4120).
+const (
+	msgASReq    = 10
+	msgASRep    = 11
+	msgTGSReq   = 12
+	msgTGSRep   = 13
+	msgKRBError = 30
+)
+
+var messageTypeNames = map[uint32]string{
+	msgASReq:    "AS-REQ",
+	msgASRep:    "AS-REP",
+	msgTGSReq:   "TGS-REQ",
+	msgTGSRep:   "TGS-REP",
+	msgKRBError: "KRB-ERROR",
+}
+
+// Encryption type names of the IANA Kerberos parameters registry.
+var encryptionTypeNames = map[int64]string{
+	1:    "des-cbc-crc",
+	2:    "des-cbc-md4",
+	3:    "des-cbc-md5",
+	5:    "des3-cbc-md5",
+	7:    "des3-cbc-sha1",
+	16:   "des3-cbc-sha1-kd",
+	17:   "aes128-cts-hmac-sha1-96",
+	18:   "aes256-cts-hmac-sha1-96",
+	19:   "aes128-cts-hmac-sha256-128",
+	20:   "aes256-cts-hmac-sha384-192",
+	23:   "rc4-hmac",
+	24:   "rc4-hmac-exp",
+	25:   "camellia128-cts-cmac",
+	26:   "camellia256-cts-cmac",
+	-128: "rc4-hmac-old-exp",
+}
+
+var paDataTypeNames = map[int64]string{
+	1:   "PA-TGS-REQ",
+	2:   "PA-ENC-TIMESTAMP",
+	3:   "PA-PW-SALT",
+	11:  "PA-ETYPE-INFO",
+	16:  "PA-PK-AS-REQ",
+	17:  "PA-PK-AS-REP",
+	19:  "PA-ETYPE-INFO2",
+	128: "PA-PAC-REQUEST",
+	129: "PA-FOR-USER",
+	130: "PA-FOR-X509-USER",
+	133: "PA-FX-COOKIE",
+	136: "PA-FX-FAST",
+	137: "PA-FX-ERROR",
+	138: "PA-ENCRYPTED-CHALLENGE",
+	149: "PA-REQ-ENC-PA-REP",
+	150: "PA-AS-FRESHNESS",
+	165: "PA-SUPPORTED-ENCTYPES",
+	167: "PA-PAC-OPTIONS",
+}
+
+// kdcOptionNames by bit position of the KDCOptions bit string.
+var kdcOptionNames = map[int]string{
+	1:  "forwardable",
+	2:  "forwarded",
+	3:  "proxiable",
+	4:  "proxy",
+	5:  "allow-postdate",
+	6:  "postdated",
+	8:  "renewable",
+	14: "cname-in-addl-tkt",
+	15: "canonicalize",
+	16: "request-anonymous",
+	26: "disable-transited-check",
+	27: "renewable-ok",
+	28: "enc-tkt-in-skey",
+	30: "renew",
+	31: "validate",
+}
+
+var errorNames = map[int64]string{
+	0:  "KDC_ERR_NONE",
+	1:  "KDC_ERR_NAME_EXP",
+	2:  "KDC_ERR_SERVICE_EXP",
+	3:  "KDC_ERR_BAD_PVNO",
+	4:  "KDC_ERR_C_OLD_MAST_KVNO",
+	5:  "KDC_ERR_S_OLD_MAST_KVNO",
+	6:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	7:  "KDC_ERR_S_PRINCIPAL_UNKNOWN",
+	8:  "KDC_ERR_PRINCIPAL_NOT_UNIQUE",
+	9:  "KDC_ERR_NULL_KEY",
+	10: "KDC_ERR_CANNOT_POSTDATE",
+	11: "KDC_ERR_NEVER_VALID",
+	12: "KDC_ERR_POLICY",
+	13: "KDC_ERR_BADOPTION",
+	14: "KDC_ERR_ETYPE_NOSUPP",
+	15: "KDC_ERR_SUMTYPE_NOSUPP",
+	16: "KDC_ERR_PADATA_TYPE_NOSUPP",
+	17: "KDC_ERR_TRTYPE_NOSUPP",
+	18: "KDC_ERR_CLIENT_REVOKED",
+	19: "KDC_ERR_SERVICE_REVOKED",
+	20: "KDC_ERR_TGT_REVOKED",
+	21: "KDC_ERR_CLIENT_NOTYET",
+	22: "KDC_ERR_SERVICE_NOTYET",
+	23: "KDC_ERR_KEY_EXPIRED",
+	24: "KDC_ERR_PREAUTH_FAILED",
+	25: "KDC_ERR_PREAUTH_REQUIRED",
+	26: "KDC_ERR_SERVER_NOMATCH",
+	27: "KDC_ERR_MUST_USE_USER2USER",
+	28: "KDC_ERR_PATH_NOT_ACCEPTED",
+	29: "KDC_ERR_SVC_UNAVAILABLE",
+	31: "KRB_AP_ERR_BAD_INTEGRITY",
+	32: "KRB_AP_ERR_TKT_EXPIRED",
+	33: "KRB_AP_ERR_TKT_NYV",
+	34: "KRB_AP_ERR_REPEAT",
+	35: "KRB_AP_ERR_NOT_US",
+	36: "KRB_AP_ERR_BADMATCH",
+	37: "KRB_AP_ERR_SKEW",
+	38: "KRB_AP_ERR_BADADDR",
+	39: "KRB_AP_ERR_BADVERSION",
+	40: "KRB_AP_ERR_MSG_TYPE",
+	41: "KRB_AP_ERR_MODIFIED",
+	42: "KRB_AP_ERR_BADORDER",
+	44: "KRB_AP_ERR_BADKEYVER",
+	45: "KRB_AP_ERR_NOKEY",
+	46: "KRB_AP_ERR_MUT_FAIL",
+	47: "KRB_AP_ERR_BADDIRECTION",
+	48: "KRB_AP_ERR_METHOD",
+	49: "KRB_AP_ERR_BADSEQ",
+	50: "KRB_AP_ERR_INAPP_CKSUM",
+	51: "KRB_AP_PATH_NOT_ACCEPTED",
+	52: "KRB_ERR_RESPONSE_TOO_BIG",
+	60: "KRB_ERR_GENERIC",
+	61: "KRB_ERR_FIELD_TOOLONG",
+	62: "KDC_ERR_CLIENT_NOT_TRUSTED",
+	63: "KDC_ERR_KDC_NOT_TRUSTED",
+	64: "KDC_ERR_INVALID_SIG",
+	65: "KDC_ERR_KEY_TOO_WEAK",
+	66: "KDC_ERR_CERTIFICATE_MISMATCH",
+	67: "KRB_AP_ERR_NO_TGT",
+	68: "KDC_ERR_WRONG_REALM",
+	69: "KRB_AP_ERR_USER_TO_USER_REQUIRED",
+	70: "KDC_ERR_CANT_VERIFY_CERTIFICATE",
+	71: "KDC_ERR_INVALID_CERTIFICATE",
+	72: "KDC_ERR_REVOKED_CERTIFICATE",
+	73: "KDC_ERR_REVOCATION_STATUS_UNKNOWN",
+	74: "KDC_ERR_REVOCATION_STATUS_UNAVAILABLE",
+	75: "KDC_ERR_CLIENT_NAME_MISMATCH",
+	76: "KDC_ERR_KDC_NAME_MISMATCH",
+}
+
+// codeName returns the name of a code, or the code itself if the name is
+// not known.
+func codeName(names map[int64]string, code int64) string {
+	if name, found := names[code]; found {
+		return name
+	}
+	return strconv.FormatInt(code, 10)
+}
+
+var errInvalidMessage = errors.New("invalid kerberos message")
+
+type message struct {
+	applayer.Message
+
+	msgType uint32
+
+	// principals and realm, from the request body, the reply or the
+	// error. The realm of a request is the realm of the server.
+	cname string
+	realm string
+	sname string
+
+	// KDC-REQ
+	kdcOptions []string
+	etypes     []int64
+	padata     []int64
+
+	// KDC-REP
+	ticketEtype int64
+	replyEtype  int64
+
+	// KRB-ERROR
+	errorCode int64
+	errorText string
+}
+
+func (m *message) isRequest() bool {
+	return m.msgType == msgASReq || m.msgType == msgTGSReq
+}
+
+// decodeMessage decodes a message sent to or received from the KDC.
+func decodeMessage(data []byte) (*message, error) {
+	v, _, err := ber.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, known := messageTypeNames[v.Tag]; !known || v.Class != ber.ClassApplication {
+		return nil, fmt.Errorf("%w: unexpected message type %d", errInvalidMessage, v.Tag)
+	}
+	seq, err := v.Explicit()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := taggedFields(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &message{msgType: v.Tag}
+	msg.Size = uint64(len(data))
+	msg.IsRequest = msg.isRequest()
+
+	switch msg.msgType {
+	case msgASReq, msgTGSReq:
+		err = msg.decodeRequest(fields)
+	case msgASRep, msgTGSRep:
+		err = msg.decodeReply(fields)
+	case msgKRBError:
+		err = msg.decodeError(fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// checkVersion validates the protocol version and message type of a
+// message, used to detect traffic that is not Kerberos.
+func (m *message) checkVersion(pvno, msgType ber.Value) error {
+	version, err := pvno.Int()
+	if err != nil {
+		return err
+	}
+	typ, err := msgType.Int()
+	if err != nil {
+		return err
+	}
+	if version != 5 || typ != int64(m.msgType) {
+		return fmt.Errorf("%w: version %d, message type %d", errInvalidMessage, version, typ)
+	}
+	return nil
+}
+
+// decodeRequest decodes a KDC-REQ.
+func (m *message) decodeRequest(fields map[uint32]ber.Value) error {
+	if err := m.checkVersion(fields[1], fields[2]); err != nil {
+		return err
+	}
+
+	if padata, found := fields[3]; found {
+		entries, err := padata.Children()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			pa, err := taggedFields(entry)
+			if err != nil {
+				return err
+			}
+			typ, err := pa[1].Int()
+			if err != nil {
+				return err
+			}
+			m.padata = append(m.padata, typ)
+		}
+	}
+
+	body, err := taggedFields(fields[4])
+	if err != nil {
+		return err
+	}
+	if options, found := body[0]; found {
+		m.kdcOptions = decodeKDCOptions(options)
+	}
+	if cname, found := body[1]; found {
+		if m.cname, err = principalName(cname); err != nil {
+			return err
+		}
+	}
+	m.realm = body[2].Text()
+	if sname, found := body[3]; found {
+		if m.sname, err = principalName(sname); err != nil {
+			return err
+		}
+	}
+
+	etypes, err := body[8].Children()
+	if err != nil {
+		return err
+	}
+	for _, etype := range etypes {
+		typ, err := etype.Int()
+		if err != nil {
+			return err
+		}
+		m.etypes = append(m.etypes, typ)
+	}
+	return nil
+}
+
+// decodeReply decodes a KDC-REP. Only the ticket and the encryption type
+// of its parts are available in cleartext.
+func (m *message) decodeReply(fields map[uint32]ber.Value) error {
+	if err := m.checkVersion(fields[0], fields[1]); err != nil {
+		return err
+	}
+
+	var err error
+	m.realm = fields[3].Text()
+	if m.cname, err = principalName(fields[4]); err != nil {
+		return err
+	}
+
+	if !fields[5].Is(ber.ClassApplication, 1) {
+		return errInvalidMessage
+	}
+	seq, err := fields[5].Explicit()
+	if err != nil {
+		return err
+	}
+	ticket, err := taggedFields(seq)
+	if err != nil {
+		return err
+	}
+	if m.sname, err = principalName(ticket[2]); err != nil {
+		return err
+	}
+	if m.ticketEtype, err = encryptionType(ticket[3]); err != nil {
+		return err
+	}
+	m.replyEtype, err = encryptionType(fields[6])
+	return err
+}
+
+// decodeError decodes a KRB-ERROR.
+func (m *message) decodeError(fields map[uint32]ber.Value) error {
+	if err := m.checkVersion(fields[0], fields[1]); err != nil {
+		return err
+	}
+
+	var err error
+	if m.errorCode, err = fields[6].Int(); err != nil {
+		return err
+	}
+	if cname, found := fields[8]; found {
+		if m.cname, err = principalName(cname); err != nil {
+			return err
+		}
+	}
+	if crealm, found := fields[7]; found {
+		m.realm = crealm.Text()
+	} else {
+		m.realm = fields[9].Text()
+	}
+	if m.sname, err = principalName(fields[10]); err != nil {
+		return err
+	}
+	if text, found := fields[11]; found {
+		m.errorText = text.Text()
+	}
+	return nil
+}
+
+// taggedFields decodes a SEQUENCE of explicitly tagged components, as used
+// by all Kerberos messages, and returns the components by tag.
+func taggedFields(v ber.Value) (map[uint32]ber.Value, error) {
+	if !v.Is(ber.ClassUniversal, ber.TagSequence) {
+		return nil, errInvalidMessage
+	}
+	children, err := v.Children()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[uint32]ber.Value, len(children))
+	for _, child := range children {
+		if child.Class != ber.ClassContext {
+			return nil, errInvalidMessage
+		}
+		inner, err := child.Explicit()
+		if err != nil {
+			return nil, err
+		}
+		fields[child.Tag] = inner
+	}
+	return fields, nil
+}
+
+// principalName returns the components of a PrincipalName joined by a
+// slash, like krbtgt/EXAMPLE.COM.
+func principalName(v ber.Value) (string, error) {
+	fields, err := taggedFields(v)
+	if err != nil {
+		return "", err
+	}
+	components, err := fields[1].Children()
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(components))
+	for _, c := range components {
+		names = append(names, c.Text())
+	}
+	return strings.Join(names, "/"), nil
+}
+
+// encryptionType returns the encryption type of EncryptedData.
+func encryptionType(v ber.Value) (int64, error) {
+	fields, err := taggedFields(v)
+	if err != nil {
+		return 0, err
+	}
+	return fields[0].Int()
+}
+
+// decodeKDCOptions returns the names of the options set in the KDCOptions
+// bit string. The first contents octet is the number of unused bits.
+func decodeKDCOptions(v ber.Value) []string {
+	if len(v.Content) < 2 {
+		return nil
+	}
+
+	var options []string
+	bits := v.Content[1:]
+	for i := 0; i < len(bits)*8; i++ {
+		if bits[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+		if name, found := kdcOptionNames[i]; found {
+			options = append(options, name)
+		}
+	}
+	return options
+}
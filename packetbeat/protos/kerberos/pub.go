@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kerberos
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/beats/v7/packetbeat/pb"
+)
+
+func (krb *kerberosPlugin) publishTransaction(t *transaction) {
+	if krb.results == nil {
+		return
+	}
+	krb.results(createEvent(t))
+}
+
+func createEvent(t *transaction) beat.Event {
+	requ, resp := t.requ, t.resp
+	src, dst := common.MakeEndpointPair(requ.Tuple.BaseTuple, requ.CmdlineTuple)
+	method := messageTypeNames[requ.msgType]
+
+	evt, pbf := pb.NewBeatEvent(requ.Ts)
+	pbf.SetSource(&src)
+	pbf.AddIP(src.IP)
+	pbf.SetDestination(&dst)
+	pbf.AddIP(dst.IP)
+	pbf.Event.Dataset = "kerberos"
+	pbf.Event.Category = append(pbf.Event.Category, "authentication")
+	pbf.Event.Action = "kerberos-" + strings.ToLower(method)
+	pbf.Network.Transport = requ.Transport.String()
+	pbf.Network.Protocol = pbf.Event.Dataset
+	pbf.Source.Bytes = int64(requ.Size)
+	pbf.Event.Start = requ.Ts
+
+	fields := evt.Fields
+	fields["type"] = pbf.Event.Dataset
+	fields["method"] = method
+
+	krb := mapstr.M{
+		"request_type": method,
+		"realm":        requ.realm,
+	}
+	if len(requ.kdcOptions) > 0 {
+		krb["kdc_options"] = requ.kdcOptions
+	}
+	if len(requ.etypes) > 0 {
+		krb["encryption_types"] = codeNames(encryptionTypeNames, requ.etypes)
+	}
+	if len(requ.padata) > 0 {
+		krb["padata_types"] = codeNames(paDataTypeNames, requ.padata)
+	}
+
+	// The client of a TGS-REQ is only known from the reply.
+	client, service, domain := requ.cname, requ.sname, requ.realm
+	status := common.OK_STATUS
+	if resp != nil {
+		pbf.Destination.Bytes = int64(resp.Size)
+		pbf.Event.End = resp.Ts
+		krb["response_type"] = messageTypeNames[resp.msgType]
+		if client == "" {
+			client = resp.cname
+		}
+		if service == "" {
+			service = resp.sname
+		}
+
+		if resp.msgType == msgKRBError {
+			status = common.ERROR_STATUS
+			pbf.Event.Outcome = "failure"
+			krb["error_code"] = resp.errorCode
+			krb["error"] = codeName(errorNames, resp.errorCode)
+			if resp.errorText != "" {
+				krb["error_text"] = resp.errorText
+			}
+		} else {
+			pbf.Event.Outcome = "success"
+			domain = resp.realm
+			krb["ticket_encryption_type"] = codeName(encryptionTypeNames, resp.ticketEtype)
+			krb["reply_encryption_type"] = codeName(encryptionTypeNames, resp.replyEtype)
+		}
+	} else {
+		status = common.ERROR_STATUS
+		pbf.Error.Message = append(pbf.Error.Message, "Unmatched request")
+	}
+
+	if client != "" {
+		krb["client"] = client
+		_, _ = fields.Put("user.name", client)
+		_, _ = fields.Put("user.domain", domain)
+		pbf.AddUser(client)
+	}
+	if service != "" {
+		krb["service"] = service
+	}
+
+	fields["status"] = status
+	fields["kerberos"] = krb
+	return evt
+}
+
+func codeNames(names map[int64]string, codes []int64) []string {
+	s := make([]string, 0, len(codes))
+	for _, code := range codes {
+		s = append(s, codeName(names, code))
+	}
+	return s
+}
@@ -0,0 +1,225 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldap
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// ldapPlugin analyzes LDAP over TCP and connectionless LDAP over UDP.
+type ldapPlugin struct {
+	ports              protos.PortsConfig
+	maxBytes           int
+	transactionTimeout time.Duration
+
+	// Cache of the requests waiting for their response, by transactionKey.
+	transactions *common.Cache
+
+	results protos.Reporter
+	watcher *procs.ProcessesWatcher
+}
+
+// transactionKey identifies a request by the address tuple of the client
+// to the server and its message ID.
+type transactionKey struct {
+	tuple     common.HashableIPPortTuple
+	transport applayer.Transport
+	id        int64
+}
+
+type transaction struct {
+	requ *message
+	resp *message
+
+	// search result entries and references returned before the response
+	// completing the search.
+	entries    int
+	references int
+
+	// responseBytes is the size of all response messages.
+	responseBytes uint64
+}
+
+var (
+	debugf  = logp.MakeDebug("ldap")
+	isDebug = false
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "ldap.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "ldap.unmatched_responses")
+)
+
+func init() {
+	protos.Register("ldap", New)
+}
+
+// New create and initializes a new ldap protocol analyzer instance.
+func New(
+	testMode bool,
+	results protos.Reporter,
+	watcher *procs.ProcessesWatcher,
+	cfg *conf.C,
+) (protos.Plugin, error) {
+	p := &ldapPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, watcher, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (ldap *ldapPlugin) init(results protos.Reporter, watcher *procs.ProcessesWatcher, config *ldapConfig) error {
+	if err := ldap.setFromConfig(config); err != nil {
+		return err
+	}
+
+	ldap.transactions = common.NewCacheWithRemovalListener(
+		ldap.transactionTimeout,
+		protos.DefaultTransactionHashSize,
+		func(k common.Key, v common.Value) {
+			trans, ok := v.(*transaction)
+			if !ok {
+				logp.Err("Expired value is not a *ldap.transaction.")
+				return
+			}
+			unmatchedRequests.Inc()
+			ldap.publishTransaction(trans)
+		})
+	ldap.transactions.StartJanitor(ldap.transactionTimeout)
+
+	ldap.results = results
+	ldap.watcher = watcher
+	isDebug = logp.IsDebug("ldap")
+	return nil
+}
+
+func (ldap *ldapPlugin) setFromConfig(config *ldapConfig) error {
+	if err := ldap.ports.Set(config.Ports); err != nil {
+		return err
+	}
+	ldap.maxBytes = tcp.TCPMaxDataInStream
+	ldap.transactionTimeout = config.TransactionTimeout
+	return nil
+}
+
+// ConnectionTimeout returns the per stream connection timeout.
+// Return <=0 to set default tcp module transaction timeout.
+func (ldap *ldapPlugin) ConnectionTimeout() time.Duration {
+	return ldap.transactionTimeout
+}
+
+// GetPorts returns the ports numbers packets shall be processed for.
+func (ldap *ldapPlugin) GetPorts() []int {
+	return ldap.ports.Ports
+}
+
+// onMessage correlates a decoded message with the pending requests. It
+// returns the transaction completed by the message, if any.
+func (ldap *ldapPlugin) onMessage(msg *message) *transaction {
+	if isDebug {
+		debugf("Received %s message %d of operation %d with tuple: %s",
+			msg.Transport, msg.id, msg.op, &msg.Tuple)
+	}
+
+	if msg.IsRequest {
+		ldap.onRequest(msg)
+		return nil
+	}
+	return ldap.onResponse(msg)
+}
+
+func (ldap *ldapPlugin) onRequest(msg *message) {
+	if msg.op == opUnbindRequest || msg.op == opAbandonRequest {
+		// no response is sent for these operations.
+		ldap.publishTransaction(&transaction{requ: msg})
+		return
+	}
+
+	key := transactionKey{tuple: msg.Tuple.Hashable(), transport: msg.Transport, id: msg.id}
+	if prev := ldap.deleteTransaction(key); prev != nil {
+		// the message ID was reused before the previous request was
+		// answered.
+		unmatchedRequests.Inc()
+		ldap.publishTransaction(prev)
+	}
+	ldap.transactions.Put(key, &transaction{requ: msg})
+}
+
+func (ldap *ldapPlugin) onResponse(msg *message) *transaction {
+	key := transactionKey{tuple: msg.Tuple.RevHashable(), transport: msg.Transport, id: msg.id}
+	v := ldap.transactions.Get(key)
+	if v == nil {
+		// unsolicited notifications use message ID 0.
+		if msg.id != 0 {
+			unmatchedResponses.Inc()
+		}
+		return nil
+	}
+
+	trans := v.(*transaction)
+	trans.responseBytes += msg.Size
+	switch msg.op {
+	case opSearchResultEntry:
+		trans.entries++
+		return nil
+	case opSearchResultReference:
+		trans.references++
+		return nil
+	case opIntermediateResponse:
+		return nil
+	}
+
+	ldap.deleteTransaction(key)
+	trans.resp = msg
+	ldap.publishTransaction(trans)
+	return trans
+}
+
+// deleteTransaction removes a transaction from the cache and returns it,
+// nil is returned if the key does not exist.
+func (ldap *ldapPlugin) deleteTransaction(k transactionKey) *transaction {
+	v := ldap.transactions.Delete(k)
+	if v != nil {
+		return v.(*transaction)
+	}
+	return nil
+}
+
+// startsTLS checks if the transaction is a successful StartTLS operation,
+// after which the connection is encrypted.
+func (t *transaction) startsTLS() bool {
+	return t.requ.op == opExtendedRequest && t.requ.oid == oidStartTLS &&
+		t.resp.hasResult && t.resp.resultCode == 0
+}
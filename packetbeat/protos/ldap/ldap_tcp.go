@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldap
+
+import (
+	"errors"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/common/streambuf"
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+)
+
+// Application Layer tcp stream data to be stored on tcp connection context.
+type connection struct {
+	streams [2]*stream
+
+	// encrypted is set once a StartTLS operation succeeded, the rest of
+	// the connection can not be decoded.
+	encrypted bool
+}
+
+// Uni-directional tcp stream state for splitting the stream into
+// LDAPMessages.
+type stream struct {
+	buf streambuf.Buffer
+
+	// skip is the number of bytes of an oversized message still to be
+	// discarded.
+	skip int
+}
+
+// Parse processes a TCP packet. Return nil if connection
+// state shall be dropped (e.g. parser not in sync with tcp stream)
+func (ldap *ldapPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	conn := getConnection(private)
+	if conn == nil {
+		conn = &connection{}
+	}
+	if conn.encrypted {
+		return conn
+	}
+
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		conn.streams[dir] = st
+	}
+
+	err := st.feed(pkt.Payload, ldap.maxBytes, func(raw []byte) error {
+		msg, err := decodeMessage(raw)
+		if err != nil {
+			return err
+		}
+		msg.Ts = pkt.Ts
+		msg.Tuple = pkt.Tuple
+		msg.Transport = applayer.TransportTCP
+		msg.CmdlineTuple = ldap.watcher.FindProcessesTupleTCP(&pkt.Tuple)
+
+		if trans := ldap.onMessage(msg); trans != nil && trans.startsTLS() {
+			debugf("StartTLS succeeded, ignoring the rest of the connection")
+			conn.encrypted = true
+		}
+		return nil
+	})
+	if err != nil {
+		debugf("%v, dropping TCP stream for error in direction %v.", err, dir)
+		return nil
+	}
+	return conn
+}
+
+// ReceivedFin handles TCP-FIN packet.
+func (ldap *ldapPlugin) ReceivedFin(
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	return private
+}
+
+// GapInStream handles lost packets in tcp-stream. The requests still
+// waiting for a response are published once they expire.
+func (ldap *ldapPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int,
+	private protos.ProtocolData,
+) (protos.ProtocolData, bool) {
+	return nil, true
+}
+
+// feed buffers the data of the stream and passes each complete message to
+// onMessage. Messages larger than maxBytes are skipped.
+func (st *stream) feed(data []byte, maxBytes int, onMessage func(raw []byte) error) error {
+	if st.skip > 0 {
+		n := min(st.skip, len(data))
+		st.skip -= n
+		data = data[n:]
+	}
+	if len(data) > 0 {
+		if _, err := st.buf.Write(data); err != nil {
+			return err
+		}
+	}
+
+	for st.skip == 0 && st.buf.Len() > 0 {
+		buffered := st.buf.Bytes()
+		if buffered[0] != 0x30 {
+			// every LDAPMessage is a SEQUENCE.
+			return errInvalidMessage
+		}
+		hdrLen, contentLen, err := ber.Header(buffered)
+		if errors.Is(err, ber.ErrShortData) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		total := hdrLen + contentLen
+		if total > maxBytes {
+			debugf("message of %d bytes exceeds the buffer limit, skipping it", total)
+			n := min(total, st.buf.Len())
+			_ = st.buf.Advance(n)
+			st.skip = total - n
+			st.buf.Reset()
+			continue
+		}
+		if !st.buf.Avail(total) {
+			break
+		}
+
+		raw, err := st.buf.Collect(total)
+		if err != nil {
+			return err
+		}
+		if err := onMessage(raw); err != nil {
+			return err
+		}
+		st.buf.Reset()
+	}
+	return nil
+}
+
+func getConnection(private protos.ProtocolData) *connection {
+	if private == nil {
+		return nil
+	}
+
+	priv, ok := private.(*connection)
+	if !ok {
+		logp.Warn("ldap connection type error")
+		return nil
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: ldap connection data not set")
+		return nil
+	}
+	return priv
+}
@@ -0,0 +1,411 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package ldap
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+	"github.com/elastic/beats/v7/packetbeat/publish"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	clientDir = tcp.TCPDirectionOriginal
+	serverDir = tcp.TCPDirectionReverse
+)
+
+type eventStore struct {
+	events []beat.Event
+}
+
+func (e *eventStore) publish(event beat.Event) {
+	publish.MarshalPacketbeatFields(&event, nil, nil)
+	e.events = append(e.events, event)
+}
+
+func newTestPlugin(t *testing.T, store *eventStore) *ldapPlugin {
+	t.Helper()
+	settings := map[string]interface{}{"ports": []int{389}}
+	p, err := New(false, store.publish, &procs.ProcessesWatcher{}, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p.(*ldapPlugin)
+}
+
+func testCreateTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		BaseTuple: common.BaseTuple{
+			SrcIP: net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+			SrcPort: 6512, DstPort: 389,
+		},
+	}
+	t.ComputeHashables()
+	return t
+}
+
+type testConn struct {
+	p       *ldapPlugin
+	tuple   *common.TCPTuple
+	private protos.ProtocolData
+}
+
+func newTestConn(p *ldapPlugin) *testConn {
+	return &testConn{p: p, tuple: testCreateTCPTuple()}
+}
+
+func (c *testConn) packet(dir uint8, payload ...[]byte) *protos.Packet {
+	var data []byte
+	for _, b := range payload {
+		data = append(data, b...)
+	}
+	pkt := &protos.Packet{Ts: time.Now(), Tuple: *c.tuple.IPPort(), Payload: data}
+	if dir == tcp.TCPDirectionReverse {
+		pkt.Tuple = common.NewIPPortTuple(4,
+			c.tuple.DstIP, c.tuple.DstPort, c.tuple.SrcIP, c.tuple.SrcPort)
+	}
+	return pkt
+}
+
+func (c *testConn) send(dir uint8, payload ...[]byte) {
+	c.private = c.p.Parse(c.packet(dir, payload...), c.tuple, dir, c.private)
+}
+
+func (c *testConn) sendUDP(dir uint8, payload ...[]byte) {
+	c.p.ParseUDP(c.packet(dir, payload...))
+}
+
+func expectTransaction(t *testing.T, e *eventStore) mapstr.M {
+	t.Helper()
+	if len(e.events) == 0 {
+		t.Fatal("No transaction")
+	}
+	event := e.events[0]
+	e.events = e.events[1:]
+	return event.Fields
+}
+
+// tlv encodes a BER value from its identifier octet and the concatenated
+// parts of its contents.
+func tlv(id byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, p := range parts {
+		content = append(content, p...)
+	}
+	b := []byte{id}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+func integer(tag byte, i int) []byte {
+	if i < 0x80 {
+		return tlv(tag, []byte{byte(i)})
+	}
+	return tlv(tag, []byte{byte(i >> 8), byte(i)})
+}
+
+func octets(s string) []byte {
+	return tlv(0x04, []byte(s))
+}
+
+func ldapMessage(id int, op []byte) []byte {
+	return tlv(0x30, integer(0x02, id), op)
+}
+
+func bindRequest(id int, dn string, auth []byte) []byte {
+	return ldapMessage(id, tlv(0x60, integer(0x02, 3), octets(dn), auth))
+}
+
+func simpleAuth(password string) []byte {
+	return tlv(0x80, []byte(password))
+}
+
+func result(id int, op byte, code int, diagnostic string) []byte {
+	return ldapMessage(id, tlv(0x60|op, integer(0x0a, code), octets(""), octets(diagnostic)))
+}
+
+func searchRequest(id int, base string, scope int, filter []byte, attrs ...string) []byte {
+	var list []byte
+	for _, a := range attrs {
+		list = append(list, octets(a)...)
+	}
+	return ldapMessage(id, tlv(0x63,
+		octets(base), integer(0x0a, scope), integer(0x0a, 0),
+		integer(0x02, 100), integer(0x02, 30), tlv(0x01, []byte{0}),
+		filter, tlv(0x30, list)))
+}
+
+func searchEntry(id int, dn string) []byte {
+	return ldapMessage(id, tlv(0x64, octets(dn),
+		tlv(0x30, tlv(0x30, octets("cn"), tlv(0x31, octets("value"))))))
+}
+
+func TestSimpleBind(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, bindRequest(1, "cn=admin,dc=example,dc=com", simpleAuth("secret")))
+	conn.send(serverDir, result(1, opBindResponse, 0, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "bind", trans["method"])
+	assert.Equal(t, "OK", trans["status"])
+	assert.Equal(t, "cn=admin,dc=example,dc=com", trans["user"].(mapstr.M)["name"])
+	assert.Equal(t, mapstr.M{
+		"message_id":  int64(1),
+		"operation":   "bind",
+		"dn":          "cn=admin,dc=example,dc=com",
+		"result_code": int64(0),
+		"result":      "success",
+		"bind": mapstr.M{
+			"version":   int64(3),
+			"auth_type": "simple",
+			"cleartext": true,
+		},
+	}, trans["ldap"])
+
+	event := trans["event"].(mapstr.M)
+	assert.Equal(t, "success", event["outcome"])
+	assert.Equal(t, "ldap-bind", event["action"])
+	assert.Contains(t, event["category"], "authentication")
+	assert.NotContains(t, trans.StringToPrint(), "secret")
+	assert.Empty(t, store.events)
+}
+
+func TestBindFailures(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, bindRequest(1, "cn=admin,dc=example,dc=com", simpleAuth("wrong")))
+	conn.send(serverDir, result(1, opBindResponse, 49, "80090308: LdapErr: DSID-0C09044E"))
+	conn.send(clientDir, bindRequest(2, "", tlv(0xa3, octets("GSSAPI"), octets("token"))))
+	conn.send(serverDir, result(2, opBindResponse, 14, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "Error", trans["status"])
+	assert.Equal(t, "failure", trans["event"].(mapstr.M)["outcome"])
+	ldap := trans["ldap"].(mapstr.M)
+	assert.Equal(t, int64(49), ldap["result_code"])
+	assert.Equal(t, "invalidCredentials", ldap["result"])
+	assert.Equal(t, "80090308: LdapErr: DSID-0C09044E", ldap["diagnostic_message"])
+
+	trans = expectTransaction(t, store)
+	assert.Equal(t, "OK", trans["status"])
+	assert.NotContains(t, trans, "user")
+	assert.Equal(t, mapstr.M{
+		"version":        int64(3),
+		"auth_type":      "sasl",
+		"sasl_mechanism": "GSSAPI",
+		"cleartext":      false,
+	}, trans["ldap"].(mapstr.M)["bind"])
+	assert.Equal(t, "saslBindInProgress", trans["ldap"].(mapstr.M)["result"])
+}
+
+func TestSearch(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	filter := tlv(0xa0,
+		tlv(0xa3, octets("objectClass"), octets("person")),
+		tlv(0xa4, octets("cn"), tlv(0x30, tlv(0x80, []byte("jo")), tlv(0x81, []byte("n")))))
+	requ := searchRequest(2, "dc=example,dc=com", 2, filter, "cn", "mail")
+	conn.send(clientDir, requ[:10])
+	conn.send(clientDir, requ[10:])
+
+	resp := append(searchEntry(2, "cn=john,dc=example,dc=com"), searchEntry(2, "cn=jon,dc=example,dc=com")...)
+	resp = append(resp, result(2, opSearchResultDone, 0, "")...)
+	conn.send(serverDir, resp[:7])
+	conn.send(serverDir, resp[7:])
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "search", trans["method"])
+	assert.Equal(t, "OK", trans["status"])
+	ldap := trans["ldap"].(mapstr.M)
+	assert.Equal(t, "dc=example,dc=com", ldap["dn"])
+	assert.Equal(t, []string{"cn", "mail"}, ldap["attributes"])
+	assert.Equal(t, mapstr.M{
+		"scope":      "sub",
+		"filter":     "(&(objectClass=person)(cn=jo*n*))",
+		"size_limit": int64(100),
+		"time_limit": int64(30),
+		"entries":    2,
+		"references": 0,
+	}, ldap["search"])
+	assert.Equal(t, int64(len(resp)), trans["destination"].(mapstr.M)["bytes"])
+	assert.Empty(t, store.events)
+}
+
+func TestModify(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	change := func(op int, attr, value string) []byte {
+		return tlv(0x30, integer(0x0a, op), tlv(0x30, octets(attr), tlv(0x31, octets(value))))
+	}
+	conn.send(clientDir, ldapMessage(3, tlv(0x66,
+		octets("cn=john,dc=example,dc=com"),
+		tlv(0x30, change(2, "userPassword", "hunter2"), change(0, "mail", "john@example.com")))))
+	conn.send(serverDir, result(3, opModifyResponse, 50, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "modify", trans["method"])
+	assert.Equal(t, "Error", trans["status"])
+	ldap := trans["ldap"].(mapstr.M)
+	assert.Equal(t, "cn=john,dc=example,dc=com", ldap["dn"])
+	assert.Equal(t, []string{"userPassword", "mail"}, ldap["attributes"])
+	assert.Equal(t, mapstr.M{"changes": []string{"replace:userPassword", "add:mail"}}, ldap["modify"])
+	assert.Equal(t, "insufficientAccessRights", ldap["result"])
+	assert.NotContains(t, trans.StringToPrint(), "hunter2")
+}
+
+func TestUnbindAndUnmatched(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, ldapMessage(4, tlv(0x4a, []byte("cn=x"))))
+	// the message ID is reused before the delete was answered.
+	conn.send(clientDir, ldapMessage(4, tlv(0x4a, []byte("cn=y"))))
+	conn.send(clientDir, ldapMessage(5, tlv(0x42)))
+	conn.send(serverDir, result(9, opDelResponse, 0, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "delete", trans["method"])
+	assert.Equal(t, "cn=x", trans["ldap"].(mapstr.M)["dn"])
+	assert.Equal(t, "Error", trans["status"])
+	assert.Equal(t, "Unmatched request", trans["error"].(mapstr.M)["message"])
+
+	trans = expectTransaction(t, store)
+	assert.Equal(t, "unbind", trans["method"])
+	assert.Equal(t, "OK", trans["status"])
+	assert.Empty(t, store.events)
+}
+
+func TestStartTLS(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, ldapMessage(1, tlv(0x77, tlv(0x80, []byte(oidStartTLS)))))
+	conn.send(serverDir, result(1, opExtendedResponse, 0, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "extended", trans["method"])
+	assert.Equal(t, mapstr.M{"oid": oidStartTLS}, trans["ldap"].(mapstr.M)["extended"])
+
+	// TLS handshake
+	conn.send(clientDir, []byte{0x16, 0x03, 0x01, 0x00, 0x05, 1, 2, 3, 4, 5})
+	assert.NotNil(t, conn.private)
+	assert.Empty(t, store.events)
+}
+
+func TestNonLDAPDropsStream(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	conn.send(clientDir, []byte("GET / HTTP/1.1\r\n\r\n"))
+	assert.Nil(t, conn.private)
+	assert.Empty(t, store.events)
+}
+
+func TestOversizedMessageSkipped(t *testing.T) {
+	store := &eventStore{}
+	p := newTestPlugin(t, store)
+	p.maxBytes = 64
+	conn := newTestConn(p)
+
+	conn.send(clientDir, searchRequest(2, "dc=example,dc=com", 1, tlv(0x87, []byte("cn"))))
+	big := searchEntry(2, "cn=large,"+string(make([]byte, 100)))
+	conn.send(serverDir, big[:30])
+	conn.send(serverDir, big[30:], result(2, opSearchResultDone, 4, ""))
+
+	trans := expectTransaction(t, store)
+	ldap := trans["ldap"].(mapstr.M)
+	assert.Equal(t, "sizeLimitExceeded", ldap["result"])
+	assert.Equal(t, 0, ldap["search"].(mapstr.M)["entries"])
+	assert.Equal(t, "one", ldap["search"].(mapstr.M)["scope"])
+}
+
+func TestConnectionlessSearch(t *testing.T) {
+	store := &eventStore{}
+	conn := newTestConn(newTestPlugin(t, store))
+
+	filter := tlv(0xa0, tlv(0xa3, octets("DnsDomain"), octets("example.com")), tlv(0xa3, octets("NtVer"), octets("\x06\x00\x00\x00")))
+	conn.sendUDP(clientDir, searchRequest(7, "", 0, filter, "Netlogon"))
+	conn.sendUDP(serverDir, searchEntry(7, ""), result(7, opSearchResultDone, 0, ""))
+
+	trans := expectTransaction(t, store)
+	assert.Equal(t, "udp", trans["network"].(mapstr.M)["transport"])
+	ldap := trans["ldap"].(mapstr.M)
+	assert.NotContains(t, ldap, "dn")
+	assert.Equal(t, "(&(DnsDomain=example.com)(NtVer=\\06\\00\\00\\00))", ldap["search"].(mapstr.M)["filter"])
+	assert.Equal(t, 1, ldap["search"].(mapstr.M)["entries"])
+	assert.Equal(t, "OK", trans["status"])
+}
+
+func TestFilterString(t *testing.T) {
+	for _, tc := range []struct {
+		filter []byte
+		want   string
+	}{
+		{tlv(0x87, []byte("objectClass")), "(objectClass=*)"},
+		{tlv(0xa2, tlv(0xa3, octets("cn"), octets("a*(b)"))), "(!(cn=a\\2a\\28b\\29))"},
+		{tlv(0xa1, tlv(0xa5, octets("uidNumber"), octets("1000")), tlv(0xa6, octets("uidNumber"), octets("10"))), "(|(uidNumber>=1000)(uidNumber<=10))"},
+		{tlv(0xa8, octets("sn"), octets("smith")), "(sn~=smith)"},
+		{tlv(0xa4, octets("cn"), tlv(0x30, tlv(0x82, []byte("son")))), "(cn=*son)"},
+		{
+			tlv(0xa9, tlv(0x81, []byte("1.2.840.113556.1.4.803")), tlv(0x82, []byte("userAccountControl")), tlv(0x83, []byte("2"))),
+			"(userAccountControl:1.2.840.113556.1.4.803:=2)",
+		},
+	} {
+		f, _, err := ber.Decode(tc.filter)
+		require.NoError(t, err)
+
+		var b strings.Builder
+		require.NoError(t, writeFilter(&b, f, 0))
+		assert.Equal(t, tc.want, b.String())
+	}
+
+	// deeply nested filters are rejected.
+	nested := tlv(0x87, []byte("cn"))
+	for i := 0; i < maxFilterDepth+1; i++ {
+		nested = tlv(0xa2, nested)
+	}
+	f, _, err := ber.Decode(nested)
+	require.NoError(t, err)
+	assert.ErrorIs(t, writeFilter(&strings.Builder{}, f, 0), errFilterDepth)
+}
@@ -0,0 +1,56 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldap
+
+import (
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+)
+
+// ParseUDP processes a datagram of connectionless LDAP (CLDAP). A response
+// datagram can hold several messages, like the entries of a search
+// followed by its result.
+func (ldap *ldapPlugin) ParseUDP(pkt *protos.Packet) {
+	debugf("Parsing packet addressed with %s of length %d.", &pkt.Tuple, len(pkt.Payload))
+
+	for data := pkt.Payload; len(data) > 0; {
+		hdrLen, contentLen, err := ber.Header(data)
+		if err == nil && hdrLen+contentLen > len(data) {
+			err = ber.ErrShortData
+		}
+		if err != nil {
+			debugf("%v", err)
+			return
+		}
+
+		total := hdrLen + contentLen
+		msg, err := decodeMessage(data[:total])
+		if err != nil {
+			debugf("%v", err)
+			return
+		}
+		msg.Ts = pkt.Ts
+		msg.Tuple = pkt.Tuple
+		msg.Transport = applayer.TransportUDP
+		msg.CmdlineTuple = ldap.watcher.FindProcessesTupleUDP(&pkt.Tuple)
+		ldap.onMessage(msg)
+
+		data = data[total:]
+	}
+}
@@ -0,0 +1,572 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+	"github.com/elastic/beats/v7/packetbeat/protos/ber"
+)
+
+// Tags of the protocolOp choice of an LDAPMessage (RFC 4511).
+const (
+	opBindRequest           = 0
+	opBindResponse          = 1
+	opUnbindRequest         = 2
+	opSearchRequest         = 3
+	opSearchResultEntry     = 4
+	opSearchResultDone      = 5
+	opModifyRequest         = 6
+	opModifyResponse        = 7
+	opAddRequest            = 8
+	opAddResponse           = 9
+	opDelRequest            = 10
+	opDelResponse           = 11
+	opModifyDNRequest       = 12
+	opModifyDNResponse      = 13
+	opCompareRequest        = 14
+	opCompareResponse       = 15
+	opAbandonRequest        = 16
+	opSearchResultReference = 19
+	opExtendedRequest       = 23
+	opExtendedResponse      = 24
+	opIntermediateResponse  = 25
+)
+
+type operation struct {
+	name     string
+	response bool
+}
+
+var operations = map[uint32]operation{
+	opBindRequest:           {"bind", false},
+	opBindResponse:          {"bind", true},
+	opUnbindRequest:         {"unbind", false},
+	opSearchRequest:         {"search", false},
+	opSearchResultEntry:     {"search", true},
+	opSearchResultDone:      {"search", true},
+	opModifyRequest:         {"modify", false},
+	opModifyResponse:        {"modify", true},
+	opAddRequest:            {"add", false},
+	opAddResponse:           {"add", true},
+	opDelRequest:            {"delete", false},
+	opDelResponse:           {"delete", true},
+	opModifyDNRequest:       {"modify_dn", false},
+	opModifyDNResponse:      {"modify_dn", true},
+	opCompareRequest:        {"compare", false},
+	opCompareResponse:       {"compare", true},
+	opAbandonRequest:        {"abandon", false},
+	opSearchResultReference: {"search", true},
+	opExtendedRequest:       {"extended", false},
+	opExtendedResponse:      {"extended", true},
+	opIntermediateResponse:  {"extended", true},
+}
+
+// OID of the StartTLS extended operation (RFC 4511, section 4.14).
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+var resultNames = map[int64]string{
+	0:   "success",
+	1:   "operationsError",
+	2:   "protocolError",
+	3:   "timeLimitExceeded",
+	4:   "sizeLimitExceeded",
+	5:   "compareFalse",
+	6:   "compareTrue",
+	7:   "authMethodNotSupported",
+	8:   "strongerAuthRequired",
+	10:  "referral",
+	11:  "adminLimitExceeded",
+	12:  "unavailableCriticalExtension",
+	13:  "confidentialityRequired",
+	14:  "saslBindInProgress",
+	16:  "noSuchAttribute",
+	17:  "undefinedAttributeType",
+	18:  "inappropriateMatching",
+	19:  "constraintViolation",
+	20:  "attributeOrValueExists",
+	21:  "invalidAttributeSyntax",
+	32:  "noSuchObject",
+	33:  "aliasProblem",
+	34:  "invalidDNSyntax",
+	36:  "aliasDereferencingProblem",
+	48:  "inappropriateAuthentication",
+	49:  "invalidCredentials",
+	50:  "insufficientAccessRights",
+	51:  "busy",
+	52:  "unavailable",
+	53:  "unwillingToPerform",
+	54:  "loopDetect",
+	64:  "namingViolation",
+	65:  "objectClassViolation",
+	66:  "notAllowedOnNonLeaf",
+	67:  "notAllowedOnRDN",
+	68:  "entryAlreadyExists",
+	69:  "objectClassModsProhibited",
+	71:  "affectsMultipleDSAs",
+	80:  "other",
+	118: "canceled",
+	119: "noSuchOperation",
+	120: "tooLate",
+	121: "cannotCancel",
+	122: "assertionFailed",
+	123: "authorizationDenied",
+}
+
+// isFailure checks if a result code reports a failed operation. Besides
+// success, the results of compare operations and the intermediate results
+// of SASL binds and referrals are not failures.
+func isFailure(code int64) bool {
+	switch code {
+	case 0, 5, 6, 10, 14:
+		return false
+	}
+	return true
+}
+
+var scopeNames = []string{"base", "one", "sub", "subordinate"}
+
+var modifyOperationNames = []string{"add", "delete", "replace", "increment"}
+
+var filterOperators = map[uint32]string{3: "=", 5: ">=", 6: "<=", 8: "~="}
+
+// maxFilterDepth limits the nesting of search filters that are decoded.
+const maxFilterDepth = 32
+
+var (
+	errInvalidMessage = errors.New("invalid ldap message")
+	errFilterDepth    = errors.New("ldap search filter nesting too deep")
+)
+
+type message struct {
+	applayer.Message
+
+	id int64
+	op uint32
+
+	// dn is the entry the request operates on: the name of a bind, the
+	// base object of a search or the entry of an update operation.
+	dn string
+
+	// BindRequest
+	version       int64
+	authType      string
+	saslMechanism string
+	cleartext     bool
+
+	// SearchRequest
+	scope      string
+	filter     string
+	sizeLimit  int64
+	timeLimit  int64
+	attributes []string
+
+	// ModifyRequest
+	changes []string
+
+	// ExtendedRequest and ExtendedResponse
+	oid string
+
+	// LDAPResult of responses
+	hasResult         bool
+	resultCode        int64
+	matchedDN         string
+	diagnosticMessage string
+}
+
+func (m *message) isResponse() bool {
+	return operations[m.op].response
+}
+
+// decodeMessage decodes an LDAPMessage.
+func decodeMessage(data []byte) (*message, error) {
+	v, _, err := ber.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Is(ber.ClassUniversal, ber.TagSequence) {
+		return nil, errInvalidMessage
+	}
+	children, err := v.Children()
+	if err != nil {
+		return nil, err
+	}
+	if len(children) < 2 || !children[0].Is(ber.ClassUniversal, ber.TagInteger) {
+		return nil, errInvalidMessage
+	}
+
+	msg := &message{}
+	if msg.id, err = children[0].Int(); err != nil {
+		return nil, err
+	}
+
+	op := children[1]
+	if _, known := operations[op.Tag]; !known || op.Class != ber.ClassApplication {
+		return nil, fmt.Errorf("%w: unknown protocol operation %d", errInvalidMessage, op.Tag)
+	}
+	msg.op = op.Tag
+	msg.Size = uint64(len(data))
+	msg.IsRequest = !msg.isResponse()
+
+	switch msg.op {
+	case opUnbindRequest, opAbandonRequest:
+		return msg, nil
+	case opDelRequest:
+		// DelRequest is a primitive LDAPDN.
+		msg.dn = op.Text()
+		return msg, nil
+	}
+
+	fields, err := op.Children()
+	if err != nil {
+		return nil, err
+	}
+	switch msg.op {
+	case opBindRequest:
+		err = msg.decodeBind(fields)
+	case opSearchRequest:
+		err = msg.decodeSearch(fields)
+	case opModifyRequest:
+		err = msg.decodeModify(fields)
+	case opAddRequest:
+		err = msg.decodeAdd(fields)
+	case opModifyDNRequest, opCompareRequest:
+		err = msg.decodeEntry(fields)
+	case opExtendedRequest:
+		if len(fields) > 0 && fields[0].Is(ber.ClassContext, 0) {
+			msg.oid = fields[0].Text()
+		}
+	case opSearchResultEntry:
+		if len(fields) > 0 {
+			msg.dn = fields[0].Text()
+		}
+	case opSearchResultReference, opIntermediateResponse:
+	default:
+		err = msg.decodeResult(fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// decodeResult decodes the LDAPResult components of a response.
+func (m *message) decodeResult(fields []ber.Value) error {
+	if len(fields) < 3 || !fields[0].Is(ber.ClassUniversal, ber.TagEnumerated) {
+		return errInvalidMessage
+	}
+
+	code, err := fields[0].Int()
+	if err != nil {
+		return err
+	}
+	m.hasResult = true
+	m.resultCode = code
+	m.matchedDN = fields[1].Text()
+	m.diagnosticMessage = fields[2].Text()
+
+	if m.op == opExtendedResponse {
+		for _, f := range fields[3:] {
+			if f.Is(ber.ClassContext, 10) {
+				m.oid = f.Text()
+			}
+		}
+	}
+	return nil
+}
+
+// decodeBind decodes a BindRequest. Credentials are never kept, the
+// message only records whether a simple bind sent a password in
+// cleartext.
+func (m *message) decodeBind(fields []ber.Value) error {
+	if len(fields) < 3 {
+		return errInvalidMessage
+	}
+
+	var err error
+	if m.version, err = fields[0].Int(); err != nil {
+		return err
+	}
+	m.dn = fields[1].Text()
+
+	auth := fields[2]
+	switch {
+	case auth.Is(ber.ClassContext, 0):
+		m.authType = "simple"
+		m.cleartext = len(auth.Content) > 0
+	case auth.Is(ber.ClassContext, 3):
+		m.authType = "sasl"
+		sasl, err := auth.Children()
+		if err != nil {
+			return err
+		}
+		if len(sasl) > 0 {
+			m.saslMechanism = sasl[0].Text()
+		}
+	default:
+		return fmt.Errorf("%w: unknown authentication choice %d", errInvalidMessage, auth.Tag)
+	}
+	return nil
+}
+
+// decodeSearch decodes a SearchRequest.
+func (m *message) decodeSearch(fields []ber.Value) error {
+	if len(fields) < 8 {
+		return errInvalidMessage
+	}
+
+	m.dn = fields[0].Text()
+	scope, err := fields[1].Int()
+	if err != nil {
+		return err
+	}
+	if scope >= 0 && scope < int64(len(scopeNames)) {
+		m.scope = scopeNames[scope]
+	}
+	if m.sizeLimit, err = fields[3].Int(); err != nil {
+		return err
+	}
+	if m.timeLimit, err = fields[4].Int(); err != nil {
+		return err
+	}
+
+	var filter strings.Builder
+	if err := writeFilter(&filter, fields[6], 0); err != nil {
+		return err
+	}
+	m.filter = filter.String()
+
+	attributes, err := fields[7].Children()
+	if err != nil {
+		return err
+	}
+	for _, attr := range attributes {
+		m.attributes = append(m.attributes, attr.Text())
+	}
+	return nil
+}
+
+// decodeModify decodes a ModifyRequest. The values of the modified
+// attributes are not kept, they may contain passwords.
+func (m *message) decodeModify(fields []ber.Value) error {
+	if len(fields) < 2 {
+		return errInvalidMessage
+	}
+
+	m.dn = fields[0].Text()
+	changes, err := fields[1].Children()
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		parts, err := change.Children()
+		if err != nil {
+			return err
+		}
+		if len(parts) < 2 {
+			return errInvalidMessage
+		}
+		op, err := parts[0].Int()
+		if err != nil {
+			return err
+		}
+		modification, err := parts[1].Children()
+		if err != nil {
+			return err
+		}
+		if len(modification) == 0 {
+			return errInvalidMessage
+		}
+
+		name := "unknown"
+		if op >= 0 && op < int64(len(modifyOperationNames)) {
+			name = modifyOperationNames[op]
+		}
+		attr := modification[0].Text()
+		m.changes = append(m.changes, name+":"+attr)
+		m.addAttribute(attr)
+	}
+	return nil
+}
+
+// decodeAdd decodes an AddRequest, keeping the attribute types of the
+// new entry.
+func (m *message) decodeAdd(fields []ber.Value) error {
+	if len(fields) < 2 {
+		return errInvalidMessage
+	}
+
+	m.dn = fields[0].Text()
+	attributes, err := fields[1].Children()
+	if err != nil {
+		return err
+	}
+	for _, attr := range attributes {
+		parts, err := attr.Children()
+		if err != nil {
+			return err
+		}
+		if len(parts) > 0 {
+			m.addAttribute(parts[0].Text())
+		}
+	}
+	return nil
+}
+
+// decodeEntry decodes the entry of a ModifyDNRequest or CompareRequest.
+func (m *message) decodeEntry(fields []ber.Value) error {
+	if len(fields) < 2 {
+		return errInvalidMessage
+	}
+	m.dn = fields[0].Text()
+
+	if m.op == opCompareRequest {
+		ava, err := fields[1].Children()
+		if err != nil {
+			return err
+		}
+		if len(ava) > 0 {
+			m.addAttribute(ava[0].Text())
+		}
+	}
+	return nil
+}
+
+func (m *message) addAttribute(attr string) {
+	for _, a := range m.attributes {
+		if a == attr {
+			return
+		}
+	}
+	m.attributes = append(m.attributes, attr)
+}
+
+// writeFilter writes the string representation of a search filter as
+// defined in RFC 4515.
+func writeFilter(b *strings.Builder, f ber.Value, depth int) error {
+	if depth > maxFilterDepth {
+		return errFilterDepth
+	}
+	if f.Class != ber.ClassContext {
+		return errInvalidMessage
+	}
+
+	b.WriteByte('(')
+	switch f.Tag {
+	case 0, 1, 2: // and, or, not
+		b.WriteByte("&|!"[f.Tag])
+		children, err := f.Children()
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := writeFilter(b, child, depth+1); err != nil {
+				return err
+			}
+		}
+	case 3, 5, 6, 8: // equalityMatch, greaterOrEqual, lessOrEqual, approxMatch
+		ava, err := f.Children()
+		if err != nil {
+			return err
+		}
+		if len(ava) < 2 {
+			return errInvalidMessage
+		}
+		b.WriteString(ava[0].Text())
+		b.WriteString(filterOperators[f.Tag])
+		writeFilterValue(b, ava[1].Content)
+	case 4: // substrings
+		parts, err := f.Children()
+		if err != nil {
+			return err
+		}
+		if len(parts) < 2 {
+			return errInvalidMessage
+		}
+		substrings, err := parts[1].Children()
+		if err != nil {
+			return err
+		}
+		b.WriteString(parts[0].Text())
+		b.WriteByte('=')
+		for i, s := range substrings {
+			if s.Tag != 0 || i > 0 {
+				b.WriteByte('*')
+			}
+			writeFilterValue(b, s.Content)
+		}
+		if n := len(substrings); n == 0 || substrings[n-1].Tag != 2 {
+			b.WriteByte('*')
+		}
+	case 7: // present
+		b.WriteString(f.Text())
+		b.WriteString("=*")
+	case 9: // extensibleMatch
+		parts, err := f.Children()
+		if err != nil {
+			return err
+		}
+		var rule, attr, value string
+		var dnAttributes bool
+		for _, p := range parts {
+			switch p.Tag {
+			case 1:
+				rule = p.Text()
+			case 2:
+				attr = p.Text()
+			case 3:
+				var v strings.Builder
+				writeFilterValue(&v, p.Content)
+				value = v.String()
+			case 4:
+				dnAttributes = p.Bool()
+			}
+		}
+		b.WriteString(attr)
+		if dnAttributes {
+			b.WriteString(":dn")
+		}
+		if rule != "" {
+			b.WriteString(":" + rule)
+		}
+		b.WriteString(":=" + value)
+	default:
+		return fmt.Errorf("%w: unknown filter choice %d", errInvalidMessage, f.Tag)
+	}
+	b.WriteByte(')')
+	return nil
+}
+
+// writeFilterValue writes an assertion value, escaping the characters
+// that are special in filters and non-printable bytes.
+func writeFilterValue(b *strings.Builder, value []byte) {
+	const hex = "0123456789abcdef"
+	for _, c := range value {
+		switch {
+		case c == '*', c == '(', c == ')', c == '\\', c < 0x20, c == 0x7f:
+			b.WriteByte('\\')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldap
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/beats/v7/packetbeat/pb"
+)
+
+func (ldap *ldapPlugin) publishTransaction(t *transaction) {
+	if ldap.results == nil {
+		return
+	}
+	ldap.results(createEvent(t))
+}
+
+func createEvent(t *transaction) beat.Event {
+	requ, resp := t.requ, t.resp
+	src, dst := common.MakeEndpointPair(requ.Tuple.BaseTuple, requ.CmdlineTuple)
+	name := operations[requ.op].name
+
+	evt, pbf := pb.NewBeatEvent(requ.Ts)
+	pbf.SetSource(&src)
+	pbf.AddIP(src.IP)
+	pbf.SetDestination(&dst)
+	pbf.AddIP(dst.IP)
+	pbf.Event.Dataset = "ldap"
+	pbf.Event.Action = "ldap-" + name
+	pbf.Network.Transport = requ.Transport.String()
+	pbf.Network.Protocol = pbf.Event.Dataset
+	pbf.Source.Bytes = int64(requ.Size)
+	pbf.Event.Start = requ.Ts
+
+	fields := evt.Fields
+	fields["type"] = pbf.Event.Dataset
+	fields["method"] = name
+
+	ldap := mapstr.M{
+		"message_id": requ.id,
+		"operation":  name,
+	}
+	if requ.dn != "" {
+		ldap["dn"] = requ.dn
+	}
+	if len(requ.attributes) > 0 {
+		ldap["attributes"] = requ.attributes
+	}
+
+	switch requ.op {
+	case opBindRequest:
+		pbf.Event.Category = append(pbf.Event.Category, "authentication")
+		bind := mapstr.M{
+			"version":   requ.version,
+			"auth_type": requ.authType,
+			"cleartext": requ.cleartext,
+		}
+		if requ.saslMechanism != "" {
+			bind["sasl_mechanism"] = requ.saslMechanism
+		}
+		ldap["bind"] = bind
+		if requ.dn != "" {
+			_, _ = fields.Put("user.name", requ.dn)
+			pbf.AddUser(requ.dn)
+		}
+	case opSearchRequest:
+		search := mapstr.M{
+			"scope":      requ.scope,
+			"filter":     requ.filter,
+			"size_limit": requ.sizeLimit,
+			"time_limit": requ.timeLimit,
+		}
+		if resp != nil {
+			search["entries"] = t.entries
+			search["references"] = t.references
+		}
+		ldap["search"] = search
+	case opModifyRequest:
+		ldap["modify"] = mapstr.M{"changes": requ.changes}
+	case opExtendedRequest:
+		ldap["extended"] = mapstr.M{"oid": requ.oid}
+	}
+
+	status := common.OK_STATUS
+	switch {
+	case resp != nil:
+		pbf.Destination.Bytes = int64(t.responseBytes)
+		pbf.Event.End = resp.Ts
+
+		ldap["result_code"] = resp.resultCode
+		if result, found := resultNames[resp.resultCode]; found {
+			ldap["result"] = result
+		}
+		if resp.diagnosticMessage != "" {
+			ldap["diagnostic_message"] = resp.diagnosticMessage
+		}
+		if resp.matchedDN != "" {
+			ldap["matched_dn"] = resp.matchedDN
+		}
+		pbf.Event.Outcome = "success"
+		if isFailure(resp.resultCode) {
+			status = common.ERROR_STATUS
+			pbf.Event.Outcome = "failure"
+		}
+	case requ.op == opUnbindRequest, requ.op == opAbandonRequest:
+	default:
+		status = common.ERROR_STATUS
+		pbf.Error.Message = append(pbf.Error.Message, "Unmatched request")
+	}
+
+	fields["status"] = status
+	fields["ldap"] = ldap
+	return evt
+}
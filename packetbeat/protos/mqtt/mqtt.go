@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package mqtt
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// mqtt application level protocol analyzer plugin
+type mqtt struct {
+	ports        protos.PortsConfig
+	parserConfig parserConfig
+	transConfig  transactionConfig
+	watcher      *procs.ProcessesWatcher
+	pub          transPub
+}
+
+// Application Layer tcp stream data to be stored on tcp connection context.
+type connection struct {
+	// clientDir is the tcp direction of the packets sent by the client. It
+	// is derived from the configured broker ports on the first packet.
+	clientDir uint8
+
+	session session
+	streams [2]*stream
+	trans   transactions
+}
+
+// Uni-directional tcp stream state for parsing messages.
+type stream struct {
+	parser parser
+}
+
+var debugf = logp.MakeDebug("mqtt")
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "mqtt.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "mqtt.unmatched_responses")
+)
+
+func init() {
+	protos.Register("mqtt", New)
+}
+
+// New create and initializes a new mqtt protocol analyzer instance.
+func New(
+	testMode bool,
+	results protos.Reporter,
+	watcher *procs.ProcessesWatcher,
+	cfg *conf.C,
+) (protos.Plugin, error) {
+	p := &mqtt{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, watcher, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (mqtt *mqtt) init(results protos.Reporter, watcher *procs.ProcessesWatcher, config *mqttConfig) error {
+	if err := mqtt.setFromConfig(config); err != nil {
+		return err
+	}
+	mqtt.pub.results = results
+	mqtt.watcher = watcher
+	isDebug = logp.IsDebug("mqtt")
+	return nil
+}
+
+func (mqtt *mqtt) setFromConfig(config *mqttConfig) error {
+	// set module configuration
+	if err := mqtt.ports.Set(config.Ports); err != nil {
+		return err
+	}
+
+	// set parser configuration
+	parser := &mqtt.parserConfig
+	parser.maxBytes = tcp.TCPMaxDataInStream
+	parser.capturePayload = config.SendRequest
+	parser.maxPayloadSize = config.MaxPayloadSize
+
+	// set transaction correlator configuration
+	mqtt.transConfig.transactionTimeout = config.TransactionTimeout
+
+	return nil
+}
+
+// ConnectionTimeout returns the per stream connection timeout.
+// Return <=0 to set default tcp module transaction timeout.
+func (mqtt *mqtt) ConnectionTimeout() time.Duration {
+	return mqtt.transConfig.transactionTimeout
+}
+
+// GetPorts returns the ports numbers packets shall be processed for.
+func (mqtt *mqtt) GetPorts() []int {
+	return mqtt.ports.Ports
+}
+
+// Parse processes a TCP packet. Return nil if connection
+// state shall be dropped (e.g. parser not in sync with tcp stream)
+func (mqtt *mqtt) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	conn := getConnection(private)
+	if conn == nil {
+		conn = mqtt.newConnection(pkt, dir)
+	}
+
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.parser.init(&mqtt.parserConfig, &conn.session, dir, dir == conn.clientDir, func(msg *message) error {
+			return conn.trans.onMessage(tcptuple.IPPort(), msg)
+		})
+		conn.streams[dir] = st
+	}
+
+	if err := st.parser.feed(pkt.Ts, pkt.Payload); err != nil {
+		debugf("%v, dropping TCP stream for error in direction %v.", err, dir)
+		conn.trans.flush()
+		return nil
+	}
+	return conn
+}
+
+// ReceivedFin handles TCP-FIN packet.
+func (mqtt *mqtt) ReceivedFin(
+	tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	return private
+}
+
+// GapInStream handles lost packets in tcp-stream.
+func (mqtt *mqtt) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int,
+	private protos.ProtocolData,
+) (protos.ProtocolData, bool) {
+	conn := getConnection(private)
+	if conn != nil {
+		conn.trans.flush()
+	}
+
+	return nil, true
+}
+
+// Expired publishes the packets still waiting for their acknowledgement
+// when the connection times out.
+func (mqtt *mqtt) Expired(tuple *common.TCPTuple, private protos.ProtocolData) {
+	conn := getConnection(private)
+	if conn != nil {
+		conn.trans.flush()
+	}
+}
+
+// newConnection creates the state of a new connection. The client sends
+// its packets to the configured broker ports. If neither port is a broker
+// port, the first packet seen is assumed to be sent by the client.
+func (mqtt *mqtt) newConnection(pkt *protos.Packet, dir uint8) *connection {
+	conn := &connection{clientDir: dir}
+	if !mqtt.isBrokerPort(pkt.Tuple.DstPort) && mqtt.isBrokerPort(pkt.Tuple.SrcPort) {
+		conn.clientDir = 1 - dir
+	}
+	conn.trans.init(&mqtt.transConfig, mqtt.watcher, mqtt.pub.onTransaction)
+	return conn
+}
+
+func (mqtt *mqtt) isBrokerPort(port uint16) bool {
+	for _, p := range mqtt.ports.Ports {
+		if p == int(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func getConnection(private protos.ProtocolData) *connection {
+	if private == nil {
+		return nil
+	}
+
+	priv, ok := private.(*connection)
+	if !ok {
+		logp.Warn("mqtt connection type error")
+		return nil
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: mqtt connection data not set")
+		return nil
+	}
+	return priv
+}
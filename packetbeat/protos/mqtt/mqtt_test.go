@@ -0,0 +1,434 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package mqtt
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+	"github.com/elastic/beats/v7/packetbeat/publish"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	clientDir = tcp.TCPDirectionOriginal
+	brokerDir = tcp.TCPDirectionReverse
+)
+
+type eventStore struct {
+	events []beat.Event
+}
+
+func (e *eventStore) publish(event beat.Event) {
+	publish.MarshalPacketbeatFields(&event, nil, nil)
+	e.events = append(e.events, event)
+}
+
+func newTestPlugin(t *testing.T, store *eventStore, settings map[string]interface{}) *mqtt {
+	t.Helper()
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	settings["ports"] = []int{1883}
+	p, err := New(false, store.publish, &procs.ProcessesWatcher{}, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return p.(*mqtt)
+}
+
+func testCreateTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		BaseTuple: common.BaseTuple{
+			SrcIP: net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+			SrcPort: 6512, DstPort: 1883,
+		},
+	}
+	t.ComputeHashables()
+	return t
+}
+
+type testConn struct {
+	p       *mqtt
+	tuple   *common.TCPTuple
+	private protos.ProtocolData
+}
+
+func (c *testConn) send(dir uint8, payload ...[]byte) {
+	var data []byte
+	for _, b := range payload {
+		data = append(data, b...)
+	}
+	pkt := protos.Packet{Ts: time.Now(), Tuple: *c.tuple.IPPort(), Payload: data}
+	if dir == tcp.TCPDirectionReverse {
+		pkt.Tuple = common.NewIPPortTuple(4,
+			c.tuple.DstIP, c.tuple.DstPort, c.tuple.SrcIP, c.tuple.SrcPort)
+	}
+	c.private = c.p.Parse(&pkt, c.tuple, dir, c.private)
+}
+
+func expectTransaction(t *testing.T, e *eventStore) mapstr.M {
+	t.Helper()
+	if len(e.events) == 0 {
+		t.Fatal("No transaction")
+	}
+	event := e.events[0]
+	e.events = e.events[1:]
+	return event.Fields
+}
+
+// packet builds a control packet from its type, its flags and the
+// concatenated parts of its variable header and payload.
+func packet(typ, flags uint8, parts ...[]byte) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	b := []byte{typ<<4 | flags}
+	n := len(body)
+	for {
+		c := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+func str(s string) []byte {
+	return append(u16(uint16(len(s))), s...)
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func props(p ...byte) []byte {
+	return append([]byte{byte(len(p))}, p...)
+}
+
+func connect(level uint8, clientID string) []byte {
+	name := "MQTT"
+	if level == level31 {
+		name = "MQIsdp"
+	}
+	parts := [][]byte{str(name), {level, 0x02}, u16(60)}
+	if level == level5 {
+		parts = append(parts, props(0x11, 0, 0, 0, 30))
+	}
+	parts = append(parts, str(clientID))
+	return packet(typeConnect, 0, parts...)
+}
+
+func TestConnect(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level311, "sensor-17"))
+	assert.Empty(t, store.events)
+	c.send(brokerDir, packet(typeConnack, 0, []byte{0x01, 0x00}))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "mqtt", fields["type"])
+	assert.Equal(t, "CONNECT", fields["method"])
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"protocol_version": "3.1.1",
+		"client_id":        "sensor-17",
+		"clean_session":    true,
+		"keep_alive":       uint16(60),
+		"session_present":  true,
+		"reason_code":      uint8(0),
+		"reason":           "Connection accepted",
+	}, fields["mqtt"])
+	v, _ := fields.GetValue("destination.port")
+	assert.Equal(t, int64(1883), v)
+}
+
+func TestConnectRefused(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level31, "sensor-17"))
+	c.send(brokerDir, packet(typeConnack, 0, []byte{0x00, 0x05}))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	mqtt := fields["mqtt"].(mapstr.M)
+	assert.Equal(t, "3.1", mqtt["protocol_version"])
+	assert.Equal(t, "Not authorized", mqtt["reason"])
+}
+
+func TestAssignedClientID(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level5, ""))
+	c.send(brokerDir, packet(typeConnack, 0, []byte{0x00, 0x00},
+		props(append([]byte{propAssignedClientID}, str("auto-4F2A")...)...)))
+
+	fields := expectTransaction(t, &store)
+	mqtt := fields["mqtt"].(mapstr.M)
+	assert.Equal(t, "5.0", mqtt["protocol_version"])
+	assert.Equal(t, "auto-4F2A", mqtt["client_id"])
+	assert.Equal(t, "Success", mqtt["reason"])
+}
+
+func TestPublishQoS0(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"send_request": true})
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level311, "sensor-17"))
+	c.send(brokerDir, packet(typeConnack, 0, []byte{0x00, 0x00}))
+	expectTransaction(t, &store)
+
+	c.send(clientDir, packet(typePublish, 0x01, str("sensors/17/temp"), []byte(`{"t":21.5}`)))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "PUBLISH", fields["method"])
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, `{"t":21.5}`, fields["request"])
+	assert.Equal(t, mapstr.M{
+		"protocol_version": "3.1.1",
+		"client_id":        "sensor-17",
+		"topic":            "sensors/17/temp",
+		"qos":              uint8(0),
+		"retain":           true,
+		"payload_bytes":    10,
+	}, fields["mqtt"])
+	_, err := fields.GetValue("destination.bytes")
+	assert.Error(t, err)
+}
+
+func TestPublishQoS1(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	// Publishes in both directions use the same packet identifier.
+	c.send(clientDir, packet(typePublish, 0x02, str("sensors/17/temp"), u16(1), []byte("21.5")))
+	c.send(brokerDir, packet(typePublish, 0x0a, str("commands/17"), u16(1), []byte("reboot")))
+	assert.Empty(t, store.events)
+
+	c.send(clientDir, packet(typePuback, 0, u16(1)))
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	mqtt := fields["mqtt"].(mapstr.M)
+	assert.Equal(t, "commands/17", mqtt["topic"])
+	assert.Equal(t, true, mqtt["dup"])
+	assert.Equal(t, uint16(1), mqtt["packet_id"])
+	assert.NotContains(t, fields, "request")
+	v, _ := fields.GetValue("source.port")
+	assert.Equal(t, int64(1883), v)
+
+	c.send(brokerDir, packet(typePuback, 0, u16(1)))
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "sensors/17/temp", fields["mqtt"].(mapstr.M)["topic"])
+	v, _ = fields.GetValue("source.port")
+	assert.Equal(t, int64(6512), v)
+}
+
+func TestPublishQoS2(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	pub := packet(typePublish, 0x04, str("meters/9"), u16(7), []byte("1234"))
+	c.send(clientDir, pub)
+	c.send(brokerDir, packet(typePubrec, 0, u16(7)))
+	c.send(clientDir, packet(typePubrel, 0x02, u16(7)))
+	assert.Empty(t, store.events)
+	c.send(brokerDir, packet(typePubcomp, 0, u16(7)))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, uint8(2), fields["mqtt"].(mapstr.M)["qos"])
+	v, _ := fields.GetValue("source.bytes")
+	assert.Equal(t, int64(len(pub)+4), v)
+	v, _ = fields.GetValue("destination.bytes")
+	assert.Equal(t, int64(8), v)
+}
+
+func TestPublishQoS2RejectedV5(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level5, "meter-9"))
+	c.send(brokerDir, packet(typeConnack, 0, []byte{0x00, 0x00}, props()))
+	expectTransaction(t, &store)
+
+	// The first PUBLISH sets topic alias 3, the second one uses it.
+	c.send(clientDir, packet(typePublish, 0x04, str("meters/9"), u16(7), props(0x23, 0, 3), []byte("1")))
+	c.send(brokerDir, packet(typePubrec, 0, u16(7), []byte{0x00}, props()))
+	c.send(clientDir, packet(typePubrel, 0x02, u16(7)))
+	c.send(brokerDir, packet(typePubcomp, 0, u16(7)))
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "OK", fields["status"])
+
+	c.send(clientDir, packet(typePublish, 0x04, str(""), u16(8), props(0x23, 0, 3), []byte("2")))
+	c.send(brokerDir, packet(typePubrec, 0, u16(8), []byte{0x97}, props()))
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	mqtt := fields["mqtt"].(mapstr.M)
+	assert.Equal(t, "meters/9", mqtt["topic"])
+	assert.Equal(t, uint8(0x97), mqtt["reason_code"])
+	assert.Equal(t, "Quota exceeded", mqtt["reason"])
+	assert.Equal(t, 1, mqtt["payload_bytes"])
+}
+
+func TestSubscribe(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, packet(typeSubscribe, 0x02, u16(2), str("commands/17"), []byte{1}, str("config/#"), []byte{2}))
+	c.send(brokerDir, packet(typeSuback, 0, u16(2), []byte{0x01, 0x80}))
+	c.send(clientDir, packet(typeUnsubscribe, 0x02, u16(3), str("config/#")))
+	c.send(brokerDir, packet(typeUnsuback, 0, u16(3)))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "SUBSCRIBE", fields["method"])
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, mapstr.M{
+		"packet_id":    uint16(2),
+		"topics":       []string{"commands/17", "config/#"},
+		"reason_codes": []int{0x01, 0x80},
+	}, fields["mqtt"])
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "UNSUBSCRIBE", fields["method"])
+	assert.Equal(t, "OK", fields["status"])
+	assert.Equal(t, []string{"config/#"}, fields["mqtt"].(mapstr.M)["topics"])
+}
+
+func TestDisconnect(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, connect(level5, "sensor-17"))
+	c.send(brokerDir,
+		packet(typeConnack, 0, []byte{0x00, 0x00}, props()),
+		packet(typeDisconnect, 0, []byte{0x8e}, props()))
+
+	expectTransaction(t, &store)
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "DISCONNECT", fields["method"])
+	assert.Equal(t, "Error", fields["status"])
+	assert.Equal(t, "Session taken over", fields["mqtt"].(mapstr.M)["reason"])
+	v, _ := fields.GetValue("source.port")
+	assert.Equal(t, int64(1883), v)
+}
+
+func TestPingIgnored(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, packet(typePingreq, 0))
+	c.send(brokerDir, packet(typePingresp, 0))
+	assert.NotNil(t, c.private)
+	assert.Empty(t, store.events)
+}
+
+func TestSplitPackets(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	payload := make([]byte, 300) // two bytes remaining length
+	for _, b := range packet(typePublish, 0x02, str("blobs"), u16(9), payload) {
+		c.send(clientDir, []byte{b})
+	}
+	c.send(brokerDir, packet(typePuback, 0, u16(9)))
+
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, 300, fields["mqtt"].(mapstr.M)["payload_bytes"])
+}
+
+func TestOversizedPublish(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, map[string]interface{}{"send_request": true, "max_payload_size": 4})
+	p.parserConfig.maxBytes = 2000
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	big := packet(typePublish, 0x02, str("firmware"), u16(1), make([]byte, 5000))
+	c.send(clientDir, big[:1500])
+	c.send(clientDir, big[1500:], packet(typePublish, 0x02, str("small"), u16(2), []byte("abcdef")))
+	c.send(brokerDir, packet(typePuback, 0, u16(1)), packet(typePuback, 0, u16(2)))
+
+	fields := expectTransaction(t, &store)
+	mqtt := fields["mqtt"].(mapstr.M)
+	assert.Equal(t, "firmware", mqtt["topic"])
+	assert.Equal(t, 5000, mqtt["payload_bytes"])
+	v, _ := fields.GetValue("source.bytes")
+	assert.Equal(t, int64(len(big)), v)
+
+	fields = expectTransaction(t, &store)
+	assert.Equal(t, "small", fields["mqtt"].(mapstr.M)["topic"])
+	assert.Equal(t, "abcd", fields["request"])
+}
+
+func TestUnmatchedOnExpiry(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, packet(typePublish, 0x02, str("a"), u16(1), []byte("x")))
+	c.send(brokerDir, packet(typePuback, 0, u16(5)))
+	assert.Empty(t, store.events)
+
+	p.Expired(c.tuple, c.private)
+	fields := expectTransaction(t, &store)
+	assert.Equal(t, "Error", fields["status"])
+	v, _ := fields.GetValue("error.message")
+	assert.Equal(t, "Unmatched request", v)
+}
+
+func TestNotMQTT(t *testing.T) {
+	var store eventStore
+	p := newTestPlugin(t, &store, nil)
+	c := &testConn{p: p, tuple: testCreateTCPTuple()}
+
+	c.send(clientDir, []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Nil(t, c.private)
+	assert.Empty(t, store.events)
+}
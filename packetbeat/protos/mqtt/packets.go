@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package mqtt
+
+import "strconv"
+
+// MQTT control packet types.
+const (
+	typeConnect     uint8 = 1
+	typeConnack     uint8 = 2
+	typePublish     uint8 = 3
+	typePuback      uint8 = 4
+	typePubrec      uint8 = 5
+	typePubrel      uint8 = 6
+	typePubcomp     uint8 = 7
+	typeSubscribe   uint8 = 8
+	typeSuback      uint8 = 9
+	typeUnsubscribe uint8 = 10
+	typeUnsuback    uint8 = 11
+	typePingreq     uint8 = 12
+	typePingresp    uint8 = 13
+	typeDisconnect  uint8 = 14
+	typeAuth        uint8 = 15
+)
+
+var packetTypeNames = []string{
+	"",
+	"CONNECT",
+	"CONNACK",
+	"PUBLISH",
+	"PUBACK",
+	"PUBREC",
+	"PUBREL",
+	"PUBCOMP",
+	"SUBSCRIBE",
+	"SUBACK",
+	"UNSUBSCRIBE",
+	"UNSUBACK",
+	"PINGREQ",
+	"PINGRESP",
+	"DISCONNECT",
+	"AUTH",
+}
+
+func packetTypeName(typ uint8) string {
+	if int(typ) < len(packetTypeNames) {
+		return packetTypeNames[typ]
+	}
+	return strconv.Itoa(int(typ))
+}
+
+// Protocol levels sent in CONNECT packets.
+const (
+	level31  uint8 = 3
+	level311 uint8 = 4
+	level5   uint8 = 5
+)
+
+func protocolVersion(level uint8) string {
+	switch level {
+	case level31:
+		return "3.1"
+	case level311:
+		return "3.1.1"
+	case level5:
+		return "5.0"
+	}
+	return strconv.Itoa(int(level))
+}
+
+// MQTT 5 properties read by the analyzer.
+const (
+	propAssignedClientID uint8 = 0x12
+	propTopicAlias       uint8 = 0x23
+)
+
+// propertySizes gives the size of the MQTT 5 properties of fixed size.
+// Properties of variable size are encoded as strings (-1), binary data
+// (-1), string pairs (-2) or variable byte integers (-3).
+var propertySizes = map[uint8]int{
+	0x01: 1,  // Payload Format Indicator
+	0x02: 4,  // Message Expiry Interval
+	0x03: -1, // Content Type
+	0x08: -1, // Response Topic
+	0x09: -1, // Correlation Data
+	0x0B: -3, // Subscription Identifier
+	0x11: 4,  // Session Expiry Interval
+	0x12: -1, // Assigned Client Identifier
+	0x13: 2,  // Server Keep Alive
+	0x15: -1, // Authentication Method
+	0x16: -1, // Authentication Data
+	0x17: 1,  // Request Problem Information
+	0x18: 4,  // Will Delay Interval
+	0x19: 1,  // Request Response Information
+	0x1A: -1, // Response Information
+	0x1C: -1, // Server Reference
+	0x1F: -1, // Reason String
+	0x21: 2,  // Receive Maximum
+	0x22: 2,  // Topic Alias Maximum
+	0x23: 2,  // Topic Alias
+	0x24: 1,  // Maximum QoS
+	0x25: 1,  // Retain Available
+	0x26: -2, // User Property
+	0x27: 4,  // Maximum Packet Size
+	0x28: 1,  // Wildcard Subscription Available
+	0x29: 1,  // Subscription Identifier Available
+	0x2A: 1,  // Shared Subscription Available
+}
+
+// Return codes of MQTT 3.1 and 3.1.1 CONNACK packets.
+var connackReturnCodes = map[uint8]string{
+	0: "Connection accepted",
+	1: "Unacceptable protocol version",
+	2: "Identifier rejected",
+	3: "Server unavailable",
+	4: "Bad user name or password",
+	5: "Not authorized",
+}
+
+// MQTT 5 reason codes. Codes below 0x80 report success.
+var reasonCodes = map[uint8]string{
+	0x00: "Success",
+	0x01: "Granted QoS 1",
+	0x02: "Granted QoS 2",
+	0x04: "Disconnect with Will Message",
+	0x10: "No matching subscribers",
+	0x11: "No subscription existed",
+	0x18: "Continue authentication",
+	0x19: "Re-authenticate",
+	0x80: "Unspecified error",
+	0x81: "Malformed Packet",
+	0x82: "Protocol Error",
+	0x83: "Implementation specific error",
+	0x84: "Unsupported Protocol Version",
+	0x85: "Client Identifier not valid",
+	0x86: "Bad User Name or Password",
+	0x87: "Not authorized",
+	0x88: "Server unavailable",
+	0x89: "Server busy",
+	0x8A: "Banned",
+	0x8B: "Server shutting down",
+	0x8C: "Bad authentication method",
+	0x8D: "Keep Alive timeout",
+	0x8E: "Session taken over",
+	0x8F: "Topic Filter invalid",
+	0x90: "Topic Name invalid",
+	0x91: "Packet Identifier in use",
+	0x92: "Packet Identifier not found",
+	0x93: "Receive Maximum exceeded",
+	0x94: "Topic Alias invalid",
+	0x95: "Packet too large",
+	0x96: "Message rate too high",
+	0x97: "Quota exceeded",
+	0x98: "Administrative action",
+	0x99: "Payload format invalid",
+	0x9A: "Retain not supported",
+	0x9B: "QoS not supported",
+	0x9C: "Use another server",
+	0x9D: "Server moved",
+	0x9E: "Shared Subscriptions not supported",
+	0x9F: "Connection rate exceeded",
+	0xA0: "Maximum connect time",
+	0xA1: "Subscription Identifiers not supported",
+	0xA2: "Wildcard Subscriptions not supported",
+}
+
+// reasonName returns the name of the reason code of a packet.
+func reasonName(typ, level, code uint8) string {
+	if level < level5 {
+		switch {
+		case typ == typeConnack:
+			return connackReturnCodes[code]
+		case typ == typeSuback && code == 0x80:
+			return "Failure"
+		case typ == typeSuback:
+			return "Granted QoS " + strconv.Itoa(int(code))
+		}
+		return ""
+	}
+	switch {
+	case code == 0 && typ == typeDisconnect:
+		return "Normal disconnection"
+	case code == 0 && typ == typeSuback:
+		return "Granted QoS 0"
+	}
+	return reasonCodes[code]
+}
+
+// isFailure reports whether a reason code reports an error.
+func isFailure(typ, level, code uint8) bool {
+	if level < level5 && typ == typeConnack {
+		return code != 0
+	}
+	return code >= 0x80
+}
@@ -0,0 +1,489 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common/streambuf"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// Bytes of an oversized packet that are buffered to decode its variable
+// header.
+const maxHeaderLen = 1024
+
+type parser struct {
+	buf        streambuf.Buffer
+	config     *parserConfig
+	session    *session
+	dir        uint8
+	fromClient bool
+	onMessage  func(m *message) error
+
+	// skip is the number of bytes of an oversized packet still to be
+	// discarded.
+	skip int
+}
+
+type parserConfig struct {
+	maxBytes       int
+	capturePayload bool
+	maxPayloadSize int
+}
+
+// session holds the state shared by both directions of a connection.
+type session struct {
+	// protocol level announced in the CONNECT packet, 0 if the capture
+	// started after it.
+	level    uint8
+	clientID string
+
+	// topic aliases of MQTT 5, by tcp direction of the PUBLISH sender.
+	aliases [2]map[uint16]string
+}
+
+// version returns the protocol level used to decode packets. MQTT 3.1.1
+// is assumed if the CONNECT packet was not seen.
+func (s *session) version() uint8 {
+	if s.level == 0 {
+		return level311
+	}
+	return s.level
+}
+
+type message struct {
+	applayer.Message
+
+	// tcp direction the packet was received in.
+	dir        uint8
+	fromClient bool
+
+	packetType uint8
+	packetID   uint16
+
+	// PUBLISH
+	topic       string
+	qos         uint8
+	retain      bool
+	dup         bool
+	payloadSize int
+	payload     []byte
+
+	// SUBSCRIBE and UNSUBSCRIBE
+	topics []string
+
+	// CONNECT
+	clientID     string
+	cleanSession bool
+	keepAlive    uint16
+
+	// CONNACK
+	sessionPresent bool
+
+	// reason codes of acknowledgements and DISCONNECT packets.
+	reasonCodes []uint8
+
+	// rec is the PUBREC received for a QoS 2 PUBLISH.
+	rec *message
+
+	session *session
+}
+
+var (
+	errInvalidLength = errors.New("invalid mqtt remaining length")
+	errInvalidPacket = errors.New("invalid mqtt packet")
+	isDebug          = false
+)
+
+func (p *parser) init(
+	cfg *parserConfig,
+	sess *session,
+	dir uint8,
+	fromClient bool,
+	onMessage func(*message) error,
+) {
+	*p = parser{
+		buf:        streambuf.Buffer{},
+		config:     cfg,
+		session:    sess,
+		dir:        dir,
+		fromClient: fromClient,
+		onMessage:  onMessage,
+	}
+}
+
+func (p *parser) feed(ts time.Time, data []byte) error {
+	if p.skip > 0 {
+		n := min(p.skip, len(data))
+		p.skip -= n
+		data = data[n:]
+	}
+	if len(data) > 0 {
+		if _, err := p.buf.Write(data); err != nil {
+			return err
+		}
+	}
+
+	for p.skip == 0 && p.buf.Avail(2) {
+		msg, err := p.parse(ts)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			break // wait for more data
+		}
+
+		if err := p.onMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse decodes the next packet in the buffer. Packets exceeding the buffer
+// limit are reported once their variable header is available, the rest of
+// the packet is skipped.
+func (p *parser) parse(ts time.Time) (*message, error) {
+	data := p.buf.Bytes()
+	header := data[0]
+	if !validFlags(header>>4, header&0x0f) {
+		return nil, errInvalidPacket
+	}
+	length, n, err := remainingLength(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil // wait for the remaining length
+	}
+
+	hdrLen := 1 + n
+	total := hdrLen + length
+	truncated := p.config.maxBytes > 0 && total > p.config.maxBytes
+	avail := total
+	if truncated {
+		avail = min(total, hdrLen+maxHeaderLen)
+	}
+	if !p.buf.Avail(avail) {
+		return nil, nil
+	}
+
+	raw, err := p.buf.Collect(avail)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &message{
+		Message: applayer.Message{
+			Ts:        ts,
+			IsRequest: p.fromClient,
+			Size:      uint64(total),
+		},
+		dir:        p.dir,
+		fromClient: p.fromClient,
+		packetType: header >> 4,
+		session:    p.session,
+	}
+	if p.fromClient {
+		msg.Direction = applayer.NetOriginalDirection
+	} else {
+		msg.Direction = applayer.NetReverseDirection
+	}
+
+	d := &decoder{buf: raw[hdrLen:], truncated: truncated}
+	if err := p.decode(msg, header&0x0f, d, length); err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		if isDebug {
+			debugf("%s packet of %d bytes exceeds the buffer limit, skipping its payload",
+				packetTypeName(msg.packetType), total)
+		}
+		p.skipBuffered(total - avail)
+	}
+	p.buf.Reset()
+	return msg, nil
+}
+
+// skipBuffered discards the rest of an oversized packet, first from the
+// buffer and then from the data received later.
+func (p *parser) skipBuffered(n int) {
+	buffered := min(n, p.buf.Len())
+	_ = p.buf.Advance(buffered)
+	p.skip = n - buffered
+}
+
+// remainingLength decodes the variable byte integer following the first
+// byte of the fixed header. It returns n = 0 if more data is required.
+func remainingLength(b []byte) (length, n int, err error) {
+	multiplier := 1
+	for i := 0; i < 4; i++ {
+		if i >= len(b) {
+			return 0, 0, nil
+		}
+		length += int(b[i]&0x7f) * multiplier
+		if b[i]&0x80 == 0 {
+			return length, i + 1, nil
+		}
+		multiplier *= 128
+	}
+	return 0, 0, errInvalidLength
+}
+
+// validFlags checks the flags of the fixed header, used to detect streams
+// not carrying MQTT. Only PUBLISH packets have variable flags.
+func validFlags(typ, flags uint8) bool {
+	switch typ {
+	case typePublish:
+		return (flags>>1)&0x03 != 3
+	case typePubrel, typeSubscribe, typeUnsubscribe:
+		return flags == 0x02
+	case 0:
+		return false
+	}
+	return flags == 0
+}
+
+// decode decodes the variable header and the payload of a packet.
+func (p *parser) decode(msg *message, flags uint8, d *decoder, length int) error {
+	level := p.session.version()
+
+	switch msg.packetType {
+	case typeConnect:
+		decodeConnect(msg, d, p.session)
+		level = p.session.version()
+	case typeConnack:
+		msg.sessionPresent = d.uint8()&0x01 != 0
+		msg.reasonCodes = []uint8{d.uint8()}
+		if level >= level5 {
+			d.properties(func(id uint8, v []byte) {
+				if id == propAssignedClientID {
+					p.session.clientID = string(v)
+				}
+			})
+		}
+	case typePublish:
+		msg.dup = flags&0x08 != 0
+		msg.qos = (flags >> 1) & 0x03
+		msg.retain = flags&0x01 != 0
+		p.decodePublish(msg, d, level, length)
+	case typePuback, typePubrec, typePubcomp, typePubrel:
+		msg.packetID = d.uint16()
+		if level >= level5 && d.remaining() > 0 {
+			msg.reasonCodes = []uint8{d.uint8()}
+		}
+	case typeSubscribe, typeUnsubscribe:
+		msg.packetID = d.uint16()
+		if level >= level5 {
+			d.properties(nil)
+		}
+		for d.err == nil && d.remaining() > 0 {
+			msg.topics = append(msg.topics, d.string())
+			if msg.packetType == typeSubscribe {
+				d.uint8() // subscription options
+			}
+		}
+	case typeSuback, typeUnsuback:
+		msg.packetID = d.uint16()
+		if level >= level5 {
+			d.properties(nil)
+		}
+		if msg.packetType == typeSuback || level >= level5 {
+			msg.reasonCodes = append([]uint8(nil), d.rest()...)
+		}
+	case typePingreq, typePingresp, typeAuth:
+	case typeDisconnect:
+		if level >= level5 && d.remaining() > 0 {
+			msg.reasonCodes = []uint8{d.uint8()}
+		}
+	}
+
+	if d.err != nil && !d.truncated {
+		debugf("failed to decode %s packet: %v", packetTypeName(msg.packetType), d.err)
+		return errInvalidPacket
+	}
+	return nil
+}
+
+func decodeConnect(msg *message, d *decoder, sess *session) {
+	name := d.string()
+	level := d.uint8()
+	if d.err != nil {
+		return
+	}
+	if (name != "MQTT" && name != "MQIsdp") || level < level31 || level > level5 {
+		d.err = errInvalidPacket
+		return
+	}
+	sess.level = level
+
+	flags := d.uint8()
+	msg.cleanSession = flags&0x02 != 0
+	msg.keepAlive = d.uint16()
+	if level >= level5 {
+		d.properties(nil)
+	}
+	msg.clientID = d.string()
+	if d.err == nil && msg.clientID != "" {
+		sess.clientID = msg.clientID
+	}
+	// The will message and the credentials are not reported.
+}
+
+func (p *parser) decodePublish(msg *message, d *decoder, level uint8, length int) {
+	msg.topic = d.string()
+	if msg.qos > 0 {
+		msg.packetID = d.uint16()
+	}
+	if level >= level5 {
+		var alias uint16
+		d.properties(func(id uint8, v []byte) {
+			if id == propTopicAlias && len(v) == 2 {
+				alias = binary.BigEndian.Uint16(v)
+			}
+		})
+		if alias != 0 {
+			aliases := &p.session.aliases[p.dir]
+			if msg.topic != "" {
+				if *aliases == nil {
+					*aliases = map[uint16]string{}
+				}
+				(*aliases)[alias] = msg.topic
+			} else {
+				msg.topic = (*aliases)[alias]
+			}
+		}
+	}
+	if d.err != nil {
+		return
+	}
+
+	msg.payloadSize = length - d.off
+	if p.config.capturePayload {
+		payload := d.rest()
+		if len(payload) > p.config.maxPayloadSize {
+			payload = payload[:p.config.maxPayloadSize]
+		}
+		msg.payload = append([]byte(nil), payload...)
+	}
+}
+
+// decoder reads the data types of MQTT packets. The first error is sticky,
+// reads after it return zero values.
+type decoder struct {
+	buf       []byte
+	off       int
+	truncated bool
+	err       error
+}
+
+var errShortPacket = errors.New("mqtt packet too short")
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf)-d.off < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) remaining() int {
+	return len(d.buf) - d.off
+}
+
+func (d *decoder) rest() []byte {
+	return d.take(d.remaining())
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// binary reads a length prefixed string or binary data.
+func (d *decoder) binary() []byte {
+	return d.take(int(d.uint16()))
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n, err := remainingLength(d.buf[d.off:])
+	if err != nil || n == 0 {
+		d.err = errShortPacket
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+// properties reads the MQTT 5 properties of a packet, calling fn with the
+// value of every property. Properties following an unknown property
+// identifier are skipped.
+func (d *decoder) properties(fn func(id uint8, v []byte)) {
+	n := d.varint()
+	props := &decoder{buf: d.take(n)}
+	if d.err != nil {
+		return
+	}
+	for props.err == nil && props.remaining() > 0 {
+		id := props.uint8()
+		var v []byte
+		switch size, ok := propertySizes[id]; {
+		case !ok:
+			return
+		case size > 0:
+			v = props.take(size)
+		case size == -1:
+			v = props.binary()
+		case size == -2:
+			props.binary()
+			v = props.binary()
+		case size == -3:
+			props.varint()
+		}
+		if fn != nil && props.err == nil {
+			fn(id, v)
+		}
+	}
+}
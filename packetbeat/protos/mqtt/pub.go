@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package mqtt
+
+import (
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/beats/v7/packetbeat/pb"
+	"github.com/elastic/beats/v7/packetbeat/protos"
+	"github.com/elastic/beats/v7/packetbeat/protos/tcp"
+)
+
+// Transaction Publisher.
+type transPub struct {
+	results protos.Reporter
+}
+
+func (pub *transPub) onTransaction(requ, resp *message) error {
+	if pub.results == nil {
+		return nil
+	}
+
+	pub.results(pub.createEvent(requ, resp))
+	return nil
+}
+
+func (pub *transPub) createEvent(requ, resp *message) beat.Event {
+	src, dst := common.MakeEndpointPair(requ.Tuple.BaseTuple, requ.CmdlineTuple)
+	if requ.dir == tcp.TCPDirectionReverse {
+		src, dst = dst, src
+	}
+
+	evt, pbf := pb.NewBeatEvent(requ.Ts)
+	pbf.SetSource(&src)
+	pbf.AddIP(src.IP)
+	pbf.SetDestination(&dst)
+	pbf.AddIP(dst.IP)
+	pbf.Event.Dataset = "mqtt"
+	pbf.Network.Transport = "tcp"
+	pbf.Network.Protocol = pbf.Event.Dataset
+	pbf.Source.Bytes = int64(requ.Size)
+	pbf.Event.Start = requ.Ts
+
+	fields := evt.Fields
+	fields["type"] = pbf.Event.Dataset
+	fields["method"] = packetTypeName(requ.packetType)
+
+	sess := requ.session
+	mqtt := mapstr.M{}
+	if sess.level != 0 {
+		mqtt["protocol_version"] = protocolVersion(sess.level)
+	}
+	if sess.clientID != "" {
+		mqtt["client_id"] = sess.clientID
+	}
+
+	switch requ.packetType {
+	case typeConnect:
+		mqtt["clean_session"] = requ.cleanSession
+		mqtt["keep_alive"] = requ.keepAlive
+	case typePublish:
+		mqtt["topic"] = requ.topic
+		mqtt["qos"] = requ.qos
+		mqtt["retain"] = requ.retain
+		if requ.dup {
+			mqtt["dup"] = true
+		}
+		if requ.qos > 0 {
+			mqtt["packet_id"] = requ.packetID
+		}
+		mqtt["payload_bytes"] = requ.payloadSize
+		if requ.payload != nil {
+			fields["request"] = string(requ.payload)
+		}
+	case typeSubscribe, typeUnsubscribe:
+		mqtt["packet_id"] = requ.packetID
+		if len(requ.topics) > 0 {
+			mqtt["topics"] = requ.topics
+		}
+	}
+
+	status := common.OK_STATUS
+	codes := requ
+	switch {
+	case resp != nil:
+		pbf.Destination.Bytes = int64(resp.Size)
+		pbf.Event.End = resp.Ts
+		if resp.packetType == typeConnack {
+			mqtt["session_present"] = resp.sessionPresent
+		}
+		codes = resp
+	case requ.packetType == typeDisconnect:
+	case requ.packetType == typePublish && requ.qos == 0:
+	default:
+		status = common.ERROR_STATUS
+		pbf.Error.Message = append(pbf.Error.Message, "Unmatched request")
+	}
+
+	level := sess.version()
+	switch rc := codes.reasonCodes; {
+	case codes.packetType == typeSuback || codes.packetType == typeUnsuback:
+		// []uint8 would be encoded as binary data.
+		values := make([]int, 0, len(rc))
+		for _, code := range rc {
+			values = append(values, int(code))
+			if isFailure(codes.packetType, level, code) {
+				status = common.ERROR_STATUS
+			}
+		}
+		if len(values) > 0 {
+			mqtt["reason_codes"] = values
+		}
+	case len(rc) > 0:
+		mqtt["reason_code"] = rc[0]
+		if name := reasonName(codes.packetType, level, rc[0]); name != "" {
+			mqtt["reason"] = name
+		}
+		if isFailure(codes.packetType, level, rc[0]) {
+			status = common.ERROR_STATUS
+		}
+	}
+
+	fields["status"] = status
+	fields["mqtt"] = mqtt
+	return evt
+}
@@ -0,0 +1,201 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package mqtt
+
+import (
+	"sort"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/packetbeat/procs"
+	"github.com/elastic/beats/v7/packetbeat/protos/applayer"
+)
+
+// transactions pairs the packets of a connection with their
+// acknowledgements. CONNECT is answered by CONNACK, SUBSCRIBE and
+// UNSUBSCRIBE by SUBACK and UNSUBACK, and PUBLISH by PUBACK for QoS 1 or
+// by PUBREC and PUBCOMP for QoS 2, matched by packet identifier. Packet
+// identifiers are allocated by the sender of the packet, so both
+// directions have their own set of pending packets.
+type transactions struct {
+	config *transactionConfig
+
+	connect *message
+	pending [2]map[uint16]*message
+
+	onTransaction transactionHandler
+
+	watcher *procs.ProcessesWatcher
+}
+
+type transactionConfig struct {
+	transactionTimeout time.Duration
+}
+
+type transactionHandler func(requ, resp *message) error
+
+func (trans *transactions) init(c *transactionConfig, watcher *procs.ProcessesWatcher, cb transactionHandler) {
+	trans.config = c
+	trans.watcher = watcher
+	trans.onTransaction = cb
+}
+
+func (trans *transactions) onMessage(
+	tuple *common.IPPortTuple,
+	msg *message,
+) error {
+	msg.Tuple = *tuple
+	msg.Transport = applayer.TransportTCP
+	msg.CmdlineTuple = trans.watcher.FindProcessesTupleTCP(&msg.Tuple)
+
+	if isDebug {
+		debugf("Received %s packet %d with tuple: %s",
+			packetTypeName(msg.packetType), msg.packetID, tuple)
+	}
+
+	switch msg.packetType {
+	case typeConnect:
+		if prev := trans.connect; prev != nil {
+			unmatchedRequests.Inc()
+			if err := trans.onTransaction(prev, nil); err != nil {
+				return err
+			}
+		}
+		trans.connect = msg
+	case typeConnack:
+		requ := trans.connect
+		if requ == nil {
+			unmatchedResponses.Inc()
+			return nil
+		}
+		trans.connect = nil
+		return trans.onTransaction(requ, msg)
+	case typePublish:
+		if msg.qos == 0 {
+			return trans.onTransaction(msg, nil)
+		}
+		return trans.onRequest(msg)
+	case typeSubscribe, typeUnsubscribe:
+		return trans.onRequest(msg)
+	case typePubrel:
+		// PUBREL is sent by the PUBLISH sender after receiving PUBREC.
+		if requ := trans.pending[msg.dir][msg.packetID]; requ != nil {
+			requ.Size += msg.Size
+		}
+	case typePubrec:
+		requ := trans.lookup(msg, typePublish)
+		if requ == nil || requ.qos != 2 {
+			return nil
+		}
+		requ.rec = msg
+		if len(msg.reasonCodes) > 0 && isFailure(msg.packetType, level5, msg.reasonCodes[0]) {
+			// The flow ends with a failed PUBREC.
+			delete(trans.pending[requ.dir], requ.packetID)
+			return trans.onTransaction(requ, msg)
+		}
+	case typePuback, typePubcomp:
+		requ := trans.lookup(msg, typePublish)
+		if requ == nil {
+			return nil
+		}
+		if msg.packetType == typePubcomp {
+			if requ.rec == nil {
+				return nil
+			}
+			msg.Size += requ.rec.Size
+			if len(msg.reasonCodes) == 0 {
+				msg.reasonCodes = requ.rec.reasonCodes
+			}
+		}
+		delete(trans.pending[requ.dir], requ.packetID)
+		return trans.onTransaction(requ, msg)
+	case typeSuback, typeUnsuback:
+		requ := trans.lookup(msg, msg.packetType-1)
+		if requ == nil {
+			return nil
+		}
+		delete(trans.pending[requ.dir], requ.packetID)
+		return trans.onTransaction(requ, msg)
+	case typeDisconnect:
+		return trans.onTransaction(msg, nil)
+	}
+	return nil
+}
+
+// onRequest adds a packet waiting for its acknowledgement. A packet still
+// waiting with the same packet identifier is replaced, unless the new
+// packet is a retransmission.
+func (trans *transactions) onRequest(msg *message) error {
+	pending := &trans.pending[msg.dir]
+	if *pending == nil {
+		*pending = map[uint16]*message{}
+	}
+
+	if prev := (*pending)[msg.packetID]; prev != nil {
+		if msg.dup && prev.packetType == msg.packetType {
+			return nil
+		}
+		unmatchedRequests.Inc()
+		if err := trans.onTransaction(prev, nil); err != nil {
+			return err
+		}
+	}
+	(*pending)[msg.packetID] = msg
+	return nil
+}
+
+// lookup returns the pending packet acknowledged by msg. Acknowledgements
+// are sent in the opposite direction of the acknowledged packet.
+func (trans *transactions) lookup(msg *message, typ uint8) *message {
+	requ := trans.pending[1-msg.dir][msg.packetID]
+	if requ == nil || requ.packetType != typ {
+		unmatchedResponses.Inc()
+		if isDebug {
+			debugf("%s %d without matching %s. Ignoring.",
+				packetTypeName(msg.packetType), msg.packetID, packetTypeName(typ))
+		}
+		return nil
+	}
+	return requ
+}
+
+// flush publishes all packets still waiting for their acknowledgement.
+func (trans *transactions) flush() {
+	var requests []*message
+	if trans.connect != nil {
+		requests = append(requests, trans.connect)
+		trans.connect = nil
+	}
+	for dir := range trans.pending {
+		for _, msg := range trans.pending[dir] {
+			requests = append(requests, msg)
+		}
+		trans.pending[dir] = nil
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].Ts.Equal(requests[j].Ts) {
+			return requests[i].Ts.Before(requests[j].Ts)
+		}
+		return requests[i].packetID < requests[j].packetID
+	})
+	for _, requ := range requests {
+		unmatchedRequests.Inc()
+		_ = trans.onTransaction(requ, nil)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/packetbeat/capture"
 	"github.com/elastic/beats/v7/packetbeat/pb"
 	conf "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -42,6 +43,7 @@ type transProcessor struct {
 	localIPs         []net.IP // TODO: Periodically update this list.
 	internalNetworks []string
 	name             string
+	capture          *capture.Capturer
 }
 
 var debugf = logp.MakeDebug("publish")
@@ -78,6 +80,12 @@ func NewTransactionPublisher(
 	return p, nil
 }
 
+// SetCapturer sets the Capturer used to save the packets of matching
+// transactions. It must be called before any reporter is created.
+func (p *TransactionPublisher) SetCapturer(c *capture.Capturer) {
+	p.processor.capture = c
+}
+
 func (p *TransactionPublisher) Stop() {
 	close(p.done)
 }
@@ -164,6 +172,10 @@ func (p *transProcessor) Run(event *beat.Event) (*beat.Event, error) {
 				fields.Source.IP, fields.Destination.IP)
 			return nil, nil
 		}
+
+		if p.capture != nil {
+			p.capture.Capture(event, fields)
+		}
 	}
 
 	return event, nil
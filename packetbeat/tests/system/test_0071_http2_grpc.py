@@ -0,0 +1,47 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the HTTP/2 and gRPC analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_grpc_calls(self):
+        """
+        Should generate one transaction per HTTP/2 stream and decode the
+        gRPC status from the response trailers.
+        """
+        self.render_config_template(
+            http2_ports=[50051],
+        )
+        self.run_packetbeat(pcap="http2_grpc.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 3
+        assert all([o["type"] == "http2" for o in objs])
+
+        ok = objs[0]
+        assert ok["status"] == "OK"
+        assert ok["method"] == "POST"
+        assert ok["http.response.status_code"] == 200
+        assert ok["grpc.service"] == "helloworld.Greeter"
+        assert ok["grpc.method"] == "SayHello"
+        assert ok["grpc.status_code"] == 0
+        assert ok["grpc.status"] == "OK"
+
+        failed = objs[1]
+        assert failed["status"] == "Error"
+        assert failed["grpc.method"] == "SayGoodbye"
+        assert failed["grpc.status_code"] == 12
+        assert failed["grpc.status"] == "UNIMPLEMENTED"
+        assert failed["grpc.message"] == \
+            "unknown method SayGoodbye for service helloworld.Greeter"
+
+        health = objs[2]
+        assert health["status"] == "OK"
+        assert health["method"] == "GET"
+        assert health["query"] == "GET /healthz"
+        assert health["http.response.status_code"] == 200
+        assert health["user_agent.original"] == "curl/8.5.0"
+        assert "grpc.service" not in health
@@ -0,0 +1,39 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the Kafka analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_produce_fetch(self):
+        """
+        Should correlate the requests and responses of a client connection
+        and report the topics, partitions and errors.
+        """
+        self.render_config_template(
+            kafka_ports=[9092],
+        )
+        self.run_packetbeat(pcap="kafka_produce_fetch.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 4
+        assert all([o["type"] == "kafka" for o in objs])
+        assert all([o["kafka.client_id"] == "orders-service" for o in objs])
+        assert [o["kafka.api_key"] for o in objs] == \
+            ["ApiVersions", "Metadata", "Produce", "Fetch"]
+        assert [o["kafka.correlation_id"] for o in objs] == [1, 2, 3, 4]
+
+        produce = objs[2]
+        assert produce["status"] == "OK"
+        assert produce["kafka.api_version"] == 9
+        assert produce["kafka.acks"] == -1
+        assert produce["kafka.topics"] == ["orders"]
+        assert produce["kafka.partitions"] == [0]
+        assert produce["kafka.record_bytes"] == 64
+
+        fetch = objs[3]
+        assert fetch["status"] == "Error"
+        assert fetch["kafka.error_code"] == 1
+        assert fetch["kafka.error"] == "OFFSET_OUT_OF_RANGE"
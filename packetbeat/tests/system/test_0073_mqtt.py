@@ -0,0 +1,48 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the MQTT analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_session(self):
+        """
+        Should pair the packets of an MQTT 3.1.1 session with their
+        acknowledgements.
+        """
+        self.render_config_template(
+            mqtt_ports=[1883],
+            mqtt_send_request=True,
+        )
+        self.run_packetbeat(pcap="mqtt_basic.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 5
+        assert all([o["type"] == "mqtt" for o in objs])
+        assert all([o["status"] == "OK" for o in objs])
+        assert all([o["mqtt.client_id"] == "sensor-17" for o in objs])
+        assert [o["method"] for o in objs] == \
+            ["CONNECT", "SUBSCRIBE", "PUBLISH", "PUBLISH", "DISCONNECT"]
+
+        connect = objs[0]
+        assert connect["mqtt.protocol_version"] == "3.1.1"
+        assert connect["mqtt.keep_alive"] == 60
+        assert connect["mqtt.reason_code"] == 0
+
+        subscribe = objs[1]
+        assert subscribe["mqtt.topics"] == ["commands/sensor-17"]
+        assert subscribe["mqtt.reason_codes"] == [1]
+
+        publish = objs[2]
+        assert publish["mqtt.topic"] == "sensors/17/temperature"
+        assert publish["mqtt.qos"] == 1
+        assert publish["mqtt.payload_bytes"] == 16
+        assert publish["request"] == '{"celsius":21.5}'
+        assert publish["destination.port"] == 1883
+
+        delivery = objs[3]
+        assert delivery["mqtt.topic"] == "commands/sensor-17"
+        assert delivery["mqtt.qos"] == 0
+        assert delivery["source.port"] == 1883
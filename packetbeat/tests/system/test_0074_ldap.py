@@ -0,0 +1,52 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the LDAP analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_bind_search_modify(self):
+        """
+        Should report the operations of an LDAP connection with their
+        result codes.
+        """
+        self.render_config_template(
+            ldap_ports=[389],
+        )
+        self.run_packetbeat(pcap="ldap_bind_search.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 4
+        assert all([o["type"] == "ldap" for o in objs])
+        assert [o["method"] for o in objs] == \
+            ["bind", "search", "modify", "unbind"]
+
+        bind = objs[0]
+        assert bind["status"] == "OK"
+        assert bind["ldap.bind.auth_type"] == "simple"
+        assert bind["ldap.bind.cleartext"] is True
+        assert bind["user.name"] == "cn=admin,dc=example,dc=com"
+        assert bind["event.outcome"] == "success"
+        assert "authentication" in bind["event.category"]
+
+        search = objs[1]
+        assert search["status"] == "OK"
+        assert search["ldap.dn"] == "dc=example,dc=com"
+        assert search["ldap.search.scope"] == "sub"
+        assert search["ldap.search.filter"] == \
+            "(&(objectClass=person)(uid=jdoe))"
+        assert search["ldap.attributes"] == ["cn", "mail"]
+        assert search["ldap.search.entries"] == 1
+
+        modify = objs[2]
+        assert modify["status"] == "Error"
+        assert modify["ldap.modify.changes"] == ["replace:userPassword"]
+        assert modify["ldap.result_code"] == 50
+        assert modify["ldap.result"] == "insufficientAccessRights"
+        assert "n3wpassw0rd" not in str(modify)
+
+        unbind = objs[3]
+        assert unbind["status"] == "OK"
+        assert "ldap.result_code" not in unbind
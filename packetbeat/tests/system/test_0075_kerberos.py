@@ -0,0 +1,55 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the Kerberos analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_as_tgs_exchanges(self):
+        """
+        Should report AS exchanges over UDP and TGS exchanges over TCP with
+        the encryption types offered and used.
+        """
+        self.render_config_template(
+            kerberos_ports=[88],
+        )
+        self.run_packetbeat(pcap="kerberos_as_tgs.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 3
+        assert all([o["type"] == "kerberos" for o in objs])
+        assert all([o["user.name"] == "jdoe" for o in objs])
+        assert all([o["kerberos.realm"] == "EXAMPLE.COM" for o in objs])
+        assert [o["method"] for o in objs] == \
+            ["AS-REQ", "AS-REQ", "TGS-REQ"]
+
+        preauth = objs[0]
+        assert preauth["network.transport"] == "udp"
+        assert preauth["status"] == "Error"
+        assert preauth["kerberos.response_type"] == "KRB-ERROR"
+        assert preauth["kerberos.error_code"] == 25
+        assert preauth["kerberos.error"] == "KDC_ERR_PREAUTH_REQUIRED"
+        assert preauth["kerberos.padata_types"] == ["PA-PAC-REQUEST"]
+        assert preauth["kerberos.encryption_types"] == [
+            "aes256-cts-hmac-sha1-96",
+            "aes128-cts-hmac-sha1-96",
+            "rc4-hmac",
+        ]
+
+        tgt = objs[1]
+        assert tgt["status"] == "OK"
+        assert tgt["kerberos.response_type"] == "AS-REP"
+        assert tgt["kerberos.padata_types"] == ["PA-ENC-TIMESTAMP"]
+        assert tgt["kerberos.service"] == "krbtgt/EXAMPLE.COM"
+        assert tgt["kerberos.ticket_encryption_type"] == \
+            "aes256-cts-hmac-sha1-96"
+        assert tgt["event.outcome"] == "success"
+
+        service = objs[2]
+        assert service["network.transport"] == "tcp"
+        assert service["status"] == "OK"
+        assert service["kerberos.service"] == "MSSQLSvc/db.example.com:1433"
+        assert service["kerberos.encryption_types"] == ["rc4-hmac"]
+        assert service["kerberos.ticket_encryption_type"] == "rc4-hmac"
@@ -0,0 +1,36 @@
+import os
+
+from packetbeat import BaseTest
+
+"""
+Tests for saving the packets of matching transactions.
+"""
+
+
+class Test(BaseTest):
+
+    def test_capture_http_error(self):
+        """
+        Should save the packets of the flow of the HTTP 404 transaction to
+        a pcapng file and add its path to the event.
+        """
+        capture_path = os.path.join(self.working_dir, "pcap")
+        self.render_config_template(
+            flows=True,
+            capture_path=capture_path,
+            capture_when="equals.http.response.status_code: 404",
+        )
+        self.run_packetbeat(pcap="http_minitwit.pcap")
+        objs = self.read_output(types=["http"])
+
+        assert len(objs) == 3
+        assert [o["http.response.status_code"] for o in objs] == [200, 304, 404]
+        assert all(["capture.path" not in o for o in objs[:2]])
+
+        captured = objs[2]
+        assert captured["capture.packets"] > 0
+        path = captured["capture.path"]
+        assert os.path.dirname(path) == capture_path
+        assert os.path.basename(path).startswith("packetbeat-")
+        assert path.endswith(".pcapng")
+        assert os.path.getsize(path) > 0